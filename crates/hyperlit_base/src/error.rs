@@ -25,8 +25,10 @@ pub enum ErrorKind {
         count: usize,
     },
 
-    /// Wrapping std error
-    StdError { error: Box<dyn std::error::Error> },
+    /// Wrapping std error (thread-safe, so errors can be returned from worker threads)
+    StdError {
+        error: Box<dyn std::error::Error + Send + Sync>,
+    },
 
     /// Catch-all for other errors with a message
     Message { message: String },
@@ -317,7 +319,7 @@ impl<T> ResultExt<T> for HyperlitResult<T> {
     }
 }
 
-impl<T: StdError + 'static> From<T> for Box<HyperlitError> {
+impl<T: StdError + Send + Sync + 'static> From<T> for Box<HyperlitError> {
     fn from(error: T) -> Self {
         Box::new(HyperlitError::new(ErrorKind::StdError {
             error: Box::new(error),
@@ -440,4 +440,11 @@ Trace:    0: hyperlit_base::error_tests::tests::outer span
              at crates/hyperlit_base/src/error_tests.rs:413"#]]
         .assert_eq(&actual.trim());
     }
+
+    #[test]
+    fn test_error_is_send_and_sync() {
+        fn assert_send_sync<T: Send + Sync>() {}
+        assert_send_sync::<HyperlitError>();
+        assert_send_sync::<HyperlitResult<()>>();
+    }
 }
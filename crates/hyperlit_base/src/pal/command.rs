@@ -0,0 +1,76 @@
+/* 📖 # Why run commands through the PAL?
+
+Documentation can embed the output of commands (such as `mytool --help`), so
+examples never drift from the real behavior. Spawning processes is a side effect
+just like touching the filesystem, so it goes through the PAL as well:
+
+- **Testable**: MockPal returns canned output, tests never spawn processes
+- **Contained**: RealPal resolves the working directory against its base
+  directory, like every other path
+- **Bounded**: every command has a timeout, a hanging command is killed
+  instead of blocking the build
+
+Commands are never run through a shell. Program and arguments are passed
+as-is, so no quoting, globbing or piping can sneak in.
+*/
+
+use std::time::Duration;
+
+use super::FilePath;
+
+/// A command to run, with its arguments.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CommandSpec {
+    /// Program to run, looked up in `PATH` if it contains no path separator
+    pub program: String,
+    /// Arguments passed to the program
+    pub args: Vec<String>,
+    /// Directory the command runs in
+    pub working_directory: FilePath,
+    /// Time after which the command is killed
+    pub timeout: Duration,
+}
+
+impl CommandSpec {
+    /// Create a command spec running `program` in `working_directory`.
+    pub fn new(program: impl Into<String>, working_directory: FilePath, timeout: Duration) -> Self {
+        Self {
+            program: program.into(),
+            args: Vec::new(),
+            working_directory,
+            timeout,
+        }
+    }
+
+    /// Add arguments passed to the program.
+    pub fn with_args(mut self, args: impl IntoIterator<Item = impl Into<String>>) -> Self {
+        self.args.extend(args.into_iter().map(Into::into));
+        self
+    }
+
+    /// Returns the command line, with program and arguments separated by spaces.
+    pub fn command_line(&self) -> String {
+        std::iter::once(self.program.as_str())
+            .chain(self.args.iter().map(String::as_str))
+            .collect::<Vec<_>>()
+            .join(" ")
+    }
+}
+
+/// Captured output of a finished command.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct CommandOutput {
+    /// Everything the command wrote to stdout
+    pub stdout: String,
+    /// Everything the command wrote to stderr
+    pub stderr: String,
+    /// Exit code, or None if the command was terminated by a signal
+    pub exit_code: Option<i32>,
+}
+
+impl CommandOutput {
+    /// Returns true if the command exited with code 0.
+    pub fn success(&self) -> bool {
+        self.exit_code == Some(0)
+    }
+}
@@ -1,10 +1,12 @@
 use std::collections::{HashMap, HashSet};
 use std::io::{Cursor, Write};
+use std::path::Path;
 use std::sync::Arc;
 use std::sync::Mutex;
 use std::sync::atomic::{AtomicU16, Ordering};
+use std::time::Duration;
 
-use globset::{GlobBuilder, GlobSetBuilder};
+use globset::{GlobBuilder, GlobSet, GlobSetBuilder};
 
 use crate::HyperlitError;
 use crate::HyperlitResult;
@@ -12,8 +14,10 @@ use crate::err;
 use crate::error::ErrorKind;
 
 use super::FilePath;
+use super::command::{CommandOutput, CommandSpec};
 use super::http::{HttpRequest, HttpResponse, HttpServerConfig, HttpServerHandle, HttpService};
 use super::traits::{FileChangeCallback, Pal, ReadSeek};
+use super::walk::{GITIGNORE_FILE, GitignoreRules, WalkOptions};
 
 /* 📖 # Why use HashMap for MockPal storage?
 
@@ -51,6 +55,10 @@ pub struct MockPal {
     executable: Arc<Mutex<Option<Vec<u8>>>>,
     http_servers: Arc<Mutex<HashMap<u16, HttpServerInfo>>>,
     next_port: Arc<AtomicU16>,
+    command_outputs: Arc<Mutex<HashMap<String, CommandOutput>>>,
+    executed_commands: Arc<Mutex<Vec<CommandSpec>>>,
+    http_statuses: Arc<Mutex<HashMap<String, u16>>>,
+    requested_urls: Arc<Mutex<Vec<String>>>,
 }
 
 /// Information about a registered HTTP server.
@@ -69,6 +77,10 @@ impl MockPal {
             executable: Arc::new(Mutex::new(None)),
             http_servers: Arc::new(Mutex::new(HashMap::new())),
             next_port: Arc::new(AtomicU16::new(10000)),
+            command_outputs: Arc::new(Mutex::new(HashMap::new())),
+            executed_commands: Arc::new(Mutex::new(Vec::new())),
+            http_statuses: Arc::new(Mutex::new(HashMap::new())),
+            requested_urls: Arc::new(Mutex::new(Vec::new())),
         }
     }
 
@@ -116,26 +128,89 @@ impl MockPal {
         *self.executable.lock().unwrap() = Some(content);
     }
 
-    /// Get all files matching the glob patterns.
-    fn get_matching_files(&self, globs: &[String]) -> HyperlitResult<Vec<FilePath>> {
-        let mut builder = GlobSetBuilder::new();
-        for glob in globs {
-            let compiled = GlobBuilder::new(glob)
-                .build()
-                .map_err(|e| err!("Invalid glob pattern '{}': {}", glob, e))?;
-            builder.add(compiled);
-        }
-        let glob_set = builder
-            .build()
-            .map_err(|e| err!("Failed to build glob set: {}", e))?;
+    /// Set the output returned when running a command.
+    ///
+    /// `command_line` is the program followed by its arguments, separated by
+    /// spaces (see [`CommandSpec::command_line`]).
+    pub fn add_command_output(&self, command_line: &str, output: CommandOutput) {
+        self.command_outputs
+            .lock()
+            .unwrap()
+            .insert(command_line.to_string(), output);
+    }
+
+    /// Get all commands run so far, in order.
+    pub fn executed_commands(&self) -> Vec<CommandSpec> {
+        self.executed_commands.lock().unwrap().clone()
+    }
+
+    /// Set the status code returned for HEAD requests to `url`.
+    pub fn add_http_status(&self, url: &str, status: u16) {
+        self.http_statuses
+            .lock()
+            .unwrap()
+            .insert(url.to_string(), status);
+    }
+
+    /// Get all URLs requested so far, in order.
+    pub fn requested_urls(&self) -> Vec<String> {
+        self.requested_urls.lock().unwrap().clone()
+    }
+
+    /// Get all files selected by the walk options.
+    fn get_matching_files(&self, options: &WalkOptions) -> HyperlitResult<Vec<FilePath>> {
+        let glob_set = build_glob_set(options.include())?;
+        let exclude_set = build_glob_set(options.exclude())?;
 
         let files = self.files.lock().unwrap();
-        Ok(files
+        let mut gitignore = GitignoreRules::default();
+        if options.respect_gitignore() {
+            let mut gitignore_files: Vec<&FilePath> = files
+                .keys()
+                .filter(|path| path.as_path().ends_with(GITIGNORE_FILE))
+                .collect();
+            // Shallower files first, so deeper ones take precedence
+            gitignore_files.sort_by_key(|path| path.as_path().components().count());
+            for path in gitignore_files {
+                let directory = path.as_path().parent().unwrap_or(Path::new(""));
+                gitignore.add_file(directory, &String::from_utf8_lossy(&files[path]));
+            }
+        }
+        // There are no directory entries, a file is skipped if it or any parent directory is
+        let skipped = |path: &Path| {
+            path.ancestors()
+                .filter(|ancestor| !ancestor.as_os_str().is_empty())
+                .any(|ancestor| {
+                    let is_directory = ancestor != path;
+                    exclude_set.is_match(ancestor)
+                        || (options.respect_gitignore()
+                            && (gitignore.is_ignored(ancestor, is_directory)
+                                || (is_directory && ancestor.ends_with(".git"))))
+                })
+        };
+        let mut matching: Vec<FilePath> = files
             .keys()
-            .filter(|path| glob_set.is_match(path.as_path()))
+            .filter(|path| glob_set.is_match(path.as_path()) && !skipped(path.as_path()))
             .cloned()
-            .collect())
+            .collect();
+        // Walk in path order like the real PAL, not in hash map order
+        matching.sort_by(|a, b| a.as_path().cmp(b.as_path()));
+        Ok(matching)
+    }
+}
+
+/// Build a GlobSet from the given glob patterns.
+fn build_glob_set(globs: &[String]) -> HyperlitResult<GlobSet> {
+    let mut builder = GlobSetBuilder::new();
+    for glob in globs {
+        let compiled = GlobBuilder::new(glob)
+            .build()
+            .map_err(|e| err!("Invalid glob pattern '{}': {}", glob, e))?;
+        builder.add(compiled);
     }
+    builder
+        .build()
+        .map_err(|e| err!("Failed to build glob set: {}", e))
 }
 
 impl Default for MockPal {
@@ -193,15 +268,31 @@ impl Pal for MockPal {
     fn remove_directory_all(&self, path: &FilePath) -> HyperlitResult<()> {
         let mut directories = self.directories.lock().unwrap();
         directories.remove(path);
+        let mut files = self.files.lock().unwrap();
+        files.retain(|file, _| !file.as_path().starts_with(path.as_path()));
+        Ok(())
+    }
+
+    fn remove_file(&self, path: &FilePath) -> HyperlitResult<()> {
+        let mut files = self.files.lock().unwrap();
+        if files.remove(path).is_none() {
+            return Err(Box::new(HyperlitError::new(ErrorKind::FileError {
+                path: path.as_path().to_path_buf(),
+                source: std::io::Error::new(
+                    std::io::ErrorKind::NotFound,
+                    format!("File not found: {}", path),
+                ),
+            })));
+        }
         Ok(())
     }
 
-    fn walk_directory(
+    fn walk_directory_with_options(
         &self,
         _path: &FilePath,
-        globs: &[String],
+        options: &WalkOptions,
     ) -> HyperlitResult<Box<dyn Iterator<Item = HyperlitResult<FilePath>> + '_>> {
-        let matching_files = self.get_matching_files(globs)?;
+        let matching_files = self.get_matching_files(options)?;
         let iter = matching_files.into_iter().map(Ok);
         Ok(Box::new(iter))
     }
@@ -253,6 +344,27 @@ impl Pal for MockPal {
         // Create and return the handle
         Ok(HttpServerHandle::new(port))
     }
+
+    fn run_command(&self, command: &CommandSpec) -> HyperlitResult<CommandOutput> {
+        self.executed_commands.lock().unwrap().push(command.clone());
+        let command_line = command.command_line();
+        self.command_outputs
+            .lock()
+            .unwrap()
+            .get(&command_line)
+            .cloned()
+            .ok_or_else(|| err!("Failed to run command '{}': not found", command_line))
+    }
+
+    fn http_head(&self, url: &str, _timeout: Duration) -> HyperlitResult<u16> {
+        self.requested_urls.lock().unwrap().push(url.to_string());
+        self.http_statuses
+            .lock()
+            .unwrap()
+            .get(url)
+            .copied()
+            .ok_or_else(|| err!("Request to '{}' failed: not found", url))
+    }
 }
 
 /// Helper struct for writing files to MockPal.
@@ -350,6 +462,17 @@ mod tests {
         // Directory removed successfully
     }
 
+    #[test]
+    fn test_remove_file() {
+        let pal = MockPal::new();
+        pal.add_file(FilePath::from("to_remove.txt"), b"content".to_vec());
+
+        pal.remove_file(&FilePath::from("to_remove.txt")).unwrap();
+
+        assert!(!pal.file_exists(&FilePath::from("to_remove.txt")).unwrap());
+        assert!(pal.remove_file(&FilePath::from("to_remove.txt")).is_err());
+    }
+
     #[test]
     fn test_walk_directory_with_glob() {
         let pal = MockPal::new();
@@ -424,6 +547,56 @@ mod tests {
         assert!(result.is_err());
     }
 
+    #[test]
+    fn test_run_command() {
+        let pal = MockPal::new();
+        let output = CommandOutput {
+            stdout: "usage: tool\n".to_string(),
+            stderr: String::new(),
+            exit_code: Some(0),
+        };
+        pal.add_command_output("tool --help", output.clone());
+        let command = CommandSpec::new("tool", FilePath::from("work"), Duration::from_secs(1))
+            .with_args(["--help"]);
+
+        assert_eq!(pal.run_command(&command).unwrap(), output);
+        assert_eq!(pal.executed_commands(), vec![command]);
+    }
+
+    #[test]
+    fn test_http_head() {
+        let pal = MockPal::new();
+        pal.add_http_status("https://example.com/", 200);
+
+        assert_eq!(
+            pal.http_head("https://example.com/", Duration::from_secs(1))
+                .unwrap(),
+            200
+        );
+        assert_eq!(
+            pal.http_head("https://example.com/missing", Duration::from_secs(1))
+                .unwrap_err()
+                .to_string(),
+            "Request to 'https://example.com/missing' failed: not found"
+        );
+        assert_eq!(
+            pal.requested_urls(),
+            ["https://example.com/", "https://example.com/missing"]
+        );
+    }
+
+    #[test]
+    fn test_run_command_not_registered() {
+        let pal = MockPal::new();
+        let command = CommandSpec::new("tool", FilePath::from(""), Duration::from_secs(1));
+
+        let result = pal.run_command(&command);
+        assert_eq!(
+            result.unwrap_err().to_string(),
+            "Failed to run command 'tool': not found"
+        );
+    }
+
     #[test]
     fn test_multiple_files() {
         let pal = MockPal::new();
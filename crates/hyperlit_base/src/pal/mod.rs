@@ -10,12 +10,15 @@ This follows the Dependency Inversion Principle—code depends on abstractions (
 not concrete implementations (RealPal or MockPal).
 */
 
+pub mod command;
 mod file_path;
 pub mod http;
 pub mod mock;
 pub mod real_pal;
 mod traits;
+mod walk;
 
+pub use command::{CommandOutput, CommandSpec};
 pub use file_path::FilePath;
 pub use http::{
     HttpBody, HttpHeaders, HttpMethod, HttpRequest, HttpResponse, HttpServerConfig,
@@ -24,3 +27,4 @@ pub use http::{
 pub use mock::MockPal;
 pub use real_pal::RealPal;
 pub use traits::{FileChangeCallback, FileChangeEvent, Pal, PalHandle, ReadSeek};
+pub use walk::{GITIGNORE_FILE, WalkOptions};
@@ -1,8 +1,10 @@
 use std::fs;
 use std::io::{Read, Write};
-use std::path::PathBuf;
+use std::path::{Component, Path, PathBuf};
+use std::process::{Command, Stdio};
 use std::sync::Arc;
 use std::thread;
+use std::time::{Duration, Instant};
 
 use globset::{GlobBuilder, GlobSet, GlobSetBuilder};
 use tracing::{debug, error, info, instrument};
@@ -11,11 +13,13 @@ use walkdir::WalkDir;
 use crate::{HyperlitError, HyperlitResult, err, error::ErrorKind};
 
 use super::FilePath;
+use super::command::{CommandOutput, CommandSpec};
 use super::http::{
     HttpBody, HttpHeaders, HttpMethod, HttpRequest, HttpResponse, HttpServerConfig,
     HttpServerHandle, HttpService, HttpStatusCode,
 };
 use super::traits::{FileChangeCallback, FileChangeEvent, Pal, ReadSeek};
+use super::walk::{GITIGNORE_FILE, GitignoreRules, WalkOptions};
 
 /* 📖 # Why use std::fs instead of async or other crates?
 
@@ -28,6 +32,19 @@ Per ARCHITECTURE.md, we avoid async complexity. std::fs is:
 This keeps the codebase simple and maintainable.
 */
 
+/* 📖 # Why ureq for HEAD requests?
+
+The only outgoing requests are HEAD requests checking that external links are
+alive. ureq is a small blocking HTTP client, so it fits the synchronous PAL
+without an async runtime, and it handles TLS (via rustls), redirects and
+timeouts, which are easy to get subtly wrong by hand. The timeout bounds the
+whole request, redirects included, and a 4xx or 5xx response is a status,
+not an error, so the link check can report it.
+*/
+
+/// Maximum number of redirects followed by HEAD requests before giving up.
+const MAX_REDIRECTS: u32 = 5;
+
 /// Concrete PAL implementation using the real filesystem via std::fs.
 ///
 /// All file paths are resolved relative to a configured base directory,
@@ -51,6 +68,15 @@ impl RealPal {
         self.base_dir.join(path.as_path())
     }
 
+    /// Add the patterns of the `.gitignore` file in `directory`, if there is one.
+    fn add_gitignore(&self, gitignore: &mut GitignoreRules, directory: &Path) {
+        let file = self.base_dir.join(directory).join(GITIGNORE_FILE);
+        if let Ok(content) = fs::read_to_string(&file) {
+            debug!(file = %file.display(), "adding gitignore patterns");
+            gitignore.add_file(directory, &content);
+        }
+    }
+
     /// Build a GlobSet from the given glob patterns.
     #[instrument(skip(self))]
     fn build_glob_set(&self, globs: &[String]) -> HyperlitResult<GlobSet> {
@@ -164,11 +190,26 @@ impl Pal for RealPal {
         Ok(())
     }
 
-    #[instrument(skip(self), fields(path = %path, globs = ?globs))]
-    fn walk_directory(
+    #[instrument(skip(self), fields(path = %path))]
+    fn remove_file(&self, path: &FilePath) -> HyperlitResult<()> {
+        let resolved = self.resolve_path(path);
+        debug!(resolved = %resolved.display(), "removing file");
+        fs::remove_file(&resolved).map_err(|e| {
+            debug!(error = %e, "failed to remove file");
+            Box::new(HyperlitError::new(ErrorKind::FileError {
+                path: resolved,
+                source: e,
+            }))
+        })?;
+        debug!("file removed successfully");
+        Ok(())
+    }
+
+    #[instrument(skip(self), fields(path = %path, options = ?options))]
+    fn walk_directory_with_options(
         &self,
         path: &FilePath,
-        globs: &[String],
+        options: &WalkOptions,
     ) -> HyperlitResult<Box<dyn Iterator<Item = HyperlitResult<FilePath>> + '_>> {
         let resolved = self.resolve_path(path);
         debug!(resolved = %resolved.display(), "starting directory walk");
@@ -181,14 +222,66 @@ impl Pal for RealPal {
             })));
         }
 
-        debug!("building glob set from {} patterns", globs.len());
-        let glob_set = self.build_glob_set(globs)?;
+        debug!(
+            "building glob sets from {} patterns",
+            options.include().len()
+        );
+        let glob_set = self.build_glob_set(options.include())?;
+        let exclude_set = self.build_glob_set(options.exclude())?;
+
+        // Gitignore patterns are matched against paths relative to the base directory
+        let gitignore_base: PathBuf = path
+            .as_path()
+            .components()
+            .filter(|component| !matches!(component, Component::CurDir))
+            .collect();
+        // Patterns of the directories above the walked one apply as well
+        let mut gitignore = GitignoreRules::default();
+        let respect_gitignore = options.respect_gitignore();
+        if respect_gitignore {
+            let mut directory = PathBuf::new();
+            self.add_gitignore(&mut gitignore, &directory);
+            for component in gitignore_base.components() {
+                directory.push(component);
+                self.add_gitignore(&mut gitignore, &directory);
+            }
+        }
 
         // Create iterator that filters by glob patterns
         debug!("creating filtered directory iterator");
         let base_path = path.clone();
+        let walk_root = resolved.clone();
         let iter = WalkDir::new(&resolved)
+            .follow_links(options.follow_symlinks())
+            .sort_by_file_name()
             .into_iter()
+            .filter_entry(move |e| {
+                // The walked directory itself is never skipped
+                let Ok(relative) = e.path().strip_prefix(&walk_root) else {
+                    return false;
+                };
+                if e.depth() == 0 {
+                    return true;
+                }
+                let is_dir = e.file_type().is_dir();
+                if exclude_set.is_match(relative) {
+                    return false;
+                }
+                if !respect_gitignore {
+                    return true;
+                }
+                if is_dir && e.file_name() == ".git" {
+                    return false;
+                }
+                let site_relative = gitignore_base.join(relative);
+                if gitignore.is_ignored(&site_relative, is_dir) {
+                    return false;
+                }
+                if is_dir {
+                    self.add_gitignore(&mut gitignore, &site_relative);
+                }
+                true
+            })
             .filter_map(move |entry| {
                 match entry {
                     Ok(e) => {
@@ -468,6 +561,93 @@ impl Pal for RealPal {
 
         Ok(handle)
     }
+
+    #[instrument(skip(self), fields(command = %command.command_line()))]
+    fn run_command(&self, command: &CommandSpec) -> HyperlitResult<CommandOutput> {
+        let working_directory = self.resolve_path(&command.working_directory);
+        debug!(working_directory = %working_directory.display(), "starting command");
+        let mut child = Command::new(&command.program)
+            .args(&command.args)
+            .current_dir(&working_directory)
+            .stdin(Stdio::null())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn()
+            .map_err(|e| {
+                debug!(error = %e, "failed to start command");
+                err!("Failed to run command '{}': {}", command.command_line(), e)
+            })?;
+
+        // Drain both pipes while waiting, so a chatty command cannot block on a full pipe
+        let stdout = child.stdout.take().map(read_to_string_in_background);
+        let stderr = child.stderr.take().map(read_to_string_in_background);
+
+        let started = Instant::now();
+        let status = loop {
+            let status = child.try_wait().map_err(|e| {
+                err!(
+                    "Failed to wait for command '{}': {}",
+                    command.command_line(),
+                    e
+                )
+            })?;
+            if let Some(status) = status {
+                break status;
+            }
+            if started.elapsed() >= command.timeout {
+                debug!(timeout = ?command.timeout, "command timed out, killing it");
+                let _ = child.kill();
+                let _ = child.wait();
+                return Err(err!(
+                    "Command '{}' timed out after {:?}",
+                    command.command_line(),
+                    command.timeout
+                ));
+            }
+            thread::sleep(Duration::from_millis(10));
+        };
+
+        let join = |reader: Option<thread::JoinHandle<String>>| {
+            reader
+                .map(|handle| handle.join().unwrap_or_default())
+                .unwrap_or_default()
+        };
+        let output = CommandOutput {
+            stdout: join(stdout),
+            stderr: join(stderr),
+            exit_code: status.code(),
+        };
+        debug!(exit_code = ?output.exit_code, "command finished");
+        Ok(output)
+    }
+
+    #[instrument(skip(self))]
+    fn http_head(&self, url: &str, timeout: Duration) -> HyperlitResult<u16> {
+        let agent: ureq::Agent = ureq::Agent::config_builder()
+            .timeout_global(Some(timeout))
+            .max_redirects(MAX_REDIRECTS)
+            .http_status_as_error(false)
+            .build()
+            .into();
+        let response = agent
+            .head(url)
+            .call()
+            .map_err(|e| err!("Request to '{}' failed: {}", url, e))?;
+        let status = response.status().as_u16();
+        debug!(status, "HEAD request finished");
+        Ok(status)
+    }
+}
+
+/// Read a pipe to its end on a separate thread, replacing invalid UTF-8.
+fn read_to_string_in_background(
+    mut pipe: impl Read + Send + 'static,
+) -> thread::JoinHandle<String> {
+    thread::spawn(move || {
+        let mut bytes = Vec::new();
+        let _ = pipe.read_to_end(&mut bytes);
+        String::from_utf8_lossy(&bytes).into_owned()
+    })
 }
 
 impl RealPal {
@@ -690,6 +870,24 @@ mod tests {
         assert!(!temp_dir.path().join("to_remove").exists());
     }
 
+    #[test]
+    fn test_remove_file() {
+        let (temp_dir, pal) = setup_test_dir();
+        fs::write(temp_dir.path().join("to_remove.txt"), "").unwrap();
+
+        pal.remove_file(&FilePath::from("to_remove.txt")).unwrap();
+
+        assert!(!temp_dir.path().join("to_remove.txt").exists());
+    }
+
+    #[test]
+    fn test_remove_file_not_found() {
+        let (_temp_dir, pal) = setup_test_dir();
+
+        let result = pal.remove_file(&FilePath::from("missing.txt"));
+        assert!(result.is_err());
+    }
+
     #[test]
     fn test_walk_directory_with_glob() {
         let (temp_dir, pal) = setup_test_dir();
@@ -732,6 +930,85 @@ mod tests {
         assert!(result.is_err());
     }
 
+    fn walk(pal: &RealPal, path: &str, options: &WalkOptions) -> Vec<String> {
+        pal.walk_directory_with_options(&FilePath::from(path), options)
+            .unwrap()
+            .map(|path| path.unwrap().to_string())
+            .collect()
+    }
+
+    #[test]
+    fn test_walk_directory_respects_gitignore_and_excludes() {
+        let (temp_dir, pal) = setup_test_dir();
+        let root = temp_dir.path();
+        for directory in ["src/vendor", "src/generated", "src/docs", ".git"] {
+            fs::create_dir_all(root.join(directory)).unwrap();
+        }
+        fs::write(root.join(".gitignore"), "vendor/\n*.log\n").unwrap();
+        fs::write(root.join("src/docs/.gitignore"), "!keep.log\n").unwrap();
+        for file in [
+            "src/lib.rs",
+            "src/debug.log",
+            "src/vendor/dep.rs",
+            "src/generated/api.rs",
+            "src/docs/keep.log",
+            ".git/config.rs",
+        ] {
+            fs::write(root.join(file), "").unwrap();
+        }
+
+        let options = WalkOptions::new(["**/*.rs", "**/*.log"]).with_exclude(["**/generated"]);
+        assert_eq!(
+            walk(&pal, ".", &options),
+            ["./src/docs/keep.log", "./src/lib.rs"]
+        );
+        // Patterns of the directories above the walked one apply as well
+        assert_eq!(
+            walk(&pal, "src", &options),
+            ["src/docs/keep.log", "src/lib.rs"]
+        );
+        assert_eq!(
+            walk(&pal, "src", &options.with_respect_gitignore(false)),
+            [
+                "src/debug.log",
+                "src/docs/keep.log",
+                "src/lib.rs",
+                "src/vendor/dep.rs"
+            ]
+        );
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_walk_directory_symlinks() {
+        let (temp_dir, pal) = setup_test_dir();
+        let root = temp_dir.path();
+        fs::create_dir_all(root.join("src/nested")).unwrap();
+        fs::create_dir_all(root.join("shared")).unwrap();
+        fs::write(root.join("src/nested/lib.rs"), "").unwrap();
+        fs::write(root.join("shared/util.rs"), "").unwrap();
+        std::os::unix::fs::symlink(root.join("shared"), root.join("src/shared")).unwrap();
+        std::os::unix::fs::symlink(root.join("src"), root.join("src/nested/loop")).unwrap();
+
+        let options = WalkOptions::new(["**/*.rs"]);
+        assert_eq!(walk(&pal, "src", &options), ["src/nested/lib.rs"]);
+
+        // Followed links yield their files, the loop is reported as an error
+        let results: Vec<_> = pal
+            .walk_directory_with_options(
+                &FilePath::from("src"),
+                &options.with_follow_symlinks(true),
+            )
+            .unwrap()
+            .collect();
+        let files: Vec<String> = results
+            .iter()
+            .filter_map(|result| result.as_ref().ok().map(ToString::to_string))
+            .collect();
+        assert_eq!(files, ["src/nested/lib.rs", "src/shared/util.rs"]);
+        assert_eq!(results.iter().filter(|result| result.is_err()).count(), 1);
+    }
+
     #[test]
     fn test_watch_directory() {
         let (temp_dir, pal) = setup_test_dir();
@@ -763,4 +1040,47 @@ mod tests {
         let result = pal.walk_directory(&FilePath::from("."), &invalid_glob);
         assert!(result.is_err());
     }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_captures_stdout_and_stderr() {
+        let (temp_dir, pal) = setup_test_dir();
+        fs::create_dir(temp_dir.path().join("work")).unwrap();
+        let command = CommandSpec::new("sh", FilePath::from("work"), Duration::from_secs(10))
+            .with_args(["-c", "pwd; echo oops >&2; exit 3"]);
+
+        let output = pal.run_command(&command).unwrap();
+
+        assert!(output.stdout.trim_end().ends_with("/work"));
+        assert_eq!(output.stderr, "oops\n");
+        assert_eq!(output.exit_code, Some(3));
+        assert!(!output.success());
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_run_command_timeout() {
+        let (_temp_dir, pal) = setup_test_dir();
+        let command = CommandSpec::new("sleep", FilePath::from(""), Duration::from_millis(50))
+            .with_args(["10"]);
+
+        let started = Instant::now();
+        let error = pal.run_command(&command).unwrap_err();
+
+        assert!(started.elapsed() < Duration::from_secs(5));
+        assert_eq!(error.to_string(), "Command 'sleep 10' timed out after 50ms");
+    }
+
+    #[test]
+    fn test_run_command_not_found() {
+        let (_temp_dir, pal) = setup_test_dir();
+        let command = CommandSpec::new(
+            "hyperlit-no-such-program",
+            FilePath::from(""),
+            Duration::from_secs(1),
+        );
+
+        let result = pal.run_command(&command);
+        assert!(result.is_err());
+    }
 }
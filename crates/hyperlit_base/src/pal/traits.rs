@@ -1,10 +1,13 @@
 use std::io::{Read, Seek, Write};
 use std::sync::Arc;
+use std::time::Duration;
 
 use crate::HyperlitResult;
 
+use super::command::{CommandOutput, CommandSpec};
 use super::file_path::FilePath;
 use super::http::{HttpServerConfig, HttpServerHandle, HttpService};
+use super::walk::WalkOptions;
 
 /* 📖 # What is the Platform Abstraction Layer (PAL)?
 
@@ -87,6 +90,9 @@ pub trait Pal: std::fmt::Debug + Send + Sync + 'static {
     /// Remove a directory and all its contents.
     fn remove_directory_all(&self, path: &FilePath) -> HyperlitResult<()>;
 
+    /// Remove a single file.
+    fn remove_file(&self, path: &FilePath) -> HyperlitResult<()>;
+
     /// Walk a directory tree, yielding paths matching the given glob patterns.
     ///
     /// # Arguments
@@ -94,10 +100,27 @@ pub trait Pal: std::fmt::Debug + Send + Sync + 'static {
     /// * `globs` - Glob patterns to match (e.g., `["*.rs", "!*.bak"]`)
     ///
     /// Returns an iterator of FilePath results that match any of the patterns.
+    /// Entries of a directory are yielded sorted by file name, so the order does
+    /// not depend on the file system and builds are reproducible.
+    ///
+    /// Uses the default [`WalkOptions`], see [`Pal::walk_directory_with_options`].
     fn walk_directory(
         &self,
         path: &FilePath,
         globs: &[String],
+    ) -> HyperlitResult<Box<dyn Iterator<Item = HyperlitResult<FilePath>> + '_>> {
+        self.walk_directory_with_options(path, &WalkOptions::new(globs.iter().cloned()))
+    }
+
+    /// Walk a directory tree, yielding paths selected by `options`.
+    ///
+    /// Yields the files matching any include glob and no exclude glob. Unless
+    /// disabled, paths ignored by `.gitignore` files are skipped. Entries are
+    /// yielded in the same order as by [`Pal::walk_directory`].
+    fn walk_directory_with_options(
+        &self,
+        path: &FilePath,
+        options: &WalkOptions,
     ) -> HyperlitResult<Box<dyn Iterator<Item = HyperlitResult<FilePath>> + '_>>;
 
     /// Watch a directory for file changes.
@@ -129,6 +152,27 @@ pub trait Pal: std::fmt::Debug + Send + Sync + 'static {
         service: Box<dyn HttpService>,
         config: HttpServerConfig,
     ) -> HyperlitResult<HttpServerHandle>;
+
+    /// Run a command and capture its output.
+    ///
+    /// The command is run directly, without a shell. Stdout and stderr are
+    /// captured separately. A command exiting with a non-zero code is not an
+    /// error, its exit code is part of the output.
+    ///
+    /// # Errors
+    /// Returns an error if the command cannot be started or does not finish
+    /// within its timeout (in which case it is killed).
+    fn run_command(&self, command: &CommandSpec) -> HyperlitResult<CommandOutput>;
+
+    /// Send an HTTP HEAD request to an `http` or `https` URL.
+    ///
+    /// Redirects are followed. Returns the status code of the final response,
+    /// a non-2xx status is not an error.
+    ///
+    /// # Errors
+    /// Returns an error if the URL is invalid, the server cannot be reached or
+    /// does not respond within `timeout`.
+    fn http_head(&self, url: &str, timeout: Duration) -> HyperlitResult<u16>;
 }
 
 /* 📖 # Why use Arc<dyn Pal> with PalHandle?
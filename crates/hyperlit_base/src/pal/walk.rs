@@ -0,0 +1,222 @@
+/* 📖 # Why make directory walks gitignore-aware?
+
+Sites are often configured at the repository root, where a walk also finds
+vendored dependencies, build output and other generated files. These are
+almost always listed in `.gitignore` already, so the walk honors the
+`.gitignore` files it passes (and those of the directories above the walked
+directory, up to the PAL root) instead of repeating the same list as exclude
+globs in the configuration. Patterns follow the git semantics: a pattern with a
+slash is relative to the directory of its `.gitignore`, others match at any
+depth, a trailing slash only matches directories, `!` re-includes a path and
+deeper files override shallower ones. Ignored directories are not descended
+into at all, which keeps walks of large repositories fast. The `.git`
+directory itself is skipped as well.
+
+Symbolic links are not followed by default, since a link to a parent directory
+would make the walk loop forever. With `follow_symlinks` they are followed and
+a link pointing back to one of its ancestors is reported as an error instead of
+being descended into.
+*/
+
+use std::path::{Path, PathBuf};
+
+use globset::{GlobBuilder, GlobMatcher};
+
+/// Name of the files holding gitignore patterns.
+pub const GITIGNORE_FILE: &str = ".gitignore";
+
+/// Options controlling which files a directory walk yields.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct WalkOptions {
+    include: Vec<String>,
+    exclude: Vec<String>,
+    respect_gitignore: bool,
+    follow_symlinks: bool,
+}
+
+impl WalkOptions {
+    /// Create walk options yielding files matching any of the `include` globs.
+    ///
+    /// `.gitignore` files are respected and symbolic links are not followed.
+    pub fn new(include: impl IntoIterator<Item = impl Into<String>>) -> Self {
+        Self {
+            include: include.into_iter().map(Into::into).collect(),
+            exclude: Vec::new(),
+            respect_gitignore: true,
+            follow_symlinks: false,
+        }
+    }
+
+    /// Skip files and directories matching any of the `exclude` globs.
+    pub fn with_exclude(mut self, exclude: impl IntoIterator<Item = impl Into<String>>) -> Self {
+        self.exclude = exclude.into_iter().map(Into::into).collect();
+        self
+    }
+
+    /// Set whether paths ignored by `.gitignore` files are skipped (defaults to true).
+    pub fn with_respect_gitignore(mut self, respect_gitignore: bool) -> Self {
+        self.respect_gitignore = respect_gitignore;
+        self
+    }
+
+    /// Set whether symbolic links to directories are followed (defaults to false).
+    pub fn with_follow_symlinks(mut self, follow_symlinks: bool) -> Self {
+        self.follow_symlinks = follow_symlinks;
+        self
+    }
+
+    /// Returns the globs of the files to yield.
+    pub fn include(&self) -> &[String] {
+        &self.include
+    }
+
+    /// Returns the globs of the files and directories to skip.
+    pub fn exclude(&self) -> &[String] {
+        &self.exclude
+    }
+
+    /// Returns true if paths ignored by `.gitignore` files are skipped.
+    pub fn respect_gitignore(&self) -> bool {
+        self.respect_gitignore
+    }
+
+    /// Returns true if symbolic links to directories are followed.
+    pub fn follow_symlinks(&self) -> bool {
+        self.follow_symlinks
+    }
+}
+
+/// One pattern of a `.gitignore` file.
+#[derive(Debug, Clone)]
+struct GitignoreRule {
+    /// Directory of the `.gitignore` file, the pattern is relative to it
+    directory: PathBuf,
+    matcher: GlobMatcher,
+    negated: bool,
+    directory_only: bool,
+}
+
+/// The patterns of all `.gitignore` files seen during a walk.
+#[derive(Debug, Clone, Default)]
+pub(crate) struct GitignoreRules {
+    /// Rules in the order they take effect, later rules override earlier ones
+    rules: Vec<GitignoreRule>,
+}
+
+impl GitignoreRules {
+    /// Add the patterns of the `.gitignore` file in `directory`.
+    ///
+    /// Files must be added from the top down, so deeper files take precedence.
+    /// Invalid patterns are skipped, as git does.
+    pub(crate) fn add_file(&mut self, directory: &Path, content: &str) {
+        for line in content.lines() {
+            let line = line.trim_end();
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            let (negated, pattern) = match line.strip_prefix('!') {
+                Some(pattern) => (true, pattern),
+                None => (false, line.strip_prefix('\\').unwrap_or(line)),
+            };
+            let (directory_only, pattern) = match pattern.strip_suffix('/') {
+                Some(pattern) => (true, pattern),
+                None => (false, pattern),
+            };
+            let glob = if pattern.contains('/') {
+                pattern.trim_start_matches('/').to_string()
+            } else {
+                format!("**/{}", pattern)
+            };
+            let Ok(glob) = GlobBuilder::new(&glob).literal_separator(true).build() else {
+                continue;
+            };
+            self.rules.push(GitignoreRule {
+                directory: directory.to_path_buf(),
+                matcher: glob.compile_matcher(),
+                negated,
+                directory_only,
+            });
+        }
+    }
+
+    /// Returns true if `path` is ignored, both relative to the same root as the added files.
+    pub(crate) fn is_ignored(&self, path: &Path, is_directory: bool) -> bool {
+        let mut ignored = false;
+        for rule in &self.rules {
+            if rule.directory_only && !is_directory {
+                continue;
+            }
+            let Ok(relative) = path.strip_prefix(&rule.directory) else {
+                continue;
+            };
+            if rule.matcher.is_match(relative) {
+                ignored = !rule.negated;
+            }
+        }
+        ignored
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rules(files: &[(&str, &str)]) -> GitignoreRules {
+        let mut rules = GitignoreRules::default();
+        for (directory, content) in files {
+            rules.add_file(Path::new(directory), content);
+        }
+        rules
+    }
+
+    #[test]
+    fn test_gitignore_patterns() {
+        let rules = rules(&[(
+            "",
+            "# build output\ntarget/\n*.log\n!keep.log\n/vendor\ndocs/generated\n\\#notes\n",
+        )]);
+        let ignored =
+            |path: &str, is_directory: bool| rules.is_ignored(Path::new(path), is_directory);
+
+        assert!(ignored("target", true));
+        assert!(ignored("crates/a/target", true));
+        assert!(!ignored("target", false));
+        assert!(ignored("debug.log", false));
+        assert!(ignored("src/debug.log", false));
+        assert!(!ignored("src/keep.log", false));
+        assert!(ignored("vendor", true));
+        assert!(!ignored("src/vendor", true));
+        assert!(ignored("docs/generated", true));
+        assert!(!ignored("src/docs/generated", true));
+        assert!(ignored("#notes", false));
+        assert!(!ignored("src/lib.rs", false));
+    }
+
+    #[test]
+    fn test_nested_gitignore_files() {
+        let rules = rules(&[("", "*.md\n"), ("docs", "!guide.md\n/drafts\n")]);
+
+        assert!(rules.is_ignored(Path::new("README.md"), false));
+        assert!(!rules.is_ignored(Path::new("docs/guide.md"), false));
+        assert!(rules.is_ignored(Path::new("src/guide.md"), false));
+        assert!(rules.is_ignored(Path::new("docs/drafts"), true));
+        assert!(!rules.is_ignored(Path::new("drafts"), true));
+    }
+
+    #[test]
+    fn test_walk_options() {
+        let options = WalkOptions::new(["**/*.rs"]);
+        assert_eq!(options.include(), ["**/*.rs"]);
+        assert!(options.exclude().is_empty());
+        assert!(options.respect_gitignore());
+        assert!(!options.follow_symlinks());
+
+        let options = options
+            .with_exclude(["vendor/**"])
+            .with_respect_gitignore(false)
+            .with_follow_symlinks(true);
+        assert_eq!(options.exclude(), ["vendor/**"]);
+        assert!(!options.respect_gitignore());
+        assert!(options.follow_symlinks());
+    }
+}
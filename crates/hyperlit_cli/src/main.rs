@@ -1,26 +1,53 @@
 /* 📖 # Why is the CLI minimal and hardcoded?
 
-The CLI is intentionally kept minimal with no argument parsing or configuration
-options. This approach:
+The CLI is intentionally kept minimal with no argument parsing library and
+hardly any options beyond an optional subcommand. This approach:
 
 1. **Reduces complexity**: No clap or similar dependency needed
 2. **Simplifies testing**: Just run `hyperlit` in a directory with hyperlit.toml
-3. **Clear conventions**: Always looks for `hyperlit.toml` in current directory
+3. **Clear conventions**: Looks for `hyperlit.toml` (or `hyperlit.yaml`) in the
+   current directory and its parents, see `hyperlit_engine::config`
 4. **Fast iteration**: Can add arguments later when use cases emerge
 
 The workflow is straightforward:
-1. Change to your project directory
-2. Ensure `hyperlit.toml` exists
+1. Change to your project directory or any directory below it
+2. Ensure `hyperlit.toml` exists in the project directory
 3. Run `hyperlit`
 4. Documents are extracted and stored
 5. HTTP server starts on port 3333 to serve the API
 
+Running `hyperlit build` instead writes the documents as a static site to the
+configured `output_directory` and exits, as HTML or, with `output_format =
+"markdown"`, as Markdown. HTML pages of unchanged files are taken from the build
+cache in `cache_directory` instead of being rendered again. `hyperlit build
+--diff` renders the site without writing it and prints a unified diff of every
+file that would change in the output directory, see `hyperlit_engine::diff`.
+`hyperlit watch` writes the site as well, then keeps running and rebuilds the
+pages of changed files.
+`hyperlit check-links` reports broken cross-references and links (and, with
+`--external`, unreachable `http(s)` URLs) instead, for use in CI. `hyperlit
+check` runs all passes of a build plus the link check without writing anything
+and reports every problem with a summary, failing on errors (and, with
+`--strict`, on warnings).
+
+Three options work with every command and override the configuration file:
+`--config FILE` loads `FILE` instead of looking for one, `--output DIR` sets
+the `output_directory` and `--set KEY=VALUE` sets any other key, e.g. `--set
+exec.allow_exec=true`. Paths in the configuration stay relative to the
+directory containing it, the project root, wherever hyperlit is run from.
+
+Problems found while extracting and rendering are logged to stderr as they are
+found, one per line with their source location, see `hyperlit_engine::logger`.
+
 Exit codes:
-- 0: Success (documents extracted and stored, server running)
-- 1: Error (config not found, parsing failed, or no documents stored)
+- 0: Success (documents extracted and stored, server running or site written)
+- 1: Error (config not found, parsing failed, no documents stored, broken links
+  or failed checks found, or the output directory differs from the site with
+  `--diff`)
 */
 
 use std::env;
+use std::path::{Path, PathBuf};
 use std::process;
 use std::thread;
 use std::time::Duration;
@@ -30,31 +57,195 @@ use hyperlit_base::tracing::init_tracing;
 use hyperlit_base::{FilePath, PalHandle, RealPal};
 use hyperlit_engine::store::{InMemoryStore, StoreHandle};
 use hyperlit_engine::{
-    extract_documents, load_config, scan_files, ApiService, FileWatcher, FileWatcherConfig,
-    SiteInfo,
+    check_links_with_options, check_site, copy_assets, diff_site, discover_config,
+    extract_documents_with_options, load_config_with_options, scan_files, write_site, ApiService,
+    BuildCache, Config, ConfigOptions, ExtractionOptions, FileChange, FileWatcher,
+    FileWatcherConfig, LinkCheckOptions, OutputFormat, RenderOptions, SiteInfo, StderrLogger,
+    SyntectHighlighter, Theme,
 };
 
+/// Command line synopsis, printed for invalid arguments.
+const USAGE: &str = "Usage: hyperlit [--config FILE] [--output DIR] [--set KEY=VALUE]... \
+     [build [--diff]|watch|check-links [--external]|check [--strict]]";
+
+/// What the CLI does after extracting the documents.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Command {
+    /// Serve the documents over HTTP and watch for changes
+    Serve,
+    /// Write the static site and exit
+    Build,
+    /// Print how writing the static site would change the output directory
+    /// and exit, writing nothing
+    BuildDiff,
+    /// Write the static site and rebuild it when files change
+    Watch,
+    /// Report broken links and exit, checking external URLs if `external` is set
+    CheckLinks { external: bool },
+    /// Report all problems a build would run into and exit, failing on
+    /// warnings as well if `strict` is set
+    Check { strict: bool },
+}
+
+/// Options accepted with every command, overriding the configuration file.
+#[derive(Debug, Default)]
+struct GlobalOptions {
+    /// Configuration file given with `--config`, looked for if None
+    config: Option<String>,
+    /// Keys set with `--output` and `--set`, in the order given
+    overrides: Vec<(String, String)>,
+}
+
+/// Split the global options off the arguments, returning the remaining ones.
+fn parse_global_options(args: &[String]) -> Result<(GlobalOptions, Vec<&str>), String> {
+    let mut options = GlobalOptions::default();
+    let mut rest = Vec::new();
+    let mut args = args.iter().map(String::as_str);
+    while let Some(arg) = args.next() {
+        if !matches!(arg, "--config" | "--output" | "--set") {
+            rest.push(arg);
+            continue;
+        }
+        let value = args
+            .next()
+            .ok_or_else(|| format!("Missing value for '{}'", arg))?;
+        match arg {
+            "--config" => options.config = Some(value.to_string()),
+            "--output" => options
+                .overrides
+                .push(("output_directory".to_string(), value.to_string())),
+            _ => {
+                let (key, value) = value
+                    .split_once('=')
+                    .ok_or_else(|| format!("Expected KEY=VALUE for '--set', got '{}'", value))?;
+                options.overrides.push((key.to_string(), value.to_string()));
+            }
+        }
+    }
+    Ok((options, rest))
+}
+
+/// Returns the configuration file in `directory` or its nearest parent
+/// directory containing one, as an absolute path.
+fn find_config(directory: &Path) -> Option<PathBuf> {
+    let root = directory.ancestors().last()?;
+    let relative = directory.strip_prefix(root).ok()?;
+    let pal = PalHandle::new(RealPal::new(root.to_path_buf()));
+    match discover_config(&pal, &FilePath::from(relative)) {
+        Ok(found) => found.map(|path| root.join(path.as_path())),
+        Err(e) => {
+            eprintln!("Error: Failed to look for a configuration file: {}", e);
+            process::exit(1);
+        }
+    }
+}
+
+/// Render options for the static site, highlighting code blocks with syntect.
+///
+/// Exits if the configured theme cannot be loaded.
+fn render_options(config: &Config, pal: &PalHandle) -> RenderOptions {
+    let options = RenderOptions::from_config(config)
+        .with_highlighter(SyntectHighlighter::new())
+        .with_pal(pal.clone());
+    match &config.theme_directory {
+        Some(directory) => match Theme::load(pal, &FilePath::from(directory.as_str())) {
+            Ok(theme) => options.with_theme(theme),
+            Err(e) => {
+                eprintln!("Error: Failed to load theme from {}: {}", directory, e);
+                process::exit(1);
+            }
+        },
+        None => options,
+    }
+}
+
 fn main() {
     init_tracing().unwrap();
 
+    let args: Vec<String> = env::args().skip(1).collect();
+    let (global_options, args) = parse_global_options(&args).unwrap_or_else(|e| {
+        eprintln!("Error: {}", e);
+        eprintln!("{}", USAGE);
+        process::exit(1);
+    });
+    let command = match args.as_slice() {
+        [] => Command::Serve,
+        ["build"] => Command::Build,
+        ["build", "--diff"] => Command::BuildDiff,
+        ["watch"] => Command::Watch,
+        ["check-links"] => Command::CheckLinks { external: false },
+        ["check-links", "--external"] => Command::CheckLinks { external: true },
+        ["check"] => Command::Check { strict: false },
+        ["check", "--strict"] => Command::Check { strict: true },
+        _ => {
+            eprintln!("Error: Unknown command '{}'", args.join(" "));
+            eprintln!("{}", USAGE);
+            process::exit(1);
+        }
+    };
+
     let current_dir = env::current_dir().unwrap_or_else(|e| {
         eprintln!("Error: Failed to get current directory: {}", e);
         process::exit(1);
     });
 
-    let pal = PalHandle::new(RealPal::new(current_dir.clone()));
+    let config_file = match &global_options.config {
+        Some(path) => current_dir.join(path),
+        None => find_config(&current_dir).unwrap_or_else(|| {
+            eprintln!(
+                "Error: No hyperlit.toml found in {} or its parent directories",
+                current_dir.display()
+            );
+            process::exit(1);
+        }),
+    };
+    let project_dir = config_file.parent().unwrap_or(&current_dir).to_path_buf();
+    let pal = PalHandle::new(RealPal::new(project_dir));
 
-    let config_path = FilePath::from("hyperlit.toml");
-    let config = match load_config(&pal, &config_path) {
+    let mut config_options = ConfigOptions::new().with_logger(StderrLogger::new());
+    for (key, value) in &global_options.overrides {
+        config_options = config_options.with_override(key.as_str(), value.as_str());
+    }
+    let config_path = FilePath::from(
+        config_file
+            .file_name()
+            .map(Path::new)
+            .unwrap_or(&config_file),
+    );
+    let config = match load_config_with_options(&pal, &config_path, &config_options) {
         Ok(config) => config,
         Err(e) => {
-            eprintln!("Error: Failed to load config from hyperlit.toml: {}", e);
+            eprintln!(
+                "Error: Failed to load config from {}: {}",
+                config_file.display(),
+                e
+            );
             process::exit(1);
         }
     };
 
     println!("Configuration loaded: {}", config.title);
 
+    if let Command::Check { strict } = command {
+        let link_options = LinkCheckOptions::new().with_pal(pal.clone());
+        let report = match check_site(&pal, &config, &render_options(&config, &pal), &link_options)
+        {
+            Ok(report) => report,
+            Err(e) => {
+                eprintln!("Error: Failed to check site: {}", e);
+                process::exit(1);
+            }
+        };
+        if !report.problems.is_empty() {
+            eprintln!("\nProblems:");
+            for problem in &report.problems {
+                eprintln!("  - {}", problem);
+            }
+        }
+        println!("{}", report.summary());
+        process::exit(if report.failed(strict) { 1 } else { 0 });
+    }
+
     let scan_result = match scan_files(&pal, &config) {
         Ok(result) => result,
         Err(e) => {
@@ -77,23 +268,119 @@ fn main() {
         process::exit(0);
     }
 
-    let extraction = match extract_documents(&pal, &scan_result.files) {
-        Ok(result) => result,
-        Err(e) => {
-            eprintln!("Error: Failed to extract documents: {}", e);
-            process::exit(1);
-        }
-    };
+    let extraction_options =
+        ExtractionOptions::from_config(&config).with_logger(StderrLogger::new());
+    let extraction =
+        match extract_documents_with_options(&pal, &scan_result.files, &extraction_options) {
+            Ok(result) => result,
+            Err(e) => {
+                eprintln!("Error: Failed to extract documents: {}", e);
+                process::exit(1);
+            }
+        };
 
+    // The problems themselves have been logged as they were found
     if !extraction.errors.is_empty() {
-        eprintln!("\nWarnings during document extraction:");
-        for error in &extraction.errors {
-            eprintln!("  - {}: {:?}", error.file_path, error.error);
-        }
+        eprintln!(
+            "Found {} problems during document extraction",
+            extraction.errors.len()
+        );
     }
 
     println!("Extracted {} documents", extraction.documents.len());
 
+    if let Command::CheckLinks { external } = command {
+        let options = LinkCheckOptions::new()
+            .with_pal(pal.clone())
+            .with_check_external(external)
+            .with_render_options(render_options(&config, &pal));
+        let problems = check_links_with_options(&extraction.documents, &options);
+        if problems.is_empty() {
+            println!("No broken links found");
+            process::exit(0);
+        }
+        eprintln!("\nBroken links:");
+        for problem in &problems {
+            eprintln!("  - {}", problem);
+        }
+        eprintln!("Found {} broken links", problems.len());
+        process::exit(1);
+    }
+
+    if command != Command::Serve {
+        let output_directory = config.output_directory();
+        let cache_directory = config.cache_directory();
+        let mut cache = BuildCache::load(&pal, &cache_directory).unwrap_or_else(|e| {
+            eprintln!("Warning: Ignoring build cache: {}", e);
+            BuildCache::new(&cache_directory)
+        });
+        let render_options = render_options(&config, &pal).with_logger(StderrLogger::new());
+        let output_format = config.output_format.unwrap_or_default();
+        let rendered = match output_format {
+            OutputFormat::Html => cache.render_site(&extraction.documents, &render_options),
+            format => format
+                .renderer()
+                .render_site(&extraction.documents, &render_options),
+        };
+        if !rendered.warnings.is_empty() {
+            eprintln!(
+                "Found {} problems during rendering",
+                rendered.warnings.len()
+            );
+        }
+        if command == Command::BuildDiff {
+            let diffs = diff_site(&pal, &output_directory, &rendered).unwrap_or_else(|e| {
+                eprintln!(
+                    "Error: Failed to compare site with {}: {}",
+                    output_directory, e
+                );
+                process::exit(1);
+            });
+            for diff in &diffs {
+                print!("{}", diff);
+            }
+            let count =
+                |change: FileChange| diffs.iter().filter(|diff| diff.change == change).count();
+            println!(
+                "\n{} created, {} modified, {} deleted, {} unchanged, {} untracked files in {}",
+                count(FileChange::Created),
+                count(FileChange::Modified),
+                count(FileChange::Deleted),
+                count(FileChange::Unchanged),
+                count(FileChange::Untracked),
+                output_directory
+            );
+            let changed = diffs.iter().any(|diff| diff.is_changed());
+            process::exit(if changed { 1 } else { 0 });
+        }
+        if let Err(e) = write_site(&pal, &output_directory, &rendered.files) {
+            eprintln!("Error: Failed to write site: {}", e);
+            process::exit(1);
+        }
+        if let Err(e) = copy_assets(&pal, &output_directory, &rendered.assets) {
+            eprintln!("Error: Failed to copy assets: {}", e);
+            process::exit(1);
+        }
+        println!(
+            "Wrote {} files to {}",
+            rendered.files.len(),
+            output_directory
+        );
+        if !rendered.assets.is_empty() {
+            println!("Copied {} assets", rendered.assets.len());
+        }
+        // Only HTML pages are rendered through the cache
+        if output_format == OutputFormat::Html {
+            println!("Reused {} unchanged pages from cache", cache.reused_pages());
+        }
+        if let Err(e) = cache.save(&pal) {
+            eprintln!("Warning: Failed to save build cache: {}", e);
+        }
+        if command == Command::Build {
+            process::exit(0);
+        }
+    }
+
     let store = StoreHandle::new(InMemoryStore::with_capacity(extraction.documents.len()));
 
     let mut success_count = 0;
@@ -121,6 +408,38 @@ fn main() {
         process::exit(1);
     }
 
+    if command == Command::Watch {
+        let output_directory = config.output_directory();
+        let watcher_config = FileWatcherConfig::new(
+            config.clone(),
+            pal.clone(),
+            store.clone(),
+            config.watch_debounce(),
+        )
+        .with_output_directory(output_directory.clone(), render_options(&config, &pal))
+        .with_weave_listener(move |update| {
+            println!("Rebuilt {}", update.source);
+            for page in &update.written {
+                println!("  + {}/{}", output_directory, page);
+            }
+            for page in &update.removed {
+                println!("  - {}/{}", output_directory, page);
+            }
+            for warning in &update.warnings {
+                eprintln!("  Warning: {}", warning);
+            }
+        });
+        if let Err(e) = FileWatcher::start(watcher_config) {
+            eprintln!("Error: Failed to start file watcher: {}", e);
+            process::exit(1);
+        }
+
+        println!("\nWatching for changes, press Ctrl+C to stop");
+        loop {
+            thread::sleep(Duration::from_secs(1));
+        }
+    }
+
     // Create SSE registry for hot-reload notifications
     let sse_registry = hyperlit_engine::api::SseRegistry::new();
 
@@ -162,7 +481,7 @@ fn main() {
         config.clone(),
         pal.clone(),
         store.clone(),
-        Duration::from_millis(100),
+        config.watch_debounce(),
     )
     .with_sse_registry(sse_registry);
 
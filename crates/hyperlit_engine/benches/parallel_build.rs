@@ -0,0 +1,71 @@
+//! Compares sequential and parallel builds of a site with 200 source files.
+//!
+//! Run with `cargo bench -p hyperlit_engine --bench parallel_build`.
+
+use std::time::{Duration, Instant};
+
+use hyperlit_base::pal::MockPal;
+use hyperlit_base::{FilePath, PalHandle};
+use hyperlit_engine::{
+    ExtractionOptions, RenderOptions, SyntectHighlighter, default_concurrency,
+    extract_documents_with_options, render_site,
+};
+
+const FILE_COUNT: usize = 200;
+const ITERATIONS: u32 = 5;
+
+/// Create source files with several documented functions and code examples each.
+fn create_files(mock_pal: &MockPal) -> Vec<FilePath> {
+    (0..FILE_COUNT)
+        .map(|i| {
+            let path = FilePath::from(format!("src/module{i}.rs"));
+            let mut content = String::new();
+            for j in 0..10 {
+                content.push_str(&format!(
+                    "/* 📖 # Why does step {j} of module {i} exist?\n\n\
+                     Step {j} prepares the input for [[step_{i}_{next}]].\n\n\
+                     ```rust\nlet value = step_{i}_{j}(input)?;\nassert!(value > 0);\n```\n*/\n\
+                     fn step_{i}_{j}(input: u32) -> Result<u32, Error> {{\n    Ok(input + {j})\n}}\n\n",
+                    next = (j + 1) % 10,
+                ));
+            }
+            mock_pal.add_file(path.clone(), content.into_bytes());
+            path
+        })
+        .collect()
+}
+
+/// Returns the average duration of a full extraction and render.
+fn measure(pal: &PalHandle, files: &[FilePath], concurrency: usize) -> Duration {
+    let extraction_options = ExtractionOptions::new().with_concurrency(concurrency);
+    let render_options = RenderOptions::new()
+        .with_highlighter(SyntectHighlighter::new())
+        .with_concurrency(concurrency);
+    let start = Instant::now();
+    for _ in 0..ITERATIONS {
+        let extraction = extract_documents_with_options(pal, files, &extraction_options)
+            .expect("extraction should succeed");
+        assert!(extraction.errors.is_empty());
+        let rendered = render_site(&extraction.documents, &render_options);
+        assert_eq!(rendered.files.len(), FILE_COUNT + 2);
+    }
+    start.elapsed() / ITERATIONS
+}
+
+fn main() {
+    let mock_pal = MockPal::new();
+    let files = create_files(&mock_pal);
+    let pal = PalHandle::new(mock_pal);
+
+    let workers = default_concurrency();
+    let sequential = measure(&pal, &files, 1);
+    let parallel = measure(&pal, &files, workers);
+
+    println!("Building {FILE_COUNT} files (average of {ITERATIONS} runs)");
+    println!("  1 worker:   {sequential:>10.2?}");
+    println!("  {workers} workers: {parallel:>10.2?}");
+    println!(
+        "  speedup:    {:>9.2}x",
+        sequential.as_secs_f64() / parallel.as_secs_f64()
+    );
+}
@@ -387,7 +387,9 @@ struct DocumentResponse {
     content: String,
     source: SourceResponse,
     #[serde(skip_serializing_if = "Option::is_none")]
-    metadata: Option<std::collections::HashMap<String, String>>,
+    metadata: Option<std::collections::BTreeMap<String, String>>,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    preamble: bool,
 }
 
 /// API response structure for search results.
@@ -587,7 +589,7 @@ impl ApiService {
         });
 
         let metadata = doc.metadata().map(|m| {
-            let mut map = std::collections::HashMap::new();
+            let mut map = std::collections::BTreeMap::new();
             for (key, value) in m.iter() {
                 map.insert(key.to_string(), value.to_string());
             }
@@ -605,6 +607,7 @@ impl ApiService {
                 byte_range,
             },
             metadata,
+            preamble: doc.is_preamble(),
         }
     }
 
@@ -0,0 +1,274 @@
+/* 📖 # Why copy assets into the output?
+
+Doc comments reference images stored next to the sources, e.g.
+`![flow](./img/flow.png)` in `src/server/handler.rs`. The page of the file is
+written to the output directory, where the relative reference points to
+nothing. While rendering, relative image references and relative links to files
+with an asset extension (see `ASSET_EXTENSIONS`) are therefore resolved against
+the directory of their source file. The referenced file is copied into the
+output directory at its path in the source tree (`src/server/img/flow.png`),
+and the reference is rewritten relative to the page, so it keeps working
+wherever the path mapper places the page. An asset referenced by several pages
+is copied once.
+
+A referenced file that does not exist is reported as a warning at the line of
+the reference, the reference is left as it is. So are references leaving the
+project directory (`../../shared/logo.png`), they have no place in the output.
+URLs, absolute paths and anchors are never touched. Assets are read through the
+PAL of the render options, without one all references are left as written.
+
+`OutputMode::SingleFile` and `OutputMode::Flat` embed local images as `data:`
+URIs instead (see `crate::flat`), linked assets are copied in every mode. The
+Markdown renderer copies documents verbatim and keeps references as written.
+
+Like included files, referenced assets are dependencies of their documents:
+`hyperlit watch` watches them, and a change re-renders the pages referencing
+the asset, which copies it again.
+*/
+
+use std::io::{Read, Write};
+
+use percent_encoding::{AsciiSet, CONTROLS, percent_decode_str, utf8_percent_encode};
+use pulldown_cmark::{Event, Parser, Tag};
+
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle, ResultExt};
+
+use crate::footnote::PARSER_OPTIONS;
+use crate::include::resolve_relative_path;
+use crate::render::relative_root;
+use crate::{Document, OutputMode, RenderOptions};
+
+/// Extensions of the files that are copied into the output when a document links to them.
+///
+/// Images are copied whatever their extension.
+pub const ASSET_EXTENSIONS: &[&str] = &[
+    "png", "jpg", "jpeg", "gif", "svg", "webp", "avif", "ico", "bmp", "pdf", "mp4", "webm", "mp3",
+    "zip", "csv",
+];
+
+/// Characters escaped in the paths of rewritten references.
+const PATH_ESCAPES: &AsciiSet = &CONTROLS
+    .add(b' ')
+    .add(b'"')
+    .add(b'#')
+    .add(b'%')
+    .add(b'<')
+    .add(b'>')
+    .add(b'?')
+    .add(b'`');
+
+/// A file of the source tree copied into the output directory.
+#[derive(Debug, Clone, PartialEq, Eq, Hash)]
+pub struct Asset {
+    /// Path of the file in the source tree
+    pub source: FilePath,
+    /// Path relative to the output directory
+    pub path: FilePath,
+}
+
+/// Returns the file a reference in the document of `source_path` points to, if
+/// it is an asset, and the query or fragment of the reference.
+///
+/// `image` is true for image sources, which are assets whatever their extension.
+pub(crate) fn asset_reference<'a>(
+    source_path: &FilePath,
+    url: &'a str,
+    image: bool,
+) -> Option<(FilePath, &'a str)> {
+    if url.is_empty() || url.contains(':') || url.starts_with('/') || url.starts_with('#') {
+        return None;
+    }
+    let end = url.find(['?', '#']).unwrap_or(url.len());
+    let (path, suffix) = url.split_at(end);
+    let file_path =
+        resolve_relative_path(source_path, &percent_decode_str(path).decode_utf8_lossy());
+    let extension = file_path
+        .as_relative()
+        .extension()
+        .map(str::to_ascii_lowercase)
+        .unwrap_or_default();
+    let is_asset = image || ASSET_EXTENSIONS.contains(&extension.as_str());
+    (is_asset && !path.is_empty()).then_some((file_path, suffix))
+}
+
+/// Rewrite an asset reference of `page` to the copy of the asset in the output.
+///
+/// Returns the new URL and the asset to copy, None if `url` is no asset
+/// reference or there is no PAL, and the reason if the asset cannot be copied.
+pub(crate) fn copy_asset_reference(
+    page: &FilePath,
+    source_path: &FilePath,
+    url: &str,
+    image: bool,
+    options: &RenderOptions,
+) -> Result<Option<(String, Asset)>, String> {
+    let Some(pal) = options.pal() else {
+        return Ok(None);
+    };
+    let Some((file_path, suffix)) = asset_reference(source_path, url, image) else {
+        return Ok(None);
+    };
+    if file_path.as_relative().as_str().starts_with("..") {
+        return Err("outside of the project directory".to_string());
+    }
+    match pal.file_exists(&file_path) {
+        Ok(true) => {}
+        Ok(false) => return Err(format!("File not found: {}", file_path)),
+        Err(e) => return Err(e.to_string()),
+    }
+    // All pages are sections of `index.html` in the single file
+    let root = match options.output_mode() {
+        OutputMode::SingleFile => String::new(),
+        OutputMode::MultiFile | OutputMode::Flat => relative_root(page),
+    };
+    let path = utf8_percent_encode(file_path.as_relative().as_str(), PATH_ESCAPES);
+    let href = format!("{}{}{}", root, path, suffix);
+    let asset = Asset {
+        source: file_path.clone(),
+        path: file_path,
+    };
+    Ok(Some((href, asset)))
+}
+
+/// Returns the assets referenced by a document, in the order of the references.
+///
+/// Missing files are included, so they are picked up once they are created.
+pub fn referenced_assets(document: &Document) -> Vec<FilePath> {
+    let source_path = document.source().file_path();
+    let mut assets = Vec::new();
+    for event in Parser::new_ext(document.content(), PARSER_OPTIONS) {
+        let (url, image) = match &event {
+            Event::Start(Tag::Image { dest_url, .. }) => (dest_url, true),
+            Event::Start(Tag::Link { dest_url, .. }) => (dest_url, false),
+            _ => continue,
+        };
+        if let Some((file_path, _)) = asset_reference(source_path, url, image)
+            && !assets.contains(&file_path)
+        {
+            assets.push(file_path);
+        }
+    }
+    assets
+}
+
+/// Sort assets by output path and remove duplicates.
+pub(crate) fn dedup_assets(assets: &mut Vec<Asset>) {
+    assets.sort_by(|a, b| a.path.as_relative().cmp(b.path.as_relative()));
+    assets.dedup_by(|a, b| a.path == b.path);
+}
+
+/// Copy assets into `output_directory`, creating directories as needed.
+pub fn copy_assets(
+    pal: &PalHandle,
+    output_directory: &FilePath,
+    assets: &[Asset],
+) -> HyperlitResult<()> {
+    for asset in assets {
+        let path = FilePath::from(
+            output_directory
+                .as_relative()
+                .join(asset.path.as_relative()),
+        );
+        let copy = || -> HyperlitResult<()> {
+            let mut data = Vec::new();
+            pal.read_file(&asset.source)?.read_to_end(&mut data)?;
+            if let Some(parent) = path.as_relative().parent() {
+                pal.create_directory_all(&FilePath::from(parent))?;
+            }
+            let mut writer = pal.create_file(&path)?;
+            writer.write_all(&data)?;
+            writer.flush()?;
+            Ok(())
+        };
+        copy().with_context(|| format!("Failed to copy asset '{}' to '{}'", asset.source, path))?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use hyperlit_base::pal::MockPal;
+    use std::collections::HashSet;
+
+    #[test]
+    fn test_asset_reference() {
+        let source_path = FilePath::from("src/server/handler.rs");
+        let reference = |url: &str, image: bool| {
+            asset_reference(&source_path, url, image)
+                .map(|(file_path, suffix)| format!("{}{}", file_path, suffix))
+        };
+        assert_eq!(
+            reference("./img/flow.png", true),
+            Some("src/server/img/flow.png".to_string())
+        );
+        assert_eq!(
+            reference("../spec%20v2.PDF#page=3", false),
+            Some("src/spec v2.PDF#page=3".to_string())
+        );
+        assert_eq!(
+            reference("diagram", true),
+            Some("src/server/diagram".to_string())
+        );
+        assert_eq!(reference("other.md", false), None);
+        assert_eq!(reference("https://example.com/a.png", true), None);
+        assert_eq!(reference("/logo.png", true), None);
+        assert_eq!(reference("#setup", false), None);
+        assert_eq!(reference("?query", true), None);
+    }
+
+    #[test]
+    fn test_referenced_assets() {
+        let source = DocumentSource::new(SourceType::MarkdownFile, FilePath::from("docs/a.md"), 1);
+        let document = Document::new(
+            "A".to_string(),
+            "# A\n\n![flow](img/flow.png) and [spec](spec.pdf), [B](b.md), ![flow](img/flow.png)\n"
+                .to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        );
+        assert_eq!(
+            referenced_assets(&document),
+            [
+                FilePath::from("docs/img/flow.png"),
+                FilePath::from("docs/spec.pdf")
+            ]
+        );
+    }
+
+    #[test]
+    fn test_copy_assets() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("docs/img/flow.png"), vec![0x89, 0x50, 0x4e]);
+        let pal = PalHandle::new(mock_pal);
+        let asset = Asset {
+            source: FilePath::from("docs/img/flow.png"),
+            path: FilePath::from("docs/img/flow.png"),
+        };
+        copy_assets(
+            &pal,
+            &FilePath::from("output"),
+            std::slice::from_ref(&asset),
+        )
+        .unwrap();
+        let mut data = Vec::new();
+        pal.read_file(&FilePath::from("output/docs/img/flow.png"))
+            .unwrap()
+            .read_to_end(&mut data)
+            .unwrap();
+        assert_eq!(data, [0x89, 0x50, 0x4e]);
+
+        let missing = Asset {
+            source: FilePath::from("docs/missing.png"),
+            path: FilePath::from("docs/missing.png"),
+        };
+        let error = copy_assets(&pal, &FilePath::from("output"), &[missing]).unwrap_err();
+        assert!(
+            error.to_string().starts_with(
+                "Failed to copy asset 'docs/missing.png' to 'output/docs/missing.png'"
+            )
+        );
+    }
+}
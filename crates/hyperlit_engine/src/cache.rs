@@ -0,0 +1,615 @@
+/* 📖 # Why cache rendered pages between builds?
+
+Rendering is the expensive part of a build: every page is parsed as markdown and
+its code blocks are highlighted. Most builds change a handful of files, so the
+build cache keeps the rendered output of every page together with a hash of
+everything the page was rendered from, and reuses the output when the hash is
+unchanged.
+
+A page does not only depend on its own source file. Heading anchors are unique
+across the whole site and `[[name]]` references link into other pages, so the
+hash also covers the anchors of the page's documents, the current targets of
+all names it references, the content of included files and the pages linked as
+previous and next. A change elsewhere that moves one of these re-renders
+the page; every other change leaves it alone. Extraction still runs for all
+files, since document IDs and the table of contents span the whole site. In the
+output modes that embed images into the pages, the hash covers the bytes of
+the referenced images as well, since editing an image changes the page.
+
+The cache is a single JSON file. It records the hyperlit version and the render
+options it was built with, and is discarded as a whole when either differs, so
+an upgrade or a new theme never serves stale output. The content hash is a
+64-bit FNV-1a hash: it is stable across Rust versions and platforms (unlike
+`DefaultHasher`) and needs no additional dependency.
+*/
+
+use std::collections::BTreeMap;
+use std::io::{Read, Write};
+
+use serde::{Deserialize, Serialize};
+
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle, ResultExt, err};
+
+use crate::asset::{Asset, dedup_assets, referenced_assets};
+use crate::parallel::parallel_map;
+use crate::render::{render_site_files, site_documents, site_manifest};
+use crate::search_index::render_search_index;
+use crate::xref::{TextPart, split_references};
+use crate::{
+    Document, OutputMode, RenderOptions, RenderResult, RenderWarning, RenderedFile, SiteMap,
+    render_file_page, render_site,
+};
+
+/// Name of the cache file in the cache directory.
+pub const CACHE_FILE: &str = "build-cache.json";
+
+/// Version of hyperlit that wrote a cache, cache files of other versions are discarded.
+const HYPERLIT_VERSION: &str = env!("CARGO_PKG_VERSION");
+
+/// Rendered pages from previous builds, keyed by source file.
+///
+/// Load the cache with [`BuildCache::load`], render through
+/// [`BuildCache::render_site`] and store it again with [`BuildCache::save`].
+#[derive(Debug, Clone)]
+pub struct BuildCache {
+    path: FilePath,
+    data: CacheData,
+    reused_pages: usize,
+}
+
+/// On-disk format of the cache file.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+struct CacheData {
+    version: String,
+    render_options: String,
+    /// Entries by source file path
+    entries: BTreeMap<String, CacheEntry>,
+}
+
+/// The rendered output of one source file.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+struct CacheEntry {
+    content_hash: String,
+    files: Vec<CachedFile>,
+    warnings: Vec<CachedWarning>,
+    #[serde(default)]
+    assets: Vec<CachedAsset>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+struct CachedFile {
+    path: String,
+    content: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+struct CachedWarning {
+    line: usize,
+    message: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+struct CachedAsset {
+    source: String,
+    path: String,
+}
+
+impl BuildCache {
+    /// Create an empty cache stored in `cache_directory`.
+    pub fn new(cache_directory: &FilePath) -> Self {
+        Self {
+            path: FilePath::from(cache_directory.as_relative().join(CACHE_FILE)),
+            data: CacheData::default(),
+            reused_pages: 0,
+        }
+    }
+
+    /// Load the cache stored in `cache_directory`.
+    ///
+    /// Returns an empty cache if the directory contains no cache file yet.
+    ///
+    /// # Errors
+    /// Returns an error if the cache file cannot be read or is not a valid cache.
+    pub fn load(pal: &PalHandle, cache_directory: &FilePath) -> HyperlitResult<Self> {
+        let mut cache = Self::new(cache_directory);
+        if !pal.file_exists(&cache.path)? {
+            return Ok(cache);
+        }
+        let content = pal
+            .read_file_to_string(&cache.path)
+            .with_context(|| format!("Failed to read build cache '{}'", cache.path))?;
+        cache.data = serde_json::from_str(&content)
+            .map_err(|e| err!("Invalid build cache '{}': {}", cache.path, e))?;
+        Ok(cache)
+    }
+
+    /// Write the cache to its cache directory, creating the directory as needed.
+    pub fn save(&self, pal: &PalHandle) -> HyperlitResult<()> {
+        let content = serde_json::to_string(&self.data)
+            .map_err(|e| err!("Failed to serialize build cache: {}", e))?;
+        let write = || -> HyperlitResult<()> {
+            if let Some(parent) = self.path.as_relative().parent() {
+                pal.create_directory_all(&FilePath::from(parent))?;
+            }
+            let mut writer = pal.create_file(&self.path)?;
+            writer.write_all(content.as_bytes())?;
+            writer.flush()?;
+            Ok(())
+        };
+        write().with_context(|| format!("Failed to write build cache '{}'", self.path))
+    }
+
+    /// Render all documents to a static site, reusing cached pages where possible.
+    ///
+    /// The result is the same as [`render_site`](crate::render_site). Afterwards
+    /// the cache holds exactly the pages of this site, entries of removed files
+    /// are dropped. With [`OutputMode::SingleFile`] the site is always rendered
+    /// from scratch.
+    pub fn render_site(&mut self, documents: &[Document], options: &RenderOptions) -> RenderResult {
+        if options.output_mode() == OutputMode::SingleFile {
+            // All pages end up in one file, so there is nothing to reuse
+            self.reused_pages = 0;
+            return render_site(documents, options);
+        }
+        let render_options = options.cache_key();
+        let mut previous = std::mem::take(&mut self.data.entries);
+        if self.data.version != HYPERLIT_VERSION || self.data.render_options != render_options {
+            previous.clear();
+            self.data.version = HYPERLIT_VERSION.to_string();
+            self.data.render_options = render_options;
+        }
+
+        let (documents, warnings) = site_documents(documents, options);
+        let site_map = SiteMap::build_with_options(&documents, options);
+        let groups = site_map.pages(&documents);
+        let mut result = RenderResult {
+            files: render_site_files(&site_map, options),
+            warnings: [warnings, site_map.unlisted_warnings()].concat(),
+            assets: Vec::new(),
+        };
+        result.files.extend(site_manifest(&groups, options));
+        result
+            .files
+            .extend(render_search_index(&groups, &site_map, options));
+        let hashes: Vec<String> = groups
+            .iter()
+            .map(|(source_path, file_documents)| {
+                page_hash(source_path, file_documents, &site_map, options)
+            })
+            .collect();
+        let mut cached: Vec<Option<CacheEntry>> = groups
+            .iter()
+            .zip(&hashes)
+            .map(|((source_path, _), content_hash)| {
+                previous
+                    .remove(&source_path.to_string())
+                    .filter(|entry| entry.content_hash == *content_hash)
+            })
+            .collect();
+        self.reused_pages = cached.iter().flatten().count();
+
+        let stale: Vec<usize> = (0..groups.len())
+            .filter(|index| cached[*index].is_none())
+            .collect();
+        let rendered = parallel_map(&stale, options.concurrency(), |index| {
+            let (source_path, file_documents) = &groups[*index];
+            render_file_page(source_path, file_documents, &site_map, options)
+        });
+        for (index, page) in stale.into_iter().zip(rendered) {
+            cached[index] = Some(CacheEntry::new(hashes[index].clone(), page));
+        }
+
+        for ((source_path, _), entry) in groups.iter().zip(cached) {
+            let entry = entry.expect("every page is cached or rendered");
+            result
+                .files
+                .extend(entry.files.iter().map(|file| RenderedFile {
+                    path: FilePath::from(file.path.as_str()),
+                    content: file.content.clone(),
+                }));
+            result
+                .warnings
+                .extend(entry.warnings.iter().map(|warning| RenderWarning {
+                    file_path: source_path.clone(),
+                    line: warning.line,
+                    message: warning.message.clone(),
+                }));
+            result.assets.extend(entry.assets.iter().map(|asset| Asset {
+                source: FilePath::from(asset.source.as_str()),
+                path: FilePath::from(asset.path.as_str()),
+            }));
+            self.data.entries.insert(source_path.to_string(), entry);
+        }
+        dedup_assets(&mut result.assets);
+        for warning in &result.warnings {
+            warning.log(options.logger());
+        }
+        result
+    }
+
+    /// Returns the number of pages the last [`render_site`](Self::render_site)
+    /// call took from the cache instead of rendering them.
+    pub fn reused_pages(&self) -> usize {
+        self.reused_pages
+    }
+}
+
+impl CacheEntry {
+    fn new(content_hash: String, page: RenderResult) -> Self {
+        Self {
+            content_hash,
+            files: page
+                .files
+                .into_iter()
+                .map(|file| CachedFile {
+                    path: file.path.to_string(),
+                    content: file.content,
+                })
+                .collect(),
+            warnings: page
+                .warnings
+                .into_iter()
+                .map(|warning| CachedWarning {
+                    line: warning.line,
+                    message: warning.message,
+                })
+                .collect(),
+            assets: page
+                .assets
+                .into_iter()
+                .map(|asset| CachedAsset {
+                    source: asset.source.to_string(),
+                    path: asset.path.to_string(),
+                })
+                .collect(),
+        }
+    }
+}
+
+/// Hash everything the page of a source file is rendered from.
+///
+/// That includes which referenced assets exist, a page referencing a missing
+/// asset is rendered again once the asset is created, and their content if
+/// the output mode embeds images.
+fn page_hash(
+    source_path: &FilePath,
+    documents: &[&Document],
+    site_map: &SiteMap,
+    options: &RenderOptions,
+) -> String {
+    let mut hasher = ContentHasher::new();
+    hasher.write_str(&source_path.to_string());
+    let neighbours = [
+        site_map.pages.previous(source_path),
+        site_map.pages.next(source_path),
+    ];
+    for page in neighbours {
+        match page {
+            Some(page) => {
+                hasher.write_str(&page.source_path.to_string());
+                hasher.write_str(&page.title);
+            }
+            None => hasher.write_str("none"),
+        }
+    }
+    for document in documents {
+        hasher.write_str(document.id().as_str());
+        hasher.write_str(document.title());
+        hasher.write_str(document.content());
+        hasher.write_usize(document.source().line_number());
+        hasher.write_usize(document.is_skipped() as usize);
+        let word_count = document.word_count();
+        hasher.write_usize(word_count.words);
+        hasher.write_usize(word_count.reading_time.as_secs() as usize);
+        for include in document.includes() {
+            hasher.write_str(&include.file_path.to_string());
+            hasher.write_str(&include.content);
+        }
+        if let Some(pal) = options.pal() {
+            for asset in referenced_assets(document) {
+                hasher.write_str(&asset.to_string());
+                let exists = pal.file_exists(&asset).unwrap_or_default();
+                hasher.write_usize(exists as usize);
+                if exists && options.output_mode().embeds_images() {
+                    let mut content = Vec::new();
+                    if let Ok(mut reader) = pal.read_file(&asset) {
+                        let _ = reader.read_to_end(&mut content);
+                    }
+                    hasher.write_usize(content.len());
+                    hasher.write_bytes(&content);
+                }
+            }
+        }
+        if let Some(following_code) = document.following_code() {
+            hasher.write_str(&following_code.code);
+            hasher.write_usize(following_code.start_line);
+        }
+        for exec in document.execs() {
+            hasher.write_str(&exec.command);
+            hasher.write_str(&exec.output.stdout);
+            hasher.write_str(&exec.output.stderr);
+        }
+        for expansion in document.expansions() {
+            hasher.write_str(&expansion.name);
+            hasher.write_str(&expansion.arguments);
+            hasher.write_str(&expansion.markdown);
+        }
+        for anchor in site_map.toc.anchors(document.id()) {
+            hasher.write_str(anchor);
+        }
+        for part in split_references(document.content()) {
+            if let TextPart::Reference { name, .. } = part {
+                hasher.write_str(name);
+                match site_map.symbols.resolve(name) {
+                    Some(target) => {
+                        hasher.write_str(&target.file_path.to_string());
+                        hasher.write_str(target.anchor.as_deref().unwrap_or_default());
+                    }
+                    None => hasher.write_str("unresolved"),
+                }
+            }
+        }
+    }
+    format!("{:016x}", hasher.finish())
+}
+
+/// 64-bit FNV-1a hash over length-prefixed values.
+pub(crate) struct ContentHasher(u64);
+
+impl ContentHasher {
+    const OFFSET_BASIS: u64 = 0xcbf2_9ce4_8422_2325;
+    const PRIME: u64 = 0x0000_0100_0000_01b3;
+
+    pub(crate) fn new() -> Self {
+        Self(Self::OFFSET_BASIS)
+    }
+
+    fn write_bytes(&mut self, bytes: &[u8]) {
+        for byte in bytes {
+            self.0 ^= u64::from(*byte);
+            self.0 = self.0.wrapping_mul(Self::PRIME);
+        }
+    }
+
+    pub(crate) fn write_usize(&mut self, value: usize) {
+        self.write_bytes(&(value as u64).to_le_bytes());
+    }
+
+    /// Write a string, prefixed by its length so that ("ab", "c") and ("a", "bc") differ.
+    pub(crate) fn write_str(&mut self, value: &str) {
+        self.write_usize(value.len());
+        self.write_bytes(value.as_bytes());
+    }
+
+    pub(crate) fn finish(&self) -> u64 {
+        self.0
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, Include, SourceType, render_site};
+    use hyperlit_base::pal::MockPal;
+    use std::collections::HashSet;
+
+    fn doc(path: &str, line: usize, title: &str, content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), line);
+        Document::new(
+            title.to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    fn documents() -> Vec<Document> {
+        vec![
+            doc("a.rs", 1, "Alpha", "# Alpha\n\nSee [[beta]].\n"),
+            doc("b.rs", 1, "Beta", "# Beta\n"),
+            doc("c.md", 1, "Gamma", "# Gamma\n\nSee [[missing]].\n"),
+        ]
+    }
+
+    fn cache_directory() -> FilePath {
+        FilePath::from(".hyperlit-cache")
+    }
+
+    #[test]
+    fn test_render_site_matches_uncached_render() {
+        let options = RenderOptions::new().with_title("Site");
+        let mut cache = BuildCache::new(&cache_directory());
+
+        let first = cache.render_site(&documents(), &options);
+        let second = cache.render_site(&documents(), &options);
+
+        assert_eq!(first, render_site(&documents(), &options));
+        assert_eq!(second, first);
+        assert_eq!(cache.reused_pages(), 3);
+    }
+
+    #[test]
+    fn test_render_site_rerenders_changed_files_only() {
+        let options = RenderOptions::new();
+        let mut cache = BuildCache::new(&cache_directory());
+        cache.render_site(&documents(), &options);
+        assert_eq!(cache.reused_pages(), 0);
+
+        let mut changed = documents();
+        changed[2] = doc("c.md", 1, "Gamma", "# Gamma\n\nNew text.\n");
+        let result = cache.render_site(&changed, &options);
+
+        assert_eq!(cache.reused_pages(), 2);
+        assert_eq!(result, render_site(&changed, &options));
+        assert!(result.warnings.is_empty());
+    }
+
+    #[test]
+    fn test_render_site_rerenders_pages_referencing_moved_targets() {
+        let options = RenderOptions::new();
+        let mut cache = BuildCache::new(&cache_directory());
+        cache.render_site(&documents(), &options);
+
+        // Renaming the heading moves the anchor "[[beta]]" in a.rs links to
+        let mut changed = documents();
+        changed[1] = doc("b.rs", 1, "Beta", "# Renamed\n");
+        let result = cache.render_site(&changed, &options);
+
+        assert_eq!(cache.reused_pages(), 1);
+        assert_eq!(result, render_site(&changed, &options));
+    }
+
+    #[test]
+    fn test_render_site_rerenders_pages_with_changed_includes() {
+        let options = RenderOptions::new();
+        let with_include = |content: &str| {
+            let mut documents = documents();
+            documents[1] =
+                doc("b.rs", 1, "Beta", "# Beta\n\n{{include b.yaml}}\n").with_include(Include {
+                    directive_path: "b.yaml".to_string(),
+                    file_path: FilePath::from("b.yaml"),
+                    content: content.to_string(),
+                });
+            documents
+        };
+        let mut cache = BuildCache::new(&cache_directory());
+        cache.render_site(&with_include("old: 1"), &options);
+        let result = cache.render_site(&with_include("new: 2"), &options);
+
+        assert_eq!(cache.reused_pages(), 2);
+        assert_eq!(result, render_site(&with_include("new: 2"), &options));
+    }
+
+    #[test]
+    fn test_render_site_single_file_is_not_cached() {
+        let options = RenderOptions::new().with_output_mode(OutputMode::SingleFile);
+        let mut cache = BuildCache::new(&cache_directory());
+        cache.render_site(&documents(), &options);
+        let result = cache.render_site(&documents(), &options);
+
+        assert_eq!(cache.reused_pages(), 0);
+        assert_eq!(result, render_site(&documents(), &options));
+    }
+
+    #[test]
+    fn test_render_site_flat_rerenders_pages_with_changed_images() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("img/logo.png"), vec![0x89, 0x50, 1]);
+        let pal = PalHandle::new(mock_pal.clone());
+        let options = RenderOptions::new()
+            .with_output_mode(OutputMode::Flat)
+            .with_pal(pal);
+        let documents = vec![
+            doc("a.md", 1, "Alpha", "# Alpha\n\n![Logo](img/logo.png)\n"),
+            doc("b.md", 1, "Beta", "# Beta\n"),
+        ];
+        let mut cache = BuildCache::new(&cache_directory());
+        let first = cache.render_site(&documents, &options);
+        mock_pal.add_file(FilePath::from("img/logo.png"), vec![0x89, 0x50, 2]);
+        let result = cache.render_site(&documents, &options);
+
+        assert_ne!(result, first);
+        assert_eq!(cache.reused_pages(), 1);
+        assert_eq!(result, render_site(&documents, &options));
+    }
+
+    #[test]
+    fn test_render_site_keeps_cached_warnings() {
+        let options = RenderOptions::new();
+        let mut cache = BuildCache::new(&cache_directory());
+        cache.render_site(&documents(), &options);
+        let result = cache.render_site(&documents(), &options);
+
+        let warnings: Vec<String> = result.warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            ["c.md:3: Unresolved cross-reference '[[missing]]'"]
+        );
+    }
+
+    #[test]
+    fn test_render_site_invalidates_on_option_change() {
+        let mut cache = BuildCache::new(&cache_directory());
+        cache.render_site(&documents(), &RenderOptions::new().with_title("Old"));
+        let result = cache.render_site(&documents(), &RenderOptions::new().with_title("New"));
+
+        assert_eq!(cache.reused_pages(), 0);
+        assert!(
+            result
+                .files
+                .iter()
+                .all(|file| !file.content.contains("Old"))
+        );
+    }
+
+    #[test]
+    fn test_save_and_load() {
+        let pal = PalHandle::new(MockPal::new());
+        let options = RenderOptions::new();
+        let mut cache = BuildCache::new(&cache_directory());
+        cache.render_site(&documents(), &options);
+        cache.save(&pal).unwrap();
+        assert!(
+            pal.file_exists(&FilePath::from(".hyperlit-cache/build-cache.json"))
+                .unwrap()
+        );
+
+        let mut loaded = BuildCache::load(&pal, &cache_directory()).unwrap();
+        assert_eq!(loaded.data, cache.data);
+        loaded.render_site(&documents(), &options);
+        assert_eq!(loaded.reused_pages(), 3);
+    }
+
+    #[test]
+    fn test_load_missing_cache_is_empty() {
+        let pal = PalHandle::new(MockPal::new());
+        let cache = BuildCache::load(&pal, &cache_directory()).unwrap();
+        assert!(cache.data.entries.is_empty());
+    }
+
+    #[test]
+    fn test_load_invalid_cache() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(
+            FilePath::from(".hyperlit-cache/build-cache.json"),
+            b"not json".to_vec(),
+        );
+        let pal = PalHandle::new(mock_pal);
+
+        let error = BuildCache::load(&pal, &cache_directory()).unwrap_err();
+        assert!(
+            error
+                .to_string()
+                .starts_with("Invalid build cache '.hyperlit-cache/build-cache.json'")
+        );
+    }
+
+    #[test]
+    fn test_load_discards_cache_of_other_version() {
+        let pal = PalHandle::new(MockPal::new());
+        let options = RenderOptions::new();
+        let mut cache = BuildCache::new(&cache_directory());
+        cache.render_site(&documents(), &options);
+        cache.data.version = "0.0.0".to_string();
+        cache.save(&pal).unwrap();
+
+        let mut loaded = BuildCache::load(&pal, &cache_directory()).unwrap();
+        loaded.render_site(&documents(), &options);
+        assert_eq!(loaded.reused_pages(), 0);
+        assert_eq!(loaded.data.version, HYPERLIT_VERSION);
+    }
+
+    #[test]
+    fn test_content_hasher_separates_values() {
+        let hash = |values: &[&str]| {
+            let mut hasher = ContentHasher::new();
+            for value in values {
+                hasher.write_str(value);
+            }
+            hasher.finish()
+        };
+        assert_eq!(hash(&["ab", "c"]), hash(&["ab", "c"]));
+        assert_ne!(hash(&["ab", "c"]), hash(&["a", "bc"]));
+    }
+}
@@ -0,0 +1,347 @@
+/* 📖 # Why a check that writes nothing?
+
+CI should fail on broken documentation before it is published, and it should
+fail on all problems at once rather than on the first one per run. `hyperlit
+build` is not suited for this: it only warns about problems, still succeeds and
+writes the whole site to disk.
+
+`check_site` runs the passes of a build (scanning, extraction with directive
+resolution, and rendering) plus the link check, but keeps the rendered files in
+memory. It calls the same functions with the same options as the build, so a
+check passes exactly when the build would work without warnings. Parse errors
+are collected for all files even if the configuration sets `fail_fast`. The
+commands of exec directives are not run, as they would write to the disk: the
+directives are only validated (see `crate::exec`).
+
+Every problem is reported with its category and severity:
+
+- Errors: malformed doc blocks, failing directives, unreadable files and
+  broken links. A build produces broken pages with these (or stops on them
+  with `fail_fast`)
+- Warnings: scan errors, unknown directives and rendering warnings (such as
+  a diagram that failed to render). A build goes on with these
+
+`hyperlit check` exits with code 1 if there are errors, and with `--strict` if
+there are warnings as well. Unresolved `[[name]]` references are found by both
+the link check and rendering, so they are only reported by the link check.
+*/
+
+use std::collections::BTreeMap;
+use std::fmt;
+
+use hyperlit_base::{HyperlitResult, PalHandle};
+
+use crate::render::UNRESOLVED_REFERENCE;
+use crate::{
+    Config, ExecOptions, ExtractionOptions, LinkCheckOptions, RenderOptions,
+    check_links_with_options, extract_documents_with_options, scan_files,
+};
+
+/// Pass of the build that found a problem.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum CheckCategory {
+    /// Finding the source files
+    Scan,
+    /// Extracting documents and resolving directives
+    Extraction,
+    /// Checking cross-references and links
+    Link,
+    /// Rendering the site
+    Render,
+}
+
+impl fmt::Display for CheckCategory {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            Self::Scan => "scan",
+            Self::Extraction => "extraction",
+            Self::Link => "links",
+            Self::Render => "render",
+        })
+    }
+}
+
+/// A problem found by [`check_site`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CheckProblem {
+    /// Pass that found the problem
+    pub category: CheckCategory,
+    /// True if a build goes on despite the problem
+    pub warning: bool,
+    /// Description of the problem, starting with its source location if known
+    pub message: String,
+}
+
+impl fmt::Display for CheckProblem {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let severity = match self.warning {
+            true => "warning",
+            false => "error",
+        };
+        write!(f, "{} ({}): {}", severity, self.category, self.message)
+    }
+}
+
+/// Result of checking a site.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct CheckReport {
+    /// Number of source files scanned
+    pub files_scanned: usize,
+    /// Number of documents extracted
+    pub documents: usize,
+    /// Problems found, in the order of the passes
+    pub problems: Vec<CheckProblem>,
+}
+
+impl CheckReport {
+    /// Returns the number of errors.
+    pub fn error_count(&self) -> usize {
+        self.problems
+            .iter()
+            .filter(|problem| !problem.warning)
+            .count()
+    }
+
+    /// Returns the number of warnings.
+    pub fn warning_count(&self) -> usize {
+        self.problems
+            .iter()
+            .filter(|problem| problem.warning)
+            .count()
+    }
+
+    /// Returns the number of problems per category.
+    pub fn counts_by_category(&self) -> BTreeMap<CheckCategory, usize> {
+        let mut counts = BTreeMap::new();
+        for problem in &self.problems {
+            *counts.entry(problem.category).or_default() += 1;
+        }
+        counts
+    }
+
+    /// Returns true if the check fails, on errors or, if `strict`, on any problem.
+    pub fn failed(&self, strict: bool) -> bool {
+        match strict {
+            true => !self.problems.is_empty(),
+            false => self.error_count() > 0,
+        }
+    }
+
+    /// Returns a one-line summary of the report.
+    ///
+    /// # Examples
+    /// ```
+    /// use hyperlit_engine::{CheckCategory, CheckProblem, CheckReport};
+    ///
+    /// let report = CheckReport {
+    ///     files_scanned: 12,
+    ///     documents: 30,
+    ///     problems: vec![CheckProblem {
+    ///         category: CheckCategory::Link,
+    ///         warning: false,
+    ///         message: "guide.md:3: broken link '#nope': no heading with anchor 'nope' in guide.md".to_string(),
+    ///     }],
+    /// };
+    /// assert_eq!(
+    ///     report.summary(),
+    ///     "Checked 12 files and 30 documents: 1 errors, 0 warnings (links: 1)"
+    /// );
+    /// ```
+    pub fn summary(&self) -> String {
+        let mut summary = format!(
+            "Checked {} files and {} documents: {} errors, {} warnings",
+            self.files_scanned,
+            self.documents,
+            self.error_count(),
+            self.warning_count()
+        );
+        let counts: Vec<String> = self
+            .counts_by_category()
+            .into_iter()
+            .map(|(category, count)| format!("{}: {}", category, count))
+            .collect();
+        if !counts.is_empty() {
+            summary.push_str(&format!(" ({})", counts.join(", ")));
+        }
+        summary
+    }
+}
+
+/// Check the site configured by `config` without writing anything.
+///
+/// Runs the passes of a build with `render_options` and checks links with
+/// `link_options` against the site rendered with them, see [`crate::check`].
+/// Fails only if the files cannot be scanned at all.
+pub fn check_site(
+    pal: &PalHandle,
+    config: &Config,
+    render_options: &RenderOptions,
+    link_options: &LinkCheckOptions,
+) -> HyperlitResult<CheckReport> {
+    let scan_result = scan_files(pal, config)?;
+    let mut problems: Vec<CheckProblem> = scan_result
+        .errors
+        .iter()
+        .map(|error| CheckProblem {
+            category: CheckCategory::Scan,
+            warning: true,
+            message: format!("{}: {}", error.directory_path, error.error),
+        })
+        .collect();
+
+    let extraction_options = ExtractionOptions::from_config(config)
+        .with_fail_fast(false)
+        .with_exec_options(ExecOptions::from_config(config).with_dry_run(true));
+    let extraction = extract_documents_with_options(pal, &scan_result.files, &extraction_options)?;
+    problems.extend(extraction.errors.iter().map(|error| CheckProblem {
+        category: CheckCategory::Extraction,
+        warning: error.warning,
+        message: match error.parse_error() {
+            Some(parse_error) => parse_error.to_string(),
+            None => format!("{}: {}", error.file_path, error.error),
+        },
+    }));
+
+    // Links are checked against the site as rendered, with its pages and anchors
+    let link_options = link_options
+        .clone()
+        .with_render_options(render_options.clone());
+    problems.extend(
+        check_links_with_options(&extraction.documents, &link_options)
+            .iter()
+            .map(|problem| CheckProblem {
+                category: CheckCategory::Link,
+                warning: false,
+                message: problem.to_string(),
+            }),
+    );
+
+    let rendered = config
+        .output_format
+        .unwrap_or_default()
+        .renderer()
+        .render_site(&extraction.documents, render_options);
+    problems.extend(
+        rendered
+            .warnings
+            .iter()
+            .filter(|warning| !warning.message.starts_with(UNRESOLVED_REFERENCE))
+            .map(|warning| CheckProblem {
+                category: CheckCategory::Render,
+                warning: true,
+                message: warning.to_string(),
+            }),
+    );
+
+    Ok(CheckReport {
+        files_scanned: scan_result.files.len(),
+        documents: extraction.documents.len(),
+        problems,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::DirectoryConfig;
+    use hyperlit_base::FilePath;
+    use hyperlit_base::pal::MockPal;
+
+    fn config() -> Config {
+        Config {
+            title: "Test".to_string(),
+            source_link_template: "https://example.com/{path}".to_string(),
+            directory: vec![DirectoryConfig {
+                paths: vec!["docs".to_string()],
+                globs: vec!["*.md".to_string()],
+                ..Default::default()
+            }],
+            ..Default::default()
+        }
+    }
+
+    fn check(files: &[(&str, &str)]) -> (PalHandle, CheckReport) {
+        let mock_pal = MockPal::new();
+        for (path, content) in files {
+            mock_pal.add_file(FilePath::from(*path), content.as_bytes().to_vec());
+        }
+        let pal = PalHandle::new(mock_pal);
+        let config = config();
+        let report = check_site(
+            &pal,
+            &config,
+            &RenderOptions::from_config(&config).with_pal(pal.clone()),
+            &LinkCheckOptions::new().with_pal(pal.clone()),
+        )
+        .unwrap();
+        (pal, report)
+    }
+
+    #[test]
+    fn test_check_site_reports_all_problems() {
+        let (pal, report) = check(&[
+            ("docs/a.md", "# A\n\nSee [[missing]] and [B](b.md#nope).\n"),
+            ("docs/b.md", "# B\n\n{{verison}}\n\n```rust\nx\n"),
+            ("docs/c.md", "# C\n\n```go {highlight=x}\nx\n```\n"),
+        ]);
+        let problems: Vec<String> = report.problems.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            problems,
+            [
+                "error (extraction): docs/b.md:5:1: unterminated code block started at line 5",
+                "warning (extraction): docs/b.md:3:1: unknown directive 'verison'",
+                "error (links): docs/a.md:3: broken link '[[missing]]': no symbol or document with this name",
+                "error (links): docs/a.md:3: broken link 'b.md#nope': no heading with anchor 'nope' in docs/b.md",
+                "warning (render): docs/c.md:3: Ignoring code block attributes: invalid line range 'x'",
+            ]
+        );
+        assert_eq!((report.files_scanned, report.documents), (3, 3));
+        assert!(report.failed(false));
+        assert_eq!(
+            report.summary(),
+            "Checked 3 files and 3 documents: 3 errors, 2 warnings (extraction: 2, links: 2, render: 1)"
+        );
+        // Nothing is written
+        assert!(
+            !pal.file_exists(&FilePath::from("output/index.html"))
+                .unwrap()
+        );
+    }
+
+    #[test]
+    fn test_check_site_does_not_run_execs() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(
+            FilePath::from("docs/a.md"),
+            b"# A\n\n{{exec: mytool --help}}\n".to_vec(),
+        );
+        let pal = PalHandle::new(mock_pal.clone());
+        let mut config = config();
+        config.exec.allow_exec = true;
+        let report = check_site(
+            &pal,
+            &config,
+            &RenderOptions::from_config(&config).with_pal(pal.clone()),
+            &LinkCheckOptions::new().with_pal(pal.clone()),
+        )
+        .unwrap();
+
+        assert_eq!(report.error_count(), 0);
+        assert!(mock_pal.executed_commands().is_empty());
+    }
+
+    #[test]
+    fn test_check_site_strict() {
+        let (_, report) = check(&[("docs/a.md", "# A\n\n{{verison}}\n")]);
+        assert_eq!((report.error_count(), report.warning_count()), (0, 1));
+        assert!(!report.failed(false));
+        assert!(report.failed(true));
+
+        let (_, report) = check(&[("docs/a.md", "# A\n")]);
+        assert!(!report.failed(true));
+        assert_eq!(
+            report.summary(),
+            "Checked 1 files and 1 documents: 0 errors, 0 warnings"
+        );
+    }
+}
@@ -0,0 +1,377 @@
+/* 📖 # Why attributes on fenced code blocks?
+
+Code in documentation is often discussed line by line, and readers need to know
+which file a snippet comes from. Attributes in braces after the language of a
+fenced code block annotate it without leaving markdown:
+
+````markdown
+```go {highlight=2-4,7 title="config.go" linenos}
+...
+```
+````
+
+- `highlight` lists the lines to emphasize as ranges (1-indexed, inclusive)
+- `title` shows a caption bar above the block, typically a file name
+- `linenos` numbers the lines
+
+Other `key=value` attributes are kept, so tooling reading the JSON export can
+use them. The syntax is close to the one used by Hugo, Pandoc and Docusaurus, so
+existing documentation renders the same.
+
+Line numbers are added by the stylesheet (from a `data-line` attribute) rather
+than as text, so copying code from the page does not copy the numbers, and they
+do not count towards the column when code is wrapped. Lines are decorated after
+highlighting, so the highlighter sees the code without any markup.
+
+A mistake in the attributes should not break the page. Malformed attributes are
+reported as a warning and the block is rendered as if it had none.
+*/
+
+use std::collections::BTreeMap;
+use std::ops::RangeInclusive;
+
+use serde::Serialize;
+
+use hyperlit_base::{HyperlitResult, bail, err};
+
+use crate::render::escape_html;
+
+/// Attributes of a fenced code block, given in braces after the language.
+///
+/// # Examples
+/// ```
+/// use hyperlit_engine::CodeBlockAttrs;
+///
+/// let attrs = CodeBlockAttrs::parse(r#"go {highlight=2-4 title="config.go" linenos}"#)
+///     .unwrap()
+///     .unwrap();
+/// assert_eq!(attrs.highlight(), [2..=4]);
+/// assert_eq!(attrs.title(), Some("config.go"));
+/// assert!(attrs.line_numbers());
+/// assert!(CodeBlockAttrs::parse("go").unwrap().is_none());
+/// ```
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CodeBlockAttrs {
+    /// Highlighted line ranges (1-indexed, inclusive)
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    highlight: Vec<RangeInclusive<usize>>,
+    /// Caption shown above the block
+    #[serde(skip_serializing_if = "Option::is_none")]
+    title: Option<String>,
+    /// True if the lines are numbered
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    line_numbers: bool,
+    /// Other `key=value` attributes, sorted by key
+    #[serde(skip_serializing_if = "BTreeMap::is_empty")]
+    other: BTreeMap<String, String>,
+}
+
+impl CodeBlockAttrs {
+    /// Parse the attributes of a fenced code block info string.
+    ///
+    /// Returns None if the info string has no attributes, and an error if they
+    /// are malformed.
+    pub fn parse(info: &str) -> HyperlitResult<Option<Self>> {
+        let Some(attributes) = split_info(info).1 else {
+            return Ok(None);
+        };
+        let Some(attributes) = attributes
+            .strip_prefix('{')
+            .and_then(|attributes| attributes.strip_suffix('}'))
+        else {
+            bail!("missing closing '}}' in '{}'", attributes);
+        };
+        let mut attrs = Self::default();
+        for (key, value) in parse_attributes(attributes)? {
+            match (key, value) {
+                ("highlight", Some(value)) => attrs.highlight = parse_line_ranges(value)?,
+                ("title", Some(value)) => attrs.title = Some(value.to_string()),
+                ("linenos", None) => attrs.line_numbers = true,
+                ("highlight" | "title", None) => bail!("'{}' needs a value", key),
+                ("linenos", Some(_)) => bail!("'linenos' takes no value"),
+                (key, Some(value)) => {
+                    attrs.other.insert(key.to_string(), value.to_string());
+                }
+                (key, None) => bail!("unknown attribute '{}'", key),
+            }
+        }
+        Ok(Some(attrs))
+    }
+
+    /// Returns the highlighted line ranges (1-indexed, inclusive).
+    pub fn highlight(&self) -> &[RangeInclusive<usize>] {
+        &self.highlight
+    }
+
+    /// Returns the caption shown above the block, if any.
+    pub fn title(&self) -> Option<&str> {
+        self.title.as_deref()
+    }
+
+    /// Returns true if the lines are numbered.
+    pub fn line_numbers(&self) -> bool {
+        self.line_numbers
+    }
+
+    /// Returns the other `key=value` attributes, sorted by key.
+    pub fn other(&self) -> &BTreeMap<String, String> {
+        &self.other
+    }
+
+    /// Returns true if line `line` (1-indexed) is highlighted.
+    pub fn is_highlighted(&self, line: usize) -> bool {
+        self.highlight.iter().any(|range| range.contains(&line))
+    }
+}
+
+/// Split a fenced code block info string into its language and its attributes
+/// in braces, if any.
+///
+/// The language is the first word, unless it is a `key=value` attribute.
+pub(crate) fn split_info(info: &str) -> (Option<&str>, Option<&str>) {
+    let (words, attributes) = match info.find('{') {
+        Some(start) => (&info[..start], Some(info[start..].trim_end())),
+        None => (info, None),
+    };
+    let language = words
+        .split_whitespace()
+        .next()
+        .filter(|word| !word.contains('='));
+    (language, attributes)
+}
+
+/// Parse words separated by whitespace into keys with optional values.
+///
+/// Values may be wrapped in double quotes to contain whitespace.
+fn parse_attributes(attributes: &str) -> HyperlitResult<Vec<(&str, Option<&str>)>> {
+    let mut parsed = Vec::new();
+    let mut rest = attributes.trim_start();
+    while !rest.is_empty() {
+        let key_end = rest
+            .find(|c: char| c == '=' || c.is_whitespace())
+            .unwrap_or(rest.len());
+        let key = &rest[..key_end];
+        if key.is_empty() {
+            bail!("missing attribute name before '{}'", rest);
+        }
+        rest = &rest[key_end..];
+        let value = match rest.strip_prefix('=') {
+            Some(quoted) if quoted.starts_with('"') => {
+                let end = quoted[1..]
+                    .find('"')
+                    .ok_or_else(|| err!("missing closing quote for '{}'", key))?;
+                rest = &quoted[end + 2..];
+                Some(&quoted[1..end + 1])
+            }
+            Some(value) => {
+                let end = value.find(char::is_whitespace).unwrap_or(value.len());
+                rest = &value[end..];
+                Some(&value[..end])
+            }
+            None => None,
+        };
+        if rest.starts_with(|c: char| !c.is_whitespace()) {
+            bail!("missing space after '{}'", key);
+        }
+        parsed.push((key, value));
+        rest = rest.trim_start();
+    }
+    Ok(parsed)
+}
+
+/// Parse comma separated line numbers and ranges like `2-4,7`.
+fn parse_line_ranges(value: &str) -> HyperlitResult<Vec<RangeInclusive<usize>>> {
+    value
+        .split(',')
+        .map(|range| {
+            let (start, end) = range.split_once('-').unwrap_or((range, range));
+            match (start.trim().parse::<usize>(), end.trim().parse::<usize>()) {
+                (Ok(start), Ok(end)) if 0 < start && start <= end => Ok(start..=end),
+                _ => Err(err!("invalid line range '{}'", range)),
+            }
+        })
+        .collect()
+}
+
+/// Decorate the lines of a rendered code block according to `attrs`.
+///
+/// Every line is wrapped in a `code-line` span carrying its number, highlighted
+/// lines get the `highlighted` class as well. The block is wrapped in a
+/// `code-block` div with the caption, if any.
+pub(crate) fn decorate_code_html(html: &str, attrs: &CodeBlockAttrs) -> String {
+    let (prefix, body, suffix) = split_code_html(html);
+    let mut decorated = String::with_capacity(html.len() * 2);
+    decorated.push_str("<div class=\"code-block");
+    if attrs.line_numbers {
+        decorated.push_str(" line-numbers");
+    }
+    decorated.push_str("\">\n");
+    if let Some(title) = &attrs.title {
+        decorated.push_str(&format!(
+            "<div class=\"code-title\">{}</div>\n",
+            escape_html(title)
+        ));
+    }
+    decorated.push_str(prefix);
+    // Tags open at the end of a line, closed and reopened around the line span
+    let mut open_tags: Vec<&str> = Vec::new();
+    let mut rest = body;
+    let mut line = 0;
+    while !rest.is_empty() {
+        line += 1;
+        let line_end = rest.find('\n').map_or(rest.len(), |end| end + 1);
+        let (text, remainder) = rest.split_at(line_end);
+        rest = remainder;
+        let class = match attrs.is_highlighted(line) {
+            true => "code-line highlighted",
+            false => "code-line",
+        };
+        decorated.push_str(&format!("<span class=\"{class}\" data-line=\"{line}\">"));
+        open_tags.iter().for_each(|tag| decorated.push_str(tag));
+        decorated.push_str(text);
+        track_open_tags(text, &mut open_tags);
+        open_tags.iter().for_each(|_| decorated.push_str("</span>"));
+        decorated.push_str("</span>");
+    }
+    decorated.push_str(suffix.trim_end());
+    decorated.push_str("\n</div>\n");
+    decorated
+}
+
+/// Split a rendered code block into the opening `<pre>` and `<code>` tags, the
+/// code and the closing tags.
+///
+/// HTML that is not a `<pre>` element is returned as the code.
+fn split_code_html(html: &str) -> (&str, &str, &str) {
+    let Some(after_pre) = html
+        .starts_with("<pre")
+        .then(|| html.find('>'))
+        .flatten()
+        .map(|end| end + 1)
+    else {
+        return ("", html, "");
+    };
+    // Highlighters may start the code on the line after the `<pre>` tag
+    let mut body_start = after_pre + usize::from(html[after_pre..].starts_with('\n'));
+    if html[body_start..].starts_with("<code") {
+        body_start += html[body_start..].find('>').map_or(0, |end| end + 1);
+    }
+    let mut body_end = html.rfind("</pre>").unwrap_or(html.len()).max(body_start);
+    if html[body_start..body_end].ends_with("</code>") {
+        body_end -= "</code>".len();
+    }
+    (
+        &html[..body_start],
+        &html[body_start..body_end],
+        &html[body_end..],
+    )
+}
+
+/// Update the stack of `<span>` tags left open after `html`.
+///
+/// Highlighters only emit spans inside code, so other tags are ignored.
+fn track_open_tags<'a>(html: &'a str, open_tags: &mut Vec<&'a str>) {
+    let mut rest = html;
+    while let Some(start) = rest.find('<') {
+        let Some(end) = rest[start..].find('>').map(|end| start + end + 1) else {
+            break;
+        };
+        let tag = &rest[start..end];
+        if tag.starts_with("</span") {
+            open_tags.pop();
+        } else if tag.starts_with("<span") {
+            open_tags.push(tag);
+        }
+        rest = &rest[end..];
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use expect_test::expect;
+
+    fn parse(info: &str) -> HyperlitResult<Option<CodeBlockAttrs>> {
+        CodeBlockAttrs::parse(info)
+    }
+
+    #[test]
+    fn test_parse_code_block_attrs() {
+        let attrs = parse(r#"go {highlight=2-4,7 title="my config.go" linenos tab=main}"#)
+            .unwrap()
+            .unwrap();
+        assert_eq!(attrs.highlight(), [2..=4, 7..=7]);
+        assert_eq!(attrs.title(), Some("my config.go"));
+        assert!(attrs.line_numbers());
+        assert_eq!(attrs.other().get("tab").map(String::as_str), Some("main"));
+        assert!(attrs.is_highlighted(3) && attrs.is_highlighted(7));
+        assert!(!attrs.is_highlighted(1) && !attrs.is_highlighted(5));
+
+        assert_eq!(parse("{linenos}").unwrap().unwrap().highlight(), []);
+        assert_eq!(parse("rust{}").unwrap(), Some(CodeBlockAttrs::default()));
+        assert_eq!(parse("rust file=main.rs").unwrap(), None);
+        assert_eq!(parse("").unwrap(), None);
+    }
+
+    #[test]
+    fn test_parse_malformed_code_block_attrs() {
+        let error = |info: &str| parse(info).unwrap_err().to_string();
+        assert_eq!(error("go {linenos"), "missing closing '}' in '{linenos'");
+        assert_eq!(error("go {highlight=4-2}"), "invalid line range '4-2'");
+        assert_eq!(error("go {highlight=0,x}"), "invalid line range '0'");
+        assert_eq!(error("go {highlight}"), "'highlight' needs a value");
+        assert_eq!(error("go {linenos=yes}"), "'linenos' takes no value");
+        assert_eq!(error("go {numbered}"), "unknown attribute 'numbered'");
+        assert_eq!(
+            error(r#"go {title="a.go}"#),
+            "missing closing quote for 'title'"
+        );
+        assert_eq!(error(r#"go {title="a"b}"#), "missing space after 'title'");
+        assert_eq!(error("go {=1}"), "missing attribute name before '=1'");
+    }
+
+    #[test]
+    fn test_split_info() {
+        assert_eq!(split_info("rust"), (Some("rust"), None));
+        assert_eq!(
+            split_info("rust {linenos} "),
+            (Some("rust"), Some("{linenos}"))
+        );
+        assert_eq!(split_info("{linenos}"), (None, Some("{linenos}")));
+        assert_eq!(split_info("file=a.rs"), (None, None));
+    }
+
+    #[test]
+    fn test_decorate_code_html() {
+        let attrs = parse(r#"{highlight=2 title="<a>" linenos}"#)
+            .unwrap()
+            .unwrap();
+        expect![[r#"
+            <div class="code-block line-numbers">
+            <div class="code-title">&lt;a&gt;</div>
+            <pre><code class="language-rust"><span class="code-line" data-line="1">a
+            </span><span class="code-line highlighted" data-line="2">b
+            </span></code></pre>
+            </div>
+        "#]]
+        .assert_eq(&decorate_code_html(
+            "<pre><code class=\"language-rust\">a\nb\n</code></pre>\n",
+            &attrs,
+        ));
+    }
+
+    #[test]
+    fn test_decorate_code_html_reopens_spans() {
+        let html = "<pre style=\"x\">\n<span style=\"a\">/* one\ntwo */</span>\n</pre>\n";
+        expect![[r#"
+            <div class="code-block">
+            <pre style="x">
+            <span class="code-line" data-line="1"><span style="a">/* one
+            </span></span><span class="code-line" data-line="2"><span style="a">two */</span>
+            </span></pre>
+            </div>
+        "#]]
+        .assert_eq(&decorate_code_html(html, &CodeBlockAttrs::default()));
+    }
+}
@@ -0,0 +1,130 @@
+/* 📖 # Why offer wrapping for code blocks?
+
+Source lines are often longer than the text column of a page. By default code
+blocks keep their lines and scroll horizontally (`CodeWrap::Scroll`), which
+preserves the layout of the code but hides the end of long lines until the
+reader scrolls. With `CodeWrap::Wrap` long lines are broken at a fixed column
+instead, and each continuation line starts with a `↪` marker so it is not
+mistaken for a line of the source. The marker is not selectable, and the
+column counts it, so no line of the block is wider than the column.
+
+Lines are broken in the rendered HTML, after highlighting: breaking them in the
+source would split tokens such as string literals and confuse the highlighter.
+Only visible characters count towards the column, markup and entities do not.
+
+Tabs would make the width of a line depend on the browser, so with wrapping
+they are expanded to spaces first (with a tab width of 4 unless configured).
+Setting a tab width expands tabs in scrolling code blocks too. The Markdown
+output keeps code blocks as they are, viewers lay them out themselves.
+*/
+
+use serde::Deserialize;
+
+/// Column at which code lines are wrapped, used when none is configured.
+pub const DEFAULT_WRAP_COLUMN: usize = 100;
+
+/// Width of a tab when expanding tabs in wrapped code blocks, used when none is configured.
+pub const DEFAULT_TAB_WIDTH: usize = 4;
+
+/// Marker at the start of a continuation line in wrapped code blocks.
+const CONTINUATION_MARKER: &str = "<span class=\"code-continuation\" aria-hidden=\"true\">↪</span>";
+
+/// How code lines wider than the page are shown in HTML output.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum CodeWrap {
+    /// Keep lines as they are, the code block scrolls horizontally
+    #[default]
+    Scroll,
+    /// Break lines at the wrap column, marking continuation lines
+    Wrap,
+}
+
+/// Replace tabs with spaces up to the next multiple of `tab_width`.
+pub(crate) fn expand_tabs(code: &str, tab_width: usize) -> String {
+    if !code.contains('\t') {
+        return code.to_string();
+    }
+    let tab_width = tab_width.max(1);
+    let mut expanded = String::with_capacity(code.len());
+    let mut column = 0;
+    for c in code.chars() {
+        match c {
+            '\t' => {
+                let spaces = tab_width - column % tab_width;
+                expanded.extend(std::iter::repeat_n(' ', spaces));
+                column += spaces;
+            }
+            '\n' => {
+                expanded.push(c);
+                column = 0;
+            }
+            _ => {
+                expanded.push(c);
+                column += 1;
+            }
+        }
+    }
+    expanded
+}
+
+/// Break the lines of a rendered code block so none is wider than `column`.
+///
+/// Tags do not count towards the width and an entity such as `&lt;` counts as
+/// one character. Continuation lines start with a marker, counted as one
+/// character as well.
+pub(crate) fn wrap_code_html(html: &str, column: usize) -> String {
+    let column = column.max(2);
+    let mut wrapped = String::with_capacity(html.len());
+    let mut width = 0;
+    let mut in_tag = false;
+    let mut in_entity = false;
+    for c in html.chars() {
+        if in_tag {
+            in_tag = c != '>';
+        } else if in_entity {
+            in_entity = c != ';';
+        } else if c == '<' {
+            in_tag = true;
+        } else if c == '\n' {
+            width = 0;
+        } else {
+            if width == column {
+                wrapped.push('\n');
+                wrapped.push_str(CONTINUATION_MARKER);
+                width = 1;
+            }
+            width += 1;
+            in_entity = c == '&';
+        }
+        wrapped.push(c);
+    }
+    wrapped
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_expand_tabs() {
+        assert_eq!(expand_tabs("\tfoo\tbar\n\tx", 4), "    foo bar\n    x");
+        assert_eq!(expand_tabs("ab\tc", 2), "ab  c");
+        assert_eq!(expand_tabs("no tabs", 4), "no tabs");
+    }
+
+    #[test]
+    fn test_wrap_code_html() {
+        let marker = CONTINUATION_MARKER;
+        assert_eq!(
+            wrap_code_html("<pre><code>abcdefgh\nabc\n</code></pre>\n", 4),
+            format!("<pre><code>abcd\n{marker}efg\n{marker}h\nabc\n</code></pre>\n")
+        );
+        // Tags and entities do not count towards the width
+        assert_eq!(
+            wrap_code_html("<span style=\"color:#a\">a&lt;b</span>cd", 4),
+            format!("<span style=\"color:#a\">a&lt;b</span>c\n{marker}d")
+        );
+        assert_eq!(wrap_code_html("abcd\n", 4), "abcd\n");
+    }
+}
@@ -0,0 +1,164 @@
+/* 📖 # Why merge doc comments separated by code?
+
+Prose explaining a piece of code is sometimes interrupted by the code itself:
+
+```text
+// 📖 # Retries
+// Requests are retried up to three times,
+let retries = 3;
+// 📖 with a delay doubling after each attempt.
+let delay = Duration::from_millis(100);
+```
+
+Each marked comment becomes a document of its own, so the explanation is split
+into two fragments, the second one titled by half a sentence. With
+`merge_comment_gap = N`, marked comments separated by at most N lines of code
+are merged into a single document instead. Blank lines are not counted, they
+never split a doc comment.
+
+Only comments starting with a marker are merged, the unmarked comments in
+between stay part of the code. The merged document takes its title, symbol and
+preamble status from its first comment. By default the code in the gaps is left
+out, so the prose reads as one paragraph. With `capture_gap_code = true` it is
+kept as a code block between the parts, in the language of the file.
+
+Comments disabled with a skip pragma are never merged with their neighbours.
+
+Merging is disabled by default (N = 0), so every marked comment stays a document
+of its own, as before.
+*/
+
+use hyperlit_base::FilePath;
+
+use crate::comment_parser::ExtractedComment;
+use crate::following_code::CodeIndentation;
+use crate::include::extension_language;
+
+/// Merge consecutive comments separated by at most `max_gap_lines` lines of code.
+///
+/// The code between merged comments is inserted as a fenced code block if
+/// `capture_code` is set, indented as set by `indentation`, see
+/// [`crate::comment_merge`].
+pub(crate) fn merge_comments(
+    file_path: &FilePath,
+    content: &str,
+    comments: Vec<ExtractedComment>,
+    max_gap_lines: usize,
+    capture_code: bool,
+    indentation: CodeIndentation,
+) -> Vec<ExtractedComment> {
+    if max_gap_lines == 0 {
+        return comments;
+    }
+    let mut merged: Vec<ExtractedComment> = Vec::with_capacity(comments.len());
+    for comment in comments {
+        let Some(previous) = merged.last_mut() else {
+            merged.push(comment);
+            continue;
+        };
+        let gap = gap_lines(content, previous.end_byte, comment.start_byte);
+        let code_lines = gap.iter().filter(|line| !line.trim().is_empty()).count();
+        // Skipped comments are left out as a whole, see [`crate::skip`]
+        if code_lines > max_gap_lines || previous.skipped || comment.skipped {
+            merged.push(comment);
+            continue;
+        }
+        if !previous.content.ends_with('\n') {
+            previous.content.push('\n');
+        }
+        if capture_code && code_lines > 0 {
+            previous
+                .content
+                .push_str(&code_block(file_path, &gap.concat(), indentation));
+        }
+        previous.content.push_str(&comment.content);
+        previous.end_byte = comment.end_byte;
+    }
+    merged
+}
+
+/// Returns the lines strictly between the line a comment ends on and the line
+/// the next one starts on.
+fn gap_lines(content: &str, end_byte: usize, next_start_byte: usize) -> Vec<&str> {
+    let mut start = end_byte.min(content.len());
+    if start > 0 && !content[..start].ends_with('\n') {
+        start = content[start..]
+            .find('\n')
+            .map_or(content.len(), |index| start + index + 1);
+    }
+    let end = content[..next_start_byte]
+        .rfind('\n')
+        .map_or(0, |index| index + 1);
+    match start < end {
+        true => content[start..end].split_inclusive('\n').collect(),
+        false => Vec::new(),
+    }
+}
+
+/// Render the code of a gap as a fenced code block in the language of the file.
+fn code_block(file_path: &FilePath, code: &str, indentation: CodeIndentation) -> String {
+    let language = file_path
+        .as_relative()
+        .extension()
+        .map(extension_language)
+        .unwrap_or_default();
+    let code = indentation.apply(code.trim_matches(|c| c == '\n'));
+    format!("\n```{}\n{}\n```\n\n", language, code.trim_end())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::CommentParser;
+
+    const SOURCE: &str = "fn main() {\n    // 📖 # Retries\n    // Requests are retried up to three times,\n    let retries = 3;\n\n    // 📖 with a delay doubling after each attempt.\n    let delay = 100;\n    let jitter = 10;\n    // 📖 Done.\n}\n";
+
+    fn merge(max_gap_lines: usize, capture_code: bool) -> Vec<String> {
+        let comments = CommentParser::new()
+            .extract_doc_comments(SOURCE, "rs")
+            .unwrap();
+        merge_comments(
+            &FilePath::from("src/main.rs"),
+            SOURCE,
+            comments,
+            max_gap_lines,
+            capture_code,
+            CodeIndentation::Dedent,
+        )
+        .into_iter()
+        .map(|comment| format!("line {}:\n{}", comment.start_line, comment.content))
+        .collect()
+    }
+
+    #[test]
+    fn test_merge_comments_disabled_by_default() {
+        assert_eq!(merge(0, false).len(), 3);
+    }
+
+    #[test]
+    fn test_merge_comments_within_gap() {
+        assert_eq!(
+            merge(1, false),
+            [
+                "line 2:\n# Retries\nRequests are retried up to three times,\nwith a delay doubling after each attempt.\n",
+                "line 9:\nDone.\n",
+            ]
+        );
+        assert_eq!(
+            merge(2, false),
+            [
+                "line 2:\n# Retries\nRequests are retried up to three times,\nwith a delay doubling after each attempt.\nDone.\n"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_merge_comments_capturing_code() {
+        assert_eq!(
+            merge(2, true),
+            [
+                "line 2:\n# Retries\nRequests are retried up to three times,\n\n```rust\nlet retries = 3;\n```\n\nwith a delay doubling after each attempt.\n\n```rust\nlet delay = 100;\nlet jitter = 10;\n```\n\nDone.\n"
+            ]
+        );
+    }
+}
@@ -12,21 +12,99 @@ pub struct ExtractedComment {
     pub start_byte: usize,
     /// Ending byte offset in the file
     pub end_byte: usize,
+    /// True if the comment starts before any code in the file
+    pub before_code: bool,
+    /// True if the comment is disabled with a skip pragma, see [`crate::skip`]
+    pub skipped: bool,
+}
+
+/// The set of documentation markers recognized at the start of a comment.
+///
+/// Markers are matched against the comment text after the language's comment
+/// delimiter (`//`, `#`, `/*`, ...) has been removed, so a `// doc: ...` comment
+/// is picked up by the marker `doc:`.
+#[derive(Debug, Clone)]
+pub struct MarkerConfig {
+    markers: Vec<String>,
+}
+
+/* 📖 # Why try the markers in configuration order?
+
+Markers are user supplied, and the configuration is the one place that says
+which of them takes precedence. They are therefore tried in the order they are
+configured, and the first matching marker is stripped. When one marker is a
+prefix of another (e.g. `NOTE` and `NOTE TO SELF:`), the longer one must be
+listed first to ever match, otherwise the shorter one strips its start.
+
+A marker must be followed by whitespace (or end the comment) to count, so that
+`📖No space` or `DOCUMENTATION` are not mistaken for markers. Markers that end in
+whitespace themselves (e.g. `/ `) already carry their delimiter.
+*/
+impl MarkerConfig {
+    /// Create a marker configuration from a list of accepted prefixes.
+    ///
+    /// Empty markers are ignored, as they would match every comment.
+    ///
+    /// # Example
+    /// ```
+    /// use hyperlit_engine::MarkerConfig;
+    /// let markers = MarkerConfig::new(["📖", "doc:"]);
+    /// assert_eq!(markers.markers(), ["📖", "doc:"]);
+    /// ```
+    pub fn new(markers: impl IntoIterator<Item = impl Into<String>>) -> Self {
+        Self {
+            markers: markers
+                .into_iter()
+                .map(Into::into)
+                .filter(|marker: &String| !marker.is_empty())
+                .collect(),
+        }
+    }
+
+    /// The configured markers, in configuration order.
+    pub fn markers(&self) -> &[String] {
+        &self.markers
+    }
+
+    /// Strip the first matching marker, in configuration order, from the start of `text`.
+    ///
+    /// Returns the remaining text directly after the marker, or None if the text
+    /// does not start with any of the configured markers.
+    pub fn strip_marker<'a>(&self, text: &'a str) -> Option<&'a str> {
+        self.markers.iter().find_map(|marker| {
+            let rest = text.strip_prefix(marker.as_str())?;
+            let is_delimited = marker.ends_with(char::is_whitespace)
+                || rest.is_empty()
+                || rest.starts_with(char::is_whitespace);
+            is_delimited.then_some(rest)
+        })
+    }
+}
+
+impl Default for MarkerConfig {
+    /// Default markers: 📖 (emoji), DOC:, DOCS:, HINT:, NOTE:, INFO:
+    fn default() -> Self {
+        Self::new(["📖", "DOC:", "DOCS:", "HINT:", "NOTE:", "INFO:"])
+    }
 }
 
 use hyperlit_base::{HyperlitResult, bail};
-use std::collections::HashSet;
 use std::str::FromStr;
 use syntect::easy::ScopeRegionIterator;
 use syntect::highlighting::ScopeSelectors;
 
+use crate::language::{LanguageRegistry, LanguageSpec, RegionKind, SourceRegion};
+
 /// Parses code comments from source files using syntect for language detection.
 pub struct CommentParser {
     syntax_set: syntect::parsing::SyntaxSet,
     comment_selector: ScopeSelectors,
+    block_comment_selector: ScopeSelectors,
     punctuation_selector: ScopeSelectors,
     /// Documentation markers to look for in comments (e.g., "📖", "DOC:", "DOCS:", "HINT:")
-    doc_comment_markers: HashSet<String>,
+    marker_config: MarkerConfig,
+    /// Language specs for extensions syntect does not handle (or that are overridden)
+    language_registry: LanguageRegistry,
 }
 
 impl CommentParser {
@@ -34,7 +112,7 @@ impl CommentParser {
     ///
     /// Default markers: 📖 (emoji), DOC:, DOCS:, HINT:, NOTE:, INFO:
     pub fn new() -> Self {
-        Self::with_markers(["📖", "DOC:", "DOCS:", "HINT:", "NOTE:", "INFO:"])
+        Self::with_marker_config(MarkerConfig::default())
     }
 
     /// Create a comment parser with custom documentation markers.
@@ -48,21 +126,36 @@ impl CommentParser {
     /// let parser = CommentParser::with_markers(vec!["📖".to_string(), "DOC:".to_string()]);
     /// ```
     pub fn with_markers(markers: impl IntoIterator<Item = impl Into<String>>) -> Self {
+        Self::with_marker_config(MarkerConfig::new(markers))
+    }
+
+    /// Create a comment parser recognizing the markers of the given configuration.
+    pub fn with_marker_config(marker_config: MarkerConfig) -> Self {
         let syntax_set = syntect::parsing::SyntaxSet::load_defaults_newlines();
         // Create a scope selector that matches comment scopes but excludes punctuation
         // This gives us the comment content without the comment delimiters (// # /* etc.)
         let comment_selector =
             ScopeSelectors::from_str("comment").expect("Failed to create comment scope selector");
+        let block_comment_selector = ScopeSelectors::from_str("comment.block")
+            .expect("Failed to create block comment scope selector");
         let punctuation_selector = ScopeSelectors::from_str("punctuation")
             .expect("Failed to create comment punctuation scope selector");
         Self {
             syntax_set,
             comment_selector,
+            block_comment_selector,
             punctuation_selector,
-            doc_comment_markers: markers.into_iter().map(|s| s.into()).collect(),
+            marker_config,
+            language_registry: LanguageRegistry::default(),
         }
     }
 
+    /// Use the given registry to look up language specs by file extension.
+    pub fn with_language_registry(mut self, language_registry: LanguageRegistry) -> Self {
+        self.language_registry = language_registry;
+        self
+    }
+
     /// Get the syntect syntax for a file extension.
     ///
     /// Returns None if the extension is not recognized.
@@ -79,115 +172,362 @@ impl CommentParser {
     /// based on their scope information. Only extracts markdown documentation from
     /// actual comment tokens, preventing false positives from emoji in strings or code.
     ///
-    /// Returns a vector of extracted comments. If the file extension is not recognized,
-    /// returns an empty vector.
+    /// Languages are resolved by file extension in this order:
+    /// 1. A spec registered in the language registry
+    /// 2. A syntect syntax definition
+    /// 3. The registry's default spec
+    ///
+    /// Returns an error if the file extension is not recognized by any of these.
     pub fn extract_doc_comments(
         &self,
         content: &str,
         file_extension: &str,
     ) -> HyperlitResult<Vec<ExtractedComment>> {
+        if let Some(spec) = self.language_registry.get(file_extension) {
+            return Ok(self.extract_with_spec(content, spec));
+        }
         // Get the syntax definition for this file extension
         let syntax = match self.get_syntax_for_extension(file_extension) {
             Some(s) => s,
-            None => {
-                bail!("Unknown extension: {}", file_extension)
-            }
+            None => match self.language_registry.default_spec() {
+                Some(spec) => return Ok(self.extract_with_spec(content, spec)),
+                None => bail!("Unknown extension: {}", file_extension),
+            },
         };
         // Build scope stack to track which parts are comments
         let mut scope_stack = syntect::parsing::ScopeStack::new();
 
-        let mut extracted = Vec::new();
+        let mut collector = CommentCollector::new(&self.marker_config);
         let mut parse_state = syntect::parsing::ParseState::new(&syntax);
-        let mut current_byte = 0;
-        #[derive(Debug)]
-        enum ExtractorState {
-            Code,
-            DocComment(ExtractedComment),
-            PlainComment,
-        }
-        let mut extractor_state = ExtractorState::Code;
+        let mut line_start_byte = 0;
         for (line_idx, line) in content.split_inclusive('\n').enumerate() {
             let line_num = line_idx + 1;
-            let line_start_byte = current_byte;
+            let mut current_byte = line_start_byte;
 
             // Parse this line with syntect to get scope operations
             let ops = parse_state.parse_line(line, &self.syntax_set)?;
 
-            'inner: for (text, op) in ScopeRegionIterator::new(&ops, line) {
+            for (text, op) in ScopeRegionIterator::new(&ops, line) {
                 // Apply the scope operation to our stack
                 scope_stack.apply(op)/*TODO: .with_context(format!("Error applying op in line {line_num}"))?*/?;
                 if text.is_empty() {
                     // skip empty strings
                     continue;
                 }
-                let end_byte = current_byte + text.len();
+                let scopes = scope_stack.as_slice();
                 // Check if current scope matches comment selector (comment without punctuation)
-                let is_in_comment = self
-                    .comment_selector
-                    .does_match(scope_stack.as_slice())
-                    .is_some();
-                if is_in_comment {
-                    if self
-                        .punctuation_selector
-                        .does_match(scope_stack.as_slice())
-                        .is_some()
-                    {
-                        current_byte += text.len();
-                        continue 'inner;
-                    }
-                    match &mut extractor_state {
-                        ExtractorState::Code => 'code: {
-                            let Some((indicator, text_rest)) =
-                                text.trim_start().split_once(char::is_whitespace)
-                            else {
-                                // No whitespace found -> no potential indicator present
-                                extractor_state = ExtractorState::PlainComment;
-                                break 'code;
-                            };
-                            if !self.doc_comment_markers.contains(indicator) {
-                                // Not a doc comment
-                                extractor_state = ExtractorState::PlainComment;
-                                break 'code;
-                            }
-                            let doc_comment = text_rest.trim_start();
-                            let start_byte = line_start_byte + doc_comment.as_ptr() as usize
-                                - line.as_ptr() as usize;
-                            let content = doc_comment.to_string();
-                            extractor_state = ExtractorState::DocComment(ExtractedComment {
-                                start_byte,
-                                end_byte,
-                                start_line: line_num,
-                                content,
-                            });
-                        }
-                        ExtractorState::DocComment(doc_comment) => {
-                            doc_comment
-                                .content
-                                .push_str(text.strip_prefix(" ").unwrap_or(text));
-                            doc_comment.end_byte = end_byte;
+                let kind = if self.comment_selector.does_match(scopes).is_none() {
+                    RegionKind::Code
+                } else if self.punctuation_selector.does_match(scopes).is_some() {
+                    RegionKind::Delimiter
+                } else if self.block_comment_selector.does_match(scopes).is_some() {
+                    RegionKind::BlockComment
+                } else {
+                    RegionKind::LineComment
+                };
+                collector.push(&SourceRegion {
+                    text,
+                    start_byte: current_byte,
+                    line: line_num,
+                    kind,
+                });
+                current_byte += text.len();
+            }
+
+            line_start_byte += line.len();
+        }
+        Ok(collector.finish())
+    }
+
+    /// Extract doc comments using a language spec instead of a syntect syntax.
+    pub(crate) fn extract_with_spec(
+        &self,
+        content: &str,
+        spec: &LanguageSpec,
+    ) -> Vec<ExtractedComment> {
+        collect_comments(&self.marker_config, &spec.lex(content))
+    }
+}
+
+/// Turn lexed source regions into the doc comments marked with one of the configured markers.
+pub(crate) fn collect_comments(
+    marker_config: &MarkerConfig,
+    regions: &[SourceRegion],
+) -> Vec<ExtractedComment> {
+    let mut collector = CommentCollector::new(marker_config);
+    for region in regions {
+        collector.push(region);
+    }
+    collector.finish()
+}
+
+/// State machine turning a stream of source regions into extracted doc comments.
+///
+/// Consecutive comments (only separated by whitespace) are merged into a single
+/// doc comment if the first one starts with a documentation marker, unless the
+/// later one is a block comment starting with a marker of its own.
+struct CommentCollector<'a> {
+    marker_config: &'a MarkerConfig,
+    state: CollectorState,
+    extracted: Vec<ExtractedComment>,
+    /// Whether any code (other than whitespace) was seen yet
+    seen_code: bool,
+    /// Whether the regions are inside a block comment, until its closing delimiter
+    in_block: bool,
+    /// Whether the current block comment has any text yet, other than whitespace and gutters
+    block_has_text: bool,
+    /// Content length and end of the doc comment when the current block comment started
+    block_start: (usize, usize),
+}
+
+#[derive(Debug)]
+enum CollectorState {
+    Code,
+    DocComment {
+        comment: ExtractedComment,
+        is_block: bool,
+        /// Length of the content taken from the line containing the marker
+        marker_line_len: usize,
+    },
+    PlainComment,
+}
+
+impl<'a> CommentCollector<'a> {
+    fn new(marker_config: &'a MarkerConfig) -> Self {
+        Self {
+            marker_config,
+            state: CollectorState::Code,
+            extracted: Vec::new(),
+            seen_code: false,
+            in_block: false,
+            block_has_text: false,
+            block_start: (0, 0),
+        }
+    }
+
+    /// Returns the text after the marker if a comment starts with a documentation marker.
+    ///
+    /// Block comments may start with a `*` gutter, as in `/**` or ` * 📖`.
+    fn doc_text<'t>(&self, text: &'t str, is_block: bool) -> Option<&'t str> {
+        let text = text.trim_start();
+        self.marker_config.strip_marker(text).or_else(|| {
+            let text = text.strip_prefix('*').filter(|_| is_block)?;
+            self.marker_config.strip_marker(text.trim_start())
+        })
+    }
+
+    fn push(&mut self, region: &SourceRegion) {
+        let text = region.text;
+        let end_byte = region.start_byte + text.len();
+        match region.kind {
+            RegionKind::Delimiter => {
+                // Comment delimiters are neither content nor code, the one
+                // after the text of a block comment closes it
+                self.in_block = false;
+            }
+            RegionKind::LineComment | RegionKind::BlockComment => {
+                let is_block = region.kind == RegionKind::BlockComment;
+                let mut first_text = false;
+                if is_block {
+                    if !std::mem::replace(&mut self.in_block, true) {
+                        self.block_has_text = false;
+                        if let CollectorState::DocComment { comment, .. } = &self.state {
+                            self.block_start = (comment.content.len(), comment.end_byte);
                         }
-                        ExtractorState::PlainComment => {
-                            // ignore
+                    }
+                    if !self.block_has_text && matches!(text.trim(), "" | "*") {
+                        // Blank lines and gutters before the text of a block comment
+                        if matches!(self.state, CollectorState::Code) {
+                            return;
                         }
+                    } else {
+                        first_text = !std::mem::replace(&mut self.block_has_text, true);
                     }
-                } else if !text.trim().is_empty() {
-                    // When text is whitespace only, keep the state in order to merge line comments
-                    if let ExtractorState::DocComment(doc_comment) = extractor_state {
-                        extracted.push(doc_comment);
+                }
+                // A block comment starting with a marker is a doc comment of its own
+                if first_text && self.doc_text(text, is_block).is_some() {
+                    if let CollectorState::DocComment { comment, .. } = &mut self.state {
+                        let (content_len, end_byte) = self.block_start;
+                        comment.content.truncate(content_len);
+                        comment.end_byte = end_byte;
                     }
-                    extractor_state = ExtractorState::Code;
+                    self.flush();
                 }
-                current_byte += text.len();
+                self.push_comment(region, is_block, end_byte);
             }
+            RegionKind::Code => {
+                // When text is whitespace only, keep the state in order to merge line comments
+                if !text.trim().is_empty() {
+                    self.flush();
+                    self.state = CollectorState::Code;
+                    self.seen_code = true;
+                }
+            }
+        }
+    }
 
-            current_byte = line_start_byte + line.len() + 1; // Account for newline
+    /// Add the text of a comment region to the current doc comment, or start one.
+    fn push_comment(&mut self, region: &SourceRegion, is_block: bool, end_byte: usize) {
+        let text = region.text;
+        match &mut self.state {
+            CollectorState::Code => {
+                let Some(text_rest) = self.doc_text(text, is_block) else {
+                    // Not a doc comment
+                    self.state = CollectorState::PlainComment;
+                    return;
+                };
+                let doc_comment = text_rest.trim_start();
+                let start_byte = region.start_byte + (text.len() - doc_comment.len());
+                self.state = CollectorState::DocComment {
+                    comment: ExtractedComment {
+                        start_byte,
+                        end_byte,
+                        start_line: region.line,
+                        content: doc_comment.to_string(),
+                        before_code: !self.seen_code,
+                        skipped: false,
+                    },
+                    is_block,
+                    marker_line_len: doc_comment.len(),
+                };
+            }
+            CollectorState::DocComment { comment, .. } => {
+                if is_block {
+                    comment.content.push_str(text);
+                } else {
+                    comment
+                        .content
+                        .push_str(text.strip_prefix(" ").unwrap_or(text));
+                }
+                comment.end_byte = end_byte;
+            }
+            CollectorState::PlainComment => {
+                // ignore
+            }
         }
-        // handle last comment
-        if let ExtractorState::DocComment(doc_comment) = extractor_state {
-            extracted.push(doc_comment);
+    }
+
+    /// Finish the current doc comment, if any.
+    fn flush(&mut self) {
+        let state = std::mem::replace(&mut self.state, CollectorState::Code);
+        if let CollectorState::DocComment {
+            mut comment,
+            is_block,
+            marker_line_len,
+        } = state
+        {
+            if is_block {
+                let rest = dedent(&strip_gutter(&dedent(&comment.content[marker_line_len..])));
+                comment.content.truncate(marker_line_len);
+                comment.content.push_str(&rest);
+            }
+            self.extracted.push(comment);
         }
-        Ok(extracted)
     }
+
+    fn finish(mut self) -> Vec<ExtractedComment> {
+        // handle last comment
+        self.flush();
+        self.extracted
+    }
+}
+
+/* 📖 # Why de-indent block comments?
+
+Block comments are usually indented to line up with their opening delimiter:
+
+```text
+    /* 📖 # Why cache?
+       Caching avoids repeated lookups.
+     */
+```
+
+The lines after the marker line keep that indentation, which markdown would
+interpret as an indented code block. Removing the indentation common to all
+continuation lines restores the intended markdown while keeping any relative
+indentation (nested lists, code blocks) intact. Only identical leading
+whitespace counts as common: a tab is never taken for some number of spaces,
+which would depend on the tab width of the author's editor.
+*/
+
+/* 📖 # Why strip gutters of block comments?
+
+Java-style block comments, common in Java, C, Go and JavaScript, start every
+line with a `*` gutter:
+
+```text
+/**
+ * 📖 # Why cache?
+ * Caching avoids repeated lookups.
+ *
+ *     let kept = "relative indentation";
+ */
+```
+
+The marker may follow the opening delimiter or sit on the first gutter line,
+the document starts at the marker either way. The gutter is not part of the
+documentation, and a line starting with `* ` would become a list item, so it
+is removed from the lines after the marker: a `*` and the space after it, if
+every non-blank line has one. Comments in which only some lines start with a
+`*` keep them, they are most likely markdown lists. A comment that consists of
+a bullet list only is indistinguishable from a gutter, its lines need another
+list marker (`-`).
+
+A marker inside a block comment never starts a document, only one at the start
+of the comment does. A block comment directly following a doc comment is merged
+into it, like consecutive line comments, unless it starts with a marker itself.
+*/
+
+/// Remove the `*` gutter from lines of a block comment, if all non-blank lines have one.
+fn strip_gutter(text: &str) -> String {
+    let has_gutter = text
+        .lines()
+        .filter(|line| !line.trim().is_empty())
+        .all(|line| line == "*" || line.starts_with("* ") || line.starts_with("*\t"));
+    if !has_gutter {
+        return text.to_string();
+    }
+    text.split_inclusive('\n')
+        .map(|line| {
+            let rest = line.strip_prefix('*').unwrap_or(line);
+            rest.strip_prefix([' ', '\t']).unwrap_or(rest)
+        })
+        .collect()
+}
+
+/// Remove the leading whitespace common to all non-blank lines.
+///
+/// Indentation is compared character by character, a tab and a space are
+/// different, so a tab-indented and a space-indented line have no common
+/// indentation and both are left as they are.
+pub(crate) fn dedent(text: &str) -> String {
+    fn indentation(line: &str) -> &str {
+        &line[..line.len() - line.trim_start_matches([' ', '\t']).len()]
+    }
+    let mut common: Option<&str> = None;
+    for line in text
+        .split_inclusive('\n')
+        .filter(|line| !line.trim().is_empty())
+    {
+        let indent = indentation(line);
+        let length = match common {
+            None => indent.len(),
+            Some(common) => common
+                .bytes()
+                .zip(indent.bytes())
+                .take_while(|(a, b)| a == b)
+                .count(),
+        };
+        common = Some(&indent[..length]);
+    }
+    let common = common.unwrap_or_default();
+    text.split_inclusive('\n')
+        .map(|line| match line.strip_prefix(common) {
+            Some(rest) => rest,
+            // Only blank lines lack the common indentation
+            None => line.trim_start_matches([' ', '\t']),
+        })
+        .collect()
 }
 
 impl Default for CommentParser {
@@ -208,8 +548,9 @@ mod tests {
     /// - Define expected extracted comments via expect-test snapshots
     /// - Handle both successful extractions and expected errors
     macro_rules! assert_extracted_comments {
-        ($source:expr, $ext:expr, $expected:expr) => {{
-            let parser = CommentParser::new();
+        ($source:expr, $ext:expr, $expected:expr) => {{ assert_extracted_comments!(CommentParser::new(), $source, $ext, $expected) }};
+        ($parser:expr, $source:expr, $ext:expr, $expected:expr) => {{
+            let parser = $parser;
             let result = parser.extract_doc_comments($source, $ext);
 
             let actual = match result {
@@ -380,7 +721,7 @@ println!("hello");
                 ---
                 line 6:
                 Block comment
-                  documentation "#]]
+                documentation "#]]
         );
     }
 
@@ -392,4 +733,249 @@ println!("hello");
             expect!["(no comments extracted)"]
         );
     }
+
+    #[test]
+    fn test_custom_ascii_marker() {
+        assert_extracted_comments!(
+            CommentParser::with_markers(["doc:"]),
+            r#"// doc: Custom marker docs
+fn foo() {}
+// 📖 Not a configured marker"#,
+            "rs",
+            expect![[r#"
+                line 1:
+                Custom marker docs
+            "#]]
+        );
+    }
+
+    #[test]
+    fn test_markers_tried_in_configuration_order() {
+        let source = r#"// NOTE TO SELF: remember the milk
+fn foo() {}
+// NOTE plain note"#;
+        assert_extracted_comments!(
+            CommentParser::with_markers(["NOTE TO SELF:", "NOTE"]),
+            source,
+            "rs",
+            expect![[r#"
+                line 1:
+                remember the milk
+
+                ---
+                line 3:
+                plain note"#]]
+        );
+        // The shorter marker listed first strips the start of the longer one
+        assert_extracted_comments!(
+            CommentParser::with_markers(["NOTE", "NOTE TO SELF:"]),
+            source,
+            "rs",
+            expect![[r#"
+                line 1:
+                TO SELF: remember the milk
+
+                ---
+                line 3:
+                plain note"#]]
+        );
+    }
+
+    #[test]
+    fn test_multibyte_and_ascii_markers() {
+        let source = r#"// ✍️ Multibyte marker
+fn foo() {}
+// doc: ASCII marker"#;
+        let parser = CommentParser::with_markers(["✍️", "doc:"]);
+        let comments = parser.extract_doc_comments(source, "rs").unwrap();
+
+        assert_eq!(comments.len(), 2);
+        assert_eq!(comments[0].content, "Multibyte marker\n");
+        assert_eq!(comments[0].start_byte, "// ✍️ ".len());
+        assert_eq!(comments[1].content, "ASCII marker");
+        assert_eq!(comments[1].start_line, 3);
+    }
+
+    #[test]
+    fn test_strip_marker() {
+        let markers = MarkerConfig::new(["📖", "DOC:", "DOCS:", "/ ", ""]);
+
+        assert_eq!(markers.strip_marker("📖 Emoji"), Some(" Emoji"));
+        assert_eq!(markers.strip_marker("DOCS: Plural"), Some(" Plural"));
+        assert_eq!(markers.strip_marker("DOC: Singular"), Some(" Singular"));
+        assert_eq!(
+            markers.strip_marker("/ Trailing space"),
+            Some("Trailing space")
+        );
+        assert_eq!(markers.strip_marker("📖"), Some(""));
+        assert_eq!(markers.strip_marker("📖No space"), None);
+        assert_eq!(markers.strip_marker("DOCUMENTATION"), None);
+        assert_eq!(markers.strip_marker("plain comment"), None);
+    }
+
+    #[test]
+    fn test_dedent_mixed_tabs_and_spaces() {
+        assert_eq!(dedent("    a\n      b\n\n"), "a\n  b\n\n");
+        assert_eq!(dedent("\t\ta\n\t    b\n"), "\ta\n    b\n");
+        // A tab is not some number of spaces
+        assert_eq!(dedent("\ta\n    b\n"), "\ta\n    b\n");
+        assert_eq!(dedent(" \ta\n\t b\n"), " \ta\n\t b\n");
+        // Blank lines do not shorten the common indentation
+        assert_eq!(dedent("\t\ta\n \n\t\tb\n"), "a\n\nb\n");
+    }
+
+    #[test]
+    fn test_block_comment_dedent() {
+        assert_extracted_comments!(
+            r#"fn foo() {
+    /* 📖
+       # Why dedent?
+       Markdown would treat indented lines as code.
+
+           let kept = "relative indentation";
+     */
+}"#,
+            "rs",
+            expect![[r#"
+                line 2:
+                # Why dedent?
+                Markdown would treat indented lines as code.
+
+                    let kept = "relative indentation";
+            "#]]
+        );
+    }
+
+    #[test]
+    fn test_block_comment_gutter() {
+        assert_extracted_comments!(
+            r#"/**
+ * 📖 # Why cache?
+ * Caching avoids repeated lookups:
+ *
+ * - fewer queries
+ *     let kept = "relative indentation";
+ */
+class Cache {}
+
+/** 📖 # Why pool?
+ * Connections are expensive.
+ */
+class Pool {}
+"#,
+            "java",
+            expect![[r#"
+                line 2:
+                # Why cache?
+                Caching avoids repeated lookups:
+
+                - fewer queries
+                    let kept = "relative indentation";
+
+                ---
+                line 10:
+                # Why pool?
+                Connections are expensive.
+            "#]]
+        );
+    }
+
+    #[test]
+    fn test_block_comment_list_is_no_gutter() {
+        assert_extracted_comments!(
+            "/* 📖 # Steps\nIn order:\n* parse\n* render\n*/\nfn main() {}\n",
+            "rs",
+            expect![[r#"
+                line 1:
+                # Steps
+                In order:
+                * parse
+                * render
+            "#]]
+        );
+    }
+
+    #[test]
+    fn test_block_comment_markers_do_not_double_trigger() {
+        assert_extracted_comments!(
+            r#"/* 📖 # Retries
+ * Marked with 📖 like all docs.
+ */
+/*
+ * 📖 # Limits
+ */
+/* Continued. */
+fn retry() {}
+"#,
+            "rs",
+            expect![[r#"
+                line 1:
+                # Retries
+                Marked with 📖 like all docs.
+
+                ---
+                line 5:
+                # Limits
+                Continued. "#]]
+        );
+    }
+
+    #[test]
+    fn test_language_spec_for_unknown_syntax() {
+        assert_extracted_comments!(
+            r#"// 📖 # TypeScript guards
+const s = "// 📖 not a comment";
+/* 📖 Block
+   docs */"#,
+            "ts",
+            expect![[r#"
+                line 1:
+                # TypeScript guards
+
+                ---
+                line 3:
+                Block
+                docs "#]]
+        );
+    }
+
+    #[test]
+    fn test_default_language_spec() {
+        let registry =
+            LanguageRegistry::empty().with_default_spec(LanguageSpec::new().with_line_comment(";"));
+        assert_extracted_comments!(
+            CommentParser::new().with_language_registry(registry),
+            "; 📖 Fallback docs\n(println \"hi\")",
+            "clj",
+            expect![[r#"
+                line 1:
+                Fallback docs
+            "#]]
+        );
+    }
+
+    #[test]
+    fn test_registered_spec_overrides_syntect() {
+        let mut registry = LanguageRegistry::empty();
+        registry.register("rs", LanguageSpec::new().with_line_comment("%"));
+        assert_extracted_comments!(
+            CommentParser::new().with_language_registry(registry),
+            "// 📖 Not a comment anymore\n% 📖 Custom comment",
+            "rs",
+            expect![[r#"
+                line 2:
+                Custom comment"#]]
+        );
+    }
+
+    #[test]
+    fn test_byte_offsets_on_later_lines() {
+        let source = "fn foo() {}\n\n// 📖 Third line\n";
+        let comments = CommentParser::new()
+            .extract_doc_comments(source, "rs")
+            .unwrap();
+        assert_eq!(comments.len(), 1);
+        assert!(source[comments[0].start_byte..].starts_with("Third line"));
+        assert_eq!(comments[0].end_byte, source.len());
+    }
 }
@@ -0,0 +1,288 @@
+/* 📖 # Why support conditional blocks?
+
+The same sources often ship in several editions, e.g. an "oss" and an
+"enterprise" build, and parts of the documentation only apply to one of them.
+Conditional blocks keep those parts next to the code they describe instead of
+in separate documents per edition:
+
+```text
+// 📖 # Authentication
+// Users log in with a password.
+// {{if enterprise}}
+// Single sign-on is available via SAML.
+// {{end}}
+```
+
+The build profiles active for a site are set in the render options. A block is
+shown if its profile is active and omitted entirely otherwise, including its
+headings, which therefore do not appear in the table of contents either.
+Blocks nest, an inner block is only shown if all enclosing blocks are.
+
+Directives must be on a line of their own, and are ignored inside code blocks
+so the syntax can be documented. Omitted lines (and the directive lines) are
+replaced with empty lines rather than removed, so the lines of the remaining
+content, and any warnings about it, still match the source file.
+
+An `{{if}}` without `{{end}}` (or the other way round) would silently hide or
+show the rest of the document, so extraction reports it as a `ParseError`.
+*/
+
+use std::borrow::Cow;
+use std::collections::BTreeSet;
+use std::sync::LazyLock;
+
+use pulldown_cmark::{Event, Parser, Tag};
+use regex::Regex;
+
+use crate::export::LineMap;
+use crate::parse_error::column_of;
+use crate::{Document, ParseError};
+
+static IF_DIRECTIVE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"^\{\{if\s+([\w-]+)\s*\}\}$").expect("valid regex"));
+
+static END_DIRECTIVE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"^\{\{end\}\}$").expect("valid regex"));
+
+/// A `{{if profile}}` or `{{end}}` line in markdown content.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Directive<'a> {
+    /// Byte offset of the start of the line
+    offset: usize,
+    /// The profile of an `{{if profile}}` directive, None for `{{end}}`
+    profile: Option<&'a str>,
+}
+
+/// Find all conditional directives outside of code blocks, in content order.
+fn find_directives(content: &str) -> Vec<Directive<'_>> {
+    let code_blocks: Vec<_> = Parser::new(content)
+        .into_offset_iter()
+        .filter(|(event, _)| matches!(event, Event::Start(Tag::CodeBlock(_))))
+        .map(|(_, range)| range)
+        .collect();
+    let mut directives = Vec::new();
+    let mut offset = 0;
+    for line in content.split_inclusive('\n') {
+        let trimmed = line.trim();
+        let profile = if END_DIRECTIVE.is_match(trimmed) {
+            Some(None)
+        } else {
+            IF_DIRECTIVE
+                .captures(trimmed)
+                .and_then(|captures| captures.get(1))
+                .map(|profile| Some(profile.as_str()))
+        };
+        let line_end = offset + line.len();
+        if let Some(profile) = profile
+            && !code_blocks
+                .iter()
+                .any(|range| range.start < line_end && offset < range.end)
+        {
+            directives.push(Directive { offset, profile });
+        }
+        offset = line_end;
+    }
+    directives
+}
+
+/// Check that the conditional blocks of a document extracted from `source` are balanced.
+pub(crate) fn check_conditionals(document: &Document, source: &str) -> Vec<ParseError> {
+    let lines = LineMap::new(document.content(), document.source().line_number());
+    let error = |offset: usize, message: String| {
+        let line = lines.line_of(offset);
+        ParseError {
+            file_path: document.source().file_path().clone(),
+            line,
+            column: column_of(source, line, "{{"),
+            message,
+        }
+    };
+    let mut errors = Vec::new();
+    let mut open = Vec::new();
+    for directive in find_directives(document.content()) {
+        match directive.profile {
+            Some(_) => open.push(directive),
+            None if open.pop().is_none() => errors.push(error(
+                directive.offset,
+                "{{end}} without a matching {{if}}".to_string(),
+            )),
+            None => {}
+        }
+    }
+    for directive in open {
+        errors.push(error(
+            directive.offset,
+            format!(
+                "unterminated {{{{if {}}}}} block started at line {}",
+                directive.profile.unwrap_or_default(),
+                lines.line_of(directive.offset)
+            ),
+        ));
+    }
+    errors.sort_by_key(|error| error.line);
+    errors
+}
+
+/// Remove the conditional blocks whose profile is not in `profiles`.
+///
+/// Returns None if the content has no conditional blocks. Omitted lines and
+/// directive lines become empty lines. An unterminated block extends to the
+/// end of the content, an `{{end}}` without `{{if}}` is dropped.
+fn strip_conditionals(content: &str, profiles: &BTreeSet<String>) -> Option<String> {
+    let directives = find_directives(content);
+    if directives.is_empty() {
+        return None;
+    }
+    let mut directives = directives.into_iter().peekable();
+    let mut stripped = String::with_capacity(content.len());
+    // Whether each enclosing block is shown
+    let mut shown: Vec<bool> = Vec::new();
+    let mut offset = 0;
+    for line in content.split_inclusive('\n') {
+        let directive = directives.next_if(|directive| directive.offset == offset);
+        offset += line.len();
+        match directive.map(|directive| directive.profile) {
+            Some(Some(profile)) => shown.push(profiles.contains(profile)),
+            Some(None) => {
+                shown.pop();
+            }
+            None if shown.iter().all(|shown| *shown) => {
+                stripped.push_str(line);
+                continue;
+            }
+            None => {}
+        }
+        if line.ends_with('\n') {
+            stripped.push('\n');
+        }
+    }
+    Some(stripped)
+}
+
+/// Returns the document as rendered with the active `profiles`.
+pub(crate) fn apply_profiles<'a>(
+    document: &'a Document,
+    profiles: &BTreeSet<String>,
+) -> Cow<'a, Document> {
+    match strip_conditionals(document.content(), profiles) {
+        Some(content) => Cow::Owned(document.clone().with_content(content)),
+        None => Cow::Borrowed(document),
+    }
+}
+
+/// Returns the documents as rendered with the active `profiles`.
+pub(crate) fn apply_profiles_to_all<'a>(
+    documents: &'a [Document],
+    profiles: &BTreeSet<String>,
+) -> Cow<'a, [Document]> {
+    if !documents
+        .iter()
+        .any(|document| !find_directives(document.content()).is_empty())
+    {
+        return Cow::Borrowed(documents);
+    }
+    Cow::Owned(
+        documents
+            .iter()
+            .map(|document| apply_profiles(document, profiles).into_owned())
+            .collect(),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use hyperlit_base::FilePath;
+    use std::collections::HashSet;
+
+    fn profiles(names: &[&str]) -> BTreeSet<String> {
+        names.iter().map(|name| name.to_string()).collect()
+    }
+
+    fn doc(line: usize, content: &str) -> Document {
+        Document::new(
+            "Doc".to_string(),
+            content.to_string(),
+            DocumentSource::new(SourceType::CodeComment, FilePath::from("src/lib.rs"), line),
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    const CONTENT: &str = "# Auth\n\nPasswords.\n\n{{if enterprise}}\n## SSO\n\nSAML.\n{{if beta}}\nOIDC.\n{{end}}\n{{end}}\n\nDone.\n";
+
+    #[test]
+    fn test_strip_conditionals() {
+        assert_eq!(
+            strip_conditionals(CONTENT, &profiles(&[])).unwrap(),
+            "# Auth\n\nPasswords.\n\n\n\n\n\n\n\n\n\n\nDone.\n"
+        );
+        assert_eq!(
+            strip_conditionals(CONTENT, &profiles(&["enterprise"])).unwrap(),
+            "# Auth\n\nPasswords.\n\n\n## SSO\n\nSAML.\n\n\n\n\n\nDone.\n"
+        );
+        assert_eq!(
+            strip_conditionals(CONTENT, &profiles(&["enterprise", "beta"])).unwrap(),
+            "# Auth\n\nPasswords.\n\n\n## SSO\n\nSAML.\n\nOIDC.\n\n\n\nDone.\n"
+        );
+        // Inner blocks are hidden with their enclosing block
+        assert!(
+            !strip_conditionals(CONTENT, &profiles(&["beta"]))
+                .unwrap()
+                .contains("OIDC")
+        );
+    }
+
+    #[test]
+    fn test_strip_without_conditionals() {
+        assert_eq!(strip_conditionals("# Doc\n", &profiles(&[])), None);
+        let content = "# Doc\n\n```text\n{{if oss}}\n```\n\n    {{end}}\n\nNot {{if oss}}\n";
+        assert_eq!(strip_conditionals(content, &profiles(&[])), None);
+    }
+
+    #[test]
+    fn test_apply_profiles() {
+        let document = doc(1, "# A\n{{if oss}}\n# B\n{{end}}\n");
+        assert_eq!(
+            apply_profiles(&document, &profiles(&[])).content(),
+            "# A\n\n\n\n"
+        );
+        let plain = doc(1, "# A\n");
+        assert!(matches!(
+            apply_profiles(&plain, &profiles(&[])),
+            Cow::Borrowed(_)
+        ));
+        assert!(matches!(
+            apply_profiles_to_all(std::slice::from_ref(&plain), &profiles(&[])),
+            Cow::Borrowed(_)
+        ));
+    }
+
+    #[test]
+    fn test_check_conditionals() {
+        let source = "fn a() {}\n// 📖 # Doc\n// {{if oss}}\n// {{if beta}}\n// {{end}}\n// text\n";
+        let document = doc(2, "# Doc\n{{if oss}}\n{{if beta}}\n{{end}}\ntext\n");
+        let errors: Vec<String> = check_conditionals(&document, source)
+            .iter()
+            .map(ToString::to_string)
+            .collect();
+        assert_eq!(
+            errors,
+            ["src/lib.rs:3:4: unterminated {{if oss}} block started at line 3"]
+        );
+
+        let source = "// 📖 # Doc\n// {{end}}\n";
+        let document = doc(1, "# Doc\n{{end}}\n");
+        let errors: Vec<String> = check_conditionals(&document, source)
+            .iter()
+            .map(ToString::to_string)
+            .collect();
+        assert_eq!(
+            errors,
+            ["src/lib.rs:2:4: {{end}} without a matching {{if}}"]
+        );
+
+        assert!(check_conditionals(&doc(1, CONTENT), CONTENT).is_empty());
+    }
+}
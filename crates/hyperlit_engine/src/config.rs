@@ -1,38 +1,332 @@
+/* 📖 # How is the configuration file found and loaded?
+
+A site is described by a single configuration file, so a build is reproducible
+from the repository alone: `hyperlit.toml` or, for teams preferring YAML,
+`hyperlit.yaml` (or `.yml`) with the same keys. The CLI looks for it in the
+working directory and then in each parent directory, like git does, so
+`hyperlit build` works from anywhere inside the project. The directory
+containing the file is the project root, all paths in the file are relative
+to it.
+
+Both formats are read into the same `Config` struct that applications embedding
+hyperlit fill in programmatically. Before that, explicit overrides are applied
+on top of the file, e.g. `hyperlit --set output_directory=site build`: each
+override sets a (dotted) key to a TOML value, or to a plain string if the value
+is no valid TOML. An override always wins over the file.
+
+Keys hyperlit does not know are otherwise silently ignored by the parser, and
+a misspelled `output_dir` would quietly fall back to the default. Every unknown
+key, including those of nested tables such as `[exec]` or `[[directory]]`, is
+therefore reported as a warning to the logger of the `ConfigOptions`. The known
+keys are taken from the `Deserialize` implementations of the config structs
+themselves, so they never get out of date.
+*/
+
+use std::collections::HashMap;
+use std::sync::Arc;
+use std::time::Duration;
+
 use serde::Deserialize;
+use serde::de::{self, DeserializeOwned, Deserializer, Visitor};
+use toml::{Table, Value};
+
+use hyperlit_base::pal::WalkOptions;
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle, bail, err};
 
-use hyperlit_base::{FilePath, HyperlitResult, PalHandle, err};
+use crate::logger::{Logger, NoopLogger};
+use crate::{
+    CodeIndentation, CodeWrap, DiagramMode, FollowingCodeUntil, LanguageSpec, OutputFormat,
+    OutputMode, UnlistedPages,
+};
 
 /// Configuration for a Hyperlit documentation site.
-#[derive(Debug, Deserialize, Clone)]
+#[derive(Debug, Deserialize, Clone, Default)]
 pub struct Config {
     /// Title of the documentation site.
     pub title: String,
     /// Template for generating source code links.
     pub source_link_template: String,
+    /// Template for linking issue references like `#123`, `{number}` is replaced with the number.
+    #[serde(default)]
+    pub issue_link_template: Option<String>,
+    /// Directory the static site is written to (defaults to "output").
+    #[serde(default)]
+    pub output_directory: Option<String>,
+    /// Format of the rendered site, "html" or "markdown" (defaults to "html").
+    #[serde(default)]
+    pub output_format: Option<OutputFormat>,
+    /// Render one page per source file ("multi-file"), a single self-contained "single-file",
+    /// or one page per source file in one directory with an `index.json` manifest ("flat")
+    /// (defaults to "multi-file").
+    #[serde(default)]
+    pub output_mode: Option<OutputMode>,
+    /// Number of levels every heading is shifted down by, e.g. to nest pages in a book (defaults to 0).
+    #[serde(default)]
+    pub heading_offset: Option<usize>,
+    /// How long code lines are shown in HTML, "scroll" or "wrap" (defaults to "scroll").
+    #[serde(default)]
+    pub code_wrap: Option<CodeWrap>,
+    /// Column at which code lines are wrapped with `code_wrap = "wrap"` (defaults to 100).
+    #[serde(default)]
+    pub code_wrap_column: Option<usize>,
+    /// Width tabs in code blocks are expanded to (defaults to 4 when wrapping, tabs are kept otherwise).
+    #[serde(default)]
+    pub tab_width: Option<usize>,
+    /// Directory with template files overriding the built-in HTML templates (defaults to none).
+    #[serde(default)]
+    pub theme_directory: Option<String>,
+    /// Write an index of all pages for client-side search (defaults to false).
+    #[serde(default)]
+    pub search_index: Option<bool>,
+    /// Build profiles whose `{{if profile}}` blocks are shown (defaults to none).
+    #[serde(default)]
+    pub profiles: Option<Vec<String>>,
+    /// Directory the build cache is stored in (defaults to ".hyperlit-cache").
+    #[serde(default)]
+    pub cache_directory: Option<String>,
+    /// Quiet period in milliseconds before a changed file is rebuilt in watch mode (defaults to 200).
+    #[serde(default)]
+    pub watch_debounce_ms: Option<u64>,
+    /// Number of files processed in parallel (defaults to the number of CPU cores).
+    #[serde(default)]
+    pub concurrency: Option<usize>,
+    /// Stop at the first malformed doc block instead of reporting all of them (defaults to false).
+    #[serde(default)]
+    pub fail_fast: Option<bool>,
+    /// Report directives without a registered handler as errors rather than warnings (defaults to false).
+    #[serde(default)]
+    pub fail_on_unknown_directives: Option<bool>,
+    /// Number of source lines shown after each doc comment (defaults to 0, none).
+    #[serde(default)]
+    pub include_following_code: Option<usize>,
+    /// Where the code shown after a doc comment ends, "blank-line" or "doc-comment" (defaults to "blank-line").
+    #[serde(default)]
+    pub following_code_until: Option<FollowingCodeUntil>,
+    /// How captured code is indented, "dedent" or "preserve" (defaults to "dedent").
+    #[serde(default)]
+    pub code_indentation: Option<CodeIndentation>,
+    /// Lines of code that may separate doc comments merged into one document (defaults to 0, no merging).
+    #[serde(default)]
+    pub merge_comment_gap: Option<usize>,
+    /// Keep the code between merged doc comments as a code block (defaults to false).
+    #[serde(default)]
+    pub capture_gap_code: Option<bool>,
+    /// Give code files without doc comments an empty stub page (defaults to false).
+    #[serde(default)]
+    pub emit_empty_pages: Option<bool>,
+    /// Extract doc comments disabled with a skip pragma anyway, marked as skipped
+    /// (defaults to false).
+    #[serde(default)]
+    pub include_skipped: Option<bool>,
+    /// Count the words of code blocks in the reading time of pages (defaults to false).
+    #[serde(default)]
+    pub count_code_words: Option<bool>,
+    /// Reading speed of the reading time estimates of pages (defaults to 200).
+    #[serde(default)]
+    pub words_per_minute: Option<u32>,
+    /// Settings for running commands of `{{exec: command}}` directives (disabled by default).
+    #[serde(default)]
+    pub exec: ExecConfig,
+    /// Settings for rendering diagram code blocks such as `mermaid` (off by default).
+    #[serde(default)]
+    pub diagrams: DiagramConfig,
     /// Custom directory configurations.
     #[serde(default)]
     pub directory: Vec<DirectoryConfig>,
+    /// Parts of the reading order of the pages, in order (defaults to none, pages follow their source paths).
+    #[serde(default)]
+    pub part: Vec<PartConfig>,
+    /// What happens to pages no part lists, "append" or "unlisted" (defaults to "append").
+    #[serde(default)]
+    pub unlisted_pages: Option<UnlistedPages>,
+    /// Markers that identify documentation comments (defaults to 📖, DOC:, DOCS:, HINT:, NOTE:, INFO:).
+    #[serde(default)]
+    pub markers: Option<Vec<String>>,
+    /// Comment syntax per file extension, overriding the built-in language support.
+    #[serde(default)]
+    pub languages: HashMap<String, LanguageSpec>,
+    /// Comment syntax used for file extensions without any language support.
+    #[serde(default)]
+    pub default_language: Option<LanguageSpec>,
+}
+
+impl Config {
+    /// Returns the directory the static site is written to.
+    pub fn output_directory(&self) -> FilePath {
+        FilePath::from(
+            self.output_directory
+                .as_deref()
+                .unwrap_or(DEFAULT_OUTPUT_DIRECTORY),
+        )
+    }
+
+    /// Returns the directory the build cache is stored in.
+    pub fn cache_directory(&self) -> FilePath {
+        FilePath::from(
+            self.cache_directory
+                .as_deref()
+                .unwrap_or(DEFAULT_CACHE_DIRECTORY),
+        )
+    }
+
+    /// Returns how long a changed file must stay unchanged before it is rebuilt.
+    pub fn watch_debounce(&self) -> Duration {
+        Duration::from_millis(self.watch_debounce_ms.unwrap_or(DEFAULT_WATCH_DEBOUNCE_MS))
+    }
+}
+
+/// Output directory used when none is configured.
+pub const DEFAULT_OUTPUT_DIRECTORY: &str = "output";
+
+/// Build cache directory used when none is configured.
+pub const DEFAULT_CACHE_DIRECTORY: &str = ".hyperlit-cache";
+
+/// Watch mode debounce in milliseconds used when none is configured.
+pub const DEFAULT_WATCH_DEBOUNCE_MS: u64 = 200;
+
+/// Configuration for running commands embedded with `{{exec: command}}` directives.
+///
+/// See [`crate::exec`].
+#[derive(Debug, Deserialize, Clone, Default)]
+pub struct ExecConfig {
+    /// Run the commands of exec directives (defaults to false).
+    #[serde(default)]
+    pub allow_exec: bool,
+    /// Commands allowed to run, matching commands that start with the same words (defaults to any command).
+    #[serde(default)]
+    pub allowlist: Option<Vec<String>>,
+    /// Seconds after which a command is killed (defaults to 10).
+    #[serde(default)]
+    pub timeout_seconds: Option<u64>,
+    /// Working directory for commands, strictly inside the cache directory (defaults to "exec" inside it).
+    #[serde(default)]
+    pub working_directory: Option<String>,
+}
+
+/// Configuration for rendering diagram code blocks.
+///
+/// See [`crate::diagram`].
+#[derive(Debug, Deserialize, Clone, Default)]
+pub struct DiagramConfig {
+    /// "off", "client" (drawn in the browser) or "static" (rendered to SVG at build time) (defaults to "off").
+    #[serde(default)]
+    pub mode: Option<DiagramMode>,
+    /// Renderer command per diagram language for static mode, with `{input}` and `{output}` placeholders.
+    #[serde(default)]
+    pub commands: HashMap<String, Vec<String>>,
+    /// Seconds after which a renderer is killed (defaults to 30).
+    #[serde(default)]
+    pub timeout_seconds: Option<u64>,
 }
 
 /// Configuration for a specific directory within the site.
-#[derive(Debug, Deserialize, Clone)]
+#[derive(Debug, Deserialize, Clone, Default)]
 pub struct DirectoryConfig {
     /// Paths to the directory.
     pub paths: Vec<String>,
     /// Glob patterns for files in this directory.
     pub globs: Vec<String>,
+    /// Glob patterns for files and directories to skip.
+    #[serde(default)]
+    pub exclude: Vec<String>,
+    /// Skip paths ignored by `.gitignore` files (defaults to true).
+    #[serde(default)]
+    pub respect_gitignore: Option<bool>,
+    /// Follow symbolic links to directories, with loop detection (defaults to false).
+    #[serde(default)]
+    pub follow_symlinks: Option<bool>,
+}
+
+impl DirectoryConfig {
+    /// Returns the options for walking the paths of this directory.
+    pub fn walk_options(&self) -> WalkOptions {
+        WalkOptions::new(self.globs.iter().cloned())
+            .with_exclude(self.exclude.iter().cloned())
+            .with_respect_gitignore(self.respect_gitignore.unwrap_or(true))
+            .with_follow_symlinks(self.follow_symlinks.unwrap_or_default())
+    }
+}
+
+/// A titled part of the reading order, see [`crate::reading_order`].
+#[derive(Debug, Deserialize, Clone, Default)]
+pub struct PartConfig {
+    /// Title of the part, shown in the table of contents.
+    pub title: String,
+    /// Source paths or globs of the pages of the part, in reading order.
+    pub files: Vec<String>,
+}
+
+/// Names of configuration files, in the order they are looked for in a directory.
+pub const CONFIG_FILE_NAMES: [&str; 3] = ["hyperlit.toml", "hyperlit.yaml", "hyperlit.yml"];
+
+/// Options controlling how a configuration file is loaded.
+#[derive(Debug, Clone, Default)]
+pub struct ConfigOptions {
+    overrides: Vec<(String, String)>,
+    logger: Option<Arc<dyn Logger>>,
+}
+
+impl ConfigOptions {
+    /// Create config options without overrides.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Set the (dotted) `key` to `value`, overriding the configuration file.
+    ///
+    /// The value is parsed as TOML, falling back to a plain string, see
+    /// [`crate::config`].
+    pub fn with_override(mut self, key: impl Into<String>, value: impl Into<String>) -> Self {
+        self.overrides.push((key.into(), value.into()));
+        self
+    }
+
+    /// Report unknown keys to `logger`, see [`crate::logger`].
+    pub fn with_logger(mut self, logger: impl Logger + 'static) -> Self {
+        self.logger = Some(Arc::new(logger));
+        self
+    }
+
+    /// Returns the overridden keys with their values, in the order they are applied.
+    pub fn overrides(&self) -> &[(String, String)] {
+        &self.overrides
+    }
+
+    /// Returns the logger unknown keys are reported to.
+    pub fn logger(&self) -> &dyn Logger {
+        self.logger.as_deref().unwrap_or(&NoopLogger)
+    }
+}
+
+/// Returns the configuration file in `directory` or its nearest parent
+/// directory containing one, None if there is none.
+///
+/// See [`crate::config`].
+pub fn discover_config(pal: &PalHandle, directory: &FilePath) -> HyperlitResult<Option<FilePath>> {
+    let mut directory = Some(directory.as_relative());
+    while let Some(current) = directory {
+        for name in CONFIG_FILE_NAMES {
+            let path = FilePath::from(current.join(name));
+            if pal.file_exists(&path)? {
+                return Ok(Some(path));
+            }
+        }
+        directory = current.parent();
+    }
+    Ok(None)
 }
 
 /// Load a configuration file from the filesystem using the PAL.
 ///
-/// Reads a TOML configuration file from the given path and deserializes it into a Config struct.
+/// Reads a TOML (or, with a `.yaml` or `.yml` extension, YAML) configuration file
+/// from the given path and deserializes it into a Config struct.
 ///
 /// # Arguments
 /// * `pal` - Platform Abstraction Layer handle for filesystem access
 /// * `path` - Path to the configuration file
 ///
 /// # Errors
-/// Returns an error if the file cannot be read or if the TOML is invalid.
+/// Returns an error if the file cannot be read or if its content is invalid.
 ///
 /// # Examples
 /// ```no_run
@@ -44,22 +338,214 @@ pub struct DirectoryConfig {
 /// println!("Config title: {}", config.title);
 /// ```
 pub fn load_config(pal: &PalHandle, path: &FilePath) -> HyperlitResult<Config> {
+    load_config_with_options(pal, path, &ConfigOptions::new())
+}
+
+/// Load a configuration file, applying the overrides of `options` and
+/// reporting unknown keys to its logger.
+///
+/// See [`crate::config`].
+pub fn load_config_with_options(
+    pal: &PalHandle,
+    path: &FilePath,
+    options: &ConfigOptions,
+) -> HyperlitResult<Config> {
     let content = pal.read_file_to_string(path)?;
-    toml::from_str(&content)
+    let extension = path.as_path().extension().and_then(|ext| ext.to_str());
+    let mut table = match extension {
+        Some("yaml" | "yml") => serde_yaml::from_str::<serde_yaml::Value>(&content)
+            .map_err(|e| e.to_string())
+            .and_then(yaml_to_toml)
+            .and_then(|value| match value {
+                Some(Value::Table(table)) => Ok(table),
+                None => Ok(Table::new()),
+                Some(_) => Err("expected a mapping of keys to values".to_string()),
+            }),
+        _ => toml::from_str::<Table>(&content).map_err(|e| e.to_string()),
+    }
+    .map_err(|e| err!("Failed to parse configuration file '{}': {}", path, e))?;
+    for (key, value) in &options.overrides {
+        apply_override(&mut table, key, value)?;
+    }
+    for key in unknown_keys(&table) {
+        options.logger().warn(
+            &format!("Unknown configuration key '{}'", key),
+            &[("file", path), ("key", &key)],
+        );
+    }
+    Value::Table(table)
+        .try_into()
         .map_err(|e| err!("Failed to parse configuration file '{}': {}", path, e))
 }
 
+/// Convert a YAML value to the equivalent TOML value, None for null.
+fn yaml_to_toml(value: serde_yaml::Value) -> Result<Option<Value>, String> {
+    Ok(Some(match value {
+        serde_yaml::Value::Null => return Ok(None),
+        serde_yaml::Value::Bool(b) => Value::Boolean(b),
+        serde_yaml::Value::Number(n) => match (n.as_i64(), n.as_f64()) {
+            (Some(i), _) => Value::Integer(i),
+            (None, Some(f)) => Value::Float(f),
+            (None, None) => return Err(format!("number {} is out of range", n)),
+        },
+        serde_yaml::Value::String(s) => Value::String(s),
+        serde_yaml::Value::Sequence(items) => {
+            let mut array = Vec::with_capacity(items.len());
+            for item in items {
+                array.extend(yaml_to_toml(item)?);
+            }
+            Value::Array(array)
+        }
+        serde_yaml::Value::Mapping(mapping) => {
+            let mut table = Table::new();
+            for (key, value) in mapping {
+                let key = match key {
+                    serde_yaml::Value::String(key) => key,
+                    serde_yaml::Value::Number(n) => n.to_string(),
+                    serde_yaml::Value::Bool(b) => b.to_string(),
+                    _ => return Err("keys must be strings".to_string()),
+                };
+                if let Some(value) = yaml_to_toml(value)? {
+                    table.insert(key, value);
+                }
+            }
+            Value::Table(table)
+        }
+        serde_yaml::Value::Tagged(tagged) => return yaml_to_toml(tagged.value),
+    }))
+}
+
+/// Set the dotted `key` of `table` to `value`, parsed as TOML or else kept as a string.
+fn apply_override(table: &mut Table, key: &str, value: &str) -> HyperlitResult<()> {
+    let value = toml::from_str::<Table>(&format!("value = {}", value))
+        .ok()
+        .and_then(|mut parsed| parsed.remove("value"))
+        .unwrap_or_else(|| Value::String(value.to_string()));
+    let mut parts: Vec<&str> = key.split('.').collect();
+    let last = parts.pop().unwrap_or_default();
+    if last.is_empty() || parts.iter().any(|part| part.is_empty()) {
+        bail!("Invalid configuration key '{}'", key);
+    }
+    let mut current = table;
+    for part in parts {
+        let entry = current
+            .entry(part)
+            .or_insert_with(|| Value::Table(Table::new()));
+        current = match entry {
+            Value::Table(nested) => nested,
+            _ => bail!("Cannot override '{}': '{}' is not a table", key, part),
+        };
+    }
+    current.insert(last.to_string(), value);
+    Ok(())
+}
+
+/// Returns the keys of a configuration not known to [`Config`], dotted and sorted.
+fn unknown_keys(table: &Table) -> Vec<String> {
+    let mut unknown = Vec::new();
+    collect_unknown_keys(table, struct_fields::<Config>(), "", &mut unknown);
+    let nested = [
+        ("exec", struct_fields::<ExecConfig>()),
+        ("diagrams", struct_fields::<DiagramConfig>()),
+        ("default_language", struct_fields::<LanguageSpec>()),
+    ];
+    for (key, fields) in nested {
+        if let Some(Value::Table(nested)) = table.get(key) {
+            collect_unknown_keys(nested, fields, key, &mut unknown);
+        }
+    }
+    let arrays = [
+        ("directory", struct_fields::<DirectoryConfig>()),
+        ("part", struct_fields::<PartConfig>()),
+    ];
+    for (key, fields) in arrays {
+        if let Some(Value::Array(items)) = table.get(key) {
+            for (index, item) in items.iter().enumerate() {
+                if let Value::Table(item) = item {
+                    let prefix = format!("{}[{}]", key, index);
+                    collect_unknown_keys(item, fields, &prefix, &mut unknown);
+                }
+            }
+        }
+    }
+    if let Some(Value::Table(languages)) = table.get("languages") {
+        for (extension, spec) in languages {
+            if let Value::Table(spec) = spec {
+                let prefix = format!("languages.{}", extension);
+                let fields = struct_fields::<LanguageSpec>();
+                collect_unknown_keys(spec, fields, &prefix, &mut unknown);
+            }
+        }
+    }
+    unknown.sort();
+    unknown
+}
+
+/// Add the keys of `table` that are not in `fields` to `unknown`, prefixed by `prefix`.
+fn collect_unknown_keys(table: &Table, fields: &[&str], prefix: &str, unknown: &mut Vec<String>) {
+    for key in table.keys() {
+        if !fields.contains(&key.as_str()) {
+            unknown.push(match prefix {
+                "" => key.clone(),
+                prefix => format!("{}.{}", prefix, key),
+            });
+        }
+    }
+}
+
+/// Returns the field names of a struct deriving `Deserialize`.
+///
+/// Derived implementations pass their field names to `deserialize_struct`, a
+/// deserializer recording them and failing right away is all it takes.
+fn struct_fields<T: DeserializeOwned>() -> &'static [&'static str] {
+    let mut fields: &'static [&'static str] = &[];
+    let _ = T::deserialize(FieldNames(&mut fields));
+    fields
+}
+
+/// A deserializer recording the field names requested by a struct, see [`struct_fields`].
+struct FieldNames<'a>(&'a mut &'static [&'static str]);
+
+impl<'de> Deserializer<'de> for FieldNames<'_> {
+    type Error = serde::de::value::Error;
+
+    fn deserialize_any<V: Visitor<'de>>(self, _visitor: V) -> Result<V::Value, Self::Error> {
+        Err(de::Error::custom("not a struct"))
+    }
+
+    fn deserialize_struct<V: Visitor<'de>>(
+        self,
+        _name: &'static str,
+        fields: &'static [&'static str],
+        _visitor: V,
+    ) -> Result<V::Value, Self::Error> {
+        *self.0 = fields;
+        Err(de::Error::custom("field names recorded"))
+    }
+
+    serde::forward_to_deserialize_any! {
+        bool i8 i16 i32 i64 i128 u8 u16 u32 u64 u128 f32 f64 char str string bytes
+        byte_buf option unit unit_struct newtype_struct seq tuple tuple_struct map
+        enum identifier ignored_any
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
     use hyperlit_base::pal::MockPal;
 
+    use crate::logger::tests::RecordingLogger;
+
     #[test]
     fn test_load_config_success() {
         let mock_pal = MockPal::new();
         let config_content = r#"
 title = "My Documentation"
 source_link_template = "https://github.com/user/repo/blob/main/{path}#L{line}"
+output_directory = "site"
+cache_directory = "build/cache"
+watch_debounce_ms = 50
 
 [[directory]]
 paths = ["src"]
@@ -80,6 +566,9 @@ globs = ["*.md"]
             config.source_link_template,
             "https://github.com/user/repo/blob/main/{path}#L{line}"
         );
+        assert_eq!(config.output_directory(), FilePath::from("site"));
+        assert_eq!(config.cache_directory(), FilePath::from("build/cache"));
+        assert_eq!(config.watch_debounce(), Duration::from_millis(50));
         assert_eq!(config.directory.len(), 2);
         assert_eq!(config.directory[0].paths, vec!["src"]);
         assert_eq!(config.directory[0].globs, vec!["*.rs"]);
@@ -125,5 +614,168 @@ source_link_template = "https://example.com"
         let config = load_config(&pal, &path).unwrap();
         assert_eq!(config.title, "Minimal Doc");
         assert_eq!(config.directory.len(), 0);
+        assert_eq!(config.output_directory(), FilePath::from("output"));
+        assert_eq!(config.cache_directory(), FilePath::from(".hyperlit-cache"));
+        assert_eq!(config.watch_debounce(), Duration::from_millis(200));
+    }
+
+    #[test]
+    fn test_load_config_languages() {
+        let mock_pal = MockPal::new();
+        let config_content = r##"
+title = "Languages"
+source_link_template = "https://example.com"
+
+[languages.hs]
+line_comments = ["--"]
+block_comments = [["{-", "-}"]]
+
+[default_language]
+line_comments = ["#"]
+"##;
+
+        let path = FilePath::from("hyperlit.toml");
+        mock_pal.add_file(path.clone(), config_content.as_bytes().to_vec());
+
+        let pal = PalHandle::new(mock_pal);
+        let config = load_config(&pal, &path).unwrap();
+        assert_eq!(
+            config.languages["hs"],
+            LanguageSpec::new()
+                .with_line_comment("--")
+                .with_block_comment("{-", "-}")
+        );
+        assert_eq!(
+            config.default_language,
+            Some(LanguageSpec::new().with_line_comment("#"))
+        );
+    }
+
+    #[test]
+    fn test_load_config_reading_order() {
+        let mock_pal = MockPal::new();
+        let config_content = r#"
+title = "Book"
+source_link_template = "https://example.com"
+unlisted_pages = "unlisted"
+
+[[part]]
+title = "Getting started"
+files = ["docs/intro.md", "docs/setup.md"]
+"#;
+
+        let path = FilePath::from("hyperlit.toml");
+        mock_pal.add_file(path.clone(), config_content.as_bytes().to_vec());
+
+        let pal = PalHandle::new(mock_pal);
+        let config = load_config(&pal, &path).unwrap();
+        assert_eq!(config.part.len(), 1);
+        assert_eq!(config.part[0].title, "Getting started");
+        assert_eq!(config.part[0].files, ["docs/intro.md", "docs/setup.md"]);
+        assert_eq!(config.unlisted_pages, Some(UnlistedPages::Unlisted));
+    }
+
+    #[test]
+    fn test_discover_config() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("project/hyperlit.yaml"), Vec::new());
+        mock_pal.add_file(FilePath::from("project/src/lib.rs"), Vec::new());
+        let pal = PalHandle::new(mock_pal);
+
+        let found = discover_config(&pal, &FilePath::from("project/src/nested")).unwrap();
+        assert_eq!(found, Some(FilePath::from("project/hyperlit.yaml")));
+        let found = discover_config(&pal, &FilePath::from("project")).unwrap();
+        assert_eq!(found, Some(FilePath::from("project/hyperlit.yaml")));
+        assert_eq!(
+            discover_config(&pal, &FilePath::from("other")).unwrap(),
+            None
+        );
+    }
+
+    #[test]
+    fn test_load_config_yaml() {
+        let mock_pal = MockPal::new();
+        let config_content = "title: YAML Doc\nsource_link_template: https://example.com\noutput_directory: site\nprofiles: [internal, beta]\nheading_offset: 1\n";
+
+        let path = FilePath::from("hyperlit.yml");
+        mock_pal.add_file(path.clone(), config_content.as_bytes().to_vec());
+
+        let pal = PalHandle::new(mock_pal);
+        let config = load_config(&pal, &path).unwrap();
+        assert_eq!(config.title, "YAML Doc");
+        assert_eq!(config.output_directory(), FilePath::from("site"));
+        assert_eq!(
+            config.profiles,
+            Some(vec!["internal".to_string(), "beta".to_string()])
+        );
+        assert_eq!(config.heading_offset, Some(1));
+    }
+
+    #[test]
+    fn test_load_config_warns_about_unknown_keys() {
+        let mock_pal = MockPal::new();
+        let config_content = r#"
+title = "Typos"
+source_link_template = "https://example.com"
+output_dir = "site"
+
+[exec]
+time_out = 5
+
+[[directory]]
+paths = ["src"]
+globs = ["*.rs"]
+exlude = ["target"]
+
+[languages.hs]
+line_comment = ["--"]
+"#;
+
+        let path = FilePath::from("hyperlit.toml");
+        mock_pal.add_file(path.clone(), config_content.as_bytes().to_vec());
+
+        let pal = PalHandle::new(mock_pal);
+        let logger = RecordingLogger::default();
+        let options = ConfigOptions::new().with_logger(logger.clone());
+        let config = load_config_with_options(&pal, &path, &options).unwrap();
+        assert_eq!(config.output_directory(), FilePath::from("output"));
+        assert_eq!(
+            logger.entries(),
+            [
+                "warning: Unknown configuration key 'directory[0].exlude' file=hyperlit.toml key=directory[0].exlude",
+                "warning: Unknown configuration key 'exec.time_out' file=hyperlit.toml key=exec.time_out",
+                "warning: Unknown configuration key 'languages.hs.line_comment' file=hyperlit.toml key=languages.hs.line_comment",
+                "warning: Unknown configuration key 'output_dir' file=hyperlit.toml key=output_dir",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_load_config_overrides() {
+        let mock_pal = MockPal::new();
+        let config_content = r#"
+title = "Overrides"
+source_link_template = "https://example.com"
+output_directory = "site"
+"#;
+
+        let path = FilePath::from("hyperlit.toml");
+        mock_pal.add_file(path.clone(), config_content.as_bytes().to_vec());
+
+        let pal = PalHandle::new(mock_pal);
+        let options = ConfigOptions::new()
+            .with_override("output_directory", "public")
+            .with_override("heading_offset", "2")
+            .with_override("profiles", r#"["beta"]"#)
+            .with_override("exec.allow_exec", "true");
+        let config = load_config_with_options(&pal, &path, &options).unwrap();
+        assert_eq!(config.output_directory(), FilePath::from("public"));
+        assert_eq!(config.heading_offset, Some(2));
+        assert_eq!(config.profiles, Some(vec!["beta".to_string()]));
+        assert!(config.exec.allow_exec);
+
+        let options = ConfigOptions::new().with_override("title.nested", "x");
+        let error = load_config_with_options(&pal, &path, &options).unwrap_err();
+        assert!(error.to_string().contains("'title' is not a table"));
     }
 }
@@ -0,0 +1,377 @@
+/* 📖 # Why render diagrams from code blocks?
+
+Architecture is easier to explain with a diagram, and a diagram written as text
+(e.g. Mermaid) lives in the doc comment, is reviewed like code and never goes
+stale as an exported image would. A fenced code block tagged with the diagram
+language is turned into the picture when rendering HTML:
+
+- **Client mode**: the block becomes a `<pre class="mermaid">` element and the
+  page loads the Mermaid runtime, which draws the diagram in the browser. No
+  tools are needed at build time, but viewing the page needs network access
+- **Static mode**: an external renderer (by default the Mermaid CLI `mmdc`) is
+  run at build time and the SVG it produces is inlined into the page, so pages
+  work offline and in single-file output. Any language can be rendered
+  statically by configuring a command for it, e.g. Graphviz `dot`
+
+Diagram rendering is off by default, so diagram blocks stay code blocks unless
+a mode is chosen. The Markdown output keeps them as they are, since Markdown
+viewers like GitHub draw Mermaid blocks themselves.
+
+A missing or failing renderer should not fail the whole build over one picture.
+The block is then rendered as a plain code block and reported as a warning.
+Like `{{exec}}` commands, renderers run through the PAL without a shell, in a
+dedicated working directory inside the cache directory, with a timeout. The
+diagram source is written to a file named after its content hash, so pages
+rendered in parallel never share files.
+*/
+
+use std::collections::BTreeMap;
+use std::time::Duration;
+
+use serde::Deserialize;
+
+use hyperlit_base::pal::CommandSpec;
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle, bail};
+
+use crate::cache::ContentHasher;
+use crate::render::{escape_html, write_file};
+use crate::{Config, DEFAULT_CACHE_DIRECTORY};
+
+/// Timeout in seconds for rendering one diagram, used when none is configured.
+pub const DEFAULT_DIAGRAM_TIMEOUT_SECONDS: u64 = 30;
+
+/// Name of the working directory for diagram renderers, inside the cache directory.
+pub const DIAGRAM_DIRECTORY: &str = "diagrams";
+
+/// URL of the Mermaid runtime loaded by pages in [`DiagramMode::Client`].
+pub const MERMAID_RUNTIME_URL: &str =
+    "https://cdn.jsdelivr.net/npm/mermaid@11/dist/mermaid.esm.min.mjs";
+
+/// Placeholder in a renderer command replaced with the diagram source file.
+const INPUT_PLACEHOLDER: &str = "{input}";
+
+/// Placeholder in a renderer command replaced with the SVG file to write.
+///
+/// Commands without it write the SVG to stdout.
+const OUTPUT_PLACEHOLDER: &str = "{output}";
+
+/// How diagram code blocks are rendered to HTML.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum DiagramMode {
+    /// Diagram blocks are rendered as code blocks
+    #[default]
+    Off,
+    /// Mermaid blocks are drawn in the browser by the Mermaid runtime
+    Client,
+    /// Diagram blocks are rendered to inline SVG by an external command at build time
+    Static,
+}
+
+/// Options controlling how diagram code blocks are rendered.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DiagramOptions {
+    mode: DiagramMode,
+    commands: BTreeMap<String, Vec<String>>,
+    timeout: Duration,
+    working_directory: FilePath,
+}
+
+impl Default for DiagramOptions {
+    fn default() -> Self {
+        Self {
+            mode: DiagramMode::Off,
+            commands: BTreeMap::from([(
+                "mermaid".to_string(),
+                [
+                    "mmdc",
+                    "--input",
+                    INPUT_PLACEHOLDER,
+                    "--output",
+                    OUTPUT_PLACEHOLDER,
+                ]
+                .map(String::from)
+                .to_vec(),
+            )]),
+            timeout: Duration::from_secs(DEFAULT_DIAGRAM_TIMEOUT_SECONDS),
+            working_directory: FilePath::from(format!(
+                "{}/{}",
+                DEFAULT_CACHE_DIRECTORY, DIAGRAM_DIRECTORY
+            )),
+        }
+    }
+}
+
+impl DiagramOptions {
+    /// Create diagram options with default settings (diagram rendering off).
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Create diagram options from the site configuration.
+    pub fn from_config(config: &Config) -> Self {
+        let diagrams = &config.diagrams;
+        let mut options = Self::new()
+            .with_mode(diagrams.mode.unwrap_or_default())
+            .with_timeout(Duration::from_secs(
+                diagrams
+                    .timeout_seconds
+                    .unwrap_or(DEFAULT_DIAGRAM_TIMEOUT_SECONDS),
+            ))
+            .with_working_directory(FilePath::from(
+                config
+                    .cache_directory()
+                    .as_relative()
+                    .join(DIAGRAM_DIRECTORY),
+            ));
+        for (language, command) in &diagrams.commands {
+            options = options.with_command(language, command.clone());
+        }
+        options
+    }
+
+    /// Set how diagram code blocks are rendered.
+    pub fn with_mode(mut self, mode: DiagramMode) -> Self {
+        self.mode = mode;
+        self
+    }
+
+    /// Set the command rendering code blocks of `language` in [`DiagramMode::Static`].
+    ///
+    /// `command` is the program followed by its arguments. `{input}` is replaced
+    /// with the file containing the diagram source and `{output}` with the SVG
+    /// file to write. Without `{output}`, the SVG is read from stdout.
+    pub fn with_command(mut self, language: impl Into<String>, command: Vec<String>) -> Self {
+        self.commands.insert(language.into(), command);
+        self
+    }
+
+    /// Set the time after which a renderer is killed.
+    pub fn with_timeout(mut self, timeout: Duration) -> Self {
+        self.timeout = timeout;
+        self
+    }
+
+    /// Set the directory renderers run in, relative to the site root.
+    ///
+    /// The directory is created if it does not exist.
+    pub fn with_working_directory(mut self, working_directory: FilePath) -> Self {
+        self.working_directory = working_directory;
+        self
+    }
+
+    /// Returns how diagram code blocks are rendered.
+    pub fn mode(&self) -> DiagramMode {
+        self.mode
+    }
+
+    /// Returns true if code blocks of `language` are rendered as diagrams.
+    pub(crate) fn renders(&self, language: &str) -> bool {
+        match self.mode {
+            DiagramMode::Off => false,
+            DiagramMode::Client => language == "mermaid",
+            DiagramMode::Static => self.commands.contains_key(language),
+        }
+    }
+
+    /// Identifies the diagram rendering for the build cache.
+    pub(crate) fn cache_key(&self) -> String {
+        match self.mode {
+            DiagramMode::Static => format!("{:?}:{:?}", self.mode, self.commands),
+            mode => format!("{:?}", mode),
+        }
+    }
+}
+
+/// Returns the HTML of a diagram drawn in the browser.
+pub(crate) fn client_diagram_html(code: &str) -> String {
+    format!("<pre class=\"mermaid\">{}</pre>\n", escape_html(code))
+}
+
+/// Returns the script loading the Mermaid runtime, for pages with client-side diagrams.
+pub(crate) fn mermaid_runtime_script() -> String {
+    format!(
+        "<script type=\"module\">\nimport mermaid from \"{MERMAID_RUNTIME_URL}\";\nmermaid.initialize({{ startOnLoad: true }});\n</script>\n"
+    )
+}
+
+/// Render a diagram with the external command for `language` and return its HTML.
+///
+/// # Errors
+/// Returns an error if there is no command for the language, the command
+/// cannot run, fails, or produces no SVG.
+pub(crate) fn render_static_diagram(
+    pal: &PalHandle,
+    options: &DiagramOptions,
+    language: &str,
+    code: &str,
+) -> HyperlitResult<String> {
+    let Some((program, args)) = options
+        .commands
+        .get(language)
+        .and_then(|command| command.split_first())
+    else {
+        bail!("no renderer configured for '{}' diagrams", language);
+    };
+    let stem = diagram_file_stem(language, code);
+    let input = format!("{stem}.{language}");
+    let output = format!("{stem}.svg");
+    let directory = options.working_directory.as_relative();
+    write_file(pal, &FilePath::from(directory.join(&input)), code)?;
+
+    let writes_file = args.iter().any(|arg| arg.contains(OUTPUT_PLACEHOLDER));
+    let spec = CommandSpec::new(
+        program.clone(),
+        options.working_directory.clone(),
+        options.timeout,
+    )
+    .with_args(args.iter().map(|arg| {
+        arg.replace(INPUT_PLACEHOLDER, &input)
+            .replace(OUTPUT_PLACEHOLDER, &output)
+    }));
+    let result = pal.run_command(&spec)?;
+    if !result.success() {
+        bail!("'{}' failed: {}", spec.command_line(), result.stderr.trim());
+    }
+    let svg = if writes_file {
+        pal.read_file_to_string(&FilePath::from(directory.join(&output)))?
+    } else {
+        result.stdout
+    };
+    let Some(start) = svg.find("<svg") else {
+        bail!("'{}' produced no SVG", spec.command_line());
+    };
+    Ok(format!(
+        "<figure class=\"diagram\">\n{}\n</figure>\n",
+        svg[start..].trim_end()
+    ))
+}
+
+/// Name of the files of a diagram, derived from its language and source.
+fn diagram_file_stem(language: &str, code: &str) -> String {
+    let mut hasher = ContentHasher::new();
+    hasher.write_str(language);
+    hasher.write_str(code);
+    format!("{:016x}", hasher.finish())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use hyperlit_base::pal::{CommandOutput, MockPal};
+
+    const CODE: &str = "graph TD\n  A --> B\n";
+
+    fn static_options() -> DiagramOptions {
+        DiagramOptions::new()
+            .with_mode(DiagramMode::Static)
+            .with_working_directory(FilePath::from("cache/diagrams"))
+    }
+
+    #[test]
+    fn test_renders() {
+        let options = DiagramOptions::new();
+        assert!(!options.renders("mermaid"));
+
+        let options = options.with_mode(DiagramMode::Client);
+        assert!(options.renders("mermaid"));
+        assert!(!options.renders("dot"));
+
+        let options = static_options().with_command("dot", vec!["dot".to_string()]);
+        assert!(options.renders("mermaid"));
+        assert!(options.renders("dot"));
+        assert!(!options.renders("rust"));
+    }
+
+    #[test]
+    fn test_client_diagram_html() {
+        assert_eq!(
+            client_diagram_html(CODE),
+            "<pre class=\"mermaid\">graph TD\n  A --&gt; B\n</pre>\n"
+        );
+    }
+
+    #[test]
+    fn test_render_static_diagram_to_file() {
+        let mock_pal = MockPal::new();
+        let stem = diagram_file_stem("mermaid", CODE);
+        let command_line = format!("mmdc --input {stem}.mermaid --output {stem}.svg");
+        mock_pal.add_command_output(
+            &command_line,
+            CommandOutput {
+                exit_code: Some(0),
+                ..CommandOutput::default()
+            },
+        );
+        mock_pal.add_file(
+            FilePath::from(format!("cache/diagrams/{stem}.svg")),
+            b"<?xml version=\"1.0\"?>\n<svg><g/></svg>\n".to_vec(),
+        );
+        let pal = PalHandle::new(mock_pal);
+
+        let html = render_static_diagram(&pal, &static_options(), "mermaid", CODE).unwrap();
+
+        assert_eq!(
+            html,
+            "<figure class=\"diagram\">\n<svg><g/></svg>\n</figure>\n"
+        );
+        assert_eq!(
+            pal.read_file_to_string(&FilePath::from(format!("cache/diagrams/{stem}.mermaid")))
+                .unwrap(),
+            CODE
+        );
+    }
+
+    #[test]
+    fn test_render_static_diagram_from_stdout() {
+        let mock_pal = MockPal::new();
+        let stem = diagram_file_stem("dot", "digraph { a -> b }\n");
+        mock_pal.add_command_output(
+            &format!("dot -Tsvg {stem}.dot"),
+            CommandOutput {
+                stdout: "<svg>graph</svg>".to_string(),
+                exit_code: Some(0),
+                ..CommandOutput::default()
+            },
+        );
+        let pal = PalHandle::new(mock_pal);
+        let options = static_options().with_command(
+            "dot",
+            ["dot", "-Tsvg", "{input}"].map(String::from).to_vec(),
+        );
+
+        let html = render_static_diagram(&pal, &options, "dot", "digraph { a -> b }\n").unwrap();
+
+        assert_eq!(
+            html,
+            "<figure class=\"diagram\">\n<svg>graph</svg>\n</figure>\n"
+        );
+    }
+
+    #[test]
+    fn test_render_static_diagram_errors() {
+        let mock_pal = MockPal::new();
+        let stem = diagram_file_stem("mermaid", CODE);
+        mock_pal.add_command_output(
+            &format!("mmdc --input {stem}.mermaid --output {stem}.svg"),
+            CommandOutput {
+                stderr: "Parse error on line 2\n".to_string(),
+                exit_code: Some(1),
+                ..CommandOutput::default()
+            },
+        );
+        let pal = PalHandle::new(mock_pal);
+
+        let error = render_static_diagram(&pal, &static_options(), "mermaid", CODE).unwrap_err();
+        assert!(error.to_string().ends_with("failed: Parse error on line 2"));
+
+        let error = render_static_diagram(&pal, &static_options(), "mermaid", "other").unwrap_err();
+        assert!(error.to_string().contains("Failed to run command 'mmdc"));
+
+        let error = render_static_diagram(&pal, &static_options(), "plantuml", CODE).unwrap_err();
+        assert_eq!(
+            error.to_string(),
+            "no renderer configured for 'plantuml' diagrams"
+        );
+    }
+}
@@ -0,0 +1,610 @@
+/* 📖 # Why diff the site against the existing output?
+
+Many projects commit the generated docs, or publish them from a directory that
+is reviewed before it goes live. Before overwriting it, `hyperlit build --diff`
+shows exactly what a build would change: the site is rendered in memory as
+usual, and every output file is compared with the file currently on disk
+instead of being written. Nothing is written, not even the build cache.
+
+Changed text files are printed as unified diffs (`--- a/path`, `+++ b/path`,
+three lines of context), like `git diff`, so the output can be read as it is
+or piped into a pager. Files the build would create are diffed against
+`/dev/null`. Binary files, i.e. copied assets that are no valid UTF-8 text,
+are only reported as changed or unchanged, a textual diff of an image helps no
+one.
+
+Output files the site no longer contains but hyperlit would have written,
+such as the page of a deleted source file or an image no page links to any
+more, are reported as deleted and diffed against `/dev/null`. They are stale
+docs a reviewer wants to see, even though the build leaves them in place.
+Generated files are recognized by their name: pages (`*.html`, or the
+extension of the rendered pages), the asset extensions and the stylesheet,
+search index and manifest of the site root. Anything else, such as a `CNAME`
+or a `.nojekyll`, was put there by someone else and is only counted as
+untracked.
+
+The command exits with 1 if a file would be created, modified or deleted, so `hyperlit
+build --diff` in CI fails when the committed docs are out of date.
+*/
+
+use std::collections::btree_map::Entry;
+use std::collections::{BTreeMap, HashSet};
+use std::fmt;
+use std::io::Read;
+use std::path::Path;
+
+use hyperlit_base::pal::WalkOptions;
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle};
+
+use crate::{ASSET_EXTENSIONS, MANIFEST_FILE, RenderResult, SEARCH_INDEX_FILE, STYLESHEET};
+
+/// Lines of unchanged text shown around each change.
+const CONTEXT_LINES: usize = 3;
+
+/// Upper bound of the work spent searching for the shortest diff of a file,
+/// larger changes are shown as replacing all changed lines at once.
+const MAX_DIFF_COST: usize = 1 << 24;
+
+/// How an output file would change if the site was written.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum FileChange {
+    /// The file does not exist yet
+    Created,
+    /// The file exists, with different content
+    Modified,
+    /// The file was generated by hyperlit but is no longer part of the site
+    Deleted,
+    /// The file exists but is not part of the site, the build leaves it in place
+    Untracked,
+    /// The file exists with the same content
+    Unchanged,
+}
+
+impl fmt::Display for FileChange {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            FileChange::Created => "created",
+            FileChange::Modified => "modified",
+            FileChange::Deleted => "deleted",
+            FileChange::Untracked => "untracked",
+            FileChange::Unchanged => "unchanged",
+        })
+    }
+}
+
+/// The difference between an output file and the file on disk.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct FileDiff {
+    /// Path relative to the output directory
+    pub path: FilePath,
+    /// How the file would change
+    pub change: FileChange,
+    /// Unified diff of the change, None if the file is unchanged, untracked or binary
+    pub diff: Option<String>,
+}
+
+impl FileDiff {
+    /// Returns true if writing the site would change the file.
+    pub fn is_changed(&self) -> bool {
+        matches!(
+            self.change,
+            FileChange::Created | FileChange::Modified | FileChange::Deleted
+        )
+    }
+}
+
+impl fmt::Display for FileDiff {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match &self.diff {
+            Some(diff) => f.write_str(diff),
+            None if self.is_changed() => {
+                writeln!(f, "Binary file {} {}", self.path, self.change)
+            }
+            None => Ok(()),
+        }
+    }
+}
+
+/// Compare the rendered site with the files in `output_directory`, sorted by path.
+///
+/// Covers the rendered files, the assets and the files of the output directory
+/// that are not part of the site, see [`crate::diff`].
+pub fn diff_site(
+    pal: &PalHandle,
+    output_directory: &FilePath,
+    rendered: &RenderResult,
+) -> HyperlitResult<Vec<FileDiff>> {
+    let output_path =
+        |path: &FilePath| FilePath::from(output_directory.as_relative().join(path.as_relative()));
+    let mut diffs = BTreeMap::new();
+    for file in &rendered.files {
+        let existing = read_existing(pal, &output_path(&file.path))?;
+        let diff = diff_file(
+            &file.path,
+            existing.as_deref(),
+            Some(file.content.as_bytes()),
+        );
+        diffs.insert(file.path.to_string(), diff);
+    }
+    for asset in &rendered.assets {
+        let mut content = Vec::new();
+        pal.read_file(&asset.source)?.read_to_end(&mut content)?;
+        let existing = read_existing(pal, &output_path(&asset.path))?;
+        let diff = diff_file(&asset.path, existing.as_deref(), Some(&content));
+        diffs.insert(asset.path.to_string(), diff);
+    }
+    let page_extensions: HashSet<&str> = rendered
+        .files
+        .iter()
+        .filter_map(|file| file.path.as_relative().extension())
+        .collect();
+    for path in existing_files(pal, output_directory)? {
+        if let Entry::Vacant(entry) = diffs.entry(path.to_string()) {
+            let existing = read_existing(pal, &output_path(&path))?;
+            let mut diff = diff_file(&path, existing.as_deref(), None);
+            if !is_generated(&path, &page_extensions) {
+                diff.change = FileChange::Untracked;
+                diff.diff = None;
+            }
+            entry.insert(diff);
+        }
+    }
+    Ok(diffs.into_values().collect())
+}
+
+/// Returns true if `path` is named like a file hyperlit writes into the output directory.
+fn is_generated(path: &FilePath, page_extensions: &HashSet<&str>) -> bool {
+    let path = path.as_relative();
+    if path
+        .file_name()
+        .is_some_and(|name| [STYLESHEET, SEARCH_INDEX_FILE, MANIFEST_FILE].contains(&name))
+    {
+        return true;
+    }
+    let extension = path
+        .extension()
+        .map(str::to_ascii_lowercase)
+        .unwrap_or_default();
+    extension == "html"
+        || page_extensions.contains(extension.as_str())
+        || ASSET_EXTENSIONS.contains(&extension.as_str())
+}
+
+/// Returns the content of a file, None if it does not exist.
+fn read_existing(pal: &PalHandle, path: &FilePath) -> HyperlitResult<Option<Vec<u8>>> {
+    if !pal.file_exists(path)? {
+        return Ok(None);
+    }
+    let mut content = Vec::new();
+    pal.read_file(path)?.read_to_end(&mut content)?;
+    Ok(Some(content))
+}
+
+/// Returns the paths of the files in the output directory, relative to it.
+fn existing_files(pal: &PalHandle, output_directory: &FilePath) -> HyperlitResult<Vec<FilePath>> {
+    let options = WalkOptions::new(["**"]).with_respect_gitignore(false);
+    let walk = match pal.walk_directory_with_options(output_directory, &options) {
+        Ok(walk) => walk,
+        Err(_) if !pal.file_exists(output_directory)? => return Ok(Vec::new()),
+        Err(e) => return Err(e),
+    };
+    let mut paths = Vec::new();
+    for path in walk {
+        let path = path?;
+        if let Ok(relative) = path.as_path().strip_prefix(output_directory.as_path())
+            && relative.components().next().is_some()
+        {
+            paths.push(relative.to_path_buf());
+        }
+    }
+    // The walk yields directories as well, they are the parents of other paths
+    let directories: HashSet<&Path> = paths.iter().filter_map(|path| path.parent()).collect();
+    Ok(paths
+        .iter()
+        .filter(|path| !directories.contains(path.as_path()))
+        .map(|path| FilePath::from(path.as_path()))
+        .collect())
+}
+
+/// Compare the content of a file on disk (`old`) with its new content, None if missing.
+fn diff_file(path: &FilePath, old: Option<&[u8]>, new: Option<&[u8]>) -> FileDiff {
+    let change = match (old, new) {
+        (None, _) => FileChange::Created,
+        (Some(_), None) => FileChange::Deleted,
+        (Some(old), Some(new)) if old == new => FileChange::Unchanged,
+        (Some(_), Some(_)) => FileChange::Modified,
+    };
+    let diff = match (old.map(as_text), new.map(as_text)) {
+        _ if change == FileChange::Unchanged => None,
+        (Some(None), _) | (_, Some(None)) => None,
+        (old, new) => {
+            let path = path.to_string();
+            let old_name = match old {
+                Some(_) => format!("a/{}", path),
+                None => "/dev/null".to_string(),
+            };
+            let new_name = match new {
+                Some(_) => format!("b/{}", path),
+                None => "/dev/null".to_string(),
+            };
+            Some(unified_diff(
+                old.flatten().unwrap_or_default(),
+                new.flatten().unwrap_or_default(),
+                &old_name,
+                &new_name,
+            ))
+        }
+    };
+    FileDiff {
+        path: path.clone(),
+        change,
+        diff,
+    }
+}
+
+/// Returns the content as text, None if it is binary.
+fn as_text(content: &[u8]) -> Option<&str> {
+    std::str::from_utf8(content)
+        .ok()
+        .filter(|text| !text.contains('\0'))
+}
+
+/// A line of a diff.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Edit {
+    /// The line at these indexes of the old and new text is unchanged
+    Keep(usize, usize),
+    /// The line at this index of the old text is removed
+    Remove(usize),
+    /// The line at this index of the new text is added
+    Add(usize),
+}
+
+/// Returns a unified diff turning `old` into `new`, empty if they are equal.
+///
+/// `old_name` and `new_name` are the file names in the `---` and `+++` header lines.
+pub fn unified_diff(old: &str, new: &str, old_name: &str, new_name: &str) -> String {
+    let old_lines: Vec<&str> = old.split_inclusive('\n').collect();
+    let new_lines: Vec<&str> = new.split_inclusive('\n').collect();
+    let edits = diff_lines(&old_lines, &new_lines);
+    let changes: Vec<usize> = (0..edits.len())
+        .filter(|&index| !matches!(edits[index], Edit::Keep(..)))
+        .collect();
+    if changes.is_empty() {
+        return String::new();
+    }
+    let mut out = format!("--- {}\n+++ {}\n", old_name, new_name);
+    let mut start = 0;
+    while start < changes.len() {
+        // Changes closer than twice the context share a hunk
+        let mut end = start;
+        while end + 1 < changes.len() && changes[end + 1] - changes[end] <= 2 * CONTEXT_LINES + 1 {
+            end += 1;
+        }
+        let first = changes[start].saturating_sub(CONTEXT_LINES);
+        let last = (changes[end] + CONTEXT_LINES).min(edits.len() - 1);
+        let before = edits[..first]
+            .iter()
+            .fold((0, 0), |(old, new), edit| match edit {
+                Edit::Keep(..) => (old + 1, new + 1),
+                Edit::Remove(_) => (old + 1, new),
+                Edit::Add(_) => (old, new + 1),
+            });
+        write_hunk(
+            &mut out,
+            &edits[first..=last],
+            before,
+            &old_lines,
+            &new_lines,
+        );
+        start = end + 1;
+    }
+    out
+}
+
+/// Append a hunk with its `@@` header, `before` are the numbers of old and new
+/// lines preceding it.
+fn write_hunk(
+    out: &mut String,
+    edits: &[Edit],
+    before: (usize, usize),
+    old_lines: &[&str],
+    new_lines: &[&str],
+) {
+    let old_count = edits
+        .iter()
+        .filter(|edit| !matches!(edit, Edit::Add(_)))
+        .count();
+    let new_count = edits
+        .iter()
+        .filter(|edit| !matches!(edit, Edit::Remove(_)))
+        .count();
+    // Ranges start at their first line, empty ranges at the line before them
+    let range = |before: usize, count: usize| match count {
+        0 => format!("{},0", before),
+        1 => format!("{}", before + 1),
+        count => format!("{},{}", before + 1, count),
+    };
+    out.push_str(&format!(
+        "@@ -{} +{} @@\n",
+        range(before.0, old_count),
+        range(before.1, new_count)
+    ));
+    for edit in edits {
+        let (prefix, line) = match *edit {
+            Edit::Keep(old, _) => (' ', old_lines[old]),
+            Edit::Remove(old) => ('-', old_lines[old]),
+            Edit::Add(new) => ('+', new_lines[new]),
+        };
+        out.push(prefix);
+        out.push_str(line);
+        if !line.ends_with('\n') {
+            out.push_str("\n\\ No newline at end of file\n");
+        }
+    }
+}
+
+/// Returns the shortest edit script turning `old` into `new` (Myers' algorithm).
+fn diff_lines(old: &[&str], new: &[&str]) -> Vec<Edit> {
+    // Unchanged lines at the start and end need no search
+    let prefix = old
+        .iter()
+        .zip(new)
+        .take_while(|(old, new)| old == new)
+        .count();
+    let suffix = old[prefix..]
+        .iter()
+        .rev()
+        .zip(new[prefix..].iter().rev())
+        .take_while(|(old, new)| old == new)
+        .count();
+    let old_middle = &old[prefix..old.len() - suffix];
+    let new_middle = &new[prefix..new.len() - suffix];
+
+    let mut edits: Vec<Edit> = (0..prefix).map(|index| Edit::Keep(index, index)).collect();
+    let middle = shortest_edit(old_middle, new_middle).unwrap_or_else(|| {
+        let removed = (0..old_middle.len()).map(Edit::Remove);
+        removed
+            .chain((0..new_middle.len()).map(Edit::Add))
+            .collect()
+    });
+    edits.extend(middle.into_iter().map(|edit| match edit {
+        Edit::Keep(old, new) => Edit::Keep(old + prefix, new + prefix),
+        Edit::Remove(old) => Edit::Remove(old + prefix),
+        Edit::Add(new) => Edit::Add(new + prefix),
+    }));
+    let old_suffix = old.len() - suffix;
+    let new_suffix = new.len() - suffix;
+    edits.extend((0..suffix).map(|index| Edit::Keep(old_suffix + index, new_suffix + index)));
+    edits
+}
+
+/// Search the shortest edit script, None if that exceeds [`MAX_DIFF_COST`].
+fn shortest_edit(old: &[&str], new: &[&str]) -> Option<Vec<Edit>> {
+    let (n, m) = (old.len() as isize, new.len() as isize);
+    let max = n + m;
+    let offset = max as usize + 1;
+    let width = 2 * offset + 1;
+    let index = |k: isize| (k + offset as isize) as usize;
+    // Furthest x reached on each diagonal k = x - y, before each round d
+    let mut v = vec![0isize; width];
+    let mut trace = Vec::new();
+    'search: for d in 0..=max {
+        if (d as usize + 1) * width > MAX_DIFF_COST {
+            return None;
+        }
+        trace.push(v.clone());
+        for k in (-d..=d).step_by(2) {
+            let mut x = if k == -d || (k != d && v[index(k - 1)] < v[index(k + 1)]) {
+                v[index(k + 1)]
+            } else {
+                v[index(k - 1)] + 1
+            };
+            let mut y = x - k;
+            while x < n && y < m && old[x as usize] == new[y as usize] {
+                x += 1;
+                y += 1;
+            }
+            v[index(k)] = x;
+            if x >= n && y >= m {
+                break 'search;
+            }
+        }
+    }
+
+    // Walk back from the end through the rounds
+    let mut edits = Vec::new();
+    let (mut x, mut y) = (n, m);
+    for (d, v) in trace.iter().enumerate().rev() {
+        let d = d as isize;
+        let k = x - y;
+        let previous_k = if k == -d || (k != d && v[index(k - 1)] < v[index(k + 1)]) {
+            k + 1
+        } else {
+            k - 1
+        };
+        let previous_x = v[index(previous_k)];
+        let previous_y = previous_x - previous_k;
+        while x > previous_x && y > previous_y {
+            x -= 1;
+            y -= 1;
+            edits.push(Edit::Keep(x as usize, y as usize));
+        }
+        if d > 0 {
+            if x == previous_x {
+                edits.push(Edit::Add(previous_y as usize));
+            } else {
+                edits.push(Edit::Remove(previous_x as usize));
+            }
+        }
+        x = previous_x;
+        y = previous_y;
+    }
+    edits.reverse();
+    Some(edits)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{Asset, RenderedFile};
+    use expect_test::expect;
+    use hyperlit_base::pal::MockPal;
+
+    #[test]
+    fn test_unified_diff() {
+        let old = "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\ntwelve\n";
+        let new =
+            "one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\ntwelve\nthirteen";
+        expect![[r#"
+            --- a/page.html
+            +++ b/page.html
+            @@ -1,5 +1,5 @@
+             one
+            -two
+            +2
+             three
+             four
+             five
+            @@ -10,3 +10,4 @@
+             ten
+             eleven
+             twelve
+            +thirteen
+            \ No newline at end of file
+        "#]]
+        .assert_eq(&unified_diff(old, new, "a/page.html", "b/page.html"));
+        assert_eq!(unified_diff(old, old, "a", "b"), "");
+        let removed = unified_diff(old, &old.replace("five\n", ""), "a", "b");
+        assert!(removed.contains("@@ -2,7 +2,6 @@\n two\n three\n four\n-five\n six\n"));
+    }
+
+    #[test]
+    fn test_unified_diff_of_created_file() {
+        expect![[r#"
+            --- /dev/null
+            +++ b/new.html
+            @@ -0,0 +1,2 @@
+            +<h1>New</h1>
+            +<p>Text</p>
+        "#]]
+        .assert_eq(&unified_diff(
+            "",
+            "<h1>New</h1>\n<p>Text</p>\n",
+            "/dev/null",
+            "b/new.html",
+        ));
+    }
+
+    #[test]
+    fn test_unified_diff_of_interleaved_changes() {
+        let old = "a\nb\nc\nd\n";
+        let new = "a\nx\nc\ny\nd\n";
+        expect![[r#"
+            --- a
+            +++ b
+            @@ -1,4 +1,5 @@
+             a
+            -b
+            +x
+             c
+            +y
+             d
+        "#]]
+        .assert_eq(&unified_diff(old, new, "a", "b"));
+    }
+
+    #[test]
+    fn test_diff_site() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(
+            FilePath::from("output/index.html"),
+            b"<h1>Site</h1>\n".to_vec(),
+        );
+        mock_pal.add_file(FilePath::from("output/a.rs.html"), b"<p>Old</p>\n".to_vec());
+        mock_pal.add_file(
+            FilePath::from("output/gone.rs.html"),
+            b"<p>Gone</p>\n".to_vec(),
+        );
+        mock_pal.add_file(
+            FilePath::from("output/CNAME"),
+            b"docs.example.com\n".to_vec(),
+        );
+        mock_pal.add_file(FilePath::from("output/.nojekyll"), Vec::new());
+        mock_pal.add_file(FilePath::from("output/img/old.png"), vec![0x89, 2]);
+        mock_pal.add_file(FilePath::from("output/img/logo.png"), vec![0x89, 0x50, 0]);
+        mock_pal.add_file(FilePath::from("img/logo.png"), vec![0x89, 0x50, 1]);
+        mock_pal.add_file(FilePath::from("img/unchanged.png"), vec![0x89, 0]);
+        mock_pal.add_file(FilePath::from("output/img/unchanged.png"), vec![0x89, 0]);
+        let pal = PalHandle::new(mock_pal);
+        let asset = |path: &str| Asset {
+            source: FilePath::from(path),
+            path: FilePath::from(path),
+        };
+        let rendered = RenderResult {
+            files: vec![
+                RenderedFile {
+                    path: FilePath::from("index.html"),
+                    content: "<h1>Site</h1>\n".to_string(),
+                },
+                RenderedFile {
+                    path: FilePath::from("a.rs.html"),
+                    content: "<p>New</p>\n".to_string(),
+                },
+                RenderedFile {
+                    path: FilePath::from("b.rs.html"),
+                    content: "<p>B</p>\n".to_string(),
+                },
+            ],
+            warnings: Vec::new(),
+            assets: vec![asset("img/logo.png"), asset("img/unchanged.png")],
+        };
+        let diffs = diff_site(&pal, &FilePath::from("output"), &rendered).unwrap();
+        let report: String = diffs
+            .iter()
+            .map(|diff| format!("{}: {}\n{}", diff.path, diff.change, diff))
+            .collect();
+        expect![[r#"
+            .nojekyll: untracked
+            CNAME: untracked
+            a.rs.html: modified
+            --- a/a.rs.html
+            +++ b/a.rs.html
+            @@ -1 +1 @@
+            -<p>Old</p>
+            +<p>New</p>
+            b.rs.html: created
+            --- /dev/null
+            +++ b/b.rs.html
+            @@ -0,0 +1 @@
+            +<p>B</p>
+            gone.rs.html: deleted
+            --- a/gone.rs.html
+            +++ /dev/null
+            @@ -1 +0,0 @@
+            -<p>Gone</p>
+            img/logo.png: modified
+            Binary file img/logo.png modified
+            img/old.png: deleted
+            Binary file img/old.png deleted
+            img/unchanged.png: unchanged
+            index.html: unchanged
+        "#]]
+        .assert_eq(&report);
+        let changed: Vec<String> = diffs
+            .iter()
+            .filter(|diff| diff.is_changed())
+            .map(|diff| diff.path.to_string())
+            .collect();
+        assert_eq!(
+            changed,
+            [
+                "a.rs.html",
+                "b.rs.html",
+                "gone.rs.html",
+                "img/logo.png",
+                "img/old.png"
+            ]
+        );
+    }
+}
@@ -0,0 +1,473 @@
+/* 📖 # Why a registry of directives?
+
+Projects often want their own shorthands in doc comments, such as
+`{{glossary: term}}` for the definition of a term or `{{apiref: Foo}}` for a
+link to the API reference, and should not need to patch the extractor for each
+of them. A directive is a paragraph consisting of nothing but `{{name}}` or
+`{{name arguments}}`, the arguments may also follow a colon as in
+`{{name: arguments}}`. Each name is expanded by a `DirectiveHandler`
+registered with the extraction options:
+
+```text
+let options = ExtractionOptions::new()
+    .with_directive("glossary", Glossary::new(terms))
+    .with_directive("apiref", ApiRef::new("https://docs.example.com/{name}"));
+```
+
+Handlers run during extraction, when the PAL is at hand, and return the
+markdown replacing the directive paragraph. The expansion is stored with the
+document, so rendering stays a pure function of the documents, every output
+format shows the same content and the build cache sees when an expansion
+changes. Expansions are spliced in as blocks such as paragraphs, lists or code
+blocks; headings in them are rendered but not listed in the table of contents.
+A handler returning an error is a `ParseError` at the location of the
+directive, which is then left as it is.
+
+The built-in `{{include path}}` and `{{exec: command}}` directives are handlers
+registered the same way, and a project may replace them by registering its own
+handler under their name. A directive without a handler is most likely a typo,
+it is reported as a located warning, or as a `ParseError` with
+`fail_on_unknown_directives`. The `{{if profile}}` and `{{end}}` lines of
+conditional blocks are not directives, they are handled before rendering.
+*/
+
+use std::collections::BTreeMap;
+use std::fmt::Debug;
+use std::ops::Range;
+use std::sync::{Arc, LazyLock};
+
+use pulldown_cmark::{Event, Parser, Tag, TagEnd};
+use regex::Regex;
+
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle};
+
+use crate::exec::{EXEC_DIRECTIVE, ExecDirective};
+use crate::export::LineMap;
+use crate::footnote::PARSER_OPTIONS;
+use crate::include::{INCLUDE_DIRECTIVE, IncludeDirective, resolve_relative_path};
+use crate::parse_error::column_of;
+use crate::{Document, Exec, ExecOptions, Include, ParseError};
+
+/// Names of the conditional block lines, which look like directives but are not.
+const CONDITIONAL_NAMES: [&str; 2] = ["if", "end"];
+
+/// Expands a `{{name arguments}}` directive into markdown.
+pub trait DirectiveHandler: Debug + Send + Sync {
+    /// Expand the directive with the given arguments (empty if there are none).
+    ///
+    /// Returns the markdown replacing the directive paragraph. On error the
+    /// directive is reported as a parse error and left as it is.
+    fn expand(&self, arguments: &str, context: &mut DirectiveContext) -> HyperlitResult<String>;
+}
+
+/// The document and location of a directive being expanded.
+pub struct DirectiveContext<'a> {
+    pal: &'a PalHandle,
+    document: &'a Document,
+    line: usize,
+    includes: Vec<Include>,
+    execs: Vec<Exec>,
+}
+
+impl<'a> DirectiveContext<'a> {
+    /// Returns the PAL, e.g. to read files referenced by the directive.
+    pub fn pal(&self) -> &PalHandle {
+        self.pal
+    }
+
+    /// Returns the document containing the directive.
+    pub fn document(&self) -> &Document {
+        self.document
+    }
+
+    /// Returns the source line of the directive (1-indexed).
+    pub fn line(&self) -> usize {
+        self.line
+    }
+
+    /// Resolve a path written in the directive relative to the directory of the document's file.
+    pub fn resolve_path(&self, path: &str) -> FilePath {
+        resolve_relative_path(self.document.source().file_path(), path)
+    }
+
+    /// Record a file included by the directive, kept even if the expansion fails.
+    pub(crate) fn add_include(&mut self, include: Include) {
+        self.includes.push(include);
+    }
+
+    /// Record a command run by the directive, kept even if the expansion fails.
+    pub(crate) fn add_exec(&mut self, exec: Exec) {
+        self.execs.push(exec);
+    }
+}
+
+/// The markdown a directive was expanded to, stored with its document.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Expansion {
+    /// Name of the directive
+    pub name: String,
+    /// Arguments as written in the directive, without surrounding whitespace
+    pub arguments: String,
+    /// Markdown replacing the directive paragraph
+    pub markdown: String,
+}
+
+/// Registry of directive handlers keyed by directive name.
+#[derive(Debug, Clone)]
+pub struct DirectiveRegistry {
+    handlers: BTreeMap<String, Arc<dyn DirectiveHandler>>,
+}
+
+impl DirectiveRegistry {
+    /// Create an empty registry, without the built-in directives.
+    pub fn empty() -> Self {
+        Self {
+            handlers: BTreeMap::new(),
+        }
+    }
+
+    /// Register the handler of the directive `{{name ...}}`.
+    ///
+    /// Replaces any handler previously registered for that name, including the built-in ones.
+    pub fn register(&mut self, name: impl Into<String>, handler: impl DirectiveHandler + 'static) {
+        self.handlers.insert(name.into(), Arc::new(handler));
+    }
+
+    /// Get the handler registered for a directive name.
+    pub fn get(&self, name: &str) -> Option<&dyn DirectiveHandler> {
+        self.handlers.get(name).map(|handler| handler.as_ref())
+    }
+
+    /// Returns the names of the registered directives, in alphabetical order.
+    pub fn names(&self) -> impl Iterator<Item = &str> {
+        self.handlers.keys().map(String::as_str)
+    }
+}
+
+impl Default for DirectiveRegistry {
+    /// Registry with the built-in `include` and `exec` directives, command execution is disabled.
+    fn default() -> Self {
+        let mut registry = Self::empty();
+        registry.register(INCLUDE_DIRECTIVE, IncludeDirective);
+        registry.register(EXEC_DIRECTIVE, ExecDirective::new(ExecOptions::default()));
+        registry
+    }
+}
+
+static DIRECTIVE: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(r"^\{\{([A-Za-z][\w-]*)(?:(?::|\s)\s*(.*?))?\s*\}\}$").expect("valid regex")
+});
+
+/// Returns the name and arguments of a directive if `paragraph` consists of nothing else.
+pub(crate) fn parse_directive(paragraph: &str) -> Option<(&str, &str)> {
+    let captures = DIRECTIVE.captures(paragraph.trim())?;
+    let name = captures.get(1).expect("group 1 always matches").as_str();
+    if CONDITIONAL_NAMES.contains(&name) {
+        return None;
+    }
+    let arguments = captures.get(2).map_or("", |arguments| arguments.as_str());
+    Some((name, arguments))
+}
+
+/// A directive found in markdown content.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct FoundDirective<'a> {
+    /// Byte offset of the directive paragraph
+    offset: usize,
+    name: &'a str,
+    arguments: &'a str,
+}
+
+/// Find all directives in markdown content, in content order.
+fn find_directives(content: &str) -> Vec<FoundDirective<'_>> {
+    Parser::new(content)
+        .into_offset_iter()
+        .filter_map(|(event, range)| match event {
+            Event::Start(Tag::Paragraph) => {
+                parse_directive(&content[range.clone()]).map(|(name, arguments)| FoundDirective {
+                    offset: range.start,
+                    name,
+                    arguments,
+                })
+            }
+            _ => None,
+        })
+        .collect()
+}
+
+/// Expand the directives of `document`, extracted from `source` (the full content of its file).
+///
+/// Returns the document with the expansions, the parse errors of failed
+/// expansions and the errors about unknown directives, which are parse errors
+/// as well if `fail_on_unknown` is set.
+pub(crate) fn run_directives(
+    pal: &PalHandle,
+    registry: &DirectiveRegistry,
+    fail_on_unknown: bool,
+    mut document: Document,
+    source: &str,
+) -> (Document, Vec<ParseError>, Vec<ParseError>) {
+    let lines = LineMap::new(document.content(), document.source().line_number());
+    let mut expansions = Vec::new();
+    let mut includes = Vec::new();
+    let mut execs = Vec::new();
+    let mut errors = Vec::new();
+    let mut warnings = Vec::new();
+    for directive in find_directives(document.content()) {
+        let line = lines.line_of(directive.offset);
+        let error = |message: String| ParseError {
+            file_path: document.source().file_path().clone(),
+            line,
+            column: column_of(source, line, "{{"),
+            message,
+        };
+        let Some(handler) = registry.get(directive.name) else {
+            let error = error(format!("unknown directive '{}'", directive.name));
+            match fail_on_unknown {
+                true => errors.push(error),
+                false => warnings.push(error),
+            }
+            continue;
+        };
+        let mut context = DirectiveContext {
+            pal,
+            document: &document,
+            line,
+            includes: Vec::new(),
+            execs: Vec::new(),
+        };
+        let result = handler.expand(directive.arguments, &mut context);
+        includes.append(&mut context.includes);
+        execs.append(&mut context.execs);
+        match result {
+            Ok(markdown) => expansions.push(Expansion {
+                name: directive.name.to_string(),
+                arguments: directive.arguments.to_string(),
+                markdown,
+            }),
+            Err(e) => errors.push(error(e.to_string())),
+        }
+    }
+    for include in includes {
+        document = document.with_include(include);
+    }
+    for exec in execs {
+        document = document.with_exec(exec);
+    }
+    for expansion in expansions {
+        document = document.with_expansion(expansion);
+    }
+    (document, errors, warnings)
+}
+
+/// Returns the expansion of `paragraph` if it is a directive expanded for `document`.
+pub(crate) fn paragraph_expansion<'a>(
+    document: &'a Document,
+    paragraph: &str,
+) -> Option<&'a Expansion> {
+    let (name, arguments) = parse_directive(paragraph)?;
+    document.expansion(name, arguments)
+}
+
+/// Replace expanded directive paragraphs in parser events with their expansion.
+///
+/// Replacements keep the byte range of the directive paragraph. Directives
+/// without an expansion are left as they are.
+pub(crate) fn expand_directives<'a>(
+    document: &'a Document,
+    events: impl IntoIterator<Item = (Event<'a>, Range<usize>)>,
+) -> Vec<(Event<'a>, Range<usize>)> {
+    let content = document.content();
+    let mut expanded = Vec::new();
+    let mut in_directive = false;
+    for (event, range) in events {
+        if in_directive {
+            in_directive = !matches!(event, Event::End(TagEnd::Paragraph));
+            continue;
+        }
+        let expansion = match event {
+            Event::Start(Tag::Paragraph) => paragraph_expansion(document, &content[range.clone()]),
+            _ => None,
+        };
+        let Some(expansion) = expansion else {
+            expanded.push((event, range));
+            continue;
+        };
+        in_directive = true;
+        expanded.extend(
+            Parser::new_ext(&expansion.markdown, PARSER_OPTIONS)
+                .map(|event| (event, range.clone())),
+        );
+    }
+    expanded
+}
+
+/// Returns true if `range` of the content is an expanded directive paragraph.
+///
+/// Expansion events keep the range of the directive, so this tells them apart
+/// from events of the content itself.
+pub(crate) fn is_expansion(content: &str, range: &Range<usize>) -> bool {
+    content
+        .get(range.clone())
+        .and_then(parse_directive)
+        .is_some()
+}
+
+/// A fenced code block with a fence longer than any backtick run in `code`.
+pub(crate) fn fenced_code_block(language: &str, code: &str) -> String {
+    let longest_run = code
+        .split(|c| c != '`')
+        .map(str::len)
+        .max()
+        .unwrap_or_default();
+    let fence = "`".repeat((longest_run + 1).max(3));
+    let newline = if code.ends_with('\n') { "" } else { "\n" };
+    format!("{fence}{language}\n{code}{newline}{fence}")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use hyperlit_base::bail;
+    use hyperlit_base::pal::MockPal;
+    use std::collections::HashSet;
+
+    fn doc(content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from("src/lib.rs"), 1);
+        Document::new(
+            "Doc".to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    /// Looks up terms, failing for unknown ones.
+    #[derive(Debug)]
+    struct Glossary;
+
+    impl DirectiveHandler for Glossary {
+        fn expand(
+            &self,
+            arguments: &str,
+            context: &mut DirectiveContext,
+        ) -> HyperlitResult<String> {
+            match arguments {
+                "PAL" => Ok(format!(
+                    "**PAL**: Platform abstraction layer (line {}).",
+                    context.line()
+                )),
+                _ => bail!("unknown term '{}'", arguments),
+            }
+        }
+    }
+
+    #[test]
+    fn test_parse_directive() {
+        assert_eq!(
+            parse_directive(" {{include config.yaml}}\n"),
+            Some(("include", "config.yaml"))
+        );
+        assert_eq!(
+            parse_directive("{{exec: mytool --help}}"),
+            Some(("exec", "mytool --help"))
+        );
+        assert_eq!(parse_directive("{{exec:ls}}"), Some(("exec", "ls")));
+        assert_eq!(parse_directive("{{toc}}"), Some(("toc", "")));
+        assert_eq!(parse_directive("{{api-ref: }}"), Some(("api-ref", "")));
+        assert_eq!(parse_directive("See {{include config.yaml}}"), None);
+        assert_eq!(parse_directive("{{ spaced }}"), None);
+        assert_eq!(parse_directive("{{if oss}}"), None);
+        assert_eq!(parse_directive("{{end}}"), None);
+    }
+
+    #[test]
+    fn test_find_directives_only_in_own_paragraph() {
+        let content = "# Doc\n\n{{include a.yaml}}\n\nText {{include b.yaml}}\n\n```\n{{include c.yaml}}\n```\n\n{{glossary: PAL}}\n";
+        let found: Vec<(usize, &str, &str)> = find_directives(content)
+            .into_iter()
+            .map(|directive| (directive.offset, directive.name, directive.arguments))
+            .collect();
+        assert_eq!(
+            found,
+            vec![(7, "include", "a.yaml"), (80, "glossary", "PAL")]
+        );
+    }
+
+    #[test]
+    fn test_run_directives() {
+        let pal = PalHandle::new(MockPal::new());
+        let mut registry = DirectiveRegistry::empty();
+        registry.register("glossary", Glossary);
+        let source = "// 📖 # Doc\n//\n// {{glossary: PAL}}\n//\n// {{glossary: ABI}}\n//\n//  {{apiref Foo}}\nfn a() {}\n";
+        let content = "# Doc\n\n{{glossary: PAL}}\n\n{{glossary: ABI}}\n\n {{apiref Foo}}\n";
+
+        let (document, errors, warnings) =
+            run_directives(&pal, &registry, false, doc(content), source);
+
+        assert_eq!(
+            document.expansions(),
+            [Expansion {
+                name: "glossary".to_string(),
+                arguments: "PAL".to_string(),
+                markdown: "**PAL**: Platform abstraction layer (line 3).".to_string(),
+            }]
+        );
+        let errors: Vec<String> = errors.iter().map(ToString::to_string).collect();
+        assert_eq!(errors, ["src/lib.rs:5:4: unknown term 'ABI'"]);
+        let warnings: Vec<String> = warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(warnings, ["src/lib.rs:7:5: unknown directive 'apiref'"]);
+
+        let (_, errors, warnings) = run_directives(&pal, &registry, true, doc(content), source);
+        assert_eq!(errors.len(), 2);
+        assert!(warnings.is_empty());
+    }
+
+    #[test]
+    fn test_expand_directives() {
+        let document =
+            doc("# Doc\n\n{{glossary: PAL}}\n\n{{glossary: ABI}}\n").with_expansion(Expansion {
+                name: "glossary".to_string(),
+                arguments: "PAL".to_string(),
+                markdown: "- *PAL*".to_string(),
+            });
+        let content = document.content();
+        let events: Vec<(Event, Range<usize>)> =
+            expand_directives(&document, Parser::new(content).into_offset_iter())
+                .into_iter()
+                .filter(|(event, _)| !matches!(event, Event::Start(_) | Event::End(_)))
+                .collect();
+        assert_eq!(
+            events,
+            vec![
+                (Event::Text("Doc".into()), 2..5),
+                (Event::Text("PAL".into()), 7..25),
+                (Event::Text("{{glossary: ABI}}".into()), 26..43),
+            ]
+        );
+        assert!(is_expansion(content, &(7..25)));
+        assert!(!is_expansion(content, &(0..6)));
+    }
+
+    #[test]
+    fn test_directive_registry() {
+        let mut registry = DirectiveRegistry::default();
+        assert_eq!(registry.names().collect::<Vec<_>>(), ["exec", "include"]);
+        registry.register("include", Glossary);
+        registry.register("glossary", Glossary);
+        assert_eq!(format!("{:?}", registry.get("include")), "Some(Glossary)");
+        assert!(registry.get("apiref").is_none());
+        assert_eq!(DirectiveRegistry::empty().names().count(), 0);
+    }
+
+    #[test]
+    fn test_fenced_code_block() {
+        assert_eq!(fenced_code_block("yaml", "a: 1"), "```yaml\na: 1\n```");
+        assert_eq!(
+            fenced_code_block("", "```\ncode\n```\n"),
+            "````\n```\ncode\n```\n````"
+        );
+    }
+}
@@ -12,9 +12,13 @@ queryable data structure. The model separates content (what's documented) from s
 This is a data model only - extraction logic comes later.
 */
 
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 
-use hyperlit_base::FilePath;
+use hyperlit_base::{FilePath, HyperlitResult};
+
+use crate::exec::EXEC_DIRECTIVE;
+use crate::include::INCLUDE_DIRECTIVE;
+use crate::{Exec, Expansion, FollowingCode, FrontMatter, Include, WordCount, WordCountOptions};
 
 /// A documentation block extracted from source code or markdown files.
 ///
@@ -30,6 +34,15 @@ pub struct Document {
     content: String,
     source: DocumentSource,
     metadata: Option<DocumentMetadata>,
+    front_matter: Option<FrontMatter>,
+    symbol: Option<String>,
+    includes: Vec<Include>,
+    execs: Vec<Exec>,
+    expansions: Vec<Expansion>,
+    following_code: Option<FollowingCode>,
+    preamble: bool,
+    skipped: bool,
+    word_count: Option<WordCount>,
 }
 
 /// Unique identifier for a document.
@@ -179,7 +192,7 @@ impl DocumentSource {
     /// # Arguments
     /// * `source_type` - Whether this is a code comment or markdown file
     /// * `file_path` - Relative path to the file
-    /// * `line_number` - Line number where the document appears (1-indexed for code, 1 for files)
+    /// * `line_number` - Line number where the document content starts (1-indexed)
     ///
     /// For a DocumentSource with byte range, use `with_byte_range()` after construction.
     pub fn new(source_type: SourceType, file_path: FilePath, line_number: usize) -> Self {
@@ -224,7 +237,9 @@ impl DocumentSource {
         &self.file_path
     }
 
-    /// Returns the line number (1-indexed for code, 1 for markdown files).
+    /// Returns the line number where the document content starts (1-indexed).
+    ///
+    /// For markdown files this is the first line after any frontmatter.
     pub fn line_number(&self) -> usize {
         self.line_number
     }
@@ -339,22 +354,25 @@ impl ByteRange {
 /// Metadata extracted from a document (simple key-value pairs).
 ///
 /// Stores additional metadata like author, date, tags, etc. Metadata is stored as simple
-/// string key-value pairs for flexibility. Future versions may add YAML frontmatter parsing.
+/// string key-value pairs for flexibility. Structured values are available from the
+/// document's front matter, see [`Document::front_matter`].
 #[derive(Debug, Clone, PartialEq, Eq)]
 pub struct DocumentMetadata {
-    fields: HashMap<String, String>,
+    fields: BTreeMap<String, String>,
 }
 
 impl DocumentMetadata {
     /// Create metadata from a HashMap of fields.
     pub fn new(fields: HashMap<String, String>) -> Self {
-        Self { fields }
+        Self {
+            fields: fields.into_iter().collect(),
+        }
     }
 
     /// Create empty metadata.
     pub fn empty() -> Self {
         Self {
-            fields: HashMap::new(),
+            fields: BTreeMap::new(),
         }
     }
 
@@ -363,7 +381,7 @@ impl DocumentMetadata {
         self.fields.get(key).map(|s| s.as_str())
     }
 
-    /// Iterate over metadata fields.
+    /// Iterate over metadata fields, sorted by key.
     pub fn iter(&self) -> impl Iterator<Item = (&str, &str)> {
         self.fields.iter().map(|(k, v)| (k.as_str(), v.as_str()))
     }
@@ -425,9 +443,122 @@ impl Document {
             content,
             source,
             metadata,
+            front_matter: None,
+            symbol: None,
+            includes: Vec::new(),
+            execs: Vec::new(),
+            expansions: Vec::new(),
+            following_code: None,
+            preamble: false,
+            skipped: false,
+            word_count: None,
         }
     }
 
+    /// Replace the ID with one derived from the title that is not in `existing_ids`.
+    pub(crate) fn assign_unique_id(&mut self, existing_ids: &std::collections::HashSet<String>) {
+        self.id = DocumentId::from_title(&self.title, existing_ids);
+    }
+
+    /// Replace the markdown content, keeping the ID, title and source.
+    pub(crate) fn with_content(mut self, content: String) -> Self {
+        self.content = content;
+        self
+    }
+
+    /// Replace the source location, keeping the ID, title and content.
+    pub(crate) fn with_source(mut self, source: DocumentSource) -> Self {
+        self.source = source;
+        self
+    }
+
+    /// Replace the markdown content, e.g. in a [`Transformer`](crate::Transformer).
+    pub fn set_content(&mut self, content: impl Into<String>) {
+        self.content = content.into();
+    }
+
+    /// Set the front matter parsed from the top of the document.
+    ///
+    /// See [`crate::front_matter`].
+    pub fn with_front_matter(mut self, front_matter: FrontMatter) -> Self {
+        self.front_matter = Some(front_matter);
+        self
+    }
+
+    /// Set the name of the code symbol this document describes.
+    ///
+    /// For code comments this is the identifier declared on the line following
+    /// the comment (e.g. `greet` for `fn greet()`), see [`crate::xref`].
+    pub fn with_symbol(mut self, symbol: impl Into<String>) -> Self {
+        self.symbol = Some(symbol.into());
+        self
+    }
+
+    /// Add a file included by an `{{include path}}` directive in the content, and its expansion.
+    ///
+    /// See [`crate::include`].
+    pub fn with_include(mut self, include: Include) -> Self {
+        let expansion = Expansion {
+            name: INCLUDE_DIRECTIVE.to_string(),
+            arguments: include.directive_path.clone(),
+            markdown: include.markdown(),
+        };
+        self.includes.push(include);
+        self.with_expansion(expansion)
+    }
+
+    /// Add the output of a command run by an `{{exec: command}}` directive in the content, and its expansion.
+    ///
+    /// See [`crate::exec`].
+    pub fn with_exec(mut self, exec: Exec) -> Self {
+        let expansion = Expansion {
+            name: EXEC_DIRECTIVE.to_string(),
+            arguments: exec.command.clone(),
+            markdown: exec.markdown(),
+        };
+        self.execs.push(exec);
+        self.with_expansion(expansion)
+    }
+
+    /// Set the expansion of a directive in the content, replacing an earlier one of the same directive.
+    ///
+    /// See [`crate::directive`].
+    pub fn with_expansion(mut self, expansion: Expansion) -> Self {
+        self.expansions.retain(|existing| {
+            existing.name != expansion.name || existing.arguments != expansion.arguments
+        });
+        self.expansions.push(expansion);
+        self
+    }
+
+    /// Set the source code following the doc comment, shown under the documentation.
+    ///
+    /// See [`crate::following_code`].
+    pub fn with_following_code(mut self, following_code: FollowingCode) -> Self {
+        self.following_code = Some(following_code);
+        self
+    }
+
+    /// Set whether the document is the preamble of its file, see [`crate::extractor`].
+    pub fn with_preamble(mut self, preamble: bool) -> Self {
+        self.preamble = preamble;
+        self
+    }
+
+    /// Set whether the document is disabled with a skip pragma, see [`crate::skip`].
+    pub fn with_skipped(mut self, skipped: bool) -> Self {
+        self.skipped = skipped;
+        self
+    }
+
+    /// Set the number of words and reading time of the document, as counted during extraction.
+    ///
+    /// See [`crate::word_count`].
+    pub fn with_word_count(mut self, word_count: WordCount) -> Self {
+        self.word_count = Some(word_count);
+        self
+    }
+
     /// Returns the document ID.
     pub fn id(&self) -> &DocumentId {
         &self.id
@@ -452,6 +583,82 @@ impl Document {
     pub fn metadata(&self) -> Option<&DocumentMetadata> {
         self.metadata.as_ref()
     }
+
+    /// Returns the front matter if the document starts with a front matter block.
+    pub fn front_matter(&self) -> Option<&FrontMatter> {
+        self.front_matter.as_ref()
+    }
+
+    /// Returns the name of the documented code symbol, if known.
+    pub fn symbol(&self) -> Option<&str> {
+        self.symbol.as_deref()
+    }
+
+    /// Returns the files included by the document, in directive order.
+    pub fn includes(&self) -> &[Include] {
+        &self.includes
+    }
+
+    /// Returns the file included by the directive `{{include directive_path}}`.
+    pub fn include(&self, directive_path: &str) -> Option<&Include> {
+        self.includes
+            .iter()
+            .find(|include| include.directive_path == directive_path)
+    }
+
+    /// Returns the commands run for the document, in directive order.
+    pub fn execs(&self) -> &[Exec] {
+        &self.execs
+    }
+
+    /// Returns the command run by the directive `{{exec: command}}`.
+    pub fn exec(&self, command: &str) -> Option<&Exec> {
+        self.execs.iter().find(|exec| exec.command == command)
+    }
+
+    /// Returns the expansions of the directives in the content.
+    pub fn expansions(&self) -> &[Expansion] {
+        &self.expansions
+    }
+
+    /// Returns the expansion of the directive `{{name arguments}}`.
+    pub fn expansion(&self, name: &str, arguments: &str) -> Option<&Expansion> {
+        self.expansions
+            .iter()
+            .find(|expansion| expansion.name == name && expansion.arguments == arguments)
+    }
+
+    /// Returns the source code following the doc comment, if captured.
+    pub fn following_code(&self) -> Option<&FollowingCode> {
+        self.following_code.as_ref()
+    }
+
+    /// Returns true if the document introduces its whole file rather than the code after it.
+    pub fn is_preamble(&self) -> bool {
+        self.preamble
+    }
+
+    /// Returns true if the document is disabled with a skip pragma and only
+    /// extracted for review.
+    pub fn is_skipped(&self) -> bool {
+        self.skipped
+    }
+
+    /// Returns the number of words and the estimated reading time of the document.
+    ///
+    /// Documents not counted during extraction are counted with the default
+    /// [`WordCountOptions`].
+    pub fn word_count(&self) -> WordCount {
+        self.word_count
+            .unwrap_or_else(|| WordCount::of(self, &WordCountOptions::default()))
+    }
+
+    /// Serializes the parsed document tree to JSON.
+    ///
+    /// See the [`export`](crate::export) module for the versioned schema.
+    pub fn to_json(&self) -> HyperlitResult<String> {
+        crate::export::export_document_json(self)
+    }
 }
 
 #[cfg(test)]
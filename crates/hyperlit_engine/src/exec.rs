@@ -0,0 +1,633 @@
+/* 📖 # Why can documents embed command output, and why is it off by default?
+
+Documentation of a command line tool is most useful with real output, such as
+the `--help` text, and copied output goes stale with every new flag. A doc block
+can instead run a command with a directive in its own paragraph:
+
+    Usage:
+
+    {{exec: mytool --help}}
+
+Stdout is rendered as a code block. If the command writes to stderr, both
+streams are rendered as separately labeled code blocks, so diagnostics are never
+mistaken for regular output.
+
+Running commands found in doc comments is dangerous: building the docs of an
+untrusted repository must not run arbitrary programs. Execution is therefore
+disabled unless `allow_exec` is explicitly enabled, and every directive is a
+`ParseError` while it is disabled, so nothing silently renders as raw text.
+On top of that:
+
+- An allowlist restricts which commands may run. An entry matches a command
+  starting with the same words, so `mytool` allows `mytool --help` and
+  `cargo run` allows `cargo run -- --help`, but not `cargo publish`
+- Commands run without a shell (see the PAL), so `;`, `|` or `$(...)` in a
+  directive are plain arguments
+- Commands run in a dedicated working directory, not the source tree: the
+  `run` subdirectory of the configured `working_directory`, which must lie
+  strictly inside the cache directory. Only that subdirectory hyperlit creates
+  itself is emptied before every command, so a misconfigured `working_directory`
+  such as `.` or `src` is refused instead of deleting the project. Commands run
+  one at a time, so no command sees the files another one (or an earlier
+  build) left behind
+- A command exceeding the timeout is killed and reported as a parse error
+
+Like included files, commands run during extraction by the directive handler
+(see `crate::directive`) and their output is stored with the document, so the
+build cache and watch mode see when it changes. A command exiting with a
+non-zero code is reported as a parse error, its output is still rendered.
+`hyperlit check` writes nothing, so it validates exec directives (enabled,
+allowlisted, well quoted) without running their commands, see
+`ExecOptions::with_dry_run`.
+*/
+
+use std::path::{Component, Path};
+use std::sync::Mutex;
+use std::time::Duration;
+
+use hyperlit_base::pal::{CommandOutput, CommandSpec};
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle, bail};
+
+use crate::directive::{DirectiveContext, DirectiveHandler, fenced_code_block};
+use crate::{Config, DEFAULT_CACHE_DIRECTORY};
+
+/// Timeout in seconds used when none is configured.
+pub const DEFAULT_EXEC_TIMEOUT_SECONDS: u64 = 10;
+
+/// Name of the working directory for commands, inside the cache directory.
+pub const EXEC_DIRECTORY: &str = "exec";
+
+/// Subdirectory of the working directory that commands run in, emptied before every command.
+const RUN_DIRECTORY: &str = "run";
+
+/// Options controlling whether and how `{{exec: command}}` directives run commands.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ExecOptions {
+    allow_exec: bool,
+    allowlist: Option<Vec<String>>,
+    timeout: Duration,
+    cache_directory: FilePath,
+    working_directory: FilePath,
+    dry_run: bool,
+}
+
+impl Default for ExecOptions {
+    fn default() -> Self {
+        Self {
+            allow_exec: false,
+            allowlist: None,
+            timeout: Duration::from_secs(DEFAULT_EXEC_TIMEOUT_SECONDS),
+            cache_directory: FilePath::from(DEFAULT_CACHE_DIRECTORY),
+            working_directory: FilePath::from(format!(
+                "{}/{}",
+                DEFAULT_CACHE_DIRECTORY, EXEC_DIRECTORY
+            )),
+            dry_run: false,
+        }
+    }
+}
+
+impl ExecOptions {
+    /// Create exec options with default settings (command execution disabled).
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Create exec options from the site configuration.
+    pub fn from_config(config: &Config) -> Self {
+        let exec = &config.exec;
+        let mut options = Self::new()
+            .with_allow_exec(exec.allow_exec)
+            .with_timeout(Duration::from_secs(
+                exec.timeout_seconds.unwrap_or(DEFAULT_EXEC_TIMEOUT_SECONDS),
+            ))
+            .with_cache_directory(config.cache_directory())
+            .with_working_directory(match &exec.working_directory {
+                Some(directory) => FilePath::from(directory.as_str()),
+                None => FilePath::from(config.cache_directory().as_relative().join(EXEC_DIRECTORY)),
+            });
+        if let Some(allowlist) = &exec.allowlist {
+            options = options.with_allowlist(allowlist.clone());
+        }
+        options
+    }
+
+    /// Enable or disable running commands.
+    pub fn with_allow_exec(mut self, allow_exec: bool) -> Self {
+        self.allow_exec = allow_exec;
+        self
+    }
+
+    /// Only allow commands starting with the words of one of the `allowlist` entries.
+    ///
+    /// Without an allowlist, any command may run once execution is allowed.
+    pub fn with_allowlist(mut self, allowlist: Vec<String>) -> Self {
+        self.allowlist = Some(allowlist);
+        self
+    }
+
+    /// Set the time after which a command is killed.
+    pub fn with_timeout(mut self, timeout: Duration) -> Self {
+        self.timeout = timeout;
+        self
+    }
+
+    /// Set the cache directory, which the working directory must lie inside.
+    pub fn with_cache_directory(mut self, cache_directory: FilePath) -> Self {
+        self.cache_directory = cache_directory;
+        self
+    }
+
+    /// Set the working directory for commands, relative to the site root.
+    ///
+    /// It must lie strictly inside the cache directory. Commands run in its
+    /// `run` subdirectory, which is emptied, or created, before every command.
+    pub fn with_working_directory(mut self, working_directory: FilePath) -> Self {
+        self.working_directory = working_directory;
+        self
+    }
+
+    /// Check directives without running their commands, they render no output.
+    pub fn with_dry_run(mut self, dry_run: bool) -> Self {
+        self.dry_run = dry_run;
+        self
+    }
+
+    /// Returns true if `args` (program followed by arguments) may run.
+    fn is_allowed(&self, args: &[String]) -> bool {
+        let Some(allowlist) = &self.allowlist else {
+            return true;
+        };
+        allowlist.iter().any(|entry| {
+            let words: Vec<&str> = entry.split_whitespace().collect();
+            !words.is_empty()
+                && words.len() <= args.len()
+                && words.iter().zip(args).all(|(word, arg)| *word == arg)
+        })
+    }
+}
+
+/// A command run by an `{{exec: command}}` directive, with its output.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Exec {
+    /// Command as written in the directive
+    pub command: String,
+    /// Captured output of the command
+    pub output: CommandOutput,
+}
+
+impl Exec {
+    /// The code blocks replacing the directive, with labeled stdout and stderr if there is any stderr.
+    pub fn markdown(&self) -> String {
+        let output = &self.output;
+        if output.stderr.is_empty() {
+            return fenced_code_block("text", &output.stdout);
+        }
+        format!(
+            "**stdout**\n\n{}\n\n**stderr**\n\n{}",
+            fenced_code_block("text", &output.stdout),
+            fenced_code_block("text", &output.stderr)
+        )
+    }
+}
+
+/// Name of the built-in exec directive.
+pub(crate) const EXEC_DIRECTIVE: &str = "exec";
+
+/// Held while a command runs, so commands never share their working directory.
+static WORKING_DIRECTORY_LOCK: Mutex<()> = Mutex::new(());
+
+/// Returns the components of `path` with `.` and `..` resolved, None if it leaves its base.
+fn normalized_components(path: &Path) -> Option<Vec<Component<'_>>> {
+    let mut components = Vec::new();
+    for component in path.components() {
+        match component {
+            Component::CurDir => {}
+            Component::ParentDir => {
+                if !matches!(components.pop(), Some(Component::Normal(_))) {
+                    return None;
+                }
+            }
+            component => components.push(component),
+        }
+    }
+    Some(components)
+}
+
+/// Returns true if `path` lies inside `directory` and is not `directory` itself.
+fn is_strictly_inside(path: &FilePath, directory: &FilePath) -> bool {
+    match (
+        normalized_components(path.as_path()),
+        normalized_components(directory.as_path()),
+    ) {
+        (Some(path), Some(directory)) => {
+            path.len() > directory.len() && path.starts_with(&directory)
+        }
+        _ => false,
+    }
+}
+
+/// Split a command into program and arguments.
+///
+/// Arguments are separated by whitespace, single or double quotes group words
+/// into one argument. Returns None if a quote is not closed.
+fn split_command(command: &str) -> Option<Vec<String>> {
+    let mut args = Vec::new();
+    let mut current: Option<String> = None;
+    let mut quote = None;
+    for c in command.chars() {
+        match (quote, c) {
+            (Some(q), c) if c == q => quote = None,
+            (Some(_), c) => current.get_or_insert_with(String::new).push(c),
+            (None, '"' | '\'') => {
+                quote = Some(c);
+                current.get_or_insert_with(String::new);
+            }
+            (None, c) if c.is_whitespace() => args.extend(current.take()),
+            (None, c) => current.get_or_insert_with(String::new).push(c),
+        }
+    }
+    if quote.is_some() {
+        return None;
+    }
+    args.extend(current);
+    Some(args)
+}
+
+/// Run a single directive command, returning its output or a parse error message.
+fn run(pal: &PalHandle, options: &ExecOptions, command: &str) -> (Option<Exec>, Option<String>) {
+    if !options.allow_exec {
+        return (
+            None,
+            Some(format!(
+                "command execution is disabled, enable allow_exec to run '{}'",
+                command
+            )),
+        );
+    }
+    let Some(args) = split_command(command) else {
+        return (
+            None,
+            Some(format!("unterminated quote in command '{}'", command)),
+        );
+    };
+    if !options.is_allowed(&args) {
+        return (
+            None,
+            Some(format!(
+                "command '{}' is not in the exec allowlist",
+                command
+            )),
+        );
+    }
+    if !is_strictly_inside(&options.working_directory, &options.cache_directory) {
+        return (
+            None,
+            Some(format!(
+                "exec working directory '{}' is not inside the cache directory '{}'",
+                options.working_directory, options.cache_directory
+            )),
+        );
+    }
+    if options.dry_run {
+        return (None, None);
+    }
+    let run_directory = FilePath::from(options.working_directory.as_relative().join(RUN_DIRECTORY));
+    let spec = CommandSpec::new(args[0].clone(), run_directory.clone(), options.timeout)
+        .with_args(args[1..].iter().cloned());
+    let _lock = WORKING_DIRECTORY_LOCK
+        .lock()
+        .unwrap_or_else(|poisoned| poisoned.into_inner());
+    // Removing fails if the directory does not exist yet
+    let _ = pal.remove_directory_all(&run_directory);
+    if let Err(e) = pal.create_directory_all(&run_directory) {
+        return (None, Some(e.to_string()));
+    }
+    match pal.run_command(&spec) {
+        Ok(output) => {
+            let error = (!output.success()).then(|| match output.exit_code {
+                Some(code) => format!("command '{}' exited with code {}", command, code),
+                None => format!("command '{}' was terminated by a signal", command),
+            });
+            let exec = Exec {
+                command: command.to_string(),
+                output,
+            };
+            (Some(exec), error)
+        }
+        Err(e) => (None, Some(e.to_string())),
+    }
+}
+
+/// The built-in `{{exec: command}}` directive.
+#[derive(Debug, Clone)]
+pub(crate) struct ExecDirective {
+    options: ExecOptions,
+}
+
+impl ExecDirective {
+    /// Create the directive, running commands as set by `options`.
+    pub(crate) fn new(options: ExecOptions) -> Self {
+        Self { options }
+    }
+}
+
+impl DirectiveHandler for ExecDirective {
+    fn expand(&self, arguments: &str, context: &mut DirectiveContext) -> HyperlitResult<String> {
+        if arguments.is_empty() {
+            bail!("exec directive expects a command");
+        }
+        let (exec, error) = run(context.pal(), &self.options, arguments);
+        let markdown = exec.as_ref().map(Exec::markdown).unwrap_or_default();
+        // The output of a failing command is kept, it is rendered unless extraction fails fast
+        if let Some(exec) = exec {
+            context.add_exec(exec);
+        }
+        match error {
+            Some(message) => bail!("{}", message),
+            None => Ok(markdown),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::directive::{DirectiveRegistry, run_directives};
+    use crate::{Document, DocumentSource, SourceType};
+    use hyperlit_base::pal::MockPal;
+    use std::collections::HashSet;
+
+    fn doc(content: &str) -> Document {
+        let source =
+            DocumentSource::new(SourceType::MarkdownFile, FilePath::from("docs/cli.md"), 1);
+        Document::new(
+            "Doc".to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    fn output(stdout: &str, stderr: &str, exit_code: i32) -> CommandOutput {
+        CommandOutput {
+            stdout: stdout.to_string(),
+            stderr: stderr.to_string(),
+            exit_code: Some(exit_code),
+        }
+    }
+
+    fn run_with(
+        mock_pal: MockPal,
+        options: &ExecOptions,
+        content: &str,
+    ) -> (Document, Vec<String>) {
+        let pal = PalHandle::new(mock_pal);
+        let mut registry = DirectiveRegistry::empty();
+        registry.register(EXEC_DIRECTIVE, ExecDirective::new(options.clone()));
+        let (document, errors, _) = run_directives(&pal, &registry, false, doc(content), content);
+        (document, errors.iter().map(ToString::to_string).collect())
+    }
+
+    #[test]
+    fn test_split_command() {
+        assert_eq!(
+            split_command(r#"tool --name "a b" 'c "d"' ''"#),
+            Some(vec![
+                "tool".to_string(),
+                "--name".to_string(),
+                "a b".to_string(),
+                "c \"d\"".to_string(),
+                String::new(),
+            ])
+        );
+        assert_eq!(split_command("tool 'open"), None);
+    }
+
+    #[test]
+    fn test_run_execs_disabled_by_default() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_command_output("mytool --help", output("usage\n", "", 0));
+        let (document, errors) = run_with(
+            mock_pal.clone(),
+            &ExecOptions::new(),
+            "# CLI\n\n{{exec: mytool --help}}\n",
+        );
+
+        assert!(document.execs().is_empty());
+        assert_eq!(
+            errors,
+            [
+                "docs/cli.md:3:1: command execution is disabled, enable allow_exec to run 'mytool --help'"
+            ]
+        );
+        assert!(mock_pal.executed_commands().is_empty());
+    }
+
+    #[test]
+    fn test_run_execs() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_command_output("mytool --help", output("usage\n", "", 0));
+        let options = ExecOptions::new()
+            .with_allow_exec(true)
+            .with_timeout(Duration::from_secs(3))
+            .with_cache_directory(FilePath::from("cache"))
+            .with_working_directory(FilePath::from("cache/sandbox"));
+        let (document, errors) = run_with(
+            mock_pal.clone(),
+            &options,
+            "# CLI\n\n{{exec: mytool --help}}\n",
+        );
+
+        assert!(errors.is_empty());
+        assert_eq!(
+            document.exec("mytool --help").map(|exec| &exec.output),
+            Some(&output("usage\n", "", 0))
+        );
+        let expected = CommandSpec::new(
+            "mytool",
+            FilePath::from("cache/sandbox/run"),
+            Duration::from_secs(3),
+        )
+        .with_args(["--help"]);
+        assert_eq!(mock_pal.executed_commands(), vec![expected]);
+    }
+
+    #[test]
+    fn test_run_execs_in_emptied_working_directory() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(
+            FilePath::from("cache/sandbox/run/leftover.txt"),
+            b"old".to_vec(),
+        );
+        mock_pal.add_file(FilePath::from("cache/sandbox/notes.txt"), b"keep".to_vec());
+        mock_pal.add_command_output("mytool --help", output("usage\n", "", 0));
+        let options = ExecOptions::new()
+            .with_allow_exec(true)
+            .with_cache_directory(FilePath::from("cache"))
+            .with_working_directory(FilePath::from("cache/sandbox"));
+        let (_, errors) = run_with(mock_pal.clone(), &options, "{{exec: mytool --help}}\n");
+        let pal = PalHandle::new(mock_pal);
+
+        assert!(errors.is_empty());
+        assert!(
+            !pal.file_exists(&FilePath::from("cache/sandbox/run/leftover.txt"))
+                .unwrap()
+        );
+        assert!(
+            pal.file_exists(&FilePath::from("cache/sandbox/notes.txt"))
+                .unwrap()
+        );
+    }
+
+    #[test]
+    fn test_run_execs_refuses_working_directory_outside_cache() {
+        for directory in [
+            "",
+            ".",
+            "src",
+            "cache",
+            "cache/../src",
+            "cache/./",
+            "/tmp/exec",
+        ] {
+            let mock_pal = MockPal::new();
+            mock_pal.add_file(FilePath::from("src/main.rs"), b"fn main() {}".to_vec());
+            mock_pal.add_command_output("mytool --help", output("usage\n", "", 0));
+            let options = ExecOptions::new()
+                .with_allow_exec(true)
+                .with_cache_directory(FilePath::from("cache"))
+                .with_working_directory(FilePath::from(directory));
+            let (document, errors) =
+                run_with(mock_pal.clone(), &options, "{{exec: mytool --help}}\n");
+
+            assert!(document.execs().is_empty());
+            assert_eq!(
+                errors,
+                [format!(
+                    "docs/cli.md:1:1: exec working directory '{}' is not inside the cache directory 'cache'",
+                    directory
+                )]
+            );
+            assert!(mock_pal.executed_commands().is_empty());
+            assert!(
+                PalHandle::new(mock_pal)
+                    .file_exists(&FilePath::from("src/main.rs"))
+                    .unwrap()
+            );
+        }
+    }
+
+    #[test]
+    fn test_run_execs_dry_run() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(
+            FilePath::from("build/cache/exec/run/leftover.txt"),
+            b"old".to_vec(),
+        );
+        mock_pal.add_command_output("mytool --help", output("usage\n", "", 0));
+        let options = ExecOptions::new()
+            .with_allow_exec(true)
+            .with_allowlist(vec!["mytool".to_string()])
+            .with_dry_run(true);
+        let (document, errors) = run_with(
+            mock_pal.clone(),
+            &options,
+            "{{exec: mytool --help}}\n\n{{exec: rm -rf src}}\n",
+        );
+
+        assert!(document.execs().is_empty());
+        assert_eq!(
+            errors,
+            ["docs/cli.md:3:1: command 'rm -rf src' is not in the exec allowlist"]
+        );
+        assert!(mock_pal.executed_commands().is_empty());
+        assert!(
+            PalHandle::new(mock_pal)
+                .file_exists(&FilePath::from("build/cache/exec/run/leftover.txt"))
+                .unwrap()
+        );
+    }
+
+    #[test]
+    fn test_run_execs_allowlist() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_command_output("cargo run -- --help", output("usage\n", "", 0));
+        let options = ExecOptions::new()
+            .with_allow_exec(true)
+            .with_allowlist(vec!["cargo run".to_string(), "mytool".to_string()]);
+        let (document, errors) = run_with(
+            mock_pal.clone(),
+            &options,
+            "{{exec: cargo run -- --help}}\n\n{{exec: cargo publish}}\n\n{{exec: mytools}}\n",
+        );
+
+        assert_eq!(document.execs().len(), 1);
+        assert_eq!(
+            errors,
+            [
+                "docs/cli.md:3:1: command 'cargo publish' is not in the exec allowlist",
+                "docs/cli.md:5:1: command 'mytools' is not in the exec allowlist",
+            ]
+        );
+        assert_eq!(mock_pal.executed_commands().len(), 1);
+    }
+
+    #[test]
+    fn test_run_execs_failing_command_keeps_output() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_command_output("mytool check", output("", "error: broken\n", 2));
+        let options = ExecOptions::new().with_allow_exec(true);
+        let (document, errors) = run_with(mock_pal, &options, "{{exec: mytool check}}\n");
+
+        assert_eq!(
+            errors,
+            ["docs/cli.md:1:1: command 'mytool check' exited with code 2"]
+        );
+        assert_eq!(document.execs().len(), 1);
+        assert!(document.expansion("exec", "mytool check").is_some());
+    }
+
+    #[test]
+    fn test_run_execs_command_error() {
+        let options = ExecOptions::new().with_allow_exec(true);
+        let (document, errors) = run_with(MockPal::new(), &options, "  {{exec: missing}}\n");
+
+        assert!(document.execs().is_empty());
+        assert_eq!(
+            errors,
+            ["docs/cli.md:1:3: Failed to run command 'missing': not found"]
+        );
+    }
+
+    #[test]
+    fn test_from_config() {
+        let config: Config = toml::from_str(
+            r#"
+title = "Docs"
+source_link_template = ""
+cache_directory = "build/cache"
+
+[exec]
+allow_exec = true
+allowlist = ["mytool"]
+timeout_seconds = 2
+"#,
+        )
+        .unwrap();
+        assert_eq!(
+            ExecOptions::from_config(&config),
+            ExecOptions::new()
+                .with_allow_exec(true)
+                .with_allowlist(vec!["mytool".to_string()])
+                .with_timeout(Duration::from_secs(2))
+                .with_cache_directory(FilePath::from("build/cache"))
+                .with_working_directory(FilePath::from("build/cache/exec"))
+        );
+        assert_eq!(
+            ExecOptions::from_config(&Config::default()),
+            ExecOptions::new()
+        );
+    }
+}
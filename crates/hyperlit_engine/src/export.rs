@@ -0,0 +1,512 @@
+/* 📖 # Why offer a machine-readable export of documents?
+
+The web UI renders a document's markdown itself, but tools such as editor
+integrations need to know *where* each part of a document comes from. Parsing
+rendered HTML for that is brittle, so the export serializes the parsed markdown
+tree as JSON instead. Every block node carries its source file and the line range
+it occupies in that file, which allows jumping from a rendered node straight to
+the source location.
+
+The schema is part of hyperlit's public interface. Its version is emitted as the
+top-level `schemaVersion` field and must be incremented whenever a field is
+removed or changes meaning. Adding optional fields is not a breaking change.
+*/
+
+use std::collections::BTreeMap;
+
+use pulldown_cmark::{CodeBlockKind, Event, HeadingLevel, Parser, Tag, TagEnd};
+use serde::Serialize;
+
+use hyperlit_base::{HyperlitResult, err};
+
+use crate::Document;
+use crate::code_attrs::{CodeBlockAttrs, split_info};
+
+/// Version of the JSON export schema.
+pub const EXPORT_SCHEMA_VERSION: u32 = 1;
+
+/// Top-level structure of a document export.
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DocumentExport {
+    /// Version of the export schema, see [`EXPORT_SCHEMA_VERSION`]
+    pub schema_version: u32,
+    /// Document ID
+    pub id: String,
+    /// Document title
+    pub title: String,
+    /// Where the document was extracted from
+    pub source: ExportSource,
+    /// Document metadata (e.g. from frontmatter), sorted by key
+    #[serde(skip_serializing_if = "BTreeMap::is_empty")]
+    pub metadata: BTreeMap<String, String>,
+    /// True if the document is the preamble introducing its file
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub preamble: bool,
+    /// True if the document is disabled with a skip pragma, see [`crate::skip`]
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub skipped: bool,
+    /// Number of words of the document, see [`crate::word_count`]
+    pub word_count: usize,
+    /// Estimated reading time of the document in whole minutes, rounded up
+    pub reading_time_minutes: u64,
+    /// Top-level block nodes of the document
+    pub nodes: Vec<ExportNode>,
+}
+
+/// Source location of an exported document.
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ExportSource {
+    /// Either "code_comment" or "markdown_file"
+    #[serde(rename = "type")]
+    pub source_type: &'static str,
+    /// Path of the source file
+    pub file_path: String,
+    /// First line of the document content in the source file (1-indexed)
+    pub start_line: usize,
+    /// Last line of the document content in the source file (1-indexed, inclusive)
+    pub end_line: usize,
+}
+
+/// A block node of the exported document tree.
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ExportNode {
+    /// Kind of node
+    #[serde(rename = "type")]
+    pub node_type: ExportNodeType,
+    /// Path of the source file containing this node
+    pub file_path: String,
+    /// First source line of this node (1-indexed)
+    pub start_line: usize,
+    /// Last source line of this node (1-indexed, inclusive)
+    pub end_line: usize,
+    /// Heading level (1-6), only present for headings
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub level: Option<u8>,
+    /// Info string language, only present for fenced code blocks that declare one
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub language: Option<String>,
+    /// Attributes in braces after the info string language, only present for
+    /// fenced code blocks with valid attributes
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub attributes: Option<CodeBlockAttrs>,
+    /// Plain text of headings, paragraphs and tight list items, or the code of code blocks
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub text: Option<String>,
+    /// Nested block nodes (e.g. the items of a list)
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub children: Vec<ExportNode>,
+}
+
+/// Kinds of block nodes in an exported document tree.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub enum ExportNodeType {
+    Heading,
+    Paragraph,
+    CodeBlock,
+    BlockQuote,
+    List,
+    ListItem,
+    HtmlBlock,
+    ThematicBreak,
+}
+
+/// Build the export tree for a document.
+pub fn export_document(document: &Document) -> DocumentExport {
+    let source = document.source();
+    let content = document.content();
+    let lines = LineMap::new(content, source.line_number());
+    let file_path = source.file_path().to_string();
+
+    let mut metadata = BTreeMap::new();
+    if let Some(document_metadata) = document.metadata() {
+        for (key, value) in document_metadata.iter() {
+            metadata.insert(key.to_string(), value.to_string());
+        }
+    }
+
+    let word_count = document.word_count();
+    DocumentExport {
+        schema_version: EXPORT_SCHEMA_VERSION,
+        id: document.id().as_str().to_string(),
+        title: document.title().to_string(),
+        source: ExportSource {
+            source_type: if source.is_code_comment() {
+                "code_comment"
+            } else {
+                "markdown_file"
+            },
+            file_path: file_path.clone(),
+            start_line: lines.line_of(0),
+            end_line: lines.end_line_of(0, content.len()),
+        },
+        metadata,
+        preamble: document.is_preamble(),
+        skipped: document.is_skipped(),
+        word_count: word_count.words,
+        reading_time_minutes: word_count.reading_minutes(),
+        nodes: build_nodes(content, &lines, &file_path),
+    }
+}
+
+/// Serialize a document to pretty-printed JSON using the export schema.
+pub fn export_document_json(document: &Document) -> HyperlitResult<String> {
+    serde_json::to_string_pretty(&export_document(document))
+        .map_err(|e| err!("Failed to serialize document '{}': {}", document.id(), e))
+}
+
+/// Build the block node tree from markdown content.
+fn build_nodes(content: &str, lines: &LineMap<'_>, file_path: &str) -> Vec<ExportNode> {
+    // The bottom of the stack collects the top-level nodes
+    let mut stack: Vec<ExportNode> = vec![new_node(ExportNodeType::Paragraph, file_path, 0, 0)];
+    for (event, range) in Parser::new(content).into_offset_iter() {
+        match event {
+            Event::Start(tag) => {
+                let Some(node_type) = block_node_type(&tag) else {
+                    continue;
+                };
+                let mut node = new_node(
+                    node_type,
+                    file_path,
+                    lines.line_of(range.start),
+                    lines.end_line_of(range.start, range.end),
+                );
+                match tag {
+                    Tag::Heading { level, .. } => node.level = Some(heading_level(level)),
+                    Tag::CodeBlock(CodeBlockKind::Fenced(info)) => {
+                        node.language = split_info(&info).0.map(|language| language.to_string());
+                        // Malformed attributes are reported when rendering
+                        node.attributes = CodeBlockAttrs::parse(&info).ok().flatten();
+                    }
+                    _ => {}
+                }
+                stack.push(node);
+            }
+            Event::End(tag_end) => {
+                if !is_block_end(&tag_end) || stack.len() < 2 {
+                    continue;
+                }
+                let node = stack.pop().expect("stack holds at least two nodes");
+                stack
+                    .last_mut()
+                    .expect("stack holds the root node")
+                    .children
+                    .push(node);
+            }
+            Event::Text(text) | Event::Code(text) => append_text(&mut stack, &text),
+            Event::SoftBreak | Event::HardBreak => append_text(&mut stack, " "),
+            Event::Rule => {
+                let node = new_node(
+                    ExportNodeType::ThematicBreak,
+                    file_path,
+                    lines.line_of(range.start),
+                    lines.end_line_of(range.start, range.end),
+                );
+                stack
+                    .last_mut()
+                    .expect("stack holds the root node")
+                    .children
+                    .push(node);
+            }
+            _ => {}
+        }
+    }
+    stack.swap_remove(0).children
+}
+
+fn new_node(
+    node_type: ExportNodeType,
+    file_path: &str,
+    start_line: usize,
+    end_line: usize,
+) -> ExportNode {
+    ExportNode {
+        node_type,
+        file_path: file_path.to_string(),
+        start_line,
+        end_line,
+        level: None,
+        language: None,
+        attributes: None,
+        text: None,
+        children: Vec::new(),
+    }
+}
+
+/// Append text to the innermost node if it is a text-carrying node.
+fn append_text(stack: &mut [ExportNode], text: &str) {
+    let Some(node) = stack.last_mut() else {
+        return;
+    };
+    if matches!(
+        node.node_type,
+        ExportNodeType::Heading
+            | ExportNodeType::Paragraph
+            | ExportNodeType::CodeBlock
+            | ExportNodeType::ListItem
+    ) {
+        node.text.get_or_insert_with(String::new).push_str(text);
+    }
+}
+
+/// Map a start tag to the node type it opens, or None for inline tags.
+fn block_node_type(tag: &Tag) -> Option<ExportNodeType> {
+    Some(match tag {
+        Tag::Heading { .. } => ExportNodeType::Heading,
+        Tag::Paragraph => ExportNodeType::Paragraph,
+        Tag::CodeBlock(_) => ExportNodeType::CodeBlock,
+        Tag::BlockQuote(_) => ExportNodeType::BlockQuote,
+        Tag::List(_) => ExportNodeType::List,
+        Tag::Item => ExportNodeType::ListItem,
+        Tag::HtmlBlock => ExportNodeType::HtmlBlock,
+        _ => return None,
+    })
+}
+
+fn is_block_end(tag_end: &TagEnd) -> bool {
+    matches!(
+        tag_end,
+        TagEnd::Heading(_)
+            | TagEnd::Paragraph
+            | TagEnd::CodeBlock
+            | TagEnd::BlockQuote(_)
+            | TagEnd::List(_)
+            | TagEnd::Item
+            | TagEnd::HtmlBlock
+    )
+}
+
+pub(crate) fn heading_level(level: HeadingLevel) -> u8 {
+    match level {
+        HeadingLevel::H1 => 1,
+        HeadingLevel::H2 => 2,
+        HeadingLevel::H3 => 3,
+        HeadingLevel::H4 => 4,
+        HeadingLevel::H5 => 5,
+        HeadingLevel::H6 => 6,
+    }
+}
+
+/// Maps byte offsets within document content to line numbers in the source file.
+///
+/// Each content line corresponds to exactly one source line, starting at the
+/// document's source line.
+pub(crate) struct LineMap<'a> {
+    content: &'a str,
+    line_starts: Vec<usize>,
+    first_line: usize,
+}
+
+impl<'a> LineMap<'a> {
+    pub(crate) fn new(content: &'a str, first_line: usize) -> Self {
+        let line_starts = std::iter::once(0)
+            .chain(content.match_indices('\n').map(|(index, _)| index + 1))
+            .collect();
+        Self {
+            content,
+            line_starts,
+            first_line,
+        }
+    }
+
+    /// Source line containing the given byte offset.
+    pub(crate) fn line_of(&self, offset: usize) -> usize {
+        let index = self.line_starts.partition_point(|&start| start <= offset);
+        self.first_line + index.saturating_sub(1)
+    }
+
+    /// Last source line of the byte range `start..end`.
+    ///
+    /// Trailing whitespace (e.g. the blank line after a list) is not counted.
+    fn end_line_of(&self, start: usize, end: usize) -> usize {
+        let end = start + self.content[start..end].trim_end().len();
+        if end <= start {
+            self.line_of(start)
+        } else {
+            self.line_of(end - 1)
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentMetadata, DocumentSource, SourceType};
+    use expect_test::expect;
+    use hyperlit_base::FilePath;
+    use std::collections::{HashMap, HashSet};
+
+    fn code_comment_document(content: &str, line_number: usize) -> Document {
+        let source = DocumentSource::new(
+            SourceType::CodeComment,
+            FilePath::from("src/lib.rs"),
+            line_number,
+        );
+        Document::new(
+            "Test".to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    /// Render the node tree compactly as "type lines [text]" per node.
+    fn format_nodes(nodes: &[ExportNode], depth: usize, out: &mut String) {
+        for node in nodes {
+            out.push_str(&format!(
+                "{}{:?} {}-{}",
+                "  ".repeat(depth),
+                node.node_type,
+                node.start_line,
+                node.end_line
+            ));
+            if let Some(level) = node.level {
+                out.push_str(&format!(" h{level}"));
+            }
+            if let Some(language) = &node.language {
+                out.push_str(&format!(" ({language})"));
+            }
+            if let Some(text) = &node.text {
+                out.push_str(&format!(" {text:?}"));
+            }
+            out.push('\n');
+            format_nodes(&node.children, depth + 1, out);
+        }
+    }
+
+    fn export_tree(document: &Document) -> String {
+        let mut out = String::new();
+        format_nodes(&export_document(document).nodes, 0, &mut out);
+        out
+    }
+
+    #[test]
+    fn test_export_line_ranges_are_source_lines() {
+        let doc = code_comment_document(
+            "# Why cache?\n\nCaching avoids\nrepeated work.\n\n```rust\nlet x = 1;\n```\n",
+            10,
+        );
+        expect![[r#"
+            Heading 10-10 h1 "Why cache?"
+            Paragraph 12-13 "Caching avoids repeated work."
+            CodeBlock 15-17 (rust) "let x = 1;\n"
+        "#]]
+        .assert_eq(&export_tree(&doc));
+    }
+
+    #[test]
+    fn test_export_nesting() {
+        let doc = code_comment_document(
+            "Steps:\n\n- first\n- second\n  > quoted\n\n---\n\n<div>raw</div>\n",
+            1,
+        );
+        expect![[r#"
+            Paragraph 1-1 "Steps:"
+            List 3-5
+              ListItem 3-3 "first"
+              ListItem 4-5 "second"
+                BlockQuote 5-5
+                  Paragraph 5-5 "quoted"
+            ThematicBreak 7-7
+            HtmlBlock 9-9
+        "#]]
+        .assert_eq(&export_tree(&doc));
+    }
+
+    #[test]
+    fn test_export_json_schema() {
+        let source = DocumentSource::new(
+            SourceType::MarkdownFile,
+            FilePath::from("docs/design.md"),
+            5,
+        );
+        let metadata = DocumentMetadata::new(HashMap::from([
+            ("author".to_string(), "Ada".to_string()),
+            ("date".to_string(), "2025-01-01".to_string()),
+        ]));
+        let doc = Document::new(
+            "Design".to_string(),
+            "## Design\n\nText\n".to_string(),
+            source,
+            Some(metadata),
+            &HashSet::new(),
+        );
+
+        expect![[r#"
+            {
+              "schemaVersion": 1,
+              "id": "design",
+              "title": "Design",
+              "source": {
+                "type": "markdown_file",
+                "filePath": "docs/design.md",
+                "startLine": 5,
+                "endLine": 7
+              },
+              "metadata": {
+                "author": "Ada",
+                "date": "2025-01-01"
+              },
+              "wordCount": 2,
+              "readingTimeMinutes": 1,
+              "nodes": [
+                {
+                  "type": "heading",
+                  "filePath": "docs/design.md",
+                  "startLine": 5,
+                  "endLine": 5,
+                  "level": 2,
+                  "text": "Design"
+                },
+                {
+                  "type": "paragraph",
+                  "filePath": "docs/design.md",
+                  "startLine": 7,
+                  "endLine": 7,
+                  "text": "Text"
+                }
+              ]
+            }"#]]
+        .assert_eq(&export_document_json(&doc).unwrap());
+    }
+
+    #[test]
+    fn test_export_code_block_attributes() {
+        let doc = code_comment_document(
+            "```go {highlight=2-3 title=\"config.go\" linenos}\na\nb\nc\n```\n",
+            1,
+        );
+        let json = export_document_json(&doc).unwrap();
+        let nodes = &serde_json::from_str::<serde_json::Value>(&json).unwrap()["nodes"];
+        expect![[r#"
+            {
+              "attributes": {
+                "highlight": [
+                  {
+                    "end": 3,
+                    "start": 2
+                  }
+                ],
+                "lineNumbers": true,
+                "title": "config.go"
+              },
+              "endLine": 5,
+              "filePath": "src/lib.rs",
+              "language": "go",
+              "startLine": 1,
+              "text": "a\nb\nc\n",
+              "type": "codeBlock"
+            }"#]]
+        .assert_eq(&serde_json::to_string_pretty(&nodes[0]).unwrap());
+
+        // Malformed attributes are left out, the language is kept
+        let doc = code_comment_document("```go {highlight=x}\na\n```\n", 1);
+        let node = &export_document(&doc).nodes[0];
+        assert_eq!(node.language.as_deref(), Some("go"));
+        assert!(node.attributes.is_none());
+    }
+}
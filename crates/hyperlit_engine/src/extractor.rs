@@ -13,11 +13,36 @@ extracting titles, and creating Document instances with proper metadata.
 */
 
 use std::collections::HashSet;
-use tracing::{instrument, warn};
-
-use hyperlit_base::{FilePath, HyperlitError, HyperlitResult, PalHandle};
-
-use crate::{ByteRange, CommentParser, Document, DocumentMetadata, DocumentSource, SourceType};
+use std::io::Read;
+use std::sync::{Arc, LazyLock};
+
+use regex::Regex;
+use tracing::instrument;
+
+use hyperlit_base::error::ErrorKind;
+use hyperlit_base::{FilePath, HyperlitError, HyperlitResult, PalHandle, ResultExt};
+
+use crate::comment_merge::merge_comments;
+use crate::comment_parser::{ExtractedComment, collect_comments};
+use crate::conditional::check_conditionals;
+use crate::directive::run_directives;
+use crate::exec::{EXEC_DIRECTIVE, ExecDirective};
+use crate::following_code::capture_following_code;
+use crate::front_matter::{find_front_matter, front_matter_metadata};
+use crate::language::parsed_comment_regions;
+use crate::logger::{LogLevel, Logger, NoopLogger};
+use crate::normalize::NormalizedText;
+use crate::parallel::{default_concurrency, parallel_map};
+use crate::parse_error::check_document;
+use crate::skip::take_skip_pragma;
+use crate::word_count::DEFAULT_WORDS_PER_MINUTE;
+use crate::xref::{following_code_line, symbol_from_declaration};
+use crate::{
+    ByteRange, CodeIndentation, CommentParser, Config, DirectiveHandler, DirectiveRegistry,
+    Document, DocumentMetadata, DocumentSource, ExecOptions, FollowingCodeUntil, FrontMatter,
+    LanguageRegistry, LanguageSpec, MarkerConfig, ParseError, ParsedComment, SourceType, WordCount,
+    WordCountOptions,
+};
 
 /// Results from extracting documents from markdown files.
 ///
@@ -38,52 +63,332 @@ pub struct ExtractionError {
     pub file_path: FilePath,
     /// The error that occurred
     pub error: Box<HyperlitError>,
+    /// True if the problem never stops extraction, such as an unknown directive
+    pub warning: bool,
 }
 
-/// Extract documents from a list of file paths.
+impl ExtractionError {
+    /// Returns the parse error if the file contains a malformed doc block.
+    pub fn parse_error(&self) -> Option<&ParseError> {
+        ParseError::find(&self.error)
+    }
+
+    /// Report the problem to `logger`, with its source location.
+    pub(crate) fn log(&self, logger: &dyn Logger) {
+        let level = match self.warning {
+            true => LogLevel::Warn,
+            false => LogLevel::Error,
+        };
+        match self.parse_error() {
+            Some(parse_error) => log_parse_error(logger, level, parse_error),
+            None => logger.log(
+                level,
+                "Failed to extract file",
+                &[("file", &self.file_path), ("error", &self.error)],
+            ),
+        }
+    }
+}
+
+/// Report a parse error to `logger` at `level`, with its source location.
+fn log_parse_error(logger: &dyn Logger, level: LogLevel, parse_error: &ParseError) {
+    logger.log(
+        level,
+        &parse_error.message,
+        &[
+            ("file", &parse_error.file_path),
+            ("line", &parse_error.line),
+            ("column", &parse_error.column),
+        ],
+    );
+}
+
+/// Options controlling how documents are extracted from files.
+#[derive(Debug, Clone, Default)]
+pub struct ExtractionOptions {
+    marker_config: MarkerConfig,
+    language_registry: LanguageRegistry,
+    concurrency: Option<usize>,
+    fail_fast: bool,
+    directive_registry: DirectiveRegistry,
+    fail_on_unknown_directives: bool,
+    following_code_lines: usize,
+    following_code_until: FollowingCodeUntil,
+    merge_comment_gap: usize,
+    capture_gap_code: bool,
+    emit_empty_pages: bool,
+    include_skipped: bool,
+    code_indentation: CodeIndentation,
+    word_count_options: WordCountOptions,
+    logger: Option<Arc<dyn Logger>>,
+}
+
+impl ExtractionOptions {
+    /// Create extraction options with default settings.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Create extraction options from the site configuration.
+    pub fn from_config(config: &Config) -> Self {
+        let mut options = Self::new();
+        if let Some(markers) = &config.markers {
+            options = options.with_marker_config(MarkerConfig::new(markers));
+        }
+        let mut language_registry = LanguageRegistry::default();
+        for (extension, spec) in &config.languages {
+            language_registry.register(extension, spec.clone());
+        }
+        if let Some(spec) = &config.default_language {
+            language_registry = language_registry.with_default_spec(spec.clone());
+        }
+        options = options.with_language_registry(language_registry);
+        if let Some(concurrency) = config.concurrency {
+            options = options.with_concurrency(concurrency);
+        }
+        options
+            .with_fail_fast(config.fail_fast.unwrap_or_default())
+            .with_exec_options(ExecOptions::from_config(config))
+            .with_fail_on_unknown_directives(config.fail_on_unknown_directives.unwrap_or_default())
+            .with_following_code(
+                config.include_following_code.unwrap_or_default(),
+                config.following_code_until.unwrap_or_default(),
+            )
+            .with_comment_merging(
+                config.merge_comment_gap.unwrap_or_default(),
+                config.capture_gap_code.unwrap_or_default(),
+            )
+            .with_emit_empty_pages(config.emit_empty_pages.unwrap_or_default())
+            .with_include_skipped(config.include_skipped.unwrap_or_default())
+            .with_code_indentation(config.code_indentation.unwrap_or_default())
+            .with_word_count_options(
+                WordCountOptions::new()
+                    .with_include_code(config.count_code_words.unwrap_or_default())
+                    .with_words_per_minute(
+                        config.words_per_minute.unwrap_or(DEFAULT_WORDS_PER_MINUTE),
+                    ),
+            )
+    }
+
+    /// Set the markers that identify documentation comments in code files.
+    pub fn with_marker_config(mut self, marker_config: MarkerConfig) -> Self {
+        self.marker_config = marker_config;
+        self
+    }
+
+    /// Set the language specs used to find comments in code files.
+    pub fn with_language_registry(mut self, language_registry: LanguageRegistry) -> Self {
+        self.language_registry = language_registry;
+        self
+    }
+
+    /// Set the number of files extracted in parallel.
+    ///
+    /// Defaults to the number of CPU cores, a concurrency of 1 extracts all
+    /// files on the calling thread.
+    pub fn with_concurrency(mut self, concurrency: usize) -> Self {
+        self.concurrency = Some(concurrency);
+        self
+    }
+
+    /// Stop at the first malformed doc block (such as an unterminated code block).
+    ///
+    /// By default all parse errors are reported in [`ExtractionResult::errors`]
+    /// and the affected documents are kept. With `fail_fast`, extraction fails
+    /// with the first [`ParseError`] instead.
+    pub fn with_fail_fast(mut self, fail_fast: bool) -> Self {
+        self.fail_fast = fail_fast;
+        self
+    }
+
+    /// Set whether and how commands of `{{exec: command}}` directives are run.
+    ///
+    /// Registers the built-in exec directive with these options. Command
+    /// execution is disabled by default, see [`crate::exec`].
+    pub fn with_exec_options(mut self, exec_options: ExecOptions) -> Self {
+        self.directive_registry
+            .register(EXEC_DIRECTIVE, ExecDirective::new(exec_options));
+        self
+    }
+
+    /// Set the handlers of `{{name arguments}}` directives.
+    ///
+    /// Defaults to the built-in `include` and `exec` directives, see [`crate::directive`].
+    pub fn with_directive_registry(mut self, directive_registry: DirectiveRegistry) -> Self {
+        self.directive_registry = directive_registry;
+        self
+    }
+
+    /// Register the handler of the directive `{{name ...}}`, replacing any previous one.
+    pub fn with_directive(
+        mut self,
+        name: impl Into<String>,
+        handler: impl DirectiveHandler + 'static,
+    ) -> Self {
+        self.directive_registry.register(name, handler);
+        self
+    }
+
+    /// Report directives without a handler as parse errors rather than warnings.
+    pub fn with_fail_on_unknown_directives(mut self, fail_on_unknown_directives: bool) -> Self {
+        self.fail_on_unknown_directives = fail_on_unknown_directives;
+        self
+    }
+
+    /// Capture up to `lines` lines of the code following each doc comment, ending as set by `until`.
+    ///
+    /// Disabled by default (0 lines), see [`crate::following_code`].
+    pub fn with_following_code(mut self, lines: usize, until: FollowingCodeUntil) -> Self {
+        self.following_code_lines = lines;
+        self.following_code_until = until;
+        self
+    }
+
+    /// Merge doc comments separated by at most `max_gap_lines` lines of code,
+    /// keeping that code as a code block if `capture_code` is set.
+    ///
+    /// Disabled by default (0 lines), see [`crate::comment_merge`].
+    pub fn with_comment_merging(mut self, max_gap_lines: usize, capture_code: bool) -> Self {
+        self.merge_comment_gap = max_gap_lines;
+        self.capture_gap_code = capture_code;
+        self
+    }
+
+    /// Set how the indentation of captured code is shown (defaults to [`CodeIndentation::Dedent`]).
+    ///
+    /// See [`crate::following_code`].
+    pub fn with_code_indentation(mut self, code_indentation: CodeIndentation) -> Self {
+        self.code_indentation = code_indentation;
+        self
+    }
+
+    /// Extract an empty stub document from code files without doc comments, so
+    /// they get a page of their own.
+    ///
+    /// Disabled by default, see [`crate::extractor`].
+    pub fn with_emit_empty_pages(mut self, emit_empty_pages: bool) -> Self {
+        self.emit_empty_pages = emit_empty_pages;
+        self
+    }
+
+    /// Extract doc comments disabled with a skip pragma anyway, marked as skipped.
+    ///
+    /// Disabled by default, see [`crate::skip`].
+    pub fn with_include_skipped(mut self, include_skipped: bool) -> Self {
+        self.include_skipped = include_skipped;
+        self
+    }
+
+    /// Set how the words of documents are counted for their reading time.
+    ///
+    /// See [`crate::word_count`].
+    pub fn with_word_count_options(mut self, word_count_options: WordCountOptions) -> Self {
+        self.word_count_options = word_count_options;
+        self
+    }
+
+    /// Report problems to `logger` as they are found, instead of discarding them.
+    ///
+    /// See [`crate::logger`].
+    pub fn with_logger(mut self, logger: impl Logger + 'static) -> Self {
+        self.logger = Some(Arc::new(logger));
+        self
+    }
+
+    /// Returns the number of files extracted in parallel.
+    pub fn concurrency(&self) -> usize {
+        self.concurrency.unwrap_or_else(default_concurrency)
+    }
+
+    /// Returns the logger problems are reported to.
+    pub fn logger(&self) -> &dyn Logger {
+        self.logger.as_deref().unwrap_or(&NoopLogger)
+    }
+}
+
+/// Extract documents from a list of file paths using default options.
 ///
 /// This function reads each file, determines its type, and extracts documentation.
 /// For markdown files, it parses YAML frontmatter (if present) and extracts the title.
 /// For code files, it extracts comments marked with the 📖 emoji.
 ///
-/// Extraction is fail-tolerant: if a file fails to extract, the error is collected
-/// and extraction continues with remaining files.
-#[instrument(skip(pal, files), fields(file_count = files.len()))]
+/// Extraction is fail-tolerant: if a file fails to extract or contains malformed
+/// doc blocks, the errors are collected and extraction continues with remaining files.
 pub fn extract_documents(pal: &PalHandle, files: &[FilePath]) -> HyperlitResult<ExtractionResult> {
+    extract_documents_with_options(pal, files, &ExtractionOptions::default())
+}
+
+/// Extract documents from a list of file paths.
+///
+/// Behaves like [`extract_documents`], but code comments are recognized using the
+/// markers and language specs configured in `options`.
+///
+/// Files are extracted in parallel (see [`ExtractionOptions::with_concurrency`]).
+/// The result does not depend on the concurrency: documents are returned in the
+/// order of `files`, IDs are assigned in that order and the errors of all files
+/// are collected.
+///
+/// # Errors
+/// Returns the first [`ParseError`] (in file order) if
+/// [`ExtractionOptions::with_fail_fast`] is set.
+#[instrument(skip(pal, files, options), fields(file_count = files.len()))]
+pub fn extract_documents_with_options(
+    pal: &PalHandle,
+    files: &[FilePath],
+    options: &ExtractionOptions,
+) -> HyperlitResult<ExtractionResult> {
     let mut documents = Vec::new();
     let mut errors = Vec::new();
     let mut existing_ids = HashSet::new();
-    let comment_parser = CommentParser::new();
-
-    for file_path in files {
-        // Determine file type by extension
-        let extension = file_path
-            .as_path()
-            .extension()
-            .and_then(|ext| ext.to_str())
-            .unwrap_or("");
-
-        let extraction_result = if extension == "md" {
-            // Markdown file
-            extract_markdown_document(pal, file_path, &existing_ids).map(|doc| vec![doc])
-        } else {
-            // Code file - try to extract comments
-            extract_code_comments(pal, file_path, &comment_parser, &existing_ids)
-        };
+    let comment_parser = CommentParser::with_marker_config(options.marker_config.clone())
+        .with_language_registry(options.language_registry.clone());
+
+    let extraction_results = parallel_map(files, options.concurrency(), |file_path| {
+        extract_file(pal, file_path, &comment_parser, options)
+    });
 
+    for (file_path, extraction_result) in files.iter().zip(extraction_results) {
         match extraction_result {
-            Ok(docs) => {
-                for doc in docs {
+            Ok((docs, parse_errors, warnings)) => {
+                if let Some(parse_error) = parse_errors.first()
+                    && options.fail_fast
+                {
+                    log_parse_error(options.logger(), LogLevel::Error, parse_error);
+                    return Err(parse_error.clone().into());
+                }
+                options.logger().debug(
+                    "Extracted documents",
+                    &[("file", file_path), ("documents", &docs.len())],
+                );
+                let problems = parse_errors
+                    .into_iter()
+                    .map(|parse_error| (parse_error, false))
+                    .chain(warnings.into_iter().map(|warning| (warning, true)));
+                for (parse_error, warning) in problems {
+                    let error = ExtractionError {
+                        file_path: file_path.clone(),
+                        error: parse_error.into(),
+                        warning,
+                    };
+                    error.log(options.logger());
+                    errors.push(error);
+                }
+                // IDs depend on all previous documents, so they are assigned
+                // here in file order rather than by the workers
+                for mut doc in docs {
+                    doc.assign_unique_id(&existing_ids);
                     existing_ids.insert(doc.id().as_str().to_string());
                     documents.push(doc);
                 }
             }
             Err(e) => {
-                warn!("failed to extract {}: {}", file_path, e);
-                errors.push(ExtractionError {
+                let error = ExtractionError {
                     file_path: file_path.clone(),
                     error: e,
-                });
+                    warning: false,
+                };
+                error.log(options.logger());
+                errors.push(error);
             }
         }
     }
@@ -91,46 +396,370 @@ pub fn extract_documents(pal: &PalHandle, files: &[FilePath]) -> HyperlitResult<
     Ok(ExtractionResult { documents, errors })
 }
 
-/// Extract code comments with 📖 markers from a code file.
+/// Extract documents from source code read from `reader`, e.g. a file in an archive.
 ///
-/// This function:
-/// 1. Reads the file content
-/// 2. Uses the comment parser to find comments with 📖 markers
-/// 3. Extracts the markdown title and content from each marked comment
-/// 4. Creates Document instances for each extracted comment
-fn extract_code_comments(
+/// Performs the same extraction as [`extract_documents_with_options`] does for
+/// a code file, but reads the code from any reader and finds comments using
+/// `spec`, since there is no file extension to infer the language from. The
+/// `file_path` identifies the source in the documents and errors, it is not read.
+///
+/// Without a file system, directives such as `{{include path}}` are left as
+/// they are.
+///
+/// # Errors
+/// Returns an error if the reader fails or does not yield UTF-8, and the first
+/// [`ParseError`] if [`ExtractionOptions::with_fail_fast`] is set.
+///
+/// # Examples
+/// ```
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::{ExtractionOptions, LanguageSpec, extract_reader};
+///
+/// let code = "-- 📖 # Why a view?\n-- Keeps queries short.\nCREATE VIEW totals AS SELECT 1;\n";
+/// let spec = LanguageSpec::new().with_line_comment("--");
+/// let result = extract_reader(
+///     code.as_bytes(),
+///     &FilePath::from("schema.zip/totals.sql"),
+///     &spec,
+///     &ExtractionOptions::new(),
+/// )
+/// .unwrap();
+/// assert_eq!(result.documents[0].title(), "Why a view?");
+/// ```
+pub fn extract_reader(
+    mut reader: impl Read,
+    file_path: &FilePath,
+    spec: &LanguageSpec,
+    options: &ExtractionOptions,
+) -> HyperlitResult<ExtractionResult> {
+    let mut bytes = Vec::new();
+    reader.read_to_end(&mut bytes).map_err(|e| {
+        Box::new(HyperlitError::new(ErrorKind::FileError {
+            path: file_path.as_path().to_path_buf(),
+            source: e,
+        }))
+    })?;
+    let content = String::from_utf8(bytes)
+        .map_err(|_e| hyperlit_base::err!("File is not valid UTF-8: {}", file_path))?;
+
+    let source = NormalizedText::new(&content);
+    let comment_parser = CommentParser::with_marker_config(options.marker_config.clone());
+    let extracted_comments = comment_parser.extract_with_spec(source.content(), spec);
+    check_code_comments(file_path, &source, extracted_comments, options)
+}
+
+/* 📖 # Why accept comments found by another parser?
+
+Tools that already parse source files, such as linters or code generators built
+on a full parser, know exactly where each comment is. Handing those comments to
+hyperlit avoids lexing the file a second time, and the comments are located
+exactly as the parser sees them, even for syntax hyperlit's lexer only
+approximates (nested block comments, raw strings).
+
+A `ParsedComment` gives the byte range of a comment and of its text without the
+delimiters. Everything else works as for a comment found by hyperlit: markers,
+merging of consecutive comments (only separated by whitespace), titles, front
+matter and following code. The content is needed as well, for the source lines
+of documents and the code between comments. Comments without a marker may be
+passed or left out, they become no documents either way.
+*/
+
+/// Extract documents from the comments of `content` found by another parser.
+///
+/// `comments` must be sorted by position, see [`crate::extractor`] and
+/// [`extract_reader`] for the extraction itself. The `file_path` identifies the
+/// source in the documents and errors, it is not read.
+///
+/// # Errors
+/// Returns an error if a comment is not within the content or out of order,
+/// and the first [`ParseError`] if [`ExtractionOptions::with_fail_fast`] is set.
+///
+/// # Examples
+/// ```
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::{ExtractionOptions, ParsedComment, extract_parsed_comments};
+///
+/// let code = "// 📖 # Why a pool?\nstruct Pool;\n";
+/// let comment = ParsedComment {
+///     range: 0..code.find('\n').unwrap(),
+///     text: 2..code.find('\n').unwrap(),
+///     block: false,
+/// };
+/// let result = extract_parsed_comments(
+///     &FilePath::from("src/pool.rs"),
+///     code,
+///     &[comment],
+///     &ExtractionOptions::new(),
+/// )
+/// .unwrap();
+/// assert_eq!(result.documents[0].title(), "Why a pool?");
+/// ```
+pub fn extract_parsed_comments(
+    file_path: &FilePath,
+    content: &str,
+    comments: &[ParsedComment],
+    options: &ExtractionOptions,
+) -> HyperlitResult<ExtractionResult> {
+    let source = NormalizedText::new(content);
+    let comments: Vec<ParsedComment> = comments
+        .iter()
+        .map(|comment| ParsedComment {
+            range: source.normalized_offset(comment.range.start)
+                ..source.normalized_offset(comment.range.end),
+            text: source.normalized_offset(comment.text.start)
+                ..source.normalized_offset(comment.text.end),
+            block: comment.block,
+        })
+        .collect();
+    let regions = parsed_comment_regions(source.content(), &comments)
+        .with_context(|| format!("Failed to extract comments of {}", file_path))?;
+    let extracted_comments = collect_comments(&options.marker_config, &regions);
+    check_code_comments(file_path, &source, extracted_comments, options)
+}
+
+/// Create and check the documents of the comments extracted from a code file.
+///
+/// Directives are not expanded, there is no file system to resolve them against.
+fn check_code_comments(
+    file_path: &FilePath,
+    source: &NormalizedText,
+    extracted_comments: Vec<ExtractedComment>,
+    options: &ExtractionOptions,
+) -> HyperlitResult<ExtractionResult> {
+    let content = source.content();
+    let (documents, front_matter_error) =
+        extract_code_comments(file_path, content, extracted_comments, options);
+    let documents: Vec<Document> = documents
+        .into_iter()
+        .map(|document| {
+            let word_count = WordCount::of(&document, &options.word_count_options);
+            restore_byte_range(document.with_word_count(word_count), source)
+        })
+        .collect();
+    let mut parse_errors: Vec<ParseError> = front_matter_error.into_iter().collect();
+    for document in &documents {
+        parse_errors.extend(check_document(document, content));
+        parse_errors.extend(check_conditionals(document, content));
+    }
+    if let Some(parse_error) = parse_errors.first()
+        && options.fail_fast
+    {
+        log_parse_error(options.logger(), LogLevel::Error, parse_error);
+        return Err(parse_error.clone().into());
+    }
+    let errors = parse_errors
+        .into_iter()
+        .map(|parse_error| {
+            let error = ExtractionError {
+                file_path: file_path.clone(),
+                error: parse_error.into(),
+                warning: false,
+            };
+            error.log(options.logger());
+            error
+        })
+        .collect();
+    Ok(ExtractionResult { documents, errors })
+}
+
+/// Extract and check the documents of a single file, based on its extension.
+///
+/// The directives of the documents are expanded as well. Returns the documents,
+/// their parse errors and the warnings about unknown directives.
+///
+/// The IDs of the returned documents are only unique within the file.
+fn extract_file(
     pal: &PalHandle,
     file_path: &FilePath,
     comment_parser: &CommentParser,
-    existing_ids: &HashSet<String>,
-) -> HyperlitResult<Vec<Document>> {
+    options: &ExtractionOptions,
+) -> HyperlitResult<(Vec<Document>, Vec<ParseError>, Vec<ParseError>)> {
     // Read file content
-    let content = pal.read_file_to_string(file_path)?;
+    let file_content = pal.read_file_to_string(file_path)?;
+    let source = NormalizedText::new(&file_content);
+    let content = source.content();
 
-    // Get file extension
+    // Determine file type by extension
     let extension = file_path
         .as_path()
         .extension()
         .and_then(|ext| ext.to_str())
         .unwrap_or("");
 
-    // Extract comments with emoji marker
-    let extracted_comments = comment_parser.extract_doc_comments(&content, extension)?;
+    let mut parse_errors = Vec::new();
+    let mut warnings = Vec::new();
+    let documents = if extension == "md" {
+        // Markdown file, rendered without its front matter if that is malformed
+        let (document, front_matter_warning) = extract_markdown_document(file_path, content)?;
+        warnings.extend(front_matter_warning);
+        vec![document]
+    } else {
+        // Code file - try to extract comments
+        let extracted_comments = comment_parser.extract_doc_comments(content, extension)?;
+        let (documents, front_matter_error) =
+            extract_code_comments(file_path, content, extracted_comments, options);
+        parse_errors.extend(front_matter_error);
+        documents
+    };
+    let mut checked = Vec::with_capacity(documents.len());
+    for document in documents {
+        parse_errors.extend(check_document(&document, content));
+        parse_errors.extend(check_conditionals(&document, content));
+        let (document, directive_errors, directive_warnings) = run_directives(
+            pal,
+            &options.directive_registry,
+            options.fail_on_unknown_directives,
+            document,
+            content,
+        );
+        parse_errors.extend(directive_errors);
+        warnings.extend(directive_warnings);
+        let word_count = WordCount::of(&document, &options.word_count_options);
+        checked.push(restore_byte_range(
+            document.with_word_count(word_count),
+            &source,
+        ));
+    }
+    Ok((checked, parse_errors, warnings))
+}
+
+/// Map the byte range of a document extracted from normalized text back to the original file.
+fn restore_byte_range(document: Document, source: &NormalizedText) -> Document {
+    let Some(range) = document.source().byte_range().copied() else {
+        return document;
+    };
+    let byte_range = ByteRange::new(
+        source.original_offset(range.start()),
+        source.original_offset(range.end()),
+    );
+    let document_source = document.source().clone().with_byte_range(byte_range);
+    document.with_source(document_source)
+}
+
+/* 📖 # Why recognize file preambles?
+
+Many files start with a comment describing the whole file or module, rather
+than the code right below it:
+
+```text
+// 📖 # HTTP server
+// Serves the API and the web UI.
+
+package server
+```
+
+Such a doc comment is a preamble: it is the introduction of the page, gives the
+page its title and is marked so it can be styled differently. A doc comment is
+a preamble if there is no code before it and it is not attached to the code
+after it, i.e. it is followed by a blank line, a package declaration or the end
+of the file. Preambles describe no symbol and show no following code. A doc
+comment directly above the first function of a file is not a preamble, so it
+is extracted exactly as before.
+*/
+
+static PACKAGE_DECLARATION: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"^package\s+[\w.]+;?$").expect("valid regex"));
+
+/// Returns true if a doc comment is the preamble of its file.
+fn is_preamble(content: &str, comment: &ExtractedComment) -> bool {
+    if !comment.before_code {
+        return false;
+    }
+    // Skip the rest of the line containing the comment end (e.g. a closing `*/`)
+    let mut rest = content.get(comment.end_byte..).unwrap_or_default();
+    if comment.end_byte > 0 && !content[..comment.end_byte].ends_with('\n') {
+        rest = rest
+            .split_once('\n')
+            .map(|(_, rest)| rest)
+            .unwrap_or_default();
+    }
+    match rest.lines().next().map(str::trim) {
+        None | Some("") => true,
+        Some(line) => PACKAGE_DECLARATION.is_match(line),
+    }
+}
+
+/// Create documents from the comments with 📖 markers found in a code file.
+///
+/// This function:
+/// 1. Takes the comments with 📖 markers found by the comment parser, merging
+///    those separated by short gaps of code if enabled in `options`, and leaves
+///    out those disabled with a skip pragma unless enabled in `options`
+/// 2. Splits off the front matter at the top of the first comment (if present)
+/// 3. Extracts the markdown title and content from each marked comment
+/// 4. Captures the code following each comment, if enabled in `options`,
+///    except for the file preamble
+/// 5. Creates Document instances for each extracted comment
+///
+/// Returns the documents and the parse error for malformed front matter, if any.
+fn extract_code_comments(
+    file_path: &FilePath,
+    content: &str,
+    mut extracted_comments: Vec<ExtractedComment>,
+    options: &ExtractionOptions,
+) -> (Vec<Document>, Option<ParseError>) {
+    for comment in &mut extracted_comments {
+        comment.skipped = take_skip_pragma(comment);
+    }
+    let extracted_comments = merge_comments(
+        file_path,
+        content,
+        extracted_comments,
+        options.merge_comment_gap,
+        options.capture_gap_code,
+        options.code_indentation,
+    );
+    let next_comment_bytes: Vec<Option<usize>> = extracted_comments
+        .iter()
+        .skip(1)
+        .map(|comment| Some(comment.start_byte))
+        .chain([None])
+        .collect();
 
     // Convert extracted comments to documents
     let mut documents = Vec::new();
     let mut id_counter = HashSet::new();
+    let mut parse_error = None;
 
-    for comment in extracted_comments {
-        // Extract title from the comment content
-        let title = extract_first_heading(&comment.content).unwrap_or_else(|| {
-            comment
-                .content
-                .lines()
-                .next()
-                .unwrap_or("Untitled")
-                .to_string()
-        });
+    for (index, (mut comment, next_comment_byte)) in extracted_comments
+        .into_iter()
+        .zip(next_comment_bytes)
+        .enumerate()
+    {
+        // Only the first comment of a file may start with front matter
+        let mut front_matter = None;
+        if index == 0 {
+            let (parsed, body_start, error) =
+                split_front_matter(file_path, content, &comment.content, comment.start_line);
+            comment.start_line += comment.content[..body_start].matches('\n').count();
+            comment.content.drain(..body_start);
+            front_matter = parsed;
+            parse_error = error;
+        }
+        // A marker without any text documents nothing
+        if comment.content.trim().is_empty() && front_matter.is_none()
+            || comment.skipped && !options.include_skipped
+        {
+            continue;
+        }
+        let metadata = front_matter.as_ref().and_then(front_matter_metadata);
+
+        // Extract title from the front matter or the comment content
+        let title = metadata
+            .as_ref()
+            .and_then(|metadata| metadata.get("title"))
+            .map(str::to_string)
+            .or_else(|| extract_first_heading(&comment.content))
+            .unwrap_or_else(|| {
+                comment
+                    .content
+                    .lines()
+                    .next()
+                    .unwrap_or("Untitled")
+                    .to_string()
+            });
+
+        let preamble = is_preamble(content, &comment);
 
         // Create document source with code comment type
         let byte_range = ByteRange::new(comment.start_byte, comment.end_byte);
@@ -141,38 +770,87 @@ fn extract_code_comments(
         )
         .with_byte_range(byte_range);
 
-        // Combine existing IDs with those found in this file
-        let all_ids = existing_ids.clone();
-        let combined_ids: HashSet<_> = all_ids.iter().chain(id_counter.iter()).cloned().collect();
-
-        // Create document with collision handling
-        let doc = Document::new(title, comment.content, source, None, &combined_ids);
+        // Create document with collision handling among the comments of this file
+        let mut doc = Document::new(title, comment.content, source, metadata, &id_counter)
+            .with_preamble(preamble)
+            .with_skipped(comment.skipped);
+        if let Some(front_matter) = front_matter {
+            doc = doc.with_front_matter(front_matter);
+        }
+        if let Some(symbol) = following_code_line(content, comment.end_byte)
+            .and_then(symbol_from_declaration)
+            .filter(|_| !preamble)
+        {
+            doc = doc.with_symbol(symbol);
+        }
+        if let Some(following_code) = capture_following_code(
+            file_path,
+            content,
+            comment.end_byte,
+            next_comment_byte,
+            options.following_code_lines,
+            options.following_code_until,
+            options.code_indentation,
+        ) && !preamble
+        {
+            doc = doc.with_following_code(following_code);
+        }
 
         id_counter.insert(doc.id().as_str().to_string());
         documents.push(doc);
     }
 
-    Ok(documents)
+    if documents.is_empty() && options.emit_empty_pages {
+        documents.push(empty_page_document(file_path));
+    }
+    (documents, parse_error)
+}
+
+/* 📖 # What happens to files without doc comments?
+
+Most files of a package usually have no marked comments at all. Such a file
+produces no documents, so it gets no page and is not mentioned in the table of
+contents: it is skipped without an error or warning. A comment consisting of a
+marker only, without any text after it, documents nothing either and is skipped
+the same way, rather than becoming an empty, untitled document.
+
+With `emit_empty_pages = true` every code file without documents gets an empty
+stub page instead, e.g. so the site lists every file of the package. The stub is
+a preamble titled by the source path, with no content. Markdown files always
+produce a document, whether they are empty or not.
+*/
+
+/// Returns the empty stub document of a code file without doc comments.
+fn empty_page_document(file_path: &FilePath) -> Document {
+    let source = DocumentSource::new(SourceType::CodeComment, file_path.clone(), 1);
+    Document::new(
+        file_path.to_string(),
+        String::new(),
+        source,
+        None,
+        &HashSet::new(),
+    )
+    .with_preamble(true)
 }
 
 /// Extract a single markdown document from a file.
 ///
 /// This function:
-/// 1. Reads the file content
-/// 2. Parses YAML frontmatter (if present)
-/// 3. Extracts the title (from frontmatter or first # heading)
-/// 4. Creates a Document with appropriate metadata
+/// 1. Parses YAML frontmatter (if present)
+/// 2. Extracts the title (from frontmatter or first # heading)
+/// 3. Creates a Document with appropriate metadata
+///
+/// Returns the document and, for malformed frontmatter, the warning about it.
+/// Such frontmatter is left out of the document, as it always has been.
 fn extract_markdown_document(
-    pal: &PalHandle,
     file_path: &FilePath,
-    existing_ids: &HashSet<String>,
-) -> HyperlitResult<Document> {
-    // Read file content
-    let content = pal.read_file_to_string(file_path)?;
-
+    content: &str,
+) -> HyperlitResult<(Document, Option<ParseError>)> {
     // Parse frontmatter
-    let (metadata, content_without_frontmatter, frontmatter_end_byte) =
-        parse_frontmatter(&content)?;
+    let (front_matter, frontmatter_end_byte, warning) =
+        split_front_matter(file_path, content, content, 1);
+    let content_without_frontmatter = &content[frontmatter_end_byte..];
+    let metadata = front_matter.as_ref().and_then(front_matter_metadata);
 
     // Extract title
     let title = extract_title(content_without_frontmatter, &metadata, file_path)?;
@@ -180,121 +858,51 @@ fn extract_markdown_document(
     // Calculate byte range (excluding frontmatter)
     let byte_range = ByteRange::new(frontmatter_end_byte, content.len());
 
+    // Content starts on the line following the frontmatter
+    let start_line = content[..frontmatter_end_byte].matches('\n').count() + 1;
+
     // Create document source
-    let source = DocumentSource::new(SourceType::MarkdownFile, file_path.clone(), 1)
+    let source = DocumentSource::new(SourceType::MarkdownFile, file_path.clone(), start_line)
         .with_byte_range(byte_range);
 
     // Create document
-    let doc = Document::new(
+    let mut doc = Document::new(
         title,
         content_without_frontmatter.to_string(),
         source,
         metadata,
-        existing_ids,
+        &HashSet::new(),
     );
+    if let Some(front_matter) = front_matter {
+        doc = doc.with_front_matter(front_matter);
+    }
 
-    Ok(doc)
+    Ok((doc, warning))
 }
 
-/// Parse YAML frontmatter from markdown content.
+/// Split a front matter block off the top of `content`, see [`crate::front_matter`].
 ///
-/// Returns (metadata, content_without_frontmatter, frontmatter_end_byte).
-/// If no frontmatter exists, returns empty metadata and full content.
-fn parse_frontmatter(content: &str) -> HyperlitResult<(Option<DocumentMetadata>, &str, usize)> {
-    // Check if content starts with YAML frontmatter (---)
-    if !content.starts_with("---") {
-        return Ok((None, content, 0));
-    }
-
-    // Find the closing --- delimiter
-    let lines: Vec<&str> = content.lines().collect();
-
-    // Must have at least opening ---, content line, closing ---
-    if lines.len() < 2 {
-        return Ok((None, content, 0));
-    }
-
-    // Look for closing --- on second or later line
-    let mut closing_index = None;
-    for (i, line) in lines.iter().enumerate().skip(1) {
-        if line.trim() == "---" {
-            closing_index = Some(i);
-            break;
-        }
-    }
-
-    let closing_idx = match closing_index {
-        Some(idx) => idx,
-        None => {
-            // No closing --- found, treat as no frontmatter
-            return Ok((None, content, 0));
-        }
-    };
-
-    // Extract frontmatter YAML content (between the --- delimiters)
-    let frontmatter_str = lines[1..closing_idx].join("\n");
-
-    // Parse YAML
-    let metadata = if frontmatter_str.trim().is_empty() {
-        None
-    } else {
-        match serde_yaml::from_str::<serde_yaml::Value>(&frontmatter_str) {
-            Ok(value) => {
-                if let serde_yaml::Value::Mapping(mapping) = value {
-                    let mut fields = std::collections::HashMap::new();
-
-                    for (key, val) in mapping {
-                        if let serde_yaml::Value::String(k) = key {
-                            let v = match val {
-                                serde_yaml::Value::String(s) => s,
-                                serde_yaml::Value::Number(n) => n.to_string(),
-                                serde_yaml::Value::Bool(b) => b.to_string(),
-                                _ => continue,
-                            };
-                            fields.insert(k, v);
-                        }
-                    }
-
-                    if fields.is_empty() {
-                        None
-                    } else {
-                        Some(DocumentMetadata::new(fields))
-                    }
-                } else {
-                    None
-                }
-            }
-            Err(_) => None,
-        }
+/// `content` starts on line `start_line` of `source` (the full content of the file).
+/// Returns the front matter, the byte offset of the content following it and,
+/// if the front matter is malformed, the parse error. Without a front matter
+/// block the offset is 0.
+fn split_front_matter(
+    file_path: &FilePath,
+    source: &str,
+    content: &str,
+    start_line: usize,
+) -> (Option<FrontMatter>, usize, Option<ParseError>) {
+    let Some(block) = find_front_matter(content) else {
+        return (None, 0, None);
     };
-
-    // Calculate frontmatter end byte position
-    // We need to account for newlines between lines
-    let mut frontmatter_end = 0;
-    for (i, line) in lines.iter().enumerate().take(closing_idx + 1) {
-        frontmatter_end += line.len();
-        if i < closing_idx {
-            frontmatter_end += 1; // newline character
-        }
+    match block.front_matter {
+        Ok(front_matter) => (Some(front_matter), block.body_start, None),
+        Err(e) => (
+            None,
+            block.body_start,
+            Some(e.to_parse_error(file_path, source, content, start_line)),
+        ),
     }
-
-    // Account for newline after closing ---
-    if closing_idx + 1 < lines.len() {
-        frontmatter_end += 1;
-    }
-
-    // Get content after frontmatter
-    let content_without_frontmatter = if closing_idx + 1 < lines.len() {
-        lines[closing_idx + 1..].join("\n")
-    } else {
-        String::new()
-    };
-
-    Ok((
-        metadata,
-        Box::leak(content_without_frontmatter.into_boxed_str()),
-        frontmatter_end,
-    ))
 }
 
 /// Extract title from markdown content or metadata.
@@ -357,6 +965,7 @@ fn extract_first_heading(content: &str) -> Option<String> {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::logger::tests::RecordingLogger;
     use hyperlit_base::pal::MockPal;
 
     #[test]
@@ -541,28 +1150,108 @@ mod tests {
 
         // Content should not include frontmatter
         assert!(!doc.content().contains("---"));
+
+        // Line number points at the first line after the frontmatter
+        assert_eq!(doc.source().line_number(), 4);
     }
 
     #[test]
-    fn test_parse_frontmatter_empty() {
+    fn test_split_front_matter_empty() {
         let content = "# No frontmatter\n\nContent.";
-        let (metadata, content_without, frontmatter_end) = parse_frontmatter(content).unwrap();
+        let (front_matter, frontmatter_end, parse_error) =
+            split_front_matter(&FilePath::from("test.md"), content, content, 1);
 
-        assert!(metadata.is_none());
-        assert_eq!(content_without, content);
+        assert!(front_matter.is_none());
         assert_eq!(frontmatter_end, 0);
+        assert!(parse_error.is_none());
     }
 
     #[test]
-    fn test_parse_frontmatter_with_data() {
+    fn test_split_front_matter_with_data() {
         let content = "---\nkey: value\n---\n\nContent.";
-        let (metadata, content_without, _) = parse_frontmatter(content).unwrap();
+        let (front_matter, frontmatter_end, parse_error) =
+            split_front_matter(&FilePath::from("test.md"), content, content, 1);
 
-        assert!(metadata.is_some());
-        let meta = metadata.unwrap();
+        let meta = front_matter_metadata(&front_matter.unwrap()).unwrap();
         assert_eq!(meta.get("key"), Some("value"));
-        assert!(!content_without.contains("---"));
-        assert!(content_without.contains("Content."));
+        assert_eq!(&content[frontmatter_end..], "\nContent.");
+        assert!(parse_error.is_none());
+    }
+
+    #[test]
+    fn test_extract_markdown_with_malformed_frontmatter() {
+        let mock_pal = MockPal::new();
+        let content = "---\ntitle: Broken\n- item\n---\n# Heading\n";
+        mock_pal.add_file(
+            FilePath::from("docs/broken.md"),
+            content.as_bytes().to_vec(),
+        );
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("docs/broken.md")];
+
+        // Markdown files are rendered without their malformed front matter,
+        // even when failing fast
+        let options = ExtractionOptions::new().with_fail_fast(true);
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        assert_eq!(result.errors.len(), 1);
+        assert!(result.errors[0].warning);
+        let parse_error = result.errors[0].parse_error().unwrap();
+        assert_eq!(parse_error.file_path, FilePath::from("docs/broken.md"));
+        assert_eq!((parse_error.line, parse_error.column), (3, 1));
+        assert!(
+            parse_error
+                .message
+                .starts_with("front matter is not well-formed YAML")
+        );
+        assert_eq!(result.documents[0].title(), "Heading");
+        assert_eq!(result.documents[0].content(), "# Heading\n");
+        assert!(result.documents[0].front_matter().is_none());
+    }
+
+    #[test]
+    fn test_extract_markdown_with_non_mapping_frontmatter() {
+        let mock_pal = MockPal::new();
+        let content = "---\njust text\n---\n# Heading\n";
+        mock_pal.add_file(FilePath::from("notes.md"), content.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+
+        let result = extract_documents(&pal, &[FilePath::from("notes.md")]).unwrap();
+        assert_eq!(result.errors.len(), 1);
+        assert!(result.errors[0].warning);
+        assert_eq!(
+            result.errors[0].parse_error().unwrap().message,
+            "front matter must be a mapping of keys to values"
+        );
+        assert_eq!(result.documents[0].content(), "# Heading\n");
+    }
+
+    #[test]
+    fn test_extract_logs_problems() {
+        let mock_pal = MockPal::new();
+        let code = "// 📖 # Config\n// {{include missing.toml}}\nfn load() {}\n";
+        mock_pal.add_file(FilePath::from("src/config.rs"), code.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("src/config.rs")];
+        let logger = RecordingLogger::default();
+
+        let options = ExtractionOptions::new().with_logger(logger.clone());
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        assert_eq!(result.errors.len(), 1);
+        assert_eq!(
+            logger.entries(),
+            [
+                "debug: Extracted documents file=src/config.rs documents=1",
+                "error: included file 'src/missing.toml' not found file=src/config.rs line=2 column=4",
+            ]
+        );
+
+        // With fail_fast the error stopping extraction is logged as well
+        let logger = RecordingLogger::default();
+        let options = ExtractionOptions::new()
+            .with_fail_fast(true)
+            .with_logger(logger.clone());
+        assert!(extract_documents_with_options(&pal, &files, &options).is_err());
+        assert_eq!(logger.entries().len(), 1);
     }
 
     #[test]
@@ -724,6 +1413,70 @@ mod tests {
         assert_eq!(doc.title(), "Arc is thread-safe reference counting");
         assert!(doc.content().contains("Arc"));
         assert_eq!(doc.source().line_number(), 1);
+        assert_eq!(doc.symbol(), None);
+    }
+
+    #[test]
+    fn test_extract_code_comment_front_matter() {
+        let mock_pal = MockPal::new();
+        let content = "// 📖 ---\n// title: Parser internals\n// tags: [parsing, internals]\n// ---\n// # How parsing works\n//\n// Tokens first.\nfn parse() {}\n\n// 📖 ---\n// Not front matter\nfn other() {}\n";
+        mock_pal.add_file(FilePath::from("src/parser.rs"), content.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+
+        let result = extract_documents(&pal, &[FilePath::from("src/parser.rs")]).unwrap();
+
+        let doc = &result.documents[0];
+        assert_eq!(doc.title(), "Parser internals");
+        assert_eq!(doc.content(), "# How parsing works\n\nTokens first.\n");
+        assert_eq!(doc.source().line_number(), 5);
+        let front_matter = doc.front_matter().unwrap();
+        assert_eq!(
+            front_matter.get("tags"),
+            Some(&serde_yaml::Value::Sequence(vec![
+                serde_yaml::Value::String("parsing".to_string()),
+                serde_yaml::Value::String("internals".to_string()),
+            ]))
+        );
+        assert_eq!(
+            doc.metadata().unwrap().get("title"),
+            Some("Parser internals")
+        );
+
+        // Only the first doc comment of a file can have front matter
+        let second = &result.documents[1];
+        assert!(second.front_matter().is_none());
+        assert!(second.content().starts_with("---\n"));
+    }
+
+    #[test]
+    fn test_extract_code_comment_without_front_matter_is_unchanged() {
+        let mock_pal = MockPal::new();
+        let content = "// 📖 # Why parse?\n// Because.\nfn parse() {}\n";
+        mock_pal.add_file(FilePath::from("src/parser.rs"), content.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+
+        let result = extract_documents(&pal, &[FilePath::from("src/parser.rs")]).unwrap();
+
+        let doc = &result.documents[0];
+        assert_eq!(doc.title(), "Why parse?");
+        assert_eq!(doc.content(), "# Why parse?\nBecause.\n");
+        assert_eq!(doc.source().line_number(), 1);
+        assert!(doc.front_matter().is_none());
+        assert!(doc.metadata().is_none());
+    }
+
+    #[test]
+    fn test_extract_code_comment_malformed_front_matter() {
+        let mock_pal = MockPal::new();
+        let content =
+            "fn a() {}\n\n// 📖 ---\n// title: Broken\n// - item\n// ---\n// # Doc\nfn b() {}\n";
+        mock_pal.add_file(FilePath::from("src/lib.rs"), content.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+
+        let result = extract_documents(&pal, &[FilePath::from("src/lib.rs")]).unwrap();
+
+        let parse_error = result.errors[0].parse_error().unwrap();
+        assert_eq!((parse_error.line, parse_error.column), (5, 4));
     }
 
     #[test]
@@ -744,7 +1497,29 @@ mod tests {
         let doc = &result.documents[0];
 
         // Verify extraction from JavaScript file (TypeScript-like)
-        assert_eq!(doc.source().is_code_comment(), true);
+        assert!(doc.source().is_code_comment());
+        assert_eq!(doc.title(), "Type checking with JSDoc");
+        assert_eq!(doc.source().line_number(), 1);
+    }
+
+    #[test]
+    fn test_extract_typescript_file_comment() {
+        let mock_pal = MockPal::new();
+
+        // syntect has no TypeScript syntax, so the built-in language spec is used
+        let ts_code = "// 📖 # Type checking with JSDoc\nfunction isString(x: unknown): x is string { return typeof x === 'string'; }";
+
+        mock_pal.add_file(FilePath::from("guards.ts"), ts_code.as_bytes().to_vec());
+
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("guards.ts")];
+        let result = extract_documents(&pal, &files).unwrap();
+
+        assert_eq!(result.documents.len(), 1);
+        let doc = &result.documents[0];
+
+        // Verify extraction from TypeScript file
+        assert!(doc.source().is_code_comment());
         assert_eq!(doc.title(), "Type checking with JSDoc");
         assert_eq!(doc.source().line_number(), 1);
     }
@@ -799,6 +1574,424 @@ mod tests {
         // Both should be code comments
         assert!(result.documents[0].source().is_code_comment());
         assert!(result.documents[1].source().is_code_comment());
+
+        // The declarations following the comments become their symbols
+        assert_eq!(result.documents[0].symbol(), Some("first"));
+        assert_eq!(result.documents[1].symbol(), Some("second"));
+    }
+
+    #[test]
+    fn test_extract_file_preamble() {
+        let mock_pal = MockPal::new();
+        let files = [
+            (
+                "a.go",
+                "// 📖 # Server\n// Serves the API.\n\npackage server\n\n// 📖 # Start\nfunc Start() {}\n",
+            ),
+            ("b.go", "// 📖 # Server\npackage server\n"),
+            ("c.rs", "// 📖 # Greeting\nfn greet() {}\n"),
+            (
+                "d.rs",
+                "use std::fmt;\n\n// 📖 # Greeting\n\nfn greet() {}\n",
+            ),
+            ("e.sh", "# 📖 # Why log to a file\n\nLOG_FILE=backup.log\n"),
+        ];
+        for (path, content) in files {
+            mock_pal.add_file(FilePath::from(path), content.as_bytes().to_vec());
+        }
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let paths: Vec<FilePath> = files
+            .iter()
+            .map(|(path, _)| FilePath::from(*path))
+            .collect();
+
+        let options =
+            ExtractionOptions::new().with_following_code(10, FollowingCodeUntil::BlankLine);
+        let result = extract_documents_with_options(&pal, &paths, &options).unwrap();
+        assert!(result.errors.is_empty(), "{:?}", result.errors);
+        let preambles: Vec<(String, &str, bool, Option<&str>)> = result
+            .documents
+            .iter()
+            .map(|document| {
+                (
+                    document.source().file_path().to_string(),
+                    document.title(),
+                    document.is_preamble(),
+                    document.symbol(),
+                )
+            })
+            .collect();
+        assert_eq!(
+            preambles,
+            [
+                ("a.go".to_string(), "Server", true, None),
+                ("a.go".to_string(), "Start", false, Some("Start")),
+                ("b.go".to_string(), "Server", true, None),
+                ("c.rs".to_string(), "Greeting", false, Some("greet")),
+                ("d.rs".to_string(), "Greeting", false, Some("greet")),
+                ("e.sh".to_string(), "Why log to a file", true, None),
+            ]
+        );
+        // Preambles show no following code
+        assert_eq!(result.documents[0].following_code(), None);
+        assert!(result.documents[1].following_code().is_some());
+    }
+
+    #[test]
+    fn test_extract_code_comments_merged_across_code() {
+        let mock_pal = MockPal::new();
+        let rust_code = "fn main() {}\n\n// 📖 # Retries\n// Retried three times,\nlet retries = 3;\n// 📖 with growing delays.\nfn retry() {}\n";
+        mock_pal.add_file(FilePath::from("retry.rs"), rust_code.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("retry.rs")];
+
+        let result = extract_documents(&pal, &files).unwrap();
+        assert_eq!(result.documents.len(), 2);
+
+        let options = ExtractionOptions::new().with_comment_merging(1, false);
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        assert_eq!(result.documents.len(), 1);
+        let doc = &result.documents[0];
+        assert_eq!(doc.title(), "Retries");
+        assert_eq!(
+            doc.content(),
+            "# Retries\nRetried three times,\nwith growing delays.\n"
+        );
+        // The symbol is the one following the merged comment
+        assert_eq!(doc.symbol(), Some("retry"));
+    }
+
+    #[test]
+    fn test_extract_crlf_and_bom_files() {
+        let code = "// 📖 # Retries\n// Requests are retried.\nfn retry() {}\n\n/* 📖 # Limits\n * At most three.\n */\nconst LIMIT: u32 = 3;\n";
+        let markdown = "---\ntitle: Design\n---\n\n# Design\n\n```text\nline\n```\n";
+        let mock_pal = MockPal::new();
+        for (extension, content) in [("rs", code), ("md", markdown)] {
+            let crlf = content.replace('\n', "\r\n");
+            mock_pal.add_file(
+                FilePath::from(format!("lf/a.{extension}")),
+                content.as_bytes().to_vec(),
+            );
+            mock_pal.add_file(
+                FilePath::from(format!("crlf/a.{extension}")),
+                crlf.as_bytes().to_vec(),
+            );
+            mock_pal.add_file(
+                FilePath::from(format!("bom/a.{extension}")),
+                format!("\u{feff}{crlf}").into_bytes(),
+            );
+        }
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let extract = |directory: &str| {
+            let files = vec![
+                FilePath::from(format!("{directory}/a.rs")),
+                FilePath::from(format!("{directory}/a.md")),
+            ];
+            let result = extract_documents(&pal, &files).unwrap();
+            assert!(result.errors.is_empty(), "{:?}", result.errors);
+            result.documents
+        };
+        let exports = |documents: &[Document]| -> Vec<String> {
+            documents
+                .iter()
+                .map(|document| {
+                    let json = document.to_json().unwrap();
+                    json.replace(&document.source().file_path().to_string(), "a")
+                })
+                .collect()
+        };
+        let lf = extract("lf");
+        assert_eq!(lf.len(), 3);
+        for directory in ["crlf", "bom"] {
+            let documents = extract(directory);
+            assert_eq!(exports(&documents), exports(&lf), "{directory}");
+            assert_eq!(documents[0].title(), "Retries");
+            assert_eq!(documents[1].source().line_number(), 5);
+        }
+
+        // Byte ranges are offsets into the file as it is
+        let content = format!("\u{feff}{}", code.replace('\n', "\r\n"));
+        let documents = extract("bom");
+        let range = documents[0].source().byte_range().unwrap();
+        assert!(content[range.start()..].starts_with("# Retries\r\n"));
+        assert!(content[..range.end()].ends_with("retried.\r\n"));
+    }
+
+    #[test]
+    fn test_extract_reader_with_bom() {
+        let code = "\u{feff}-- 📖 # Why a view?\r\n-- Keeps queries short.\r\nCREATE VIEW totals AS SELECT 1;\r\n";
+        let spec = LanguageSpec::new().with_line_comment("--");
+        let result = extract_reader(
+            code.as_bytes(),
+            &FilePath::from("totals.sql"),
+            &spec,
+            &ExtractionOptions::new(),
+        )
+        .unwrap();
+        assert_eq!(result.documents[0].title(), "Why a view?");
+        assert_eq!(
+            result.documents[0].content(),
+            "# Why a view?\nKeeps queries short.\n"
+        );
+    }
+
+    #[test]
+    fn test_extract_counts_words() {
+        let mock_pal = MockPal::new();
+        let code = "// 📖 # Retries\n// Requests are retried.\n// ```\n// let retries = 3;\n// ```\nfn retry() {}\n";
+        mock_pal.add_file(FilePath::from("retry.rs"), code.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("retry.rs")];
+
+        let result = extract_documents(&pal, &files).unwrap();
+        let word_count = result.documents[0].word_count();
+        assert_eq!(word_count.words, 4);
+        assert_eq!(word_count.reading_minutes(), 1);
+
+        let options = ExtractionOptions::new().with_word_count_options(
+            WordCountOptions::new()
+                .with_include_code(true)
+                .with_words_per_minute(1),
+        );
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        let word_count = result.documents[0].word_count();
+        assert_eq!(word_count.words, 7);
+        assert_eq!(word_count.reading_minutes(), 7);
+    }
+
+    #[test]
+    fn test_extract_skipped_comments() {
+        let mock_pal = MockPal::new();
+        let code = "// 📖 # Uploads\n// Files are uploaded at once.\nfn upload() {}\n\n// 📖 !skip # Streaming uploads\n// Large files are uploaded in chunks.\nfn stream() {}\n\n/* 📖\n{{skip}}\n# Resumable uploads\n*/\nfn resume() {}\n";
+        mock_pal.add_file(FilePath::from("upload.rs"), code.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("upload.rs")];
+        let summary = |documents: &[Document]| -> Vec<(String, usize, bool, String)> {
+            documents
+                .iter()
+                .map(|document| {
+                    (
+                        document.title().to_string(),
+                        document.source().line_number(),
+                        document.is_skipped(),
+                        document.content().to_string(),
+                    )
+                })
+                .collect()
+        };
+        let uploads = (
+            "Uploads".to_string(),
+            1,
+            false,
+            "# Uploads\nFiles are uploaded at once.\n".to_string(),
+        );
+
+        let result = extract_documents(&pal, &files).unwrap();
+        assert!(result.errors.is_empty(), "{:?}", result.errors);
+        assert_eq!(summary(&result.documents), std::slice::from_ref(&uploads));
+
+        // Skipped comments are never merged with their neighbours
+        let options = ExtractionOptions::new()
+            .with_include_skipped(true)
+            .with_comment_merging(5, false);
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        assert!(result.errors.is_empty(), "{:?}", result.errors);
+        assert_eq!(
+            summary(&result.documents),
+            [
+                uploads,
+                (
+                    "Streaming uploads".to_string(),
+                    5,
+                    true,
+                    "# Streaming uploads\nLarge files are uploaded in chunks.\n".to_string()
+                ),
+                (
+                    "Resumable uploads".to_string(),
+                    10,
+                    true,
+                    "# Resumable uploads\n".to_string()
+                ),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_extract_files_without_doc_comments() {
+        let mock_pal = MockPal::new();
+        // Only a marker, without any text
+        let marker_only = "// 📖\nfn util() {}\n";
+        mock_pal.add_file(FilePath::from("marker.rs"), marker_only.as_bytes().to_vec());
+        mock_pal.add_file(FilePath::from("plain.rs"), b"fn main() {}\n".to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("marker.rs"), FilePath::from("plain.rs")];
+
+        let result = extract_documents(&pal, &files).unwrap();
+        assert!(result.documents.is_empty());
+        assert!(result.errors.is_empty(), "{:?}", result.errors);
+
+        let options = ExtractionOptions::new().with_emit_empty_pages(true);
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        assert!(result.errors.is_empty(), "{:?}", result.errors);
+        let stubs: Vec<(&str, &str, bool)> = result
+            .documents
+            .iter()
+            .map(|document| (document.title(), document.content(), document.is_preamble()))
+            .collect();
+        assert_eq!(stubs, [("marker.rs", "", true), ("plain.rs", "", true)]);
+
+        let rendered = crate::render_site(&result.documents, &crate::RenderOptions::new());
+        assert!(rendered.warnings.is_empty(), "{:?}", rendered.warnings);
+        let page = rendered
+            .files
+            .iter()
+            .find(|file| file.path == FilePath::from("marker.rs.html"))
+            .unwrap();
+        assert!(page.content.contains(
+            "<article class=\"document preamble\">\n<p class=\"source\">marker.rs:1</p>\n</article>"
+        ));
+    }
+
+    #[test]
+    fn test_extract_code_comment_with_following_code() {
+        let mock_pal = MockPal::new();
+        // Code before the comment, a comment at the top of the file would be its preamble
+        let rust_code = "use std::fmt;\n\n// 📖 # Greeting\n\nfn greet(name: &str) -> String {\n    format!(\"Hello, {}!\", name)\n}\n\nfn main() {}\n";
+        mock_pal.add_file(FilePath::from("greet.rs"), rust_code.as_bytes().to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("greet.rs")];
+
+        let options =
+            ExtractionOptions::new().with_following_code(10, FollowingCodeUntil::BlankLine);
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        let following_code = result.documents[0].following_code().unwrap();
+        assert_eq!(
+            following_code.code,
+            "fn greet(name: &str) -> String {\n    format!(\"Hello, {}!\", name)\n}\n"
+        );
+        assert_eq!(following_code.start_line, 5);
+
+        // Disabled by default
+        let result = extract_documents(&pal, &files).unwrap();
+        assert_eq!(result.documents[0].following_code(), None);
+    }
+
+    #[test]
+    fn test_extract_reader() {
+        let code = "%% 📖 # Why retry?\n%% Networks fail.\nretry(F) -> F().\n\n%% 📖 # Why retry?\nretry_twice(F) -> F().\n";
+        let spec = LanguageSpec::new().with_line_comment("%%");
+
+        let result = extract_reader(
+            code.as_bytes(),
+            &FilePath::from("archive.zip/src/retry.erl"),
+            &spec,
+            &ExtractionOptions::new(),
+        )
+        .unwrap();
+
+        assert_eq!(result.documents.len(), 2);
+        assert!(result.errors.is_empty());
+        let doc = &result.documents[0];
+        assert_eq!(doc.title(), "Why retry?");
+        assert_eq!(doc.content(), "# Why retry?\nNetworks fail.\n");
+        assert_eq!(
+            doc.source().file_path(),
+            &FilePath::from("archive.zip/src/retry.erl")
+        );
+        assert_eq!(result.documents[1].id().as_str(), "why-retry-1");
+        assert_eq!(result.documents[1].source().line_number(), 5);
+    }
+
+    #[test]
+    fn test_extract_reader_reports_parse_errors() {
+        let code = "# 📖 # A\n# ```text\n# open\nx = 1\n";
+        let spec = LanguageSpec::new().with_line_comment("#");
+        let file_path = FilePath::from("blob/a.conf");
+
+        let options = ExtractionOptions::new().with_fail_fast(true);
+        let error = extract_reader(code.as_bytes(), &file_path, &spec, &options).unwrap_err();
+        assert!(ParseError::find(&error).is_some());
+
+        let options = ExtractionOptions::new();
+        let result = extract_reader(code.as_bytes(), &file_path, &spec, &options).unwrap();
+        assert_eq!(result.documents.len(), 1);
+        assert_eq!(result.errors.len(), 1);
+        assert_eq!(result.errors[0].parse_error().unwrap().line, 2);
+    }
+
+    #[test]
+    fn test_extract_parsed_comments() {
+        let content = "fn a() {}\n\n/* 📖 # Pools\n   Reused connections. */\nstruct Pool;\n\n// 📖 # Limits\n// At most 8.\nconst MAX: usize = 8;\n";
+        let comment = |text: &str, delimiters: (&str, &str)| {
+            let start = content.find(text).unwrap();
+            let end = start + text.len();
+            ParsedComment {
+                range: start - delimiters.0.len()..end + delimiters.1.len(),
+                text: start..end,
+                block: !delimiters.1.is_empty(),
+            }
+        };
+        let comments = [
+            comment(" 📖 # Pools\n   Reused connections. ", ("/*", "*/")),
+            comment(" 📖 # Limits", ("//", "")),
+            comment(" At most 8.", ("//", "")),
+        ];
+        let result = extract_parsed_comments(
+            &FilePath::from("src/pool.rs"),
+            content,
+            &comments,
+            &ExtractionOptions::new(),
+        )
+        .unwrap();
+        let documents: Vec<(&str, &str, usize, Option<&str>)> = result
+            .documents
+            .iter()
+            .map(|doc| {
+                (
+                    doc.title(),
+                    doc.content(),
+                    doc.source().line_number(),
+                    doc.symbol(),
+                )
+            })
+            .collect();
+        assert_eq!(
+            documents,
+            [
+                ("Pools", "# Pools\nReused connections. ", 3, Some("Pool")),
+                ("Limits", "# Limits\nAt most 8.\n", 7, Some("MAX")),
+            ]
+        );
+
+        // Comments must be sorted
+        let error = extract_parsed_comments(
+            &FilePath::from("src/pool.rs"),
+            content,
+            &[comments[1].clone(), comments[0].clone()],
+            &ExtractionOptions::new(),
+        )
+        .unwrap_err();
+        assert!(
+            error
+                .to_string()
+                .contains("Failed to extract comments of src/pool.rs")
+        );
+    }
+
+    #[test]
+    fn test_extract_reader_non_utf8() {
+        let result = extract_reader(
+            &[0xff, 0xfe][..],
+            &FilePath::from("blob"),
+            &LanguageSpec::new(),
+            &ExtractionOptions::new(),
+        );
+        assert!(
+            result
+                .unwrap_err()
+                .to_string()
+                .contains("File is not valid UTF-8: blob")
+        );
     }
 
     #[test]
@@ -826,4 +2019,170 @@ mod tests {
         assert_eq!(id1, "design-pattern");
         assert_eq!(id2, "design-pattern-1");
     }
+
+    #[test]
+    fn test_extract_result_is_independent_of_concurrency() {
+        let mock_pal = MockPal::new();
+        let mut files = Vec::new();
+        for i in 0..20 {
+            let path = FilePath::from(format!("src/file{i}.rs"));
+            let content =
+                format!("// 📖 # Shared\nfn a{i}() {{}}\n// 📖 # Shared\nfn b{i}() {{}}\n");
+            mock_pal.add_file(path.clone(), content.into_bytes());
+            files.push(path);
+        }
+        files.push(FilePath::from("missing.md"));
+        files.push(FilePath::from("docs/shared.md"));
+        mock_pal.add_file(FilePath::from("docs/shared.md"), b"# Shared\n".to_vec());
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+
+        let extract = |concurrency| {
+            let options = ExtractionOptions::new().with_concurrency(concurrency);
+            extract_documents_with_options(&pal, &files, &options).unwrap()
+        };
+        let sequential = extract(1);
+        let parallel = extract(8);
+
+        assert_eq!(sequential.documents, parallel.documents);
+        assert_eq!(sequential.documents.len(), 41);
+        assert_eq!(sequential.documents[0].id().as_str(), "shared");
+        assert_eq!(sequential.documents[3].id().as_str(), "shared-3");
+        assert_eq!(sequential.documents[40].id().as_str(), "shared-40");
+        let failed: Vec<_> = parallel
+            .errors
+            .iter()
+            .map(|e| e.file_path.clone())
+            .collect();
+        assert_eq!(failed, vec![FilePath::from("missing.md")]);
+    }
+
+    fn add_files_with_unterminated_code_blocks(mock_pal: &MockPal) -> Vec<FilePath> {
+        mock_pal.add_file(
+            FilePath::from("a.rs"),
+            "// 📖 # A\n// ```rust\n// let a = 1;\nfn a() {}\n"
+                .as_bytes()
+                .to_vec(),
+        );
+        mock_pal.add_file(
+            FilePath::from("b.md"),
+            b"# B\n\nText\n\n~~~\ncode\n".to_vec(),
+        );
+        mock_pal.add_file(FilePath::from("c.md"), b"# C\n".to_vec());
+        vec![
+            FilePath::from("a.rs"),
+            FilePath::from("b.md"),
+            FilePath::from("c.md"),
+        ]
+    }
+
+    #[test]
+    fn test_extract_fail_fast_stops_at_first_parse_error() {
+        let mock_pal = MockPal::new();
+        let files = add_files_with_unterminated_code_blocks(&mock_pal);
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+
+        let options = ExtractionOptions::new().with_fail_fast(true);
+        let error = extract_documents_with_options(&pal, &files, &options).unwrap_err();
+        let parse_error = ParseError::find(&error).unwrap();
+        assert_eq!(parse_error.file_path, FilePath::from("a.rs"));
+        assert_eq!((parse_error.line, parse_error.column), (2, 4));
+        assert_eq!(
+            error.to_string(),
+            "a.rs:2:4: unterminated code block started at line 2"
+        );
+    }
+
+    #[test]
+    fn test_extract_collects_all_parse_errors() {
+        let mock_pal = MockPal::new();
+        let files = add_files_with_unterminated_code_blocks(&mock_pal);
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+
+        let result = extract_documents(&pal, &files).unwrap();
+
+        assert_eq!(result.documents.len(), 3);
+        let errors: Vec<String> = result
+            .errors
+            .iter()
+            .map(|error| error.parse_error().unwrap().to_string())
+            .collect();
+        assert_eq!(
+            errors,
+            [
+                "a.rs:2:4: unterminated code block started at line 2",
+                "b.md:5:1: unterminated code block started at line 5"
+            ]
+        );
+    }
+
+    /// Expands `{{version}}` to a fixed version.
+    #[derive(Debug)]
+    struct Version;
+
+    impl DirectiveHandler for Version {
+        fn expand(
+            &self,
+            _arguments: &str,
+            _context: &mut crate::DirectiveContext,
+        ) -> HyperlitResult<String> {
+            Ok("Version 1.2".to_string())
+        }
+    }
+
+    #[test]
+    fn test_extract_with_custom_directive() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(
+            FilePath::from("guide.md"),
+            b"# Guide\n\n{{version}}\n\n{{verison}}\n".to_vec(),
+        );
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let files = vec![FilePath::from("guide.md")];
+
+        // Unknown directives are warnings, extraction goes on
+        let options = ExtractionOptions::new().with_directive("version", Version);
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        assert_eq!(
+            result.documents[0]
+                .expansion("version", "")
+                .map(|expansion| expansion.markdown.as_str()),
+            Some("Version 1.2")
+        );
+        let errors: Vec<String> = result
+            .errors
+            .iter()
+            .map(|error| error.parse_error().unwrap().to_string())
+            .collect();
+        assert_eq!(errors, ["guide.md:5:1: unknown directive 'verison'"]);
+        assert!(result.errors[0].warning);
+
+        let options = options.with_fail_on_unknown_directives(true);
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+        assert!(!result.errors[0].warning);
+        let options = options.with_fail_fast(true);
+        let error = extract_documents_with_options(&pal, &files, &options).unwrap_err();
+        assert_eq!(
+            error.to_string(),
+            "guide.md:5:1: unknown directive 'verison'"
+        );
+    }
+
+    #[test]
+    fn test_extract_code_comment_with_configured_markers() {
+        let mock_pal = MockPal::new();
+        let content = "// doc: Custom Marker\nfn foo() {}\n// 📖 Default marker\nfn bar() {}";
+        mock_pal.add_file(FilePath::from("src/lib.rs"), content.as_bytes().to_vec());
+
+        let pal = hyperlit_base::PalHandle::new(mock_pal);
+        let config = Config {
+            markers: Some(vec!["doc:".to_string()]),
+            ..Default::default()
+        };
+        let options = ExtractionOptions::from_config(&config);
+        let files = vec![FilePath::from("src/lib.rs")];
+        let result = extract_documents_with_options(&pal, &files, &options).unwrap();
+
+        assert_eq!(result.documents.len(), 1);
+        assert_eq!(result.documents[0].title(), "Custom Marker");
+    }
 }
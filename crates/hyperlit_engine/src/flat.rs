@@ -0,0 +1,191 @@
+/* 📖 # Why a flat output layout with a manifest?
+
+Docs are often shipped inside a binary, e.g. with Go's `embed.FS` or Rust's
+`include_dir!`, and served from there. The default layout mirrors the source
+tree, so a server has to know that tree or walk the embedded files to find a
+page. With `OutputMode::Flat` all pages are written next to `index.html`, and
+an `index.json` manifest lists every page with its slug, title, output path and
+source file, so a server can map request paths to files with one lookup:
+
+```json
+{
+  "version": 1,
+  "pages": [
+    { "slug": "index", "title": "My Project", "path": "index.html", "source": null },
+    { "slug": "src-lib-rs-3f0a6c21", "title": "HTTP server", "path": "src-lib-rs-3f0a6c21.html", "source": "src/lib.rs" }
+  ]
+}
+```
+
+Page names must work on every file system the embedded docs end up on. A page
+name is the lowercased source path with every character other than ASCII
+letters and digits replaced by a dash, so there are no colons, backslashes or
+other characters Windows rejects, and no names differing only in case, which
+would collide on case-insensitive file systems. Lowercasing and replacing
+characters can map different paths to the same name (`src/Lib.rs` and
+`src/lib-rs`), so a short hash of the source path is appended. Names only
+depend on the source path, so links to a page stay valid across builds, and
+long paths are shortened to stay far below path length limits.
+
+Pages in the flat layout are no longer next to the source files, so local images
+are embedded as `data:` URIs, as in `OutputMode::SingleFile`.
+*/
+
+use serde::Serialize;
+
+use hyperlit_base::FilePath;
+
+use crate::RenderedFile;
+use crate::cache::ContentHasher;
+
+/// Name of the manifest listing all pages of a flat site.
+pub const MANIFEST_FILE: &str = "index.json";
+
+/// Version of the manifest format, incremented on incompatible changes.
+pub const MANIFEST_VERSION: u32 = 1;
+
+/// Maximum length of the slug derived from the source path, without the hash.
+const MAX_SLUG_LENGTH: usize = 64;
+
+/// Returns the slug of the page for a source file in the flat layout.
+///
+/// # Examples
+/// ```
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::flat_page_slug;
+///
+/// let slug = flat_page_slug(&FilePath::from("src/Server/mod.rs"));
+/// assert!(slug.starts_with("src-server-mod-rs-"));
+/// ```
+pub fn flat_page_slug(source_path: &FilePath) -> String {
+    let source = source_path.to_string();
+    let mut slug = String::with_capacity(source.len());
+    for c in source.chars() {
+        if c.is_ascii_alphanumeric() {
+            slug.push(c.to_ascii_lowercase());
+        } else if !slug.is_empty() && !slug.ends_with('-') {
+            slug.push('-');
+        }
+    }
+    slug.truncate(MAX_SLUG_LENGTH);
+    let slug = slug.trim_end_matches('-');
+    let mut hasher = ContentHasher::new();
+    hasher.write_str(&source);
+    // 32 bits are plenty to tell apart the few paths with the same slug
+    let hash = hasher.finish() as u32;
+    match slug.is_empty() {
+        true => format!("{hash:08x}"),
+        false => format!("{slug}-{hash:08x}"),
+    }
+}
+
+/// One page listed in the manifest.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct ManifestPage {
+    slug: String,
+    title: String,
+    path: String,
+    /// Source file of the page, None for the table of contents
+    source: Option<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct Manifest {
+    version: u32,
+    pages: Vec<ManifestPage>,
+}
+
+/// A page of a flat site listed in the manifest: its source file (None for
+/// the table of contents), output path and title.
+pub(crate) type PageEntry = (Option<FilePath>, FilePath, String);
+
+/// Render the manifest of a flat site, listing `pages` in the given order.
+pub(crate) fn render_manifest(pages: Vec<PageEntry>) -> RenderedFile {
+    let pages = pages
+        .into_iter()
+        .map(|(source_path, path, title)| {
+            let path = path.to_string();
+            let slug = path
+                .rsplit_once('.')
+                .map_or(path.as_str(), |(slug, _)| slug)
+                .to_string();
+            ManifestPage {
+                slug,
+                title,
+                path,
+                source: source_path.map(|source_path| source_path.to_string()),
+            }
+        })
+        .collect();
+    let manifest = Manifest {
+        version: MANIFEST_VERSION,
+        pages,
+    };
+    let mut content = serde_json::to_string_pretty(&manifest).expect("manifest serializes");
+    content.push('\n');
+    RenderedFile {
+        path: FilePath::from(MANIFEST_FILE),
+        content,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use expect_test::expect;
+
+    #[test]
+    fn test_flat_page_slug() {
+        let slug = |path: &str| flat_page_slug(&FilePath::from(path));
+        let lib = slug("src/lib.rs");
+        assert_eq!(lib.len(), "src-lib-rs-".len() + 8);
+        assert!(lib.starts_with("src-lib-rs-"));
+        // Stable across calls, unique for paths with the same slug
+        assert_eq!(slug("src/lib.rs"), lib);
+        assert_ne!(slug("src/Lib.rs"), lib);
+        assert_ne!(slug("src/lib-rs"), lib);
+        assert!(slug("docs/Über: notes.md").starts_with("docs-ber-notes-md-"));
+        assert_eq!(slug("😀").len(), 8);
+        let long = slug(&format!("{}/lib.rs", "nested/".repeat(20)));
+        assert!(long.len() <= MAX_SLUG_LENGTH + 9);
+        for slug in [lib, long] {
+            assert!(
+                slug.chars()
+                    .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-')
+            );
+        }
+    }
+
+    #[test]
+    fn test_render_manifest() {
+        let manifest = render_manifest(vec![
+            (None, FilePath::from("index.html"), "Docs".to_string()),
+            (
+                Some(FilePath::from("src/server.rs")),
+                FilePath::from("src-server-rs-0123abcd.html"),
+                "HTTP server".to_string(),
+            ),
+        ]);
+        assert_eq!(manifest.path, FilePath::from("index.json"));
+        expect![[r#"
+            {
+              "version": 1,
+              "pages": [
+                {
+                  "slug": "index",
+                  "title": "Docs",
+                  "path": "index.html",
+                  "source": null
+                },
+                {
+                  "slug": "src-server-rs-0123abcd",
+                  "title": "HTTP server",
+                  "path": "src-server-rs-0123abcd.html",
+                  "source": "src/server.rs"
+                }
+              ]
+            }
+        "#]]
+        .assert_eq(&manifest.content);
+    }
+}
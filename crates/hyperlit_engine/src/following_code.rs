@@ -0,0 +1,313 @@
+/* 📖 # Why show the code following a doc comment?
+
+A doc comment usually explains the code right below it, yet the rendered page
+only shows the prose. Readers then have to jump to the source to see what is
+being explained. With `include_following_code = N` the first N lines of code
+following each doc comment are captured during extraction and rendered as a
+syntax-highlighted code block right under the documentation:
+
+```text
+// 📖 # Greeting
+// Greets by name.
+
+fn greet(name: &str) -> String {   <- shown under "Greeting"
+    format!("Hello, {}!", name)
+}
+```
+
+Blank lines directly after the comment are skipped. By default the code ends at
+the next blank line, which usually is the end of the documented item. With
+`following_code_until = "doc-comment"` it extends up to the next doc comment
+instead, for items with blank lines inside. Either way at most N lines are
+shown, so a long function does not swamp its documentation.
+
+The code keeps its relative indentation, with the leading whitespace common to
+all lines removed, so a method documented inside an `impl` block does not start
+deep in the page. With `code_indentation = "preserve"` the code is shown with
+its indentation in the file instead, e.g. for languages where the absolute
+indentation matters. Tabs and spaces are never mixed up: only whitespace that
+is identical on all lines is removed, so code indented with tabs on some lines
+and spaces on others keeps its indentation. The language is inferred from the
+file extension, like for included files.
+
+Capturing is disabled by default (N = 0), existing sites render unchanged.
+*/
+
+use std::ops::Range;
+
+use pulldown_cmark::{CodeBlockKind, Event, Tag, TagEnd};
+use serde::Deserialize;
+
+use hyperlit_base::FilePath;
+
+use crate::Document;
+use crate::comment_parser::dedent;
+use crate::include::extension_language;
+
+/// Where the code captured after a doc comment ends.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum FollowingCodeUntil {
+    /// The code ends at the first blank line
+    #[default]
+    BlankLine,
+    /// The code ends at the next doc comment (or the end of the file)
+    DocComment,
+}
+
+/// How the indentation of captured code is shown, see [`crate::following_code`].
+///
+/// Applies to the code following doc comments and the code between merged doc
+/// comments.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum CodeIndentation {
+    /// The leading whitespace common to all lines is removed
+    #[default]
+    Dedent,
+    /// The code keeps its indentation in the file
+    Preserve,
+}
+
+impl CodeIndentation {
+    /// Apply the indentation mode to captured code.
+    pub(crate) fn apply(self, code: &str) -> String {
+        match self {
+            CodeIndentation::Dedent => dedent(code),
+            CodeIndentation::Preserve => code.to_string(),
+        }
+    }
+}
+
+/// The source code following a doc comment, see [`crate::following_code`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct FollowingCode {
+    /// The code, ending with a newline, with common leading whitespace removed
+    /// unless indentation is preserved
+    pub code: String,
+    /// Line of the first line of code in the file (1-indexed)
+    pub start_line: usize,
+    /// Language for syntax highlighting, based on the file extension
+    pub language: Option<String>,
+}
+
+/// Capture up to `max_lines` lines of code following a doc comment.
+///
+/// The comment ends at `end_byte` in `content`, the next doc comment (if any)
+/// starts at `next_comment_byte`. Returns None if there is no code to show.
+pub(crate) fn capture_following_code(
+    file_path: &FilePath,
+    content: &str,
+    end_byte: usize,
+    next_comment_byte: Option<usize>,
+    max_lines: usize,
+    until: FollowingCodeUntil,
+    indentation: CodeIndentation,
+) -> Option<FollowingCode> {
+    if max_lines == 0 {
+        return None;
+    }
+    // Start at the line following the one the comment ends on
+    let mut offset = end_byte.min(content.len());
+    if offset > 0 && !content[..offset].ends_with('\n') {
+        offset += content[offset..].find('\n')? + 1;
+    }
+    let end = next_comment_byte
+        .map_or(content.len(), |next| {
+            content[..next].rfind('\n').map_or(0, |index| index + 1)
+        })
+        .max(offset);
+
+    let mut start_line = content[..offset].matches('\n').count() + 1;
+    let mut lines = Vec::new();
+    for line in content[offset..end].split_inclusive('\n') {
+        if line.trim().is_empty() {
+            if lines.is_empty() {
+                start_line += 1;
+                continue;
+            }
+            if until == FollowingCodeUntil::BlankLine {
+                break;
+            }
+        }
+        lines.push(line);
+        if lines.len() == max_lines {
+            break;
+        }
+    }
+    while lines.last().is_some_and(|line| line.trim().is_empty()) {
+        lines.pop();
+    }
+    if lines.is_empty() {
+        return None;
+    }
+
+    let mut code = indentation.apply(&lines.concat());
+    if !code.ends_with('\n') {
+        code.push('\n');
+    }
+    let language = file_path
+        .as_relative()
+        .extension()
+        .map(|extension| extension_language(extension).to_string());
+    Some(FollowingCode {
+        code,
+        start_line,
+        language,
+    })
+}
+
+/// Append the following code of a document to its parser events as a code block.
+///
+/// The events of the code block have the empty range at the end of the content.
+pub(crate) fn expand_following_code<'a>(
+    document: &Document,
+    mut events: Vec<(Event<'a>, Range<usize>)>,
+) -> Vec<(Event<'a>, Range<usize>)> {
+    let Some(following_code) = document.following_code() else {
+        return events;
+    };
+    let end = document.content().len();
+    let info = following_code.language.clone().unwrap_or_default();
+    events.push((
+        Event::Start(Tag::CodeBlock(CodeBlockKind::Fenced(info.into()))),
+        end..end,
+    ));
+    events.push((Event::Text(following_code.code.clone().into()), end..end));
+    events.push((Event::End(TagEnd::CodeBlock), end..end));
+    events
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SOURCE: &str = "// 📖 # Greeting\n\nfn greet(name: &str) -> String {\n    format!(\"Hello, {}!\", name)\n}\n\nfn main() {\n    greet(\"World\");\n}\n";
+
+    fn capture(
+        content: &str,
+        next_comment_byte: Option<usize>,
+        max_lines: usize,
+        until: FollowingCodeUntil,
+    ) -> Option<FollowingCode> {
+        let end_byte = content.find('\n').unwrap();
+        capture_following_code(
+            &FilePath::from("src/lib.rs"),
+            content,
+            end_byte,
+            next_comment_byte,
+            max_lines,
+            until,
+            CodeIndentation::Dedent,
+        )
+    }
+
+    #[test]
+    fn test_capture_until_blank_line() {
+        let following_code = capture(SOURCE, None, 10, FollowingCodeUntil::BlankLine).unwrap();
+
+        assert_eq!(
+            following_code.code,
+            "fn greet(name: &str) -> String {\n    format!(\"Hello, {}!\", name)\n}\n"
+        );
+        assert_eq!(following_code.start_line, 3);
+        assert_eq!(following_code.language.as_deref(), Some("rust"));
+    }
+
+    #[test]
+    fn test_capture_until_doc_comment() {
+        let content = "// 📖 # A\nfn a() {\n\n    1\n}\n\n// 📖 # B\nfn b() {}\n";
+        let next_comment_byte = content.find("// 📖 # B").unwrap();
+
+        let following_code = capture(
+            content,
+            Some(next_comment_byte),
+            10,
+            FollowingCodeUntil::DocComment,
+        )
+        .unwrap();
+
+        assert_eq!(following_code.code, "fn a() {\n\n    1\n}\n");
+    }
+
+    #[test]
+    fn test_capture_limits_lines() {
+        let following_code = capture(SOURCE, None, 2, FollowingCodeUntil::BlankLine).unwrap();
+
+        assert_eq!(
+            following_code.code,
+            "fn greet(name: &str) -> String {\n    format!(\"Hello, {}!\", name)\n"
+        );
+    }
+
+    #[test]
+    fn test_capture_strips_common_indentation() {
+        let content = "    // 📖 # Size\n    fn size(&self) -> usize {\n        self.len\n    }\n";
+
+        let following_code = capture(content, None, 10, FollowingCodeUntil::BlankLine).unwrap();
+
+        assert_eq!(
+            following_code.code,
+            "fn size(&self) -> usize {\n    self.len\n}\n"
+        );
+        assert_eq!(following_code.start_line, 2);
+    }
+
+    #[test]
+    fn test_capture_preserving_indentation() {
+        let content = "    // 📖 # Size\n    fn size(&self) -> usize {\n        self.len\n    }\n";
+        let end_byte = content.find('\n').unwrap();
+
+        let following_code = capture_following_code(
+            &FilePath::from("src/lib.rs"),
+            content,
+            end_byte,
+            None,
+            10,
+            FollowingCodeUntil::BlankLine,
+            CodeIndentation::Preserve,
+        )
+        .unwrap();
+
+        assert_eq!(
+            following_code.code,
+            "    fn size(&self) -> usize {\n        self.len\n    }\n"
+        );
+    }
+
+    #[test]
+    fn test_capture_mixed_tab_and_space_indentation() {
+        // The common indentation is the tab, the spaces after it are kept
+        let content = "\t// 📖 # Size\n\tfn size() {\n\t    1\n\t}\n";
+        let following_code = capture(content, None, 10, FollowingCodeUntil::BlankLine).unwrap();
+        assert_eq!(following_code.code, "fn size() {\n    1\n}\n");
+
+        // Tabs and spaces have no common indentation, nothing is removed
+        let content = "// 📖 # Size\n\tfn size() {\n    1\n\t}\n";
+        let following_code = capture(content, None, 10, FollowingCodeUntil::BlankLine).unwrap();
+        assert_eq!(following_code.code, "\tfn size() {\n    1\n\t}\n");
+    }
+
+    #[test]
+    fn test_capture_nothing() {
+        assert_eq!(
+            capture(SOURCE, None, 0, FollowingCodeUntil::BlankLine),
+            None
+        );
+        assert_eq!(
+            capture("// 📖 # End\n\n\n", None, 10, FollowingCodeUntil::BlankLine),
+            None
+        );
+        let content = "// 📖 # A\n// 📖 # B\nfn b() {}\n";
+        let next_comment_byte = content.find("// 📖 # B");
+        assert_eq!(
+            capture(
+                content,
+                next_comment_byte,
+                10,
+                FollowingCodeUntil::DocComment
+            ),
+            None
+        );
+    }
+}
@@ -0,0 +1,285 @@
+/* 📖 # Why collect footnotes per page?
+
+Footnotes keep asides and references out of the flow of the text. They use the
+standard Markdown syntax, a `[^label]` reference and a `[^label]: text`
+definition:
+
+```text
+// 📖 # Retries
+// Requests are retried with exponential backoff[^backoff].
+
+// 📖 # Backoff
+// [^backoff]: Starting at 100ms, doubling up to 10s.
+```
+
+A page shows all doc comments of a source file, and so a definition may be in
+any doc comment of the file, before or after its reference. Each document is
+parsed on its own though, so the renderer collects the definitions of all
+documents of a page first, and resolves references against them.
+
+Footnotes are numbered per page in the order of their first reference and
+listed at the bottom of the page, each with links back to its references.
+Numbers only depend on the page content, so they are stable across builds.
+Labels match case-insensitively, as on GitHub. A reference without any
+definition on the page is rendered as written and reported as a warning.
+*/
+
+use std::collections::{BTreeMap, BTreeSet};
+use std::sync::LazyLock;
+
+use pulldown_cmark::{Event, Options, Parser, Tag, TagEnd, html};
+use regex::Regex;
+
+use crate::Document;
+use crate::conditional::apply_profiles;
+use crate::render::escape_html;
+
+/// Options for parsing document content, with footnotes enabled.
+pub(crate) const PARSER_OPTIONS: Options = Options::ENABLE_FOOTNOTES;
+
+static REFERENCE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"\[\^([^\]\s]+)\]").expect("valid regex"));
+
+/// A piece of text, split at `[^label]` footnote references.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum FootnotePart<'a> {
+    Text(&'a str),
+    /// A reference, with the byte offset of the `[^` in the text
+    Reference {
+        label: &'a str,
+        offset: usize,
+    },
+}
+
+/// Split text into plain parts and `[^label]` references.
+///
+/// The parser only emits references to definitions in the same document, the
+/// others remain text and are found with this function.
+pub(crate) fn split_footnote_references(text: &str) -> Vec<FootnotePart<'_>> {
+    let mut parts = Vec::new();
+    let mut last = 0;
+    for captures in REFERENCE.captures_iter(text) {
+        let whole = captures.get(0).expect("group 0 always matches");
+        if whole.start() > last {
+            parts.push(FootnotePart::Text(&text[last..whole.start()]));
+        }
+        parts.push(FootnotePart::Reference {
+            label: captures.get(1).expect("group 1 always matches").as_str(),
+            offset: whole.start(),
+        });
+        last = whole.end();
+    }
+    if last < text.len() {
+        parts.push(FootnotePart::Text(&text[last..]));
+    }
+    parts
+}
+
+/// The footnotes of one page, numbered in order of their first reference.
+#[derive(Debug, Clone, Default)]
+pub(crate) struct PageFootnotes {
+    /// Prefix of the element ids, to keep them unique in single-file output
+    id_prefix: String,
+    /// Rendered content of each definition, by lowercase label
+    definitions: BTreeMap<String, String>,
+    /// Lowercase labels in order of first reference, with their number of references
+    referenced: Vec<(String, usize)>,
+}
+
+impl PageFootnotes {
+    /// Collect the footnote definitions of the documents on a page.
+    ///
+    /// If a label is defined more than once, the first definition is used.
+    pub(crate) fn collect(
+        documents: &[&Document],
+        profiles: &BTreeSet<String>,
+        id_prefix: impl Into<String>,
+    ) -> Self {
+        let mut definitions = BTreeMap::new();
+        for document in documents {
+            let document = apply_profiles(document, profiles);
+            // Label and events of the definition currently being collected
+            let mut definition: Option<(String, Vec<Event>)> = None;
+            for event in Parser::new_ext(document.content(), PARSER_OPTIONS) {
+                match event {
+                    Event::Start(Tag::FootnoteDefinition(label)) => {
+                        definition = Some((label.to_lowercase(), Vec::new()));
+                    }
+                    Event::End(TagEnd::FootnoteDefinition) => {
+                        if let Some((label, events)) = definition.take() {
+                            let mut content = String::new();
+                            html::push_html(&mut content, events.into_iter());
+                            definitions.entry(label).or_insert(content);
+                        }
+                    }
+                    // Footnotes in footnotes are not linked
+                    Event::FootnoteReference(label) => {
+                        if let Some((_, events)) = &mut definition {
+                            events.push(Event::Text(format!("[^{}]", label).into()));
+                        }
+                    }
+                    event => {
+                        if let Some((_, events)) = &mut definition {
+                            events.push(event);
+                        }
+                    }
+                }
+            }
+        }
+        Self {
+            id_prefix: id_prefix.into(),
+            definitions,
+            referenced: Vec::new(),
+        }
+    }
+
+    /// Returns the HTML of a reference to `label`, or None if it has no definition.
+    pub(crate) fn reference(&mut self, label: &str) -> Option<String> {
+        let label = label.to_lowercase();
+        if !self.definitions.contains_key(&label) {
+            return None;
+        }
+        let index = match self.referenced.iter().position(|(l, _)| *l == label) {
+            Some(index) => {
+                self.referenced[index].1 += 1;
+                index
+            }
+            None => {
+                self.referenced.push((label, 1));
+                self.referenced.len() - 1
+            }
+        };
+        let number = index + 1;
+        Some(format!(
+            "<sup class=\"footnote-ref\"><a href=\"#{}\" id=\"{}\">{}</a></sup>",
+            self.footnote_id(number),
+            self.reference_id(number, self.referenced[index].1),
+            number
+        ))
+    }
+
+    /// Render the list of referenced footnotes, empty if there are none.
+    pub(crate) fn render(&self) -> String {
+        if self.referenced.is_empty() {
+            return String::new();
+        }
+        let mut html = String::from("<section class=\"footnotes\">\n<ol>\n");
+        for (index, (label, references)) in self.referenced.iter().enumerate() {
+            let number = index + 1;
+            let back_links: Vec<String> = (1..=*references)
+                .map(|reference| {
+                    format!(
+                        "<a href=\"#{}\" class=\"footnote-backref\">↩</a>",
+                        self.reference_id(number, reference)
+                    )
+                })
+                .collect();
+            let back_links = back_links.join(" ");
+            let content = &self.definitions[label];
+            html.push_str(&format!("<li id=\"{}\">\n", self.footnote_id(number)));
+            match content.strip_suffix("</p>\n") {
+                Some(paragraphs) => html.push_str(&format!("{} {}</p>\n", paragraphs, back_links)),
+                None => html.push_str(&format!("{}<p>{}</p>\n", content, back_links)),
+            }
+            html.push_str("</li>\n");
+        }
+        html.push_str("</ol>\n</section>\n");
+        html
+    }
+
+    fn footnote_id(&self, number: usize) -> String {
+        format!("{}fn-{}", escape_html(&self.id_prefix), number)
+    }
+
+    /// Id of the `reference`-th reference (1-based) to footnote `number`.
+    fn reference_id(&self, number: usize, reference: usize) -> String {
+        match reference {
+            1 => format!("{}fnref-{}", escape_html(&self.id_prefix), number),
+            _ => format!(
+                "{}fnref-{}-{}",
+                escape_html(&self.id_prefix),
+                number,
+                reference
+            ),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use hyperlit_base::FilePath;
+    use std::collections::HashSet;
+
+    fn doc(line: usize, content: &str) -> Document {
+        Document::new(
+            format!("Doc {line}"),
+            content.to_string(),
+            DocumentSource::new(SourceType::CodeComment, FilePath::from("src/lib.rs"), line),
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    #[test]
+    fn test_split_footnote_references() {
+        assert_eq!(
+            split_footnote_references("See[^a] and [^Long-Note]."),
+            [
+                FootnotePart::Text("See"),
+                FootnotePart::Reference {
+                    label: "a",
+                    offset: 3
+                },
+                FootnotePart::Text(" and "),
+                FootnotePart::Reference {
+                    label: "Long-Note",
+                    offset: 12
+                },
+                FootnotePart::Text("."),
+            ]
+        );
+        assert_eq!(
+            split_footnote_references("[^] [x] [^a b]"),
+            [FootnotePart::Text("[^] [x] [^a b]")]
+        );
+    }
+
+    #[test]
+    fn test_page_footnotes() {
+        let first = doc(1, "# A\n\nText[^b].\n");
+        let second = doc(10, "# B\n\n[^a]: First *note*.\n[^B]: Second note.\n");
+        let mut footnotes = PageFootnotes::collect(&[&first, &second], &BTreeSet::new(), "");
+
+        // Numbered in order of first reference, labels are case-insensitive
+        assert_eq!(
+            footnotes.reference("b").unwrap(),
+            "<sup class=\"footnote-ref\"><a href=\"#fn-1\" id=\"fnref-1\">1</a></sup>"
+        );
+        assert_eq!(
+            footnotes.reference("A").unwrap(),
+            "<sup class=\"footnote-ref\"><a href=\"#fn-2\" id=\"fnref-2\">2</a></sup>"
+        );
+        assert_eq!(
+            footnotes.reference("b").unwrap(),
+            "<sup class=\"footnote-ref\"><a href=\"#fn-1\" id=\"fnref-1-2\">1</a></sup>"
+        );
+        assert_eq!(footnotes.reference("missing"), None);
+
+        assert_eq!(
+            footnotes.render(),
+            "<section class=\"footnotes\">\n<ol>\n\
+             <li id=\"fn-1\">\n<p>Second note. <a href=\"#fnref-1\" class=\"footnote-backref\">↩</a> <a href=\"#fnref-1-2\" class=\"footnote-backref\">↩</a></p>\n</li>\n\
+             <li id=\"fn-2\">\n<p>First <em>note</em>. <a href=\"#fnref-2\" class=\"footnote-backref\">↩</a></p>\n</li>\n\
+             </ol>\n</section>\n"
+        );
+    }
+
+    #[test]
+    fn test_page_footnotes_without_references() {
+        let document = doc(1, "# A\n\n[^a]: Unused.\n");
+        let footnotes = PageFootnotes::collect(&[&document], &BTreeSet::new(), "page-");
+        assert_eq!(footnotes.render(), "");
+    }
+}
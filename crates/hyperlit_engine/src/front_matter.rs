@@ -0,0 +1,247 @@
+/* 📖 # Why support front matter in doc comments?
+
+Markdown files have long been able to start with a YAML front matter block
+carrying page metadata such as the title, author or tags. Pages generated from
+code files deserve the same, so the first doc comment of a file may start with a
+front matter block as well:
+
+```text
+// 📖 ---
+// title: Parser internals
+// tags: [parsing, internals]
+// ---
+// # How the parser works
+```
+
+Front matter is only recognized at the very top of the first doc comment (or
+markdown file), where it describes the page as a whole. Anywhere else a `---`
+line keeps its markdown meaning of a thematic break or setext heading, so
+existing doc comments are not affected.
+
+The block is removed from the document content and exposed as
+`Document::front_matter()`, with values keeping their YAML structure (lists,
+nested mappings). A `title` overrides the title taken from the first heading.
+Scalar values are also available as plain strings in `Document::metadata()`.
+
+Malformed YAML is a `ParseError` located at the offending line and column of the
+source file, like any other malformed doc block, instead of silently dropping
+the metadata. Markdown files have always been rendered without malformed front
+matter, so for them it stays a warning at the same location.
+*/
+
+use std::collections::{BTreeMap, HashMap};
+
+use hyperlit_base::FilePath;
+
+use crate::parse_error::column_of;
+use crate::{DocumentMetadata, ParseError};
+
+/// Front matter of a document, mapping keys to YAML values.
+pub type FrontMatter = BTreeMap<String, serde_yaml::Value>;
+
+const DELIMITER: &str = "---";
+
+/// A front matter block found at the top of a document's content.
+#[derive(Debug)]
+pub(crate) struct FrontMatterBlock {
+    /// The parsed front matter, or the problem with its YAML
+    pub(crate) front_matter: Result<FrontMatter, FrontMatterError>,
+    /// Byte offset of the content following the closing delimiter
+    pub(crate) body_start: usize,
+}
+
+/// Malformed front matter, located in the content of a document.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct FrontMatterError {
+    /// Line of the problem in the content (0-indexed)
+    line_index: usize,
+    /// Column of the problem in the content line in characters (1-indexed)
+    column: usize,
+    message: String,
+}
+
+impl FrontMatterError {
+    /// Locate the error in `source` (the full content of the file).
+    ///
+    /// `content` is the content of the document, starting at line `start_line` of the file.
+    pub(crate) fn to_parse_error(
+        &self,
+        file_path: &FilePath,
+        source: &str,
+        content: &str,
+        start_line: usize,
+    ) -> ParseError {
+        let line = start_line + self.line_index;
+        let content_line = content.lines().nth(self.line_index).unwrap_or_default();
+        ParseError {
+            file_path: file_path.clone(),
+            line,
+            column: column_of(source, line, content_line) + self.column - 1,
+            message: self.message.clone(),
+        }
+    }
+}
+
+/// Find and parse a front matter block at the very top of `content`.
+///
+/// The block starts with a `---` line and ends at the next `---` line. Returns
+/// None if the content does not start with such a block.
+pub(crate) fn find_front_matter(content: &str) -> Option<FrontMatterBlock> {
+    let mut lines = content.split_inclusive('\n');
+    if lines.next()?.trim_end() != DELIMITER {
+        return None;
+    }
+    let yaml_start = DELIMITER.len() + content[DELIMITER.len()..].find('\n')? + 1;
+    let mut offset = yaml_start;
+    for line in lines {
+        if line.trim() == DELIMITER {
+            return Some(FrontMatterBlock {
+                front_matter: parse_yaml(&content[yaml_start..offset]),
+                body_start: offset + line.len(),
+            });
+        }
+        offset += line.len();
+    }
+    None
+}
+
+/// Parse the YAML between the delimiters, which starts on line 1 of the content.
+fn parse_yaml(yaml: &str) -> Result<FrontMatter, FrontMatterError> {
+    let value = serde_yaml::from_str::<serde_yaml::Value>(yaml).map_err(|e| {
+        let (line_index, column) = e
+            .location()
+            .map_or((0, 1), |location| (location.line(), location.column()));
+        FrontMatterError {
+            line_index,
+            column,
+            message: format!("front matter is not well-formed YAML: {}", e),
+        }
+    })?;
+    let mapping = match value {
+        serde_yaml::Value::Mapping(mapping) => mapping,
+        serde_yaml::Value::Null => return Ok(FrontMatter::new()),
+        _ => {
+            return Err(FrontMatterError {
+                line_index: 1,
+                column: 1,
+                message: "front matter must be a mapping of keys to values".to_string(),
+            });
+        }
+    };
+    Ok(mapping
+        .into_iter()
+        .filter_map(|(key, value)| scalar_to_string(&key).map(|key| (key, value)))
+        .collect())
+}
+
+/// Returns the scalar fields of the front matter as metadata, or None if there are none.
+pub(crate) fn front_matter_metadata(front_matter: &FrontMatter) -> Option<DocumentMetadata> {
+    let fields: HashMap<String, String> = front_matter
+        .iter()
+        .filter_map(|(key, value)| scalar_to_string(value).map(|value| (key.clone(), value)))
+        .collect();
+    (!fields.is_empty()).then(|| DocumentMetadata::new(fields))
+}
+
+fn scalar_to_string(value: &serde_yaml::Value) -> Option<String> {
+    match value {
+        serde_yaml::Value::String(s) => Some(s.clone()),
+        serde_yaml::Value::Number(n) => Some(n.to_string()),
+        serde_yaml::Value::Bool(b) => Some(b.to_string()),
+        _ => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_find_front_matter() {
+        let content = "---\ntitle: Parser\ntags: [a, b]\n---\n# Heading\n";
+        let block = find_front_matter(content).unwrap();
+        let front_matter = block.front_matter.unwrap();
+
+        assert_eq!(&content[block.body_start..], "# Heading\n");
+        assert_eq!(
+            front_matter.get("title"),
+            Some(&serde_yaml::Value::String("Parser".to_string()))
+        );
+        assert!(matches!(
+            front_matter.get("tags"),
+            Some(serde_yaml::Value::Sequence(tags)) if tags.len() == 2
+        ));
+    }
+
+    #[test]
+    fn test_find_front_matter_requires_delimiters() {
+        assert!(find_front_matter("# Heading\n\n---\ntitle: A\n---\n").is_none());
+        assert!(find_front_matter("---\ntitle: A\n# Heading\n").is_none());
+        assert!(find_front_matter("----\ntitle: A\n---\n").is_none());
+        assert!(find_front_matter("---").is_none());
+    }
+
+    #[test]
+    fn test_find_front_matter_empty() {
+        let block = find_front_matter("---\n---\nText").unwrap();
+
+        assert_eq!(block.front_matter, Ok(FrontMatter::new()));
+        assert_eq!(block.body_start, 8);
+    }
+
+    #[test]
+    fn test_find_front_matter_malformed() {
+        let content = "---\ntitle: A\n- b\n---\n";
+        let error = find_front_matter(content)
+            .unwrap()
+            .front_matter
+            .unwrap_err();
+
+        assert_eq!((error.line_index, error.column), (2, 1));
+        assert!(
+            error
+                .message
+                .starts_with("front matter is not well-formed YAML")
+        );
+    }
+
+    #[test]
+    fn test_find_front_matter_not_a_mapping() {
+        let error = find_front_matter("---\njust text\n---\n")
+            .unwrap()
+            .front_matter
+            .unwrap_err();
+
+        assert_eq!(
+            error.message,
+            "front matter must be a mapping of keys to values"
+        );
+    }
+
+    #[test]
+    fn test_front_matter_error_location_in_source() {
+        let source = "fn a() {}\n// 📖 ---\n// title: A\n// - b\n// ---\n";
+        let content = "---\ntitle: A\n- b\n---\n";
+        let error = find_front_matter(content)
+            .unwrap()
+            .front_matter
+            .unwrap_err();
+
+        let parse_error = error.to_parse_error(&FilePath::from("src/lib.rs"), source, content, 2);
+
+        assert_eq!((parse_error.line, parse_error.column), (4, 4));
+    }
+
+    #[test]
+    fn test_front_matter_metadata() {
+        let block =
+            find_front_matter("---\ntitle: A\nweight: 3\ndraft: true\ntags: [x]\n---\n").unwrap();
+        let metadata = front_matter_metadata(&block.front_matter.unwrap()).unwrap();
+
+        assert_eq!(metadata.get("title"), Some("A"));
+        assert_eq!(metadata.get("weight"), Some("3"));
+        assert_eq!(metadata.get("draft"), Some("true"));
+        assert_eq!(metadata.get("tags"), None);
+        assert!(front_matter_metadata(&FrontMatter::new()).is_none());
+    }
+}
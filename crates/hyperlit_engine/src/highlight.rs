@@ -0,0 +1,144 @@
+/* 📖 # Why make syntax highlighting pluggable?
+
+Code blocks in woven pages are much easier to read with syntax highlighting, but
+the right highlighter depends on the site: some want a particular theme, others
+already run a client-side highlighter or prefer plain output for smaller pages.
+The renderer therefore only depends on the small `Highlighter` trait, and
+highlighting is optional: without a highlighter (the default), code blocks are
+rendered as plain escaped text exactly as before.
+
+The bundled `SyntectHighlighter` reuses syntect, which hyperlit already depends
+on for comment extraction, so no additional dependency is needed. It emits
+inline styles rather than CSS classes so highlighted pages need no extra
+stylesheet per theme.
+*/
+
+use std::fmt::Debug;
+
+use syntect::highlighting::{Theme, ThemeSet};
+use syntect::parsing::SyntaxSet;
+
+use hyperlit_base::{HyperlitResult, err};
+
+/// Converts the code of a fenced code block to highlighted HTML.
+pub trait Highlighter: Debug + Send + Sync {
+    /// Highlight `code` written in `language` (the first word of the fence info string).
+    ///
+    /// Returns the complete HTML for the code block, including the surrounding
+    /// `<pre>` element. On error, the renderer falls back to plain escaped text.
+    fn highlight(&self, language: &str, code: &str) -> HyperlitResult<String>;
+
+    /// Identifies the output of this highlighter for the build cache.
+    ///
+    /// Cached pages are rendered again when the key changes, so it should cover
+    /// every setting that affects the highlighted HTML (such as the theme).
+    fn cache_key(&self) -> String {
+        format!("{self:?}")
+    }
+}
+
+/// Theme used by [`SyntectHighlighter::new`].
+pub const DEFAULT_HIGHLIGHT_THEME: &str = "InspiredGitHub";
+
+/// Syntax highlighter backed by syntect's bundled syntaxes and themes.
+///
+/// Languages are looked up by name or file extension (e.g. `rust` or `rs`).
+/// Unknown languages are rendered as plain text.
+#[derive(Debug)]
+pub struct SyntectHighlighter {
+    syntax_set: SyntaxSet,
+    theme_name: String,
+    theme: Theme,
+}
+
+impl SyntectHighlighter {
+    /// Create a highlighter using the default theme.
+    pub fn new() -> Self {
+        Self::with_theme(DEFAULT_HIGHLIGHT_THEME).expect("default theme should be bundled")
+    }
+
+    /// Create a highlighter using one of syntect's bundled themes.
+    ///
+    /// # Errors
+    /// Returns an error if no bundled theme has the given name.
+    pub fn with_theme(theme_name: &str) -> HyperlitResult<Self> {
+        let mut themes = ThemeSet::load_defaults().themes;
+        let Some(theme) = themes.remove(theme_name) else {
+            let available: Vec<String> = themes.into_keys().collect();
+            return Err(err!(
+                "Unknown highlighting theme '{}', available themes: {}",
+                theme_name,
+                available.join(", ")
+            ));
+        };
+        Ok(Self {
+            syntax_set: SyntaxSet::load_defaults_newlines(),
+            theme_name: theme_name.to_string(),
+            theme,
+        })
+    }
+}
+
+impl Default for SyntectHighlighter {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl Highlighter for SyntectHighlighter {
+    fn highlight(&self, language: &str, code: &str) -> HyperlitResult<String> {
+        let syntax = self
+            .syntax_set
+            .find_syntax_by_token(language)
+            .unwrap_or_else(|| self.syntax_set.find_syntax_plain_text());
+        syntect::html::highlighted_html_for_string(code, &self.syntax_set, syntax, &self.theme)
+            .map_err(|e| err!("Failed to highlight {} code: {}", language, e))
+    }
+
+    fn cache_key(&self) -> String {
+        format!("syntect:{}", self.theme_name)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_syntect_highlighter_highlights_known_language() {
+        let highlighter = SyntectHighlighter::new();
+        let html = highlighter
+            .highlight("rust", "// comment\nlet s = \"<str>\";\n")
+            .unwrap();
+        assert!(html.starts_with("<pre style=\"background-color:"));
+        assert!(html.contains("&lt;str&gt;"));
+        assert!(html.contains("<span style=\"color:"));
+    }
+
+    #[test]
+    fn test_syntect_highlighter_unknown_language_is_plain() {
+        let highlighter = SyntectHighlighter::new();
+        let html = highlighter
+            .highlight("no-such-language", "a < b\n")
+            .unwrap();
+        assert!(html.contains("a &lt; b"));
+    }
+
+    #[test]
+    fn test_syntect_highlighter_cache_key_covers_theme() {
+        let default = SyntectHighlighter::new();
+        let other = SyntectHighlighter::with_theme("Solarized (dark)").unwrap();
+        assert_eq!(default.cache_key(), "syntect:InspiredGitHub");
+        assert_ne!(default.cache_key(), other.cache_key());
+    }
+
+    #[test]
+    fn test_syntect_highlighter_unknown_theme() {
+        let error = SyntectHighlighter::with_theme("Neon").unwrap_err();
+        assert!(
+            error
+                .to_string()
+                .starts_with("Unknown highlighting theme 'Neon', available themes: ")
+        );
+    }
+}
@@ -0,0 +1,203 @@
+/* 📖 # Why resolve `{{include path}}` directives during extraction?
+
+Examples such as configuration files often live next to the code as separate
+files. Copying them into doc comments means they silently go stale, so a doc
+block can instead include a file with a directive in its own paragraph:
+
+    Example configuration:
+
+    {{include examples/config.yaml}}
+
+The directive is rendered as a fenced code block with the file's content, the
+language is inferred from the file extension. Paths are relative to the
+directory of the file containing the directive.
+
+The directive is registered like any other (see `crate::directive`). Included
+files are read during extraction, when the PAL is at hand, and stored with the
+document. Rendering stays a pure function of the documents, and every
+document knows the files it depends on: the build cache hashes their content
+and watch mode watches them, so editing an included file rebuilds the pages
+that include it. A missing included file is a `ParseError` at the location of
+the directive, like any other malformed doc block.
+
+Only a paragraph consisting of nothing but the directive is replaced, so the
+directive can still be mentioned in prose or shown in code blocks.
+*/
+
+use hyperlit_base::{FilePath, HyperlitResult, bail, err};
+
+use crate::directive::{DirectiveContext, DirectiveHandler, fenced_code_block};
+use crate::normalize::normalize;
+
+/// Name of the built-in include directive.
+pub(crate) const INCLUDE_DIRECTIVE: &str = "include";
+
+/// A file included into a document by an `{{include path}}` directive.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Include {
+    /// Path as written in the directive
+    pub directive_path: String,
+    /// Path of the included file, relative to the site root
+    pub file_path: FilePath,
+    /// Content of the included file
+    pub content: String,
+}
+
+impl Include {
+    /// Language of the included file for syntax highlighting, based on its extension.
+    pub fn language(&self) -> Option<&str> {
+        let extension = self.file_path.as_relative().extension()?;
+        Some(extension_language(extension))
+    }
+
+    /// The fenced code block replacing the directive.
+    pub fn markdown(&self) -> String {
+        fenced_code_block(self.language().unwrap_or_default(), &self.content)
+    }
+}
+
+/// Language for syntax highlighting of a file with the given extension.
+pub(crate) fn extension_language(extension: &str) -> &str {
+    match extension {
+        "rs" => "rust",
+        "py" => "python",
+        "js" => "javascript",
+        "ts" => "typescript",
+        "yml" => "yaml",
+        "sh" => "bash",
+        "md" => "markdown",
+        other => other,
+    }
+}
+
+/// Resolve a path written in `source_path` (e.g. of an included file) relative to its directory.
+pub(crate) fn resolve_relative_path(source_path: &FilePath, path: &str) -> FilePath {
+    let directory = source_path
+        .as_relative()
+        .parent()
+        .map(|parent| parent.to_relative_path_buf())
+        .unwrap_or_default();
+    FilePath::from(directory.join(path).normalize())
+}
+
+/// The built-in `{{include path}}` directive.
+#[derive(Debug, Clone, Copy, Default)]
+pub(crate) struct IncludeDirective;
+
+impl DirectiveHandler for IncludeDirective {
+    fn expand(&self, arguments: &str, context: &mut DirectiveContext) -> HyperlitResult<String> {
+        if arguments.is_empty() || arguments.contains(char::is_whitespace) {
+            bail!(
+                "include directive expects a single path, got '{}'",
+                arguments
+            );
+        }
+        let file_path = context.resolve_path(arguments);
+        let content = match context.pal().file_exists(&file_path) {
+            Ok(true) => context
+                .pal()
+                .read_file_to_string(&file_path)
+                .map(normalize)
+                .map_err(|e| err!("failed to read included file '{}': {}", file_path, e))?,
+            Ok(false) => bail!("included file '{}' not found", file_path),
+            Err(e) => bail!("failed to read included file '{}': {}", file_path, e),
+        };
+        let include = Include {
+            directive_path: arguments.to_string(),
+            file_path,
+            content,
+        };
+        let markdown = include.markdown();
+        context.add_include(include);
+        Ok(markdown)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::directive::{DirectiveRegistry, run_directives};
+    use crate::{Document, DocumentSource, SourceType};
+    use hyperlit_base::PalHandle;
+    use hyperlit_base::pal::MockPal;
+    use std::collections::HashSet;
+
+    fn doc(path: &str, line: usize, content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), line);
+        Document::new(
+            "Doc".to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    #[test]
+    fn test_resolve_relative_path() {
+        let source = FilePath::from("src/config/mod.rs");
+        assert_eq!(
+            resolve_relative_path(&source, "example.yaml"),
+            FilePath::from("src/config/example.yaml")
+        );
+        assert_eq!(
+            resolve_relative_path(&source, "../../examples/site.toml"),
+            FilePath::from("examples/site.toml")
+        );
+        assert_eq!(
+            resolve_relative_path(&FilePath::from("README.md"), "a.json"),
+            FilePath::from("a.json")
+        );
+    }
+
+    #[test]
+    fn test_include_directive() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("src/example.yaml"), b"key: value\n".to_vec());
+        let pal = PalHandle::new(mock_pal);
+        let source = "// 📖 # Doc\n//\n// {{include example.yaml}}\n//\n//   {{include missing.yaml}}\n//\n// {{include a b}}\nfn a() {}\n";
+        let document = doc(
+            "src/lib.rs",
+            1,
+            "# Doc\n\n{{include example.yaml}}\n\n  {{include missing.yaml}}\n\n{{include a b}}\n",
+        );
+
+        let (document, errors, _) =
+            run_directives(&pal, &DirectiveRegistry::default(), false, document, source);
+
+        assert_eq!(
+            document.includes(),
+            [Include {
+                directive_path: "example.yaml".to_string(),
+                file_path: FilePath::from("src/example.yaml"),
+                content: "key: value\n".to_string(),
+            }]
+        );
+        assert_eq!(
+            document
+                .expansion("include", "example.yaml")
+                .map(|expansion| expansion.markdown.as_str()),
+            Some("```yaml\nkey: value\n```")
+        );
+        let errors: Vec<String> = errors.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            errors,
+            [
+                "src/lib.rs:5:6: included file 'src/missing.yaml' not found",
+                "src/lib.rs:7:4: include directive expects a single path, got 'a b'",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_include_language() {
+        let include = |path: &str| Include {
+            directive_path: path.to_string(),
+            file_path: FilePath::from(path),
+            content: String::new(),
+        };
+        assert_eq!(include("a.yml").language(), Some("yaml"));
+        assert_eq!(include("a.toml").language(), Some("toml"));
+        assert_eq!(include("Makefile").language(), None);
+    }
+}
@@ -0,0 +1,469 @@
+/* 📖 # Why have language specs in addition to syntect?
+
+Comment extraction primarily relies on syntect's syntax definitions, which know
+about strings, character literals and other constructs that could contain a
+marker without being a comment. The default syntax set does not cover every
+language though (TypeScript, Kotlin and Swift are missing, for example), and
+projects may use file extensions syntect has never heard of.
+
+A `LanguageSpec` describes just enough of a language to find its comments: the
+line comment tokens, the block comment delimiters and the string delimiters.
+The `LanguageRegistry` maps file extensions to specs. Specs registered for an
+extension take precedence over syntect, so a project can override how a
+language is parsed. An optional default spec is used for extensions that neither
+the registry nor syntect know about.
+*/
+
+use std::collections::HashMap;
+use std::ops::Range;
+
+use serde::Deserialize;
+
+use hyperlit_base::{HyperlitResult, bail};
+
+/// Comment syntax of a programming language.
+///
+/// # Example
+/// ```
+/// use hyperlit_engine::LanguageSpec;
+/// let spec = LanguageSpec::new()
+///     .with_line_comment("//")
+///     .with_block_comment("/*", "*/")
+///     .with_string_delimiter("\"");
+/// assert_eq!(spec.line_comments(), ["//"]);
+/// ```
+#[derive(Debug, Clone, Default, PartialEq, Eq, Deserialize)]
+pub struct LanguageSpec {
+    /// Tokens starting a comment that extends to the end of the line (e.g. `//`, `#`)
+    #[serde(default)]
+    line_comments: Vec<String>,
+    /// Start and end delimiters of block comments (e.g. `/*` and `*/`)
+    #[serde(default)]
+    block_comments: Vec<(String, String)>,
+    /// Delimiters of string literals, which never contain comments (e.g. `"`)
+    #[serde(default)]
+    string_delimiters: Vec<String>,
+}
+
+impl LanguageSpec {
+    /// Create an empty language spec without any comment syntax.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Add a line comment token.
+    pub fn with_line_comment(mut self, token: impl Into<String>) -> Self {
+        self.line_comments.push(token.into());
+        self
+    }
+
+    /// Add a pair of block comment delimiters.
+    pub fn with_block_comment(mut self, start: impl Into<String>, end: impl Into<String>) -> Self {
+        self.block_comments.push((start.into(), end.into()));
+        self
+    }
+
+    /// Add a string literal delimiter.
+    pub fn with_string_delimiter(mut self, delimiter: impl Into<String>) -> Self {
+        self.string_delimiters.push(delimiter.into());
+        self
+    }
+
+    /// Line comment tokens of this language.
+    pub fn line_comments(&self) -> &[String] {
+        &self.line_comments
+    }
+
+    /// Block comment delimiters of this language.
+    pub fn block_comments(&self) -> &[(String, String)] {
+        &self.block_comments
+    }
+
+    /// Split source code into code, comment and comment delimiter regions.
+    ///
+    /// Regions never span multiple lines. Block comments are not nested, and an
+    /// unterminated block comment or string extends to the end of the content.
+    pub(crate) fn lex<'a>(&self, content: &'a str) -> Vec<SourceRegion<'a>> {
+        // Longest tokens first, so that e.g. `--[[` wins over `--`
+        let mut tokens: Vec<(&str, Token)> = Vec::new();
+        for line_comment in &self.line_comments {
+            tokens.push((line_comment, Token::LineComment));
+        }
+        for (start, end) in &self.block_comments {
+            tokens.push((start, Token::BlockComment(end)));
+        }
+        for delimiter in &self.string_delimiters {
+            tokens.push((delimiter, Token::String(delimiter)));
+        }
+        tokens.retain(|(token, _)| !token.is_empty());
+        tokens.sort_by_key(|(token, _)| std::cmp::Reverse(token.len()));
+
+        let mut lexer = Lexer::new(content);
+        let mut code_start = 0;
+        let mut position = 0;
+        while position < content.len() {
+            let rest = &content[position..];
+            let Some((token, kind)) = tokens.iter().find(|(token, _)| rest.starts_with(token))
+            else {
+                position += rest.chars().next().map_or(1, char::len_utf8);
+                continue;
+            };
+            match kind {
+                Token::LineComment => {
+                    lexer.emit(code_start, position, RegionKind::Code);
+                    let text_start = position + token.len();
+                    let text_end = content[text_start..]
+                        .find('\n')
+                        .map_or(content.len(), |index| text_start + index + 1);
+                    lexer.emit(position, text_start, RegionKind::Delimiter);
+                    lexer.emit(text_start, text_end, RegionKind::LineComment);
+                    position = text_end;
+                    code_start = position;
+                }
+                Token::BlockComment(end) => {
+                    lexer.emit(code_start, position, RegionKind::Code);
+                    let text_start = position + token.len();
+                    lexer.emit(position, text_start, RegionKind::Delimiter);
+                    match content[text_start..].find(end) {
+                        Some(index) => {
+                            let text_end = text_start + index;
+                            lexer.emit(text_start, text_end, RegionKind::BlockComment);
+                            lexer.emit(text_end, text_end + end.len(), RegionKind::Delimiter);
+                            position = text_end + end.len();
+                        }
+                        None => {
+                            lexer.emit(text_start, content.len(), RegionKind::BlockComment);
+                            position = content.len();
+                        }
+                    }
+                    code_start = position;
+                }
+                Token::String(delimiter) => {
+                    // Strings are code, skip to the closing delimiter
+                    position = find_string_end(content, position + token.len(), delimiter);
+                }
+            }
+        }
+        lexer.emit(code_start, content.len(), RegionKind::Code);
+        lexer.regions
+    }
+}
+
+/// Registry of language specs keyed by file extension.
+#[derive(Debug, Clone)]
+pub struct LanguageRegistry {
+    specs: HashMap<String, LanguageSpec>,
+    default_spec: Option<LanguageSpec>,
+}
+
+impl LanguageRegistry {
+    /// Create an empty registry without any specs or default.
+    pub fn empty() -> Self {
+        Self {
+            specs: HashMap::new(),
+            default_spec: None,
+        }
+    }
+
+    /// Register a spec for a file extension (without the leading dot).
+    ///
+    /// Replaces any spec previously registered for that extension.
+    pub fn register(&mut self, extension: impl Into<String>, spec: LanguageSpec) {
+        self.specs.insert(extension.into(), spec);
+    }
+
+    /// Set the spec used for extensions that are not otherwise known.
+    pub fn with_default_spec(mut self, spec: LanguageSpec) -> Self {
+        self.default_spec = Some(spec);
+        self
+    }
+
+    /// Get the spec registered for an extension.
+    pub fn get(&self, extension: &str) -> Option<&LanguageSpec> {
+        self.specs.get(extension)
+    }
+
+    /// Get the default spec, if one is configured.
+    pub fn default_spec(&self) -> Option<&LanguageSpec> {
+        self.default_spec.as_ref()
+    }
+}
+
+impl Default for LanguageRegistry {
+    /// Registry with specs for common languages missing from syntect's default syntax set.
+    fn default() -> Self {
+        let c_like = LanguageSpec::new()
+            .with_line_comment("//")
+            .with_block_comment("/*", "*/")
+            .with_string_delimiter("\"")
+            .with_string_delimiter("'");
+        let mut registry = Self::empty();
+        for extension in ["ts", "tsx", "mts", "cts", "jsx", "mjs", "cjs"] {
+            registry.register(extension, c_like.clone().with_string_delimiter("`"));
+        }
+        for extension in ["kt", "kts", "swift", "dart", "proto"] {
+            registry.register(extension, c_like.clone());
+        }
+        registry.register(
+            "toml",
+            LanguageSpec::new()
+                .with_line_comment("#")
+                .with_string_delimiter("\"")
+                .with_string_delimiter("'"),
+        );
+        registry
+    }
+}
+
+/// Kind of a lexed source region.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum RegionKind {
+    /// Anything that is not a comment, including string literals
+    Code,
+    /// Text of a line comment, excluding the comment token
+    LineComment,
+    /// Text of a block comment, excluding the delimiters
+    BlockComment,
+    /// A comment token or delimiter
+    Delimiter,
+}
+
+/// A lexed region of source code on a single line.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct SourceRegion<'a> {
+    pub text: &'a str,
+    /// Byte offset of the region within the content
+    pub start_byte: usize,
+    /// Line number (1-indexed)
+    pub line: usize,
+    pub kind: RegionKind,
+}
+
+enum Token<'a> {
+    LineComment,
+    BlockComment(&'a str),
+    String(&'a str),
+}
+
+/// A comment found by a parser outside of hyperlit, see [`crate::extract_parsed_comments`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ParsedComment {
+    /// Byte range of the whole comment in the content, including its delimiters
+    pub range: Range<usize>,
+    /// Byte range of the comment text, without the delimiters
+    pub text: Range<usize>,
+    /// True for block comments, false for line comments
+    pub block: bool,
+}
+
+/// Split `content` into regions at the comments found by another parser.
+///
+/// Fails if the comments are not sorted, overlap or are not within the content.
+pub(crate) fn parsed_comment_regions<'a>(
+    content: &'a str,
+    comments: &[ParsedComment],
+) -> HyperlitResult<Vec<SourceRegion<'a>>> {
+    let mut lexer = Lexer::new(content);
+    let mut code_start = 0;
+    for comment in comments {
+        let ParsedComment { range, text, block } = comment;
+        let valid = code_start <= range.start
+            && range.start <= text.start
+            && text.start <= text.end
+            && text.end <= range.end
+            && [&range, &text]
+                .iter()
+                .all(|range| content.get(range.start..range.end).is_some());
+        if !valid {
+            bail!(
+                "Invalid comment at bytes {}..{} (text {}..{}): comments must be sorted and within the content",
+                range.start,
+                range.end,
+                text.start,
+                text.end
+            );
+        }
+        // Line comment text ends with the newline, as when lexed
+        let mut text_end = text.end;
+        let mut end = range.end;
+        if !block && content[end..].starts_with('\n') && text_end == end {
+            text_end += 1;
+            end += 1;
+        }
+        let kind = match block {
+            true => RegionKind::BlockComment,
+            false => RegionKind::LineComment,
+        };
+        lexer.emit(code_start, range.start, RegionKind::Code);
+        lexer.emit(range.start, text.start, RegionKind::Delimiter);
+        lexer.emit(text.start, text_end, kind);
+        lexer.emit(text_end, end, RegionKind::Delimiter);
+        code_start = end;
+    }
+    lexer.emit(code_start, content.len(), RegionKind::Code);
+    Ok(lexer.regions)
+}
+
+/// Collects regions, splitting them at line boundaries.
+struct Lexer<'a> {
+    content: &'a str,
+    line_starts: Vec<usize>,
+    regions: Vec<SourceRegion<'a>>,
+}
+
+impl<'a> Lexer<'a> {
+    fn new(content: &'a str) -> Self {
+        let line_starts = std::iter::once(0)
+            .chain(content.match_indices('\n').map(|(index, _)| index + 1))
+            .collect();
+        Self {
+            content,
+            line_starts,
+            regions: Vec::new(),
+        }
+    }
+
+    fn emit(&mut self, start: usize, end: usize, kind: RegionKind) {
+        let mut offset = start;
+        for text in self.content[start..end].split_inclusive('\n') {
+            let line = self
+                .line_starts
+                .partition_point(|&line_start| line_start <= offset);
+            self.regions.push(SourceRegion {
+                text,
+                start_byte: offset,
+                line,
+                kind,
+            });
+            offset += text.len();
+        }
+    }
+}
+
+/// Find the byte position directly after the closing string delimiter.
+///
+/// Delimiters preceded by a backslash are escaped.
+fn find_string_end(content: &str, start: usize, delimiter: &str) -> usize {
+    let mut position = start;
+    while let Some(index) = content[position..].find(['\\', delimiter.chars().next().unwrap()]) {
+        position += index;
+        if content[position..].starts_with('\\') {
+            // Skip the escaped character
+            position += 1;
+            position += content[position..].chars().next().map_or(0, char::len_utf8);
+        } else if content[position..].starts_with(delimiter) {
+            return position + delimiter.len();
+        } else {
+            position += content[position..].chars().next().map_or(1, char::len_utf8);
+        }
+    }
+    content.len()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use expect_test::expect;
+
+    fn format_regions(spec: &LanguageSpec, content: &str) -> String {
+        spec.lex(content)
+            .iter()
+            .map(|region| {
+                format!(
+                    "{} @{} {:?} {:?}\n",
+                    region.line, region.start_byte, region.kind, region.text
+                )
+            })
+            .collect()
+    }
+
+    fn c_like() -> LanguageSpec {
+        LanguageSpec::new()
+            .with_line_comment("//")
+            .with_block_comment("/*", "*/")
+            .with_string_delimiter("\"")
+    }
+
+    #[test]
+    fn test_lex_line_and_block_comments() {
+        expect![[r#"
+            1 @0 Code "let x = 1; "
+            1 @11 Delimiter "//"
+            1 @13 LineComment " trailing\n"
+            2 @23 Delimiter "/*"
+            2 @25 BlockComment " block\n"
+            3 @32 BlockComment "   comment "
+            3 @43 Delimiter "*/"
+            3 @45 Code "\n"
+        "#]]
+        .assert_eq(&format_regions(
+            &c_like(),
+            "let x = 1; // trailing\n/* block\n   comment */\n",
+        ));
+    }
+
+    #[test]
+    fn test_lex_ignores_comment_tokens_in_strings() {
+        expect![[r#"
+            1 @0 Code "let s = \"// not \\\" a comment\"; "
+            1 @31 Delimiter "//"
+            1 @33 LineComment " real"
+        "#]]
+        .assert_eq(&format_regions(
+            &c_like(),
+            r#"let s = "// not \" a comment"; // real"#,
+        ));
+    }
+
+    #[test]
+    fn test_lex_prefers_longest_token() {
+        let lua = LanguageSpec::new()
+            .with_line_comment("--")
+            .with_block_comment("--[[", "]]");
+        expect![[r#"
+            1 @0 Delimiter "--[["
+            1 @4 BlockComment " block "
+            1 @11 Delimiter "]]"
+            1 @13 Code " "
+            1 @14 Delimiter "--"
+            1 @16 LineComment " line"
+        "#]]
+        .assert_eq(&format_regions(&lua, "--[[ block ]] -- line"));
+    }
+
+    #[test]
+    fn test_lex_unterminated_block_comment() {
+        expect![[r#"
+            1 @0 Delimiter "/*"
+            1 @2 BlockComment " open\n"
+            2 @8 BlockComment "forever"
+        "#]]
+        .assert_eq(&format_regions(&c_like(), "/* open\nforever"));
+    }
+
+    #[test]
+    fn test_default_registry() {
+        let registry = LanguageRegistry::default();
+        assert!(registry.get("ts").is_some());
+        assert!(registry.get("toml").is_some());
+        assert!(registry.get("xyz").is_none());
+        assert!(registry.default_spec().is_none());
+    }
+
+    #[test]
+    fn test_deserialize_spec() {
+        let spec: LanguageSpec = toml::from_str(
+            r#"
+line_comments = ["--"]
+block_comments = [["{-", "-}"]]
+"#,
+        )
+        .unwrap();
+        assert_eq!(
+            spec,
+            LanguageSpec::new()
+                .with_line_comment("--")
+                .with_block_comment("{-", "-}")
+        );
+    }
+}
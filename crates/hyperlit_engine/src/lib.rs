@@ -1,19 +1,109 @@
 pub mod api;
+pub mod asset;
+pub mod cache;
+pub mod check;
+pub mod code_attrs;
+pub mod code_wrap;
+pub mod comment_merge;
 pub mod comment_parser;
+pub mod conditional;
 pub mod config;
+pub mod diagram;
+pub mod diff;
+pub mod directive;
 pub mod document;
+pub mod exec;
+pub mod export;
 pub mod extractor;
+pub mod flat;
+pub mod following_code;
+pub mod footnote;
+pub mod front_matter;
+pub mod highlight;
+pub mod include;
+pub mod language;
+pub mod link_check;
+pub mod logger;
+pub mod markdown;
+pub mod normalize;
+pub mod parallel;
+pub mod parse_error;
+pub mod path_mapper;
+pub mod reading_order;
+pub mod render;
 pub mod scanner;
 pub mod search;
+pub mod search_index;
+pub mod skip;
 pub mod store;
+pub mod tangle;
+pub mod theme;
+pub mod toc;
+pub mod transform;
 pub mod watcher;
+pub mod word_count;
+pub mod xref;
 
 pub use api::{ApiService, SiteInfo};
-pub use comment_parser::CommentParser;
-pub use config::{Config, DirectoryConfig, load_config};
+pub use asset::{ASSET_EXTENSIONS, Asset, copy_assets, referenced_assets};
+pub use cache::{BuildCache, CACHE_FILE};
+pub use check::{CheckCategory, CheckProblem, CheckReport, check_site};
+pub use code_attrs::CodeBlockAttrs;
+pub use code_wrap::{CodeWrap, DEFAULT_TAB_WIDTH, DEFAULT_WRAP_COLUMN};
+pub use comment_parser::{CommentParser, MarkerConfig};
+pub use config::{
+    CONFIG_FILE_NAMES, Config, ConfigOptions, DEFAULT_CACHE_DIRECTORY, DEFAULT_OUTPUT_DIRECTORY,
+    DEFAULT_WATCH_DEBOUNCE_MS, DiagramConfig, DirectoryConfig, ExecConfig, PartConfig,
+    discover_config, load_config, load_config_with_options,
+};
+pub use diagram::{
+    DEFAULT_DIAGRAM_TIMEOUT_SECONDS, DIAGRAM_DIRECTORY, DiagramMode, DiagramOptions,
+    MERMAID_RUNTIME_URL,
+};
+pub use diff::{FileChange, FileDiff, diff_site, unified_diff};
+pub use directive::{DirectiveContext, DirectiveHandler, DirectiveRegistry, Expansion};
 pub use document::{ByteRange, Document, DocumentId, DocumentMetadata, DocumentSource, SourceType};
-pub use extractor::{ExtractionError, ExtractionResult, extract_documents};
+pub use exec::{DEFAULT_EXEC_TIMEOUT_SECONDS, EXEC_DIRECTORY, Exec, ExecOptions};
+pub use export::{DocumentExport, EXPORT_SCHEMA_VERSION, export_document, export_document_json};
+pub use extractor::{
+    ExtractionError, ExtractionOptions, ExtractionResult, extract_documents,
+    extract_documents_with_options, extract_parsed_comments, extract_reader,
+};
+pub use flat::{MANIFEST_FILE, MANIFEST_VERSION, flat_page_slug};
+pub use following_code::{CodeIndentation, FollowingCode, FollowingCodeUntil};
+pub use front_matter::FrontMatter;
+pub use highlight::{DEFAULT_HIGHLIGHT_THEME, Highlighter, SyntectHighlighter};
+pub use include::Include;
+pub use language::{LanguageRegistry, LanguageSpec, ParsedComment};
+pub use link_check::{
+    DEFAULT_LINK_CHECK_CONCURRENCY, DEFAULT_LINK_CHECK_TIMEOUT_SECONDS, LinkCheckOptions,
+    LinkProblem, check_links, check_links_with_options,
+};
+pub use logger::{LogFields, LogLevel, Logger, NoopLogger, StderrLogger};
+pub use markdown::{MARKDOWN_INDEX_PAGE, MarkdownRenderer, markdown_page_path};
+pub use parallel::default_concurrency;
+pub use parse_error::ParseError;
+pub use path_mapper::{FnPathMapper, MirrorPathMapper, PathMapper};
+pub use reading_order::{OrderedPage, PageSequence, ReadingOrder, SequencePart, UnlistedPages};
+pub use render::{
+    HtmlRenderer, INDEX_PAGE, OutputFormat, OutputMode, RenderOptions, RenderResult, RenderWarning,
+    RenderedFile, Renderer, STYLESHEET, SiteMap, page_path, render_file_page, render_index_page,
+    render_site, write_site,
+};
 pub use scanner::{ScanError, ScanResult, scan_files};
 pub use search::{MatchType, SearchResult, SimpleSearch};
+pub use search_index::{SEARCH_INDEX_FILE, SEARCH_INDEX_VERSION};
 pub use store::{DocumentStore, InMemoryStore, StoreHandle};
-pub use watcher::{FileWatcher, FileWatcherConfig};
+pub use tangle::tangle;
+pub use theme::{
+    CODE_BLOCK_TEMPLATE, CodeBlockData, PAGE_TEMPLATE, PageData, PageLink, TOC_TEMPLATE, Theme,
+    TocData,
+};
+pub use toc::{
+    DefaultSlugifier, FnSlugifier, Slugifier, Toc, TocEntry, TocPart, build_toc,
+    build_toc_with_slugifier,
+};
+pub use transform::{IssueLinker, Transformer};
+pub use watcher::{FileWatcher, FileWatcherConfig, WeaveUpdate};
+pub use word_count::{DEFAULT_WORDS_PER_MINUTE, WordCount, WordCountOptions, count_words};
+pub use xref::{LinkTarget, SymbolIndex};
@@ -0,0 +1,644 @@
+/* 📖 # Why check links as a separate step?
+
+Renaming a file or a heading silently breaks every link pointing at it: the
+site still builds, the `[[name]]` reference just renders as plain text and the
+relative link leads to a 404. Rendering only warns about unresolved
+cross-references, so broken links are easy to miss until a reader hits them.
+
+The link check validates all links of all documents in one pass and returns a
+`LinkProblem` per broken link, with its source location and target:
+
+- `[[name]]` cross-references must resolve to a symbol or document
+- Relative links and images must point at a file with documentation or, with
+  a PAL, at an existing file. Without a PAL there is no way to tell whether an
+  image, asset or `LICENSE` exists, so only links to pages are checked. A
+  `#fragment` must be the anchor of a heading on the target page
+- With `check_external`, `http(s)` URLs are requested with HEAD requests.
+  Timeouts, connection errors and non-2xx responses are problems. Each URL is
+  requested once, with a bounded number of requests in flight
+
+With render options (see `LinkCheckOptions::with_render_options`), links are
+checked against the site as it is rendered: its documents after transformers
+and profiles, its heading anchors, and the pages where the output mode and the
+path mapper place them. A link to `guide.md.html` is only valid if the page is
+written there, which is not the case in the flat layout.
+
+Running `hyperlit check-links` (with `--external` for URLs) exits with code 1 if
+there are problems, so it can run as a CI step. External URLs are not checked
+by default, since they make the check slow and dependent on the network.
+*/
+
+use std::borrow::Cow;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::fmt;
+use std::sync::{Arc, LazyLock};
+use std::time::Duration;
+
+use percent_encoding::percent_decode_str;
+use pulldown_cmark::{Event, Parser, Tag, TagEnd};
+use regex::Regex;
+
+use hyperlit_base::{FilePath, PalHandle};
+
+use crate::conditional::apply_profiles_to_all;
+use crate::export::LineMap;
+use crate::footnote::PARSER_OPTIONS;
+use crate::include::resolve_relative_path;
+use crate::parallel::parallel_map;
+use crate::render::site_documents;
+use crate::toc::sorted_by_source;
+use crate::xref::{TextPart, split_references};
+use crate::{DefaultSlugifier, Document, RenderOptions, SiteMap, Slugifier, page_path};
+
+/// Default timeout of HEAD requests to external URLs, in seconds.
+pub const DEFAULT_LINK_CHECK_TIMEOUT_SECONDS: u64 = 10;
+
+/// Default number of HEAD requests to external URLs in flight at the same time.
+pub const DEFAULT_LINK_CHECK_CONCURRENCY: usize = 8;
+
+/// A link that does not lead anywhere.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct LinkProblem {
+    /// Source file containing the link
+    pub file_path: FilePath,
+    /// Source line of the link (1-indexed)
+    pub line: usize,
+    /// Link target as written, e.g. `[[name]]`, `../guide.md#setup` or a URL
+    pub target: String,
+    /// Description of the problem
+    pub message: String,
+}
+
+impl fmt::Display for LinkProblem {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "{}:{}: broken link '{}': {}",
+            self.file_path, self.line, self.target, self.message
+        )
+    }
+}
+
+/// Options controlling which links are checked and how.
+#[derive(Debug, Clone, Default)]
+pub struct LinkCheckOptions {
+    pal: Option<PalHandle>,
+    check_external: bool,
+    concurrency: Option<usize>,
+    timeout: Option<Duration>,
+    slugifier: Option<Arc<dyn Slugifier>>,
+    render_options: Option<RenderOptions>,
+}
+
+impl LinkCheckOptions {
+    /// Create link check options with default settings.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Check the existence of linked files and external URLs through `pal`.
+    ///
+    /// Without a PAL, only relative links to files with documentation are
+    /// checked and external URLs are not checked.
+    pub fn with_pal(mut self, pal: PalHandle) -> Self {
+        self.pal = Some(pal);
+        self
+    }
+
+    /// Set whether `http(s)` URLs are checked with HEAD requests (off by default).
+    pub fn with_check_external(mut self, check_external: bool) -> Self {
+        self.check_external = check_external;
+        self
+    }
+
+    /// Set the number of HEAD requests in flight at the same time.
+    ///
+    /// Defaults to [`DEFAULT_LINK_CHECK_CONCURRENCY`].
+    pub fn with_concurrency(mut self, concurrency: usize) -> Self {
+        self.concurrency = Some(concurrency);
+        self
+    }
+
+    /// Set the time after which a HEAD request counts as failed.
+    ///
+    /// Defaults to [`DEFAULT_LINK_CHECK_TIMEOUT_SECONDS`].
+    pub fn with_timeout(mut self, timeout: Duration) -> Self {
+        self.timeout = Some(timeout);
+        self
+    }
+
+    /// Derive heading anchors with `slugifier`, as configured for rendering.
+    pub fn with_slugifier(mut self, slugifier: impl Slugifier + 'static) -> Self {
+        self.slugifier = Some(Arc::new(slugifier));
+        self
+    }
+
+    /// Check links against the site rendered with `render_options`.
+    ///
+    /// The documents are transformed and their profiles applied as for
+    /// rendering, and anchors and page paths follow the options, overriding
+    /// [`with_slugifier`](Self::with_slugifier).
+    pub fn with_render_options(mut self, render_options: RenderOptions) -> Self {
+        self.render_options = Some(render_options);
+        self
+    }
+
+    /// Returns the number of HEAD requests in flight at the same time.
+    pub fn concurrency(&self) -> usize {
+        self.concurrency.unwrap_or(DEFAULT_LINK_CHECK_CONCURRENCY)
+    }
+
+    /// Returns the timeout of HEAD requests.
+    pub fn timeout(&self) -> Duration {
+        self.timeout
+            .unwrap_or(Duration::from_secs(DEFAULT_LINK_CHECK_TIMEOUT_SECONDS))
+    }
+
+    /// Returns the output path of the page for a source file.
+    fn page_path(&self, source_path: &FilePath) -> FilePath {
+        match &self.render_options {
+            Some(render_options) => render_options.page_path(source_path),
+            None => page_path(source_path),
+        }
+    }
+}
+
+/// Check the cross-references and relative links of all documents.
+///
+/// Returns the broken links ordered by file and line. External URLs are not
+/// checked, see [`check_links_with_options`].
+pub fn check_links(documents: &[Document]) -> Vec<LinkProblem> {
+    check_links_with_options(documents, &LinkCheckOptions::default())
+}
+
+/// Check the links of all documents.
+///
+/// Behaves like [`check_links`], but linked files are looked up and external
+/// URLs requested as configured in `options`.
+pub fn check_links_with_options(
+    documents: &[Document],
+    options: &LinkCheckOptions,
+) -> Vec<LinkProblem> {
+    let (documents, site_map) = match &options.render_options {
+        Some(render_options) => {
+            let (documents, _) = site_documents(documents, render_options);
+            let documents =
+                apply_profiles_to_all(&documents, render_options.profiles()).into_owned();
+            let site_map = SiteMap::build_with_options(&documents, render_options);
+            (Cow::Owned(documents), site_map)
+        }
+        None => {
+            let slugifier = options.slugifier.as_deref().unwrap_or(&DefaultSlugifier);
+            let site_map = SiteMap::build_with_slugifier(documents, slugifier);
+            (Cow::Borrowed(documents), site_map)
+        }
+    };
+    let pages = page_anchors(&documents, &site_map);
+    let output_pages: HashMap<FilePath, FilePath> = pages
+        .keys()
+        .map(|source_path| (options.page_path(source_path), source_path.clone()))
+        .collect();
+
+    let mut problems = Vec::new();
+    // External URLs with the locations linking to them, requested once each
+    let mut external: BTreeMap<&str, Vec<(FilePath, usize)>> = BTreeMap::new();
+    let links: Vec<(&Document, Vec<Link>)> = sorted_by_source(&documents)
+        .into_iter()
+        .map(|document| (document, collect_links(document)))
+        .collect();
+    for (document, document_links) in &links {
+        let file_path = document.source().file_path();
+        for link in document_links {
+            let message = match link.kind {
+                LinkKind::Reference => site_map
+                    .symbols
+                    .resolve(&link.target)
+                    .is_none()
+                    .then(|| "no symbol or document with this name".to_string()),
+                LinkKind::Url => {
+                    external
+                        .entry(link.target.as_str())
+                        .or_default()
+                        .push((file_path.clone(), link.line));
+                    None
+                }
+                LinkKind::Relative => {
+                    check_relative(file_path, &link.target, &pages, &output_pages, options)
+                }
+                LinkKind::Other => None,
+            };
+            if let Some(message) = message {
+                problems.push(LinkProblem {
+                    file_path: file_path.clone(),
+                    line: link.line,
+                    target: link.display_target(),
+                    message,
+                });
+            }
+        }
+    }
+
+    if options.check_external
+        && let Some(pal) = &options.pal
+    {
+        let urls: Vec<&str> = external.keys().copied().collect();
+        let results = parallel_map(&urls, options.concurrency(), |url| {
+            match pal.http_head(url, options.timeout()) {
+                Ok(status) if (200..300).contains(&status) => None,
+                Ok(status) => Some(format!("HTTP status {}", status)),
+                Err(e) => Some(e.to_string()),
+            }
+        });
+        for (url, message) in urls.into_iter().zip(results) {
+            let Some(message) = message else {
+                continue;
+            };
+            for (file_path, line) in &external[url] {
+                problems.push(LinkProblem {
+                    file_path: file_path.clone(),
+                    line: *line,
+                    target: url.to_string(),
+                    message: message.clone(),
+                });
+            }
+        }
+    }
+
+    problems.sort_by(|a, b| {
+        (a.file_path.as_relative().as_str(), a.line)
+            .cmp(&(b.file_path.as_relative().as_str(), b.line))
+    });
+    problems
+}
+
+/// Returns the heading anchors of every source file with documentation.
+fn page_anchors<'a>(
+    documents: &'a [Document],
+    site_map: &'a SiteMap,
+) -> HashMap<FilePath, HashSet<&'a str>> {
+    let mut pages: HashMap<FilePath, HashSet<&str>> = HashMap::new();
+    for document in documents {
+        pages
+            .entry(document.source().file_path().clone())
+            .or_default()
+            .extend(
+                site_map
+                    .toc
+                    .anchors(document.id())
+                    .iter()
+                    .map(String::as_str),
+            );
+    }
+    pages
+}
+
+/// Check a relative link written in `source_path`, returns the problem if it is broken.
+///
+/// The link may point at the source file of a page or at the page itself
+/// (e.g. `lib.rs` or `lib.rs.html`). Links to pages are resolved against the
+/// output path of the linking page, `output_pages` maps page paths to sources.
+fn check_relative(
+    source_path: &FilePath,
+    target: &str,
+    pages: &HashMap<FilePath, HashSet<&str>>,
+    output_pages: &HashMap<FilePath, FilePath>,
+    options: &LinkCheckOptions,
+) -> Option<String> {
+    let (path, fragment) = target.split_once('#').unwrap_or((target, ""));
+    let path = path.split('?').next().unwrap_or_default();
+    let path = percent_decode_str(path).decode_utf8_lossy();
+    let resolve = |base: &FilePath| {
+        if path.is_empty() {
+            base.clone()
+        } else if let Some(root_relative) = path.strip_prefix('/') {
+            FilePath::from(root_relative)
+        } else {
+            resolve_relative_path(base, &path)
+        }
+    };
+    let file_path = resolve(source_path);
+    let page = pages.get_key_value(&file_path).or_else(|| {
+        let page_source = output_pages.get(&resolve(&options.page_path(source_path)))?;
+        pages.get_key_value(page_source)
+    });
+    match page {
+        Some((page_source, anchors)) => (!fragment.is_empty() && !anchors.contains(fragment))
+            .then(|| format!("no heading with anchor '{}' in {}", fragment, page_source)),
+        // Without a PAL files other than pages cannot be looked up
+        None => {
+            let exists = options
+                .pal
+                .as_ref()
+                .is_none_or(|pal| pal.file_exists(&file_path).unwrap_or(false));
+            (!exists).then(|| format!("{} not found", file_path))
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum LinkKind {
+    /// A `[[name]]` cross-reference
+    Reference,
+    /// An `http` or `https` URL
+    Url,
+    /// A path, relative to the linking file or the site root
+    Relative,
+    /// A URL with another scheme (e.g. `mailto:`), not checked
+    Other,
+}
+
+/// A link found in a document.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Link {
+    kind: LinkKind,
+    /// Reference name or link destination
+    target: String,
+    /// Source line of the link
+    line: usize,
+}
+
+impl Link {
+    fn display_target(&self) -> String {
+        match self.kind {
+            LinkKind::Reference => format!("[[{}]]", self.target),
+            _ => self.target.clone(),
+        }
+    }
+}
+
+static SCHEME: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"^[A-Za-z][A-Za-z0-9+.-]*:").expect("valid regex"));
+
+fn link_kind(destination: &str) -> LinkKind {
+    if destination.starts_with("http://") || destination.starts_with("https://") {
+        LinkKind::Url
+    } else if SCHEME.is_match(destination) || destination.starts_with("//") {
+        LinkKind::Other
+    } else {
+        LinkKind::Relative
+    }
+}
+
+/// Collect the cross-references, links and images of a document.
+///
+/// References in code are not links, just like when rendering.
+fn collect_links(document: &Document) -> Vec<Link> {
+    let content = document.content();
+    let lines = LineMap::new(content, document.source().line_number());
+    let mut links = Vec::new();
+    // Adjacent text events (and the offset of the first), merged to find references
+    let mut pending_text: Option<(String, usize)> = None;
+    let mut in_code_block = false;
+    let flush_text = |pending_text: &mut Option<(String, usize)>, links: &mut Vec<Link>| {
+        let Some((text, start)) = pending_text.take() else {
+            return;
+        };
+        for part in split_references(&text) {
+            if let TextPart::Reference { name, offset, .. } = part {
+                links.push(Link {
+                    kind: LinkKind::Reference,
+                    target: name.to_string(),
+                    line: lines.line_of(start + offset),
+                });
+            }
+        }
+    };
+    // With footnotes enabled, a `[^1]: text` definition is not a link to `text`
+    for (event, range) in Parser::new_ext(content, PARSER_OPTIONS).into_offset_iter() {
+        if let Event::Text(text) = &event
+            && !in_code_block
+        {
+            pending_text
+                .get_or_insert_with(|| (String::new(), range.start))
+                .0
+                .push_str(text);
+            continue;
+        }
+        flush_text(&mut pending_text, &mut links);
+        match event {
+            Event::Start(Tag::CodeBlock(_)) => in_code_block = true,
+            Event::End(TagEnd::CodeBlock) => in_code_block = false,
+            Event::Start(Tag::Link { dest_url, .. } | Tag::Image { dest_url, .. })
+                if !dest_url.is_empty() =>
+            {
+                links.push(Link {
+                    kind: link_kind(&dest_url),
+                    target: dest_url.to_string(),
+                    line: lines.line_of(range.start),
+                });
+            }
+            _ => {}
+        }
+    }
+    flush_text(&mut pending_text, &mut links);
+    links
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use hyperlit_base::pal::MockPal;
+
+    fn doc(path: &str, line: usize, title: &str, content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), line);
+        Document::new(
+            title.to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    fn format(problems: &[LinkProblem]) -> Vec<String> {
+        problems.iter().map(ToString::to_string).collect()
+    }
+
+    #[test]
+    fn test_check_links_cross_references() {
+        let documents = vec![
+            doc(
+                "src/greet.rs",
+                3,
+                "Why greet?",
+                "# Why greet?\n\nSee [[Config]] and\n[[missing]].\n\n```text\n[[ignored]]\n```\n\n`[[code]]`\n",
+            ),
+            doc("src/config.rs", 1, "Config", "# Config\n").with_symbol("Config"),
+        ];
+
+        assert_eq!(
+            format(&check_links(&documents)),
+            ["src/greet.rs:6: broken link '[[missing]]': no symbol or document with this name"]
+        );
+    }
+
+    #[test]
+    fn test_check_links_relative_links() {
+        let documents = vec![
+            doc(
+                "docs/guide.md",
+                1,
+                "Guide",
+                concat!(
+                    "# Guide\n\n## Setup\n\n",
+                    "[a](../src/lib.rs) [b](../src/lib.rs.html#api) [c](#setup)\n",
+                    "[d](/src/lib.rs#usage) [e](missing.md) [f](#nope)\n",
+                    "![logo](img/logo.png) [mail](mailto:a@b.c) [g](other.md#x)\n",
+                    // A footnote, not a link to `notes.md`
+                    "\nSee[^note].\n\n[^note]: notes.md\n",
+                ),
+            ),
+            doc("src/lib.rs", 1, "Lib", "# API\n\n## Install\n"),
+        ];
+
+        assert_eq!(
+            format(&check_links(&documents)),
+            [
+                "docs/guide.md:6: broken link '/src/lib.rs#usage': no heading with anchor 'usage' in src/lib.rs",
+                "docs/guide.md:6: broken link '#nope': no heading with anchor 'nope' in docs/guide.md",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_check_links_existing_files_with_pal() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("docs/img/logo.png"), b"PNG".to_vec());
+        let options = LinkCheckOptions::new().with_pal(PalHandle::new(mock_pal));
+        let documents = vec![doc(
+            "docs/guide.md",
+            1,
+            "Guide",
+            "![logo](img/logo.png) ![x](img/x%20y.png) [m](missing.md#x)\n",
+        )];
+
+        assert_eq!(
+            format(&check_links_with_options(&documents, &options)),
+            [
+                "docs/guide.md:1: broken link 'img/x%20y.png': docs/img/x y.png not found",
+                "docs/guide.md:1: broken link 'missing.md#x': docs/missing.md not found",
+            ]
+        );
+        // Without a PAL, only links to pages are checked
+        assert!(check_links(&documents).is_empty());
+    }
+
+    #[test]
+    fn test_check_links_external_urls() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_http_status("https://example.com/ok", 200);
+        mock_pal.add_http_status("https://example.com/gone", 404);
+        let pal = PalHandle::new(mock_pal.clone());
+        let documents = vec![
+            doc(
+                "a.md",
+                1,
+                "A",
+                "[ok](https://example.com/ok) [gone](https://example.com/gone)\n",
+            ),
+            doc(
+                "b.md",
+                1,
+                "B",
+                "[gone](https://example.com/gone)\n\n<http://down.example>\n",
+            ),
+        ];
+
+        assert!(
+            check_links_with_options(&documents, &LinkCheckOptions::new().with_pal(pal.clone()))
+                .is_empty()
+        );
+        assert!(mock_pal.requested_urls().is_empty());
+
+        let options = LinkCheckOptions::new()
+            .with_pal(pal)
+            .with_check_external(true)
+            .with_concurrency(2);
+        assert_eq!(
+            format(&check_links_with_options(&documents, &options)),
+            [
+                "a.md:1: broken link 'https://example.com/gone': HTTP status 404",
+                "b.md:1: broken link 'https://example.com/gone': HTTP status 404",
+                "b.md:3: broken link 'http://down.example': Request to 'http://down.example' failed: not found",
+            ]
+        );
+        let mut requested = mock_pal.requested_urls();
+        requested.sort();
+        assert_eq!(
+            requested,
+            [
+                "http://down.example",
+                "https://example.com/gone",
+                "https://example.com/ok"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_check_links_with_render_options() {
+        // Flat pages are all at the root of the site
+        let flat_page = crate::flat_page_slug(&FilePath::from("docs/b.md"));
+        let documents = vec![
+            doc(
+                "docs/a.md",
+                1,
+                "A",
+                &format!(
+                    "# A\n\n[b](b.md.html) [flat]({}.html#setup) [pro](b.md#pro)\n",
+                    flat_page
+                ),
+            ),
+            doc(
+                "docs/b.md",
+                1,
+                "B",
+                "# B\n\n## Setup\n\n{{if pro}}\n## Pro\n{{end}}\n",
+            ),
+        ];
+        let pal = PalHandle::new(MockPal::new());
+        let options = LinkCheckOptions::new()
+            .with_pal(pal.clone())
+            .with_render_options(
+                RenderOptions::new()
+                    .with_output_mode(crate::OutputMode::Flat)
+                    .with_profiles(["free"]),
+            );
+
+        assert_eq!(
+            format(&check_links_with_options(&documents, &options)),
+            [
+                "docs/a.md:3: broken link 'b.md.html': docs/b.md.html not found",
+                "docs/a.md:3: broken link 'b.md#pro': no heading with anchor 'pro' in docs/b.md",
+            ]
+        );
+        // Without render options, the mirrored page and every heading exist
+        assert_eq!(
+            format(&check_links_with_options(
+                &documents,
+                &LinkCheckOptions::new().with_pal(pal)
+            )),
+            [format!(
+                "docs/a.md:3: broken link '{}.html#setup': docs/{}.html not found",
+                flat_page, flat_page
+            )]
+        );
+    }
+
+    #[test]
+    fn test_check_links_uses_slugifier() {
+        let documents = vec![doc(
+            "a.md",
+            1,
+            "A",
+            "# Getting Started\n\n[x](#getting_started)\n",
+        )];
+        let options = LinkCheckOptions::new()
+            .with_slugifier(crate::FnSlugifier::new("underscore", |text: &str| {
+                text.to_lowercase().replace(' ', "_")
+            }));
+
+        assert!(check_links_with_options(&documents, &options).is_empty());
+        assert_eq!(check_links(&documents).len(), 1);
+    }
+}
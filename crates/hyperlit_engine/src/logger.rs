@@ -0,0 +1,205 @@
+/* 📖 # Why a pluggable logger?
+
+Extraction and rendering are fail-tolerant: a broken cross-reference, a missing
+include or a heading clamped to level 6 does not stop the build, it is reported
+and the content is rendered as well as possible. Those reports are collected in
+`ExtractionResult::errors` and `RenderResult::warnings`, but only handed over
+once everything is done, and an application embedding hyperlit wants them in
+its own log, next to everything else it does.
+
+Problems are therefore also reported to a `Logger` set in the extraction and
+render options, as they are found. A log entry has a level (debug, info, warn,
+error), a message and key-value fields, including the source location of the
+problem (`file`, `line` and, if known, `column`), so it can be forwarded to any
+structured logging library by implementing the trait. Library users get a
+`NoopLogger` by default, nothing is written unless they ask for it. The CLI
+uses the `StderrLogger`, which writes each entry as a line to stderr, keeping
+stdout for the regular output.
+
+The `tracing` spans and events of the engine are unaffected. They trace what
+hyperlit itself is doing, for debugging hyperlit, whereas the logger reports
+problems of the documentation being built, for its authors.
+*/
+
+use std::fmt;
+use std::io::Write;
+
+/// Severity of a log entry, from least to most severe.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, PartialOrd, Ord, Hash)]
+pub enum LogLevel {
+    /// Details of what is being done
+    Debug,
+    /// Progress worth knowing about
+    #[default]
+    Info,
+    /// A problem that does not stop the build, e.g. an unresolved reference
+    Warn,
+    /// A problem that fails the build or leaves out part of the site
+    Error,
+}
+
+impl fmt::Display for LogLevel {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            LogLevel::Debug => "debug",
+            LogLevel::Info => "info",
+            LogLevel::Warn => "warning",
+            LogLevel::Error => "error",
+        })
+    }
+}
+
+/// Key-value fields of a log entry, e.g. `[("file", &path), ("line", &line)]`.
+pub type LogFields<'a> = [(&'a str, &'a dyn fmt::Display)];
+
+/// Receives the log entries of extraction and rendering, see [`crate::logger`].
+pub trait Logger: fmt::Debug + Send + Sync {
+    /// Record a log entry.
+    fn log(&self, level: LogLevel, message: &str, fields: &LogFields<'_>);
+
+    /// Record a debug entry.
+    fn debug(&self, message: &str, fields: &LogFields<'_>) {
+        self.log(LogLevel::Debug, message, fields);
+    }
+
+    /// Record an info entry.
+    fn info(&self, message: &str, fields: &LogFields<'_>) {
+        self.log(LogLevel::Info, message, fields);
+    }
+
+    /// Record a warning.
+    fn warn(&self, message: &str, fields: &LogFields<'_>) {
+        self.log(LogLevel::Warn, message, fields);
+    }
+
+    /// Record an error.
+    fn error(&self, message: &str, fields: &LogFields<'_>) {
+        self.log(LogLevel::Error, message, fields);
+    }
+}
+
+/// Discards all log entries, the default logger.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct NoopLogger;
+
+impl Logger for NoopLogger {
+    fn log(&self, _level: LogLevel, _message: &str, _fields: &LogFields<'_>) {}
+}
+
+/// Writes log entries at or above a minimum level to stderr, one per line.
+///
+/// Fields follow the message as `key=value`, values are quoted if they contain
+/// whitespace: `warning: Unresolved reference 'pool' file=src/lib.rs line=12`.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct StderrLogger {
+    min_level: LogLevel,
+}
+
+impl StderrLogger {
+    /// Create a logger writing entries at info level and above.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Set the least severe level written (defaults to [`LogLevel::Info`]).
+    pub fn with_min_level(mut self, min_level: LogLevel) -> Self {
+        self.min_level = min_level;
+        self
+    }
+
+    /// Returns the least severe level written.
+    pub fn min_level(&self) -> LogLevel {
+        self.min_level
+    }
+}
+
+impl Logger for StderrLogger {
+    fn log(&self, level: LogLevel, message: &str, fields: &LogFields<'_>) {
+        if level < self.min_level {
+            return;
+        }
+        let line = format_entry(level, message, fields);
+        // A log line that cannot be written has nowhere else to go
+        let _ = writeln!(std::io::stderr().lock(), "{}", line);
+    }
+}
+
+/// Format a log entry as a single line, see [`StderrLogger`].
+fn format_entry(level: LogLevel, message: &str, fields: &LogFields<'_>) -> String {
+    let mut line = format!("{}: {}", level, message);
+    for (key, value) in fields {
+        let value = value.to_string();
+        if value.is_empty() || value.contains(char::is_whitespace) {
+            line.push_str(&format!(" {}={:?}", key, value));
+        } else {
+            line.push_str(&format!(" {}={}", key, value));
+        }
+    }
+    line
+}
+
+#[cfg(test)]
+pub(crate) mod tests {
+    use super::*;
+    use std::sync::{Arc, Mutex};
+
+    /// Records log entries as formatted lines, for tests.
+    #[derive(Debug, Clone, Default)]
+    pub(crate) struct RecordingLogger {
+        entries: Arc<Mutex<Vec<String>>>,
+    }
+
+    impl RecordingLogger {
+        /// Returns the lines logged so far.
+        pub(crate) fn entries(&self) -> Vec<String> {
+            self.entries.lock().unwrap().clone()
+        }
+    }
+
+    impl Logger for RecordingLogger {
+        fn log(&self, level: LogLevel, message: &str, fields: &LogFields<'_>) {
+            let line = format_entry(level, message, fields);
+            self.entries.lock().unwrap().push(line);
+        }
+    }
+
+    #[test]
+    fn test_format_entry() {
+        assert_eq!(
+            format_entry(
+                LogLevel::Warn,
+                "Unresolved reference 'pool'",
+                &[("file", &"src/lib.rs"), ("line", &12)]
+            ),
+            "warning: Unresolved reference 'pool' file=src/lib.rs line=12"
+        );
+        assert_eq!(
+            format_entry(
+                LogLevel::Error,
+                "Failed to extract file",
+                &[("error", &"no such file"), ("hint", &"")]
+            ),
+            "error: Failed to extract file error=\"no such file\" hint=\"\""
+        );
+    }
+
+    #[test]
+    fn test_levels() {
+        let logger = RecordingLogger::default();
+        logger.debug("Extracted", &[]);
+        logger.info("Rendered", &[]);
+        logger.warn("Clamped", &[]);
+        logger.error("Failed", &[]);
+        assert_eq!(
+            logger.entries(),
+            [
+                "debug: Extracted",
+                "info: Rendered",
+                "warning: Clamped",
+                "error: Failed"
+            ]
+        );
+        assert!(LogLevel::Debug < LogLevel::Info && LogLevel::Warn < LogLevel::Error);
+        assert_eq!(StderrLogger::new().min_level(), LogLevel::Info);
+    }
+}
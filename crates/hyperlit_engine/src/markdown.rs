@@ -0,0 +1,738 @@
+/* 📖 # Why render the woven site as Markdown?
+
+HTML is the end of the line, but many documentation pipelines (e.g. Pandoc to
+PDF) start from Markdown. The `MarkdownRenderer` produces the same site as the
+HTML renderer, one `<source path>.md` page per source file plus an `index.md`
+with the table of contents, as GitHub-flavored Markdown.
+
+Documents already are Markdown, so pages are not generated from the parsed
+events (which loses the original formatting) but by editing the source text in
+the few places where weaving adds something:
+
+- Headings become ATX headings with their page-unique anchor as a Pandoc header
+  attribute (`## Setup {#setup-1}`), so links to them keep working. The heading
+  level and text are left untouched
+- `[[name]]` references become standard `[name](page.md#anchor)` links
+- Include and exec directives become fenced code blocks, as does the code
+  following a doc comment (see `include_following_code`)
+
+Everything else, in particular fenced code blocks with their language tags and
+contents, is copied verbatim. Extracting a page again therefore yields the same
+headings and code blocks. With `OutputMode::SingleFile` all pages are written
+one after another into `index.md`, separated by thematic breaks.
+*/
+
+use std::ops::Range;
+
+use pulldown_cmark::{Event, HeadingLevel, Parser, Tag, TagEnd};
+
+use hyperlit_base::FilePath;
+
+use crate::conditional::apply_profiles;
+use crate::directive::{fenced_code_block, paragraph_expansion};
+use crate::export::LineMap;
+use crate::flat::{MANIFEST_FILE, render_manifest};
+use crate::parallel::parallel_map;
+use crate::path_mapper::drop_colliding_pages;
+use crate::render::{UNRESOLVED_REFERENCE, clamped_heading_warning, page_title, relative_root};
+use crate::toc::offset_heading_level;
+use crate::transform::apply_transformers;
+use crate::xref::{LinkTarget, TextPart, split_references};
+use crate::{
+    Document, OutputMode, RenderOptions, RenderResult, RenderWarning, RenderedFile, Renderer,
+    SiteMap, Toc, TocEntry,
+};
+
+/// Name of the table of contents page of the Markdown output.
+pub const MARKDOWN_INDEX_PAGE: &str = "index.md";
+
+/// Returns the output path of the Markdown page for a source file.
+///
+/// # Examples
+/// ```
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::markdown_page_path;
+///
+/// assert_eq!(markdown_page_path(&FilePath::from("src/lib.rs")), FilePath::from("src/lib.rs.md"));
+/// ```
+pub fn markdown_page_path(source_path: &FilePath) -> FilePath {
+    FilePath::from(format!("{}.md", source_path))
+}
+
+/// Returns the output path of the Markdown page for a source file with `options`.
+fn mode_page_path(source_path: &FilePath, options: &RenderOptions) -> FilePath {
+    options.page_path_with_extension(source_path, "md")
+}
+
+/// Renders the site as GitHub-flavored Markdown.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct MarkdownRenderer;
+
+impl MarkdownRenderer {
+    /// Create a Markdown renderer.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Renderer for MarkdownRenderer {
+    fn name(&self) -> &'static str {
+        "markdown"
+    }
+
+    /// Render the index page and one page per source file, in reading order.
+    ///
+    /// With [`OutputMode::SingleFile`], only `index.md` is returned, containing
+    /// the index followed by all pages. With [`OutputMode::Flat`], the `index.json`
+    /// manifest follows the index.
+    fn render_site(&self, documents: &[Document], options: &RenderOptions) -> RenderResult {
+        let (documents, mut transform_warnings) = apply_transformers(documents, options);
+        let (documents, collision_warnings) = drop_colliding_pages(
+            documents,
+            &[MARKDOWN_INDEX_PAGE, MANIFEST_FILE],
+            |source_path| mode_page_path(source_path, options),
+        );
+        transform_warnings.extend(collision_warnings);
+        let documents = &*documents;
+        let site_map = SiteMap::build_with_options(documents, options);
+        let mode = options.output_mode();
+        let groups = site_map.pages(documents);
+        transform_warnings.extend(site_map.unlisted_warnings());
+        let pages = parallel_map(
+            &groups,
+            options.concurrency(),
+            |(source_path, file_documents)| {
+                let page = mode_page_path(source_path, options);
+                let mut warnings = Vec::new();
+                let content = render_page(
+                    source_path,
+                    file_documents,
+                    &page,
+                    &site_map,
+                    options,
+                    &mut warnings,
+                );
+                (page, content, warnings)
+            },
+        );
+
+        let mut index = format!("# {}\n\n", escape_markdown(options.title()));
+        render_toc_parts(&site_map.toc, options, &mut index);
+        let mut result = RenderResult {
+            files: Vec::new(),
+            warnings: transform_warnings,
+            assets: Vec::new(),
+        };
+        match mode {
+            OutputMode::MultiFile | OutputMode::Flat => {
+                result.files.push(RenderedFile {
+                    path: FilePath::from(MARKDOWN_INDEX_PAGE),
+                    content: index,
+                });
+                if mode == OutputMode::Flat {
+                    let mut manifest = vec![(
+                        None,
+                        FilePath::from(MARKDOWN_INDEX_PAGE),
+                        options.title().to_string(),
+                    )];
+                    manifest.extend(groups.iter().zip(&pages).map(
+                        |((source_path, file_documents), (path, _, _))| {
+                            (
+                                Some(source_path.clone()),
+                                path.clone(),
+                                page_title(source_path, file_documents),
+                            )
+                        },
+                    ));
+                    result.files.push(render_manifest(manifest));
+                }
+                for (path, content, warnings) in pages {
+                    result.files.push(RenderedFile { path, content });
+                    result.warnings.extend(warnings);
+                }
+            }
+            OutputMode::SingleFile => {
+                for (path, content, warnings) in pages {
+                    index.push_str(&format!("\n---\n\n<a id=\"{}\"></a>\n\n", path));
+                    index.push_str(&content);
+                    result.warnings.extend(warnings);
+                }
+                result.files.push(RenderedFile {
+                    path: FilePath::from(MARKDOWN_INDEX_PAGE),
+                    content: index,
+                });
+            }
+        }
+        for warning in &result.warnings {
+            warning.log(options.logger());
+        }
+        result
+    }
+}
+
+/// Render the documents of one source file, in source order, as shown on `page`.
+fn render_page(
+    source_path: &FilePath,
+    documents: &[&Document],
+    page: &FilePath,
+    site_map: &SiteMap,
+    options: &RenderOptions,
+    warnings: &mut Vec<RenderWarning>,
+) -> String {
+    let mut sorted = documents.to_vec();
+    sorted.sort_by_key(|document| document.source().line_number());
+
+    let mut content = String::new();
+    for document in sorted {
+        if !content.is_empty() {
+            content.push('\n');
+        }
+        content.push_str(render_document(document, page, site_map, options, warnings).trim_end());
+        if let Some(following_code) = document.following_code() {
+            content.push_str("\n\n");
+            content.push_str(&fenced_code_block(
+                following_code.language.as_deref().unwrap_or_default(),
+                &following_code.code,
+            ));
+        }
+        content.push_str(&format!(
+            "\n\n*{}:{}*\n",
+            escape_markdown(&source_path.to_string()),
+            document.source().line_number()
+        ));
+    }
+    content
+}
+
+/// A replacement of part of the document content.
+struct Edit {
+    range: Range<usize>,
+    replacement: String,
+}
+
+/// Render the content of a document as Markdown, as shown on `page`.
+fn render_document(
+    document: &Document,
+    page: &FilePath,
+    site_map: &SiteMap,
+    options: &RenderOptions,
+    warnings: &mut Vec<RenderWarning>,
+) -> String {
+    let document = &*apply_profiles(document, options.profiles());
+    let content = document.content();
+    let lines = LineMap::new(content, document.source().line_number());
+    let mut anchors = site_map.toc.anchors(document.id()).iter();
+    let mut edits = Vec::new();
+    // Ranges whose text is copied verbatim, even if it looks like a reference
+    let mut verbatim: Vec<Range<usize>> = Vec::new();
+    // Level, range and inline content range of the current heading
+    let mut heading: Option<(HeadingLevel, Range<usize>, Option<Range<usize>>)> = None;
+
+    for (event, range) in Parser::new(content).into_offset_iter() {
+        if let Some((_, _, inner)) = &mut heading
+            && !matches!(event, Event::End(TagEnd::Heading(_)))
+        {
+            match inner {
+                Some(inner) => inner.end = inner.end.max(range.end),
+                None => *inner = Some(range.clone()),
+            }
+        }
+        match event {
+            Event::Start(Tag::Heading { level, .. }) => heading = Some((level, range, None)),
+            Event::End(TagEnd::Heading(_)) => {
+                if let Some((level, range, inner)) = heading.take() {
+                    let (shifted, clamped) = offset_heading_level(level, options.heading_offset());
+                    if clamped {
+                        warnings.push(clamped_heading_warning(
+                            document,
+                            lines.line_of(range.start),
+                            level,
+                            options.heading_offset(),
+                        ));
+                    }
+                    edits.extend(heading_edits(
+                        content,
+                        shifted,
+                        shifted != level,
+                        range,
+                        inner,
+                        anchors.next(),
+                    ));
+                }
+            }
+            Event::Start(Tag::Paragraph) => {
+                if let Some(replacement) = directive_replacement(document, &content[range.clone()])
+                {
+                    let end = range.start + content[range.clone()].trim_end().len();
+                    edits.push(Edit {
+                        range: range.start..end,
+                        replacement,
+                    });
+                    verbatim.push(range);
+                }
+            }
+            Event::Start(Tag::CodeBlock(_)) | Event::Code(_) | Event::Html(_) => {
+                verbatim.push(range)
+            }
+            _ => {}
+        }
+    }
+
+    for part in split_references(content) {
+        let TextPart::Reference {
+            name,
+            offset,
+            length,
+        } = part
+        else {
+            continue;
+        };
+        if verbatim.iter().any(|range| range.contains(&offset)) {
+            continue;
+        }
+        match site_map.symbols.resolve(name) {
+            Some(target) => edits.push(Edit {
+                range: offset..offset + length,
+                replacement: format!(
+                    "[{}]({})",
+                    escape_markdown(name),
+                    link_href(page, target, options)
+                ),
+            }),
+            None => warnings.push(RenderWarning {
+                file_path: document.source().file_path().clone(),
+                line: lines.line_of(offset),
+                message: format!("{} '[[{}]]'", UNRESOLVED_REFERENCE, name),
+            }),
+        }
+    }
+
+    apply_edits(content, edits)
+}
+
+/// Edits turning a heading into an ATX heading with `anchor` as header attribute.
+///
+/// The inline content of the heading is left as it is. Setext headings are kept
+/// unless their level was `shifted` by the heading offset.
+fn heading_edits(
+    content: &str,
+    level: HeadingLevel,
+    shifted: bool,
+    range: Range<usize>,
+    inner: Option<Range<usize>>,
+    anchor: Option<&String>,
+) -> Vec<Edit> {
+    let marker = "#".repeat(level as usize);
+    let attribute = anchor
+        .map(|anchor| format!(" {{#{}}}", anchor))
+        .unwrap_or_default();
+    let end = range.start + content[range.clone()].trim_end().len();
+    let Some(inner) = inner else {
+        return vec![Edit {
+            range: range.start..end,
+            replacement: format!("{}{}", marker, attribute),
+        }];
+    };
+    if content[range.clone()].starts_with('#') || shifted {
+        // ATX heading: normalize the opening marker, drop an optional closing
+        // sequence (or the underline of a shifted setext heading)
+        vec![
+            Edit {
+                range: range.start..inner.start,
+                replacement: format!("{} ", marker),
+            },
+            Edit {
+                range: inner.end..end,
+                replacement: attribute,
+            },
+        ]
+    } else {
+        // Setext headings are kept, the attribute goes on the text line
+        vec![Edit {
+            range: inner.end..inner.end,
+            replacement: attribute,
+        }]
+    }
+}
+
+/// Returns the markdown replacing a directive paragraph, if the directive was expanded.
+fn directive_replacement(document: &Document, paragraph: &str) -> Option<String> {
+    paragraph_expansion(document, paragraph).map(|expansion| expansion.markdown.clone())
+}
+
+/// Apply non-overlapping edits to `content`.
+fn apply_edits(content: &str, mut edits: Vec<Edit>) -> String {
+    edits.sort_by_key(|edit| (edit.range.start, edit.range.end));
+    let mut output = String::with_capacity(content.len());
+    let mut last = 0;
+    for edit in edits {
+        if edit.range.start < last {
+            continue;
+        }
+        output.push_str(&content[last..edit.range.start]);
+        output.push_str(&edit.replacement);
+        last = edit.range.end;
+    }
+    output.push_str(&content[last..]);
+    output
+}
+
+/// Returns the link from `page` to a cross-reference target.
+fn link_href(page: &FilePath, target: &LinkTarget, options: &RenderOptions) -> String {
+    let target_page = mode_page_path(&target.file_path, options);
+    if options.output_mode() == OutputMode::SingleFile {
+        return match &target.anchor {
+            Some(anchor) => format!("#{}", anchor),
+            None => format!("#{}", target_page),
+        };
+    }
+    let mut href = if target_page == *page {
+        String::new()
+    } else {
+        format!("{}{}", relative_root(page), target_page)
+    };
+    if let Some(anchor) = &target.anchor {
+        href.push('#');
+        href.push_str(anchor);
+    }
+    href
+}
+
+/// Render the TOC as nested lists, below headings with the titles of the parts
+/// of the reading order.
+fn render_toc_parts(toc: &Toc, options: &RenderOptions, out: &mut String) {
+    let mut rest = 0;
+    for part in &toc.parts {
+        if !out.ends_with("\n\n") {
+            out.push('\n');
+        }
+        out.push_str(&format!("## {}\n\n", escape_markdown(&part.title)));
+        render_toc_entries(&toc.entries[part.entries.clone()], options, 0, out);
+        rest = part.entries.end;
+    }
+    if rest > 0 && rest < toc.entries.len() {
+        out.push('\n');
+    }
+    render_toc_entries(&toc.entries[rest..], options, 0, out);
+}
+
+fn render_toc_entries(
+    entries: &[TocEntry],
+    options: &RenderOptions,
+    depth: usize,
+    out: &mut String,
+) {
+    for entry in entries {
+        let page = match options.output_mode() {
+            OutputMode::MultiFile | OutputMode::Flat => {
+                mode_page_path(&entry.file_path, options).to_string()
+            }
+            OutputMode::SingleFile => String::new(),
+        };
+        out.push_str(&format!(
+            "{}- [{}]({}#{})\n",
+            "  ".repeat(depth),
+            escape_markdown(&entry.title),
+            page,
+            entry.anchor
+        ));
+        render_toc_entries(&entry.children, options, depth + 1, out);
+    }
+}
+
+/// Escape characters with a meaning in Markdown inline text.
+fn escape_markdown(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.chars() {
+        if matches!(
+            c,
+            '\\' | '`' | '*' | '_' | '[' | ']' | '<' | '>' | '#' | '!' | '|'
+        ) {
+            escaped.push('\\');
+        }
+        escaped.push(c);
+    }
+    escaped
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{
+        DocumentSource, FollowingCode, Include, ReadingOrder, SourceType, extract_documents,
+    };
+    use expect_test::expect;
+    use hyperlit_base::PalHandle;
+    use hyperlit_base::pal::MockPal;
+    use pulldown_cmark::{CodeBlockKind, Options};
+    use std::collections::HashSet;
+
+    fn doc(path: &str, line: usize, title: &str, content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), line);
+        Document::new(
+            title.to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    fn render(documents: &[Document], options: &RenderOptions) -> RenderResult {
+        MarkdownRenderer::new().render_site(documents, options)
+    }
+
+    fn find<'a>(files: &'a [RenderedFile], path: &str) -> &'a str {
+        &files
+            .iter()
+            .find(|file| file.path == FilePath::from(path))
+            .unwrap()
+            .content
+    }
+
+    /// Heading levels and texts, and code block languages and contents.
+    fn structure(markdown: &str) -> Vec<String> {
+        let mut structure = Vec::new();
+        let mut current: Option<String> = None;
+        for event in Parser::new_ext(markdown, Options::ENABLE_HEADING_ATTRIBUTES) {
+            match event {
+                Event::Start(Tag::Heading { level, .. }) => current = Some(format!("{}: ", level)),
+                Event::Start(Tag::CodeBlock(kind)) => {
+                    let language = match kind {
+                        CodeBlockKind::Fenced(info) => info.to_string(),
+                        CodeBlockKind::Indented => "indented".to_string(),
+                    };
+                    current = Some(format!("code {}: ", language));
+                }
+                Event::Text(text) | Event::Code(text) => {
+                    if let Some(current) = &mut current {
+                        current.push_str(&text);
+                    }
+                }
+                Event::End(TagEnd::Heading(_) | TagEnd::CodeBlock) => {
+                    structure.extend(current.take());
+                }
+                _ => {}
+            }
+        }
+        structure
+    }
+
+    #[test]
+    fn test_render_site_pages() {
+        let documents = vec![
+            doc(
+                "src/greet.rs",
+                1,
+                "Greeting",
+                "# Greeting\n\nSays hello, see [[main]] and `[[not_a_reference]]`.\n\n```rust\nfn greet() {}\n```\n",
+            )
+            .with_symbol("greet"),
+            doc(
+                "src/main.rs",
+                1,
+                "Main",
+                "Main\n====\n\n## Usage ##\n\nCalls [[greet]], not [[missing]].\n",
+            )
+            .with_symbol("main"),
+        ];
+        let result = render(&documents, &RenderOptions::new().with_title("My *Site*"));
+
+        let paths: Vec<String> = result
+            .files
+            .iter()
+            .map(|file| file.path.to_string())
+            .collect();
+        assert_eq!(paths, ["index.md", "src/greet.rs.md", "src/main.rs.md"]);
+        expect![[r#"
+            # My \*Site\*
+
+            - [Greeting](src/greet.rs.md#greeting)
+            - [Main](src/main.rs.md#main)
+              - [Usage](src/main.rs.md#usage)
+        "#]]
+        .assert_eq(find(&result.files, "index.md"));
+        expect![[r#"
+            # Greeting {#greeting}
+
+            Says hello, see [main](../src/main.rs.md#main) and `[[not_a_reference]]`.
+
+            ```rust
+            fn greet() {}
+            ```
+
+            *src/greet.rs:1*
+        "#]]
+        .assert_eq(find(&result.files, "src/greet.rs.md"));
+        expect![[r#"
+            Main {#main}
+            ====
+
+            ## Usage {#usage}
+
+            Calls [greet](../src/greet.rs.md#greeting), not [[missing]].
+
+            *src/main.rs:1*
+        "#]]
+        .assert_eq(find(&result.files, "src/main.rs.md"));
+        let warnings: Vec<String> = result.warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            ["src/main.rs:6: Unresolved cross-reference '[[missing]]'"]
+        );
+    }
+
+    #[test]
+    fn test_render_site_in_reading_order() {
+        let documents = vec![
+            doc("src/lib.rs", 1, "Lib", "# Library\n"),
+            doc("docs/intro.md", 1, "Intro", "# Introduction\n"),
+            doc("notes.md", 1, "Notes", "# Notes\n"),
+        ];
+        let order = ReadingOrder::new()
+            .with_part("Getting started", ["docs/intro.md"])
+            .with_part("Internals", ["src/**"]);
+        let result = render(
+            &documents,
+            &RenderOptions::new()
+                .with_title("Book")
+                .with_reading_order(order),
+        );
+        let paths: Vec<String> = result
+            .files
+            .iter()
+            .map(|file| file.path.to_string())
+            .collect();
+        assert_eq!(
+            paths,
+            [
+                "index.md",
+                "docs/intro.md.md",
+                "src/lib.rs.md",
+                "notes.md.md"
+            ]
+        );
+        expect![[r#"
+            # Book
+
+            ## Getting started
+
+            - [Introduction](docs/intro.md.md#introduction)
+
+            ## Internals
+
+            - [Library](src/lib.rs.md#library)
+
+            - [Notes](notes.md.md#notes)
+        "#]]
+        .assert_eq(find(&result.files, "index.md"));
+    }
+
+    #[test]
+    fn test_render_site_single_file() {
+        let documents = vec![
+            doc("a.md", 1, "Alpha", "# Alpha\n\nSee [[b]].\n"),
+            doc("b.rs", 1, "Beta", "No heading here.\n").with_symbol("b"),
+        ];
+        let options = RenderOptions::new()
+            .with_title("Site")
+            .with_output_mode(OutputMode::SingleFile);
+        let result = render(&documents, &options);
+
+        assert_eq!(result.files.len(), 1);
+        expect![[r#"
+            # Site
+
+            - [Alpha](#alpha)
+
+            ---
+
+            <a id="a.md.md"></a>
+
+            # Alpha {#alpha}
+
+            See [b](#b.rs.md).
+
+            *a.md:1*
+
+            ---
+
+            <a id="b.rs.md"></a>
+
+            No heading here.
+
+            *b.rs:1*
+        "#]]
+        .assert_eq(find(&result.files, "index.md"));
+    }
+
+    #[test]
+    fn test_render_directives_as_code_blocks() {
+        let document = doc(
+            "src/lib.rs",
+            1,
+            "Config",
+            "# Config\n\n{{include example.yaml}}\n",
+        )
+        .with_include(Include {
+            directive_path: "example.yaml".to_string(),
+            file_path: FilePath::from("src/example.yaml"),
+            content: "text: ```\n".to_string(),
+        });
+        let result = render(&[document], &RenderOptions::new());
+        assert!(
+            find(&result.files, "src/lib.rs.md")
+                .contains("# Config {#config}\n\n````yaml\ntext: ```\n````\n")
+        );
+    }
+
+    #[test]
+    fn test_render_with_heading_offset() {
+        let document = doc(
+            "guide.md",
+            1,
+            "Guide",
+            "Guide\n=====\n\n## Install ##\n\n###### Deep\n",
+        );
+        let result = render(&[document], &RenderOptions::new().with_heading_offset(1));
+        assert!(
+            find(&result.files, "guide.md.md").starts_with(
+                "## Guide {#guide}\n\n### Install {#install}\n\n###### Deep {#deep}\n"
+            )
+        );
+        assert_eq!(result.warnings.len(), 1);
+        assert_eq!(result.warnings[0].line, 6);
+    }
+
+    #[test]
+    fn test_render_following_code() {
+        let document =
+            doc("src/greet.rs", 1, "Greet", "# Greet\n").with_following_code(FollowingCode {
+                code: "fn greet() {}\n".to_string(),
+                start_line: 3,
+                language: Some("rust".to_string()),
+            });
+        let result = render(&[document], &RenderOptions::new());
+        assert_eq!(
+            find(&result.files, "src/greet.rs.md"),
+            "# Greet {#greet}\n\n```rust\nfn greet() {}\n```\n\n*src/greet.rs:1*\n"
+        );
+    }
+
+    #[test]
+    fn test_round_trip_preserves_headings_and_code_blocks() {
+        let source = "# Guide\n\nIntro with [[guide]].\n\n## Install `tool`\n\n```bash\ncargo install tool\n```\n\nSetext\n------\n\n````markdown\n```rust\nlet x = [[1]];\n```\n````\n\n    indented code\n\n### Deep ###\n\n> quoted\n";
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("docs/guide.md"), source.as_bytes().to_vec());
+        let pal = PalHandle::new(mock_pal);
+        let documents = extract_documents(&pal, &[FilePath::from("docs/guide.md")])
+            .unwrap()
+            .documents;
+
+        let result = render(&documents, &RenderOptions::new());
+        let page = find(&result.files, "docs/guide.md.md");
+
+        assert_eq!(structure(page), structure(documents[0].content()));
+        assert!(page.contains("let x = [[1]];"));
+    }
+}
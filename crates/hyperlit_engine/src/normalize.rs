@@ -0,0 +1,151 @@
+/* 📖 # Why normalize byte order marks and line endings?
+
+Files saved by some Windows editors start with a UTF-8 byte order mark
+(`U+FEFF`) and end their lines with `\r\n`. Neither is visible in an editor,
+but both get in the way of extraction: a BOM before the first comment hides its
+delimiter from the lexer, so a doc comment on the first line is not found, and
+carriage returns end up in the extracted markdown, in titles and in code blocks.
+
+Source files are therefore normalized before anything else looks at them: a
+leading BOM is removed and every `\r\n` becomes `\n`, so a file is extracted
+exactly like its LF equivalent without a BOM. Included files are normalized the
+same way. A lone `\r` is no line ending on any current platform and is kept.
+
+Normalizing keeps the number of lines, so reported line numbers are the lines
+in the file as it is. Byte ranges of documents (see `ByteRange`) are offsets
+into the original file as well, they are mapped back after extraction.
+*/
+
+use std::borrow::Cow;
+
+/// The UTF-8 byte order mark.
+const BOM: char = '\u{feff}';
+
+/// Text with a leading byte order mark removed and CRLF line endings
+/// normalized to LF, see [`crate::normalize`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct NormalizedText<'a> {
+    content: Cow<'a, str>,
+    /// Offsets of removals in the normalized content, with the number of bytes
+    /// removed up to and including each
+    removed: Vec<(usize, usize)>,
+}
+
+impl<'a> NormalizedText<'a> {
+    /// Normalize `text`, borrowing it if there is nothing to normalize.
+    pub(crate) fn new(text: &'a str) -> Self {
+        let without_bom = text.strip_prefix(BOM);
+        let rest = without_bom.unwrap_or(text);
+        if without_bom.is_none() && !rest.contains("\r\n") {
+            return Self {
+                content: Cow::Borrowed(text),
+                removed: Vec::new(),
+            };
+        }
+        let mut removed = Vec::new();
+        let mut total = 0;
+        if without_bom.is_some() {
+            total = BOM.len_utf8();
+            removed.push((0, total));
+        }
+        let mut content = String::with_capacity(rest.len());
+        for line in rest.split_inclusive('\n') {
+            match line.strip_suffix("\r\n") {
+                Some(line) => {
+                    content.push_str(line);
+                    total += 1;
+                    removed.push((content.len(), total));
+                    content.push('\n');
+                }
+                None => content.push_str(line),
+            }
+        }
+        Self {
+            content: Cow::Owned(content),
+            removed,
+        }
+    }
+
+    /// Returns the normalized text.
+    pub(crate) fn content(&self) -> &str {
+        &self.content
+    }
+
+    /// Returns the offset in the original text of an offset in the normalized text.
+    pub(crate) fn original_offset(&self, offset: usize) -> usize {
+        let index = self.removed.partition_point(|&(at, _)| at <= offset);
+        match index {
+            0 => offset,
+            index => offset + self.removed[index - 1].1,
+        }
+    }
+
+    /// Returns the offset in the normalized text of an offset in the original text.
+    ///
+    /// Offsets within removed bytes map to the position they were removed at.
+    pub(crate) fn normalized_offset(&self, offset: usize) -> usize {
+        let mut removed_before = 0;
+        for &(at, total) in &self.removed {
+            // The removed bytes are at `at + removed_before..at + total` in the original text
+            if offset < at + removed_before {
+                break;
+            }
+            if offset < at + total {
+                return at;
+            }
+            removed_before = total;
+        }
+        offset - removed_before
+    }
+}
+
+/// Normalize the content of a file, see [`crate::normalize`].
+pub(crate) fn normalize(text: String) -> String {
+    match NormalizedText::new(&text).content {
+        Cow::Borrowed(_) => text,
+        Cow::Owned(content) => content,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_normalize() {
+        assert_eq!(normalize("a\nb".to_string()), "a\nb");
+        assert_eq!(normalize("\u{feff}a\r\nb\r\n".to_string()), "a\nb\n");
+        assert_eq!(normalize("a\rb\r\r\n".to_string()), "a\rb\r\n");
+        assert!(matches!(
+            NormalizedText::new("a\n").content,
+            Cow::Borrowed(_)
+        ));
+    }
+
+    #[test]
+    fn test_offsets() {
+        let original = "\u{feff}ab\r\ncd\r\ne";
+        let text = NormalizedText::new(original);
+        assert_eq!(text.content(), "ab\ncd\ne");
+        for (normalized, expected) in [
+            (0, 3),
+            (1, 4),
+            (2, 6),
+            (3, 7),
+            (4, 8),
+            (5, 10),
+            (6, 11),
+            (7, 12),
+        ] {
+            assert_eq!(text.original_offset(normalized), expected, "{normalized}");
+            assert_eq!(text.normalized_offset(expected), normalized, "{expected}");
+        }
+        // The BOM and carriage returns map to where they were removed
+        assert_eq!(text.normalized_offset(0), 0);
+        assert_eq!(text.normalized_offset(5), 2);
+
+        let plain = NormalizedText::new("ab\ncd");
+        assert_eq!(plain.original_offset(4), 4);
+        assert_eq!(plain.normalized_offset(4), 4);
+    }
+}
@@ -0,0 +1,117 @@
+/* 📖 # Why a small worker pool instead of a thread pool crate?
+
+Extraction and rendering process each file independently, which makes them easy
+to spread across cores. The only requirement beyond that is determinism: results
+must come back in input order, so output never depends on thread scheduling.
+
+Scoped threads pulling the next item index from a shared counter cover this in a
+few lines. Each result is stored with its index and put back in order at the
+end, so callers see the same `Vec` a sequential `map` would produce. Work is
+handed out one item at a time, so a few large files do not leave other workers
+idle. Anything shared across files (such as assigning unique document IDs) is
+done by the caller afterwards, in input order.
+*/
+
+use std::num::NonZeroUsize;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::thread;
+
+/// Returns the number of workers used when no concurrency is configured.
+///
+/// This is the number of CPU cores available to the process, or 1 if unknown.
+pub fn default_concurrency() -> usize {
+    thread::available_parallelism()
+        .map(NonZeroUsize::get)
+        .unwrap_or(1)
+}
+
+/// Apply `f` to all items using up to `concurrency` worker threads.
+///
+/// Results are returned in the order of `items`, regardless of which worker
+/// processed an item. With a concurrency of 1 (or 0) the items are processed
+/// on the calling thread.
+pub(crate) fn parallel_map<T, R, F>(items: &[T], concurrency: usize, f: F) -> Vec<R>
+where
+    T: Sync,
+    R: Send,
+    F: Fn(&T) -> R + Sync,
+{
+    let workers = concurrency.min(items.len());
+    if workers <= 1 {
+        return items.iter().map(f).collect();
+    }
+
+    let next_index = AtomicUsize::new(0);
+    let mut indexed: Vec<(usize, R)> = thread::scope(|scope| {
+        let handles: Vec<_> = (0..workers)
+            .map(|_| {
+                scope.spawn(|| {
+                    let mut results = Vec::new();
+                    loop {
+                        let index = next_index.fetch_add(1, Ordering::Relaxed);
+                        let Some(item) = items.get(index) else {
+                            break;
+                        };
+                        results.push((index, f(item)));
+                    }
+                    results
+                })
+            })
+            .collect();
+        handles
+            .into_iter()
+            .flat_map(|handle| handle.join().expect("worker thread panicked"))
+            .collect()
+    });
+    indexed.sort_unstable_by_key(|(index, _)| *index);
+    indexed.into_iter().map(|(_, result)| result).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashSet;
+    use std::sync::Mutex;
+
+    #[test]
+    fn test_parallel_map_preserves_order() {
+        let items: Vec<u64> = (0..100).collect();
+        let results = parallel_map(&items, 8, |item| {
+            // Make early items slower so workers finish out of order
+            thread::sleep(std::time::Duration::from_micros(100 - item));
+            item * 2
+        });
+        assert_eq!(
+            results,
+            items.iter().map(|item| item * 2).collect::<Vec<_>>()
+        );
+    }
+
+    #[test]
+    fn test_parallel_map_uses_multiple_threads() {
+        let items: Vec<usize> = (0..32).collect();
+        let threads = Mutex::new(HashSet::new());
+        parallel_map(&items, 4, |_| {
+            threads.lock().unwrap().insert(thread::current().id());
+            thread::sleep(std::time::Duration::from_millis(1));
+        });
+        let threads = threads.into_inner().unwrap();
+        assert!(threads.len() > 1);
+        assert!(threads.len() <= 4);
+        assert!(!threads.contains(&thread::current().id()));
+    }
+
+    #[test]
+    fn test_parallel_map_sequential() {
+        let items = ["a", "b"];
+        let caller = thread::current().id();
+        let results = parallel_map(&items, 1, |item| {
+            (item.to_uppercase(), thread::current().id())
+        });
+        assert_eq!(
+            results,
+            vec![("A".to_string(), caller), ("B".to_string(), caller)]
+        );
+        assert!(parallel_map(&[] as &[u8], 4, |item| *item).is_empty());
+    }
+}
@@ -0,0 +1,193 @@
+/* 📖 # Why check doc blocks for parse errors?
+
+Markdown never fails to parse: a code fence that is never closed simply swallows
+the rest of the document as code. In a doc comment this is easy to miss, since
+the rendered page still looks plausible, with the remaining prose shown
+as one big code block.
+
+Extraction therefore checks each document for such mistakes and reports them as
+`ParseError`s pointing at the exact file, line and column, e.g.
+`src/lib.rs:12:4: unterminated code block started at line 12`. Lines and columns
+refer to the original source file, so editors can jump straight to the problem
+even in comments whose prefixes (`// `, ` * `) were stripped during extraction.
+
+By default all parse errors of all files are collected, so every problem is
+reported in one pass, and the affected documents are kept as markdown would
+render them. With `fail_fast` extraction stops at the first parse error instead.
+*/
+
+use std::fmt;
+
+use pulldown_cmark::{CodeBlockKind, Event, Parser, Tag};
+
+use hyperlit_base::error::ErrorKind;
+use hyperlit_base::{FilePath, HyperlitError};
+
+use crate::Document;
+use crate::export::LineMap;
+
+/// A malformed doc block, located in its source file.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ParseError {
+    /// Source file containing the problem
+    pub file_path: FilePath,
+    /// Source line of the problem (1-indexed)
+    pub line: usize,
+    /// Column of the problem in characters (1-indexed)
+    pub column: usize,
+    /// Description of the problem
+    pub message: String,
+}
+
+impl ParseError {
+    /// Returns the parse error wrapped by a hyperlit error, if any.
+    pub fn find(error: &HyperlitError) -> Option<&ParseError> {
+        match error.kind() {
+            ErrorKind::StdError { error } => error.downcast_ref::<ParseError>(),
+            _ => None,
+        }
+    }
+}
+
+impl fmt::Display for ParseError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "{}:{}:{}: {}",
+            self.file_path, self.line, self.column, self.message
+        )
+    }
+}
+
+impl std::error::Error for ParseError {}
+
+/// Check a document extracted from `source` (the full content of its file).
+pub(crate) fn check_document(document: &Document, source: &str) -> Vec<ParseError> {
+    let content = document.content();
+    let lines = LineMap::new(content, document.source().line_number());
+    let mut errors = Vec::new();
+    for (event, range) in Parser::new(content).into_offset_iter() {
+        let Event::Start(Tag::CodeBlock(CodeBlockKind::Fenced(_))) = event else {
+            continue;
+        };
+        let block = &content[range.start..range.end];
+        let Some(fence) = opening_fence(block) else {
+            continue;
+        };
+        if !is_closed(block, fence) {
+            let line = lines.line_of(range.start);
+            errors.push(ParseError {
+                file_path: document.source().file_path().clone(),
+                line,
+                column: column_of(source, line, fence),
+                message: format!("unterminated code block started at line {}", line),
+            });
+        }
+    }
+    errors
+}
+
+/// Returns the opening fence (e.g. "```" or "~~~~") of a fenced code block.
+fn opening_fence(block: &str) -> Option<&str> {
+    let first_line = strip_container_prefix(block.lines().next()?);
+    let marker = first_line
+        .chars()
+        .next()
+        .filter(|c| *c == '`' || *c == '~')?;
+    let length = first_line.len() - first_line.trim_start_matches(marker).len();
+    Some(&first_line[..length])
+}
+
+/// Returns true if the last line of the block closes the opening `fence`.
+fn is_closed(block: &str, fence: &str) -> bool {
+    let mut block_lines = block.trim_end().lines();
+    block_lines.next();
+    let Some(last_line) = block_lines.last() else {
+        return false;
+    };
+    let last_line = strip_container_prefix(last_line).trim_end();
+    let marker = fence.chars().next().unwrap_or('`');
+    last_line.len() >= fence.len() && last_line.chars().all(|c| c == marker)
+}
+
+/// Strip indentation and block quote markers in front of a line.
+fn strip_container_prefix(line: &str) -> &str {
+    line.trim_start_matches(|c: char| c.is_whitespace() || c == '>')
+}
+
+/// Column of `needle` on a source line, or 1 if it cannot be found there.
+pub(crate) fn column_of(source: &str, line: usize, needle: &str) -> usize {
+    source
+        .lines()
+        .nth(line.saturating_sub(1))
+        .and_then(|text| text.find(needle).map(|index| text[..index].chars().count()))
+        .map_or(1, |column| column + 1)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use std::collections::HashSet;
+
+    fn check(source: &str, line: usize, content: &str) -> Vec<String> {
+        let document = Document::new(
+            "Doc".to_string(),
+            content.to_string(),
+            DocumentSource::new(SourceType::CodeComment, FilePath::from("src/lib.rs"), line),
+            None,
+            &HashSet::new(),
+        );
+        check_document(&document, source)
+            .iter()
+            .map(ToString::to_string)
+            .collect()
+    }
+
+    #[test]
+    fn test_check_document_unterminated_code_block() {
+        let source = "fn a() {}\n  // 📖 # Doc\n  // ```rust\n  // let x = 1;\n";
+        assert_eq!(
+            check(source, 2, "# Doc\n```rust\nlet x = 1;\n"),
+            ["src/lib.rs:3:6: unterminated code block started at line 3"]
+        );
+    }
+
+    #[test]
+    fn test_check_document_closed_code_blocks() {
+        let content = "# Doc\n```rust\nlet x = 1;\n```\n\n~~~~\n```\n~~~~~\n\n> ```\n> quoted\n> ```\n\n- item\n  ```\n  code\n  ```\n";
+        assert!(check(content, 1, content).is_empty());
+    }
+
+    #[test]
+    fn test_check_document_mismatched_closing_fence() {
+        let content = "# Doc\n\n````\ncode\n```\n";
+        assert_eq!(
+            check(content, 1, content),
+            ["src/lib.rs:3:1: unterminated code block started at line 3"]
+        );
+    }
+
+    #[test]
+    fn test_check_document_fence_at_end_of_document() {
+        let content = "# Doc\n```";
+        assert_eq!(
+            check(content, 1, content),
+            ["src/lib.rs:2:1: unterminated code block started at line 2"]
+        );
+    }
+
+    #[test]
+    fn test_find_parse_error() {
+        let parse_error = ParseError {
+            file_path: FilePath::from("a.md"),
+            line: 1,
+            column: 2,
+            message: "broken".to_string(),
+        };
+        let error: Box<HyperlitError> = parse_error.clone().into();
+        assert_eq!(error.to_string(), "a.md:1:2: broken");
+        assert_eq!(ParseError::find(&error), Some(&parse_error));
+        assert_eq!(ParseError::find(&HyperlitError::message("other")), None);
+    }
+}
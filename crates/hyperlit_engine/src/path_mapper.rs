@@ -0,0 +1,237 @@
+/* 📖 # Why map source paths to page paths?
+
+By default every page mirrors the path of its source file, so
+`src/server/http/handler.rs` becomes `src/server/http/handler.rs.html`. Projects
+with deeply nested packages end up with long, unwieldy URLs for their docs. A
+`PathMapper` decides where the page of a source file goes instead, e.g.
+`handler` for flat, pretty URLs. The renderers append the extension of their
+format (`.html`, `.md`), so one mapper works for every output format.
+
+Every link to a page is computed through the same mapping: the table of
+contents, `[[name]]` cross-references and the pages' links back to the index,
+so links stay correct whatever the mapper returns. With
+`OutputMode::SingleFile` the mapped paths become the IDs of the sections. The
+flat layout has its own naming, see `crate::flat`, and ignores the mapper.
+
+A mapper can map two source files to the same page, or a page onto a file of
+the site itself (such as `index.html`). Rather than one page silently
+overwriting the other, the renderers report a warning and leave out the pages
+that collide with an earlier one (in source path order), as they do for pages
+mapped outside of the output directory.
+*/
+
+use std::borrow::Cow;
+use std::collections::{BTreeMap, HashSet};
+use std::fmt::Debug;
+use std::path::Component;
+
+use hyperlit_base::FilePath;
+
+use crate::render::group_by_file;
+use crate::{Document, RenderWarning};
+
+/// Decides where the page of a source file is written in the output directory.
+///
+/// Mappers must be deterministic, since page paths end up in shared links.
+pub trait PathMapper: Debug + Send + Sync {
+    /// Returns the path of the page for a source file, without the extension
+    /// of the output format.
+    fn map_path(&self, source_path: &FilePath) -> FilePath;
+
+    /// Identifies the output of this mapper for the build cache.
+    ///
+    /// Cached pages are rendered again when the key changes.
+    fn cache_key(&self) -> String {
+        format!("{self:?}")
+    }
+}
+
+/// The default path mapper, pages mirror the source tree.
+///
+/// `src/lib.rs` becomes `src/lib.rs.html` in HTML output.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct MirrorPathMapper;
+
+impl MirrorPathMapper {
+    /// Create the default path mapper.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl PathMapper for MirrorPathMapper {
+    fn map_path(&self, source_path: &FilePath) -> FilePath {
+        source_path.clone()
+    }
+}
+
+/// A path mapper backed by a function.
+///
+/// # Examples
+/// ```
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::{FnPathMapper, PathMapper};
+///
+/// let mapper = FnPathMapper::new("file-stem", |source_path: &FilePath| {
+///     let stem = source_path.as_relative().file_stem().unwrap_or_default();
+///     FilePath::from(stem)
+/// });
+/// assert_eq!(mapper.map_path(&FilePath::from("src/net/http.rs")), FilePath::from("http"));
+/// ```
+pub struct FnPathMapper<F> {
+    name: String,
+    function: F,
+}
+
+impl<F: Fn(&FilePath) -> FilePath + Send + Sync> FnPathMapper<F> {
+    /// Create a path mapper calling `function`.
+    ///
+    /// The `name` identifies the function in the build cache, so it should be
+    /// changed whenever the function produces different paths.
+    pub fn new(name: impl Into<String>, function: F) -> Self {
+        Self {
+            name: name.into(),
+            function,
+        }
+    }
+}
+
+impl<F> Debug for FnPathMapper<F> {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("FnPathMapper")
+            .field("name", &self.name)
+            .finish_non_exhaustive()
+    }
+}
+
+impl<F: Fn(&FilePath) -> FilePath + Send + Sync> PathMapper for FnPathMapper<F> {
+    fn map_path(&self, source_path: &FilePath) -> FilePath {
+        (self.function)(source_path)
+    }
+}
+
+/// Leave out the documents of source files whose page cannot be written.
+///
+/// That is a page outside of the output directory, one of the `site_files` or
+/// the page of an earlier source file, in source path order. `page_path`
+/// returns the output path of the page for a source file.
+pub(crate) fn drop_colliding_pages<'a>(
+    documents: Cow<'a, [Document]>,
+    site_files: &[&str],
+    page_path: impl Fn(&FilePath) -> FilePath,
+) -> (Cow<'a, [Document]>, Vec<RenderWarning>) {
+    // Owner of every page path, None for the files of the site itself
+    let mut owners: BTreeMap<String, Option<FilePath>> = site_files
+        .iter()
+        .map(|site_file| (site_file.to_string(), None))
+        .collect();
+    let mut dropped = HashSet::new();
+    let mut warnings = Vec::new();
+    for (source_path, file_documents) in group_by_file(&documents) {
+        let page = page_path(&source_path);
+        let problem = if !is_within_output(&page) {
+            Some("is outside of the output directory".to_string())
+        } else {
+            match owners.get(&page.to_string()) {
+                Some(Some(owner)) => Some(format!("collides with the page of {}", owner)),
+                Some(None) => Some("collides with a file of the site".to_string()),
+                None => None,
+            }
+        };
+        match problem {
+            Some(problem) => {
+                warnings.push(RenderWarning {
+                    file_path: source_path.clone(),
+                    line: file_documents
+                        .iter()
+                        .map(|document| document.source().line_number())
+                        .min()
+                        .unwrap_or(1),
+                    message: format!("Page '{}' {}, left out", page, problem),
+                });
+                dropped.insert(source_path);
+            }
+            None => {
+                owners.insert(page.to_string(), Some(source_path));
+            }
+        }
+    }
+    if dropped.is_empty() {
+        return (documents, warnings);
+    }
+    let kept = documents
+        .iter()
+        .filter(|document| !dropped.contains(document.source().file_path()))
+        .cloned()
+        .collect();
+    (Cow::Owned(kept), warnings)
+}
+
+/// Returns true if a page path is a relative path that stays in the output directory.
+fn is_within_output(page: &FilePath) -> bool {
+    let path = page.as_path();
+    path.components().next().is_some()
+        && path
+            .components()
+            .all(|component| matches!(component, Component::Normal(_)))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+
+    fn doc(path: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), 3);
+        Document::new(
+            path.to_string(),
+            "Text".to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    #[test]
+    fn test_drop_colliding_pages() {
+        let documents = vec![
+            doc("a/index.rs"),
+            doc("b/lib.rs"),
+            doc("c/lib.rs"),
+            doc("d/up.rs"),
+        ];
+        let (kept, warnings) =
+            drop_colliding_pages(Cow::Borrowed(&documents), &["index.html"], |source_path| {
+                let stem = source_path.as_relative().file_stem().unwrap_or_default();
+                match stem {
+                    "up" => FilePath::from("../up.html"),
+                    stem => FilePath::from(format!("{stem}.html")),
+                }
+            });
+        let kept: Vec<String> = kept
+            .iter()
+            .map(|document| document.source().file_path().to_string())
+            .collect();
+        assert_eq!(kept, ["b/lib.rs"]);
+        let warnings: Vec<String> = warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            [
+                "a/index.rs:3: Page 'index.html' collides with a file of the site, left out",
+                "c/lib.rs:3: Page 'lib.html' collides with the page of b/lib.rs, left out",
+                "d/up.rs:3: Page '../up.html' is outside of the output directory, left out",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_drop_colliding_pages_keeps_distinct_pages() {
+        let documents = vec![doc("a.rs"), doc("b.rs")];
+        let (kept, warnings) =
+            drop_colliding_pages(Cow::Borrowed(&documents), &["index.html"], |source_path| {
+                FilePath::from(format!("{}.html", MirrorPathMapper.map_path(source_path)))
+            });
+        assert!(matches!(kept, Cow::Borrowed(_)));
+        assert!(warnings.is_empty());
+    }
+}
@@ -0,0 +1,379 @@
+/* 📖 # Why an explicit reading order?
+
+Without further configuration pages follow their source paths, which is a fine
+order for browsing a code base but not for reading a book woven from it: the
+introduction in `docs/intro.md` should come before `core/`, and `core/` before
+the `adapters/` that build on it, whatever their names. An ordering manifest in
+the configuration lists the pages in reading order, grouped into titled parts:
+
+```toml
+[[part]]
+title = "Getting started"
+files = ["docs/intro.md", "docs/setup.md"]
+
+[[part]]
+title = "Internals"
+files = ["src/core.rs", "src/adapters.rs"]
+```
+
+Entries are source paths or globs, where `*` stays within a directory and `**`
+spans directories. An entry that is no valid glob names a file literally, and a
+path without glob characters, such as `src/core`, also covers all files below
+it. Pages take the position of the first entry matching them, pages matching
+the same entry follow their source paths. Listing a file or directory before a
+glob covering the rest thus moves it to the front without listing every file.
+
+The resulting `PageSequence` is part of the site map, so everything that has an
+order follows it: the table of contents, grouped under the titles of the parts,
+the previous and next links of the pages, the sections of a single-file site
+and the manifest of the flat layout. The anchors of headings do not depend on
+the order (see `crate::toc`), reordering pages never breaks links into them.
+
+Pages no entry matches (new files, usually) are appended after the last part by
+default, so nothing vanishes from the site. With `unlisted_pages = "unlisted"`
+they are still rendered, but left out of the table of contents and the previous
+and next links, and each is reported with a warning so the manifest can be
+completed. Without any part, all pages are listed in source path order.
+*/
+
+use std::collections::HashMap;
+use std::ops::Range;
+
+use globset::{Glob, GlobBuilder, GlobSet, GlobSetBuilder};
+use serde::Deserialize;
+
+use hyperlit_base::FilePath;
+
+use crate::Config;
+
+/// What happens to pages not listed in the reading order.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Hash, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum UnlistedPages {
+    /// Append them after the last part, in source path order
+    #[default]
+    Append,
+    /// Leave them out of the table of contents and the previous and next links,
+    /// with a warning
+    Unlisted,
+}
+
+/// Reading order of the pages of a site, see [`crate::reading_order`].
+#[derive(Debug, Clone, Default)]
+pub struct ReadingOrder {
+    parts: Vec<OrderPart>,
+    unlisted_pages: UnlistedPages,
+}
+
+/// A titled part of the reading order with the entries selecting its pages.
+#[derive(Debug, Clone)]
+struct OrderPart {
+    title: String,
+    entries: Vec<(String, GlobSet)>,
+}
+
+impl ReadingOrder {
+    /// Create a reading order listing all pages in source path order.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Create the reading order of the `[[part]]` entries of a site configuration.
+    pub fn from_config(config: &Config) -> Self {
+        let order = config.part.iter().fold(Self::new(), |order, part| {
+            order.with_part(&part.title, &part.files)
+        });
+        order.with_unlisted_pages(config.unlisted_pages.unwrap_or_default())
+    }
+
+    /// Add a part titled `title` with the pages matching `files`, in order.
+    ///
+    /// Each entry is a source path or a glob, see [`crate::reading_order`].
+    pub fn with_part(
+        mut self,
+        title: impl Into<String>,
+        files: impl IntoIterator<Item = impl AsRef<str>>,
+    ) -> Self {
+        let entries = files
+            .into_iter()
+            .map(|file| {
+                let file = file.as_ref();
+                (file.to_string(), entry_matcher(file))
+            })
+            .collect();
+        self.parts.push(OrderPart {
+            title: title.into(),
+            entries,
+        });
+        self
+    }
+
+    /// Set what happens to pages no part lists (defaults to [`UnlistedPages::Append`]).
+    pub fn with_unlisted_pages(mut self, unlisted_pages: UnlistedPages) -> Self {
+        self.unlisted_pages = unlisted_pages;
+        self
+    }
+
+    /// Returns what happens to pages no part lists.
+    pub fn unlisted_pages(&self) -> UnlistedPages {
+        self.unlisted_pages
+    }
+
+    /// Returns true if no part is defined, so pages follow their source paths.
+    pub fn is_empty(&self) -> bool {
+        self.parts.is_empty()
+    }
+
+    /// Identifies the reading order for the build cache.
+    pub(crate) fn cache_key(&self) -> String {
+        let parts: Vec<(&str, Vec<&str>)> = self
+            .parts
+            .iter()
+            .map(|part| {
+                let entries = part.entries.iter().map(|(file, _)| file.as_str());
+                (part.title.as_str(), entries.collect())
+            })
+            .collect();
+        format!("{:?};{:?}", parts, self.unlisted_pages)
+    }
+
+    /// Arrange `pages` in reading order.
+    ///
+    /// The result does not depend on the order of `pages`.
+    pub fn arrange(&self, mut pages: Vec<OrderedPage>) -> PageSequence {
+        pages.sort_by(|a, b| {
+            let a = a.source_path.as_relative().as_str();
+            a.cmp(b.source_path.as_relative().as_str())
+        });
+        let mut remaining: Vec<Option<OrderedPage>> = pages.into_iter().map(Some).collect();
+        let mut sequence = PageSequence::default();
+        for part in &self.parts {
+            let start = sequence.pages.len();
+            for (_, matcher) in &part.entries {
+                for slot in &mut remaining {
+                    let matches = slot.as_ref().is_some_and(|page| {
+                        matcher.is_match(page.source_path.as_relative().as_str())
+                    });
+                    if matches {
+                        sequence.pages.extend(slot.take());
+                    }
+                }
+            }
+            sequence.parts.push(SequencePart {
+                title: part.title.clone(),
+                pages: start..sequence.pages.len(),
+            });
+        }
+        let remaining = remaining.into_iter().flatten();
+        match self.unlisted_pages {
+            UnlistedPages::Unlisted if !self.is_empty() => sequence.unlisted.extend(remaining),
+            _ => sequence.pages.extend(remaining),
+        }
+        sequence.positions = sequence
+            .pages
+            .iter()
+            .enumerate()
+            .map(|(position, page)| (page.source_path.to_string(), position))
+            .collect();
+        sequence
+    }
+}
+
+/// Compile the matcher of a reading order entry, see [`crate::reading_order`].
+fn entry_matcher(file: &str) -> GlobSet {
+    let glob = |pattern: &str| {
+        GlobBuilder::new(pattern)
+            .literal_separator(true)
+            .build()
+            .ok()
+    };
+    let literal =
+        |pattern: &str| Glob::new(&globset::escape(pattern)).expect("escaped glob is valid");
+    let mut builder = GlobSetBuilder::new();
+    if file.contains(['*', '?', '[', '{']) {
+        builder.add(glob(file).unwrap_or_else(|| literal(file)));
+    } else {
+        // A plain path names a file or a directory, the files below it
+        let directory = file.trim_end_matches('/');
+        builder.add(literal(directory));
+        builder.add(
+            glob(&format!("{}/**", globset::escape(directory))).expect("directory glob is valid"),
+        );
+    }
+    builder.build().expect("globs are valid")
+}
+
+/// A page of the site in its reading order.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct OrderedPage {
+    /// Source file of the page
+    pub source_path: FilePath,
+    /// Title of the page
+    pub title: String,
+}
+
+/// A titled part of a [`PageSequence`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct SequencePart {
+    /// Title of the part
+    pub title: String,
+    /// Positions of the pages of the part in [`PageSequence::pages`]
+    pub pages: Range<usize>,
+}
+
+/// The pages of a site in reading order, see [`ReadingOrder::arrange`].
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct PageSequence {
+    /// Listed pages in reading order
+    pub pages: Vec<OrderedPage>,
+    /// Parts of the reading order, pages after the last part belong to none
+    pub parts: Vec<SequencePart>,
+    /// Pages left out of the reading order, in source path order
+    pub unlisted: Vec<OrderedPage>,
+    /// Position of each listed page, by source path
+    positions: HashMap<String, usize>,
+}
+
+impl PageSequence {
+    /// Returns the position of the page of `source_path`, None if it is not listed.
+    pub fn position(&self, source_path: &FilePath) -> Option<usize> {
+        self.positions.get(&source_path.to_string()).copied()
+    }
+
+    /// Returns the page before the page of `source_path` in reading order.
+    pub fn previous(&self, source_path: &FilePath) -> Option<&OrderedPage> {
+        let position = self.position(source_path)?;
+        self.pages.get(position.checked_sub(1)?)
+    }
+
+    /// Returns the page after the page of `source_path` in reading order.
+    pub fn next(&self, source_path: &FilePath) -> Option<&OrderedPage> {
+        self.pages.get(self.position(source_path)? + 1)
+    }
+
+    /// Sort items by the reading order of their pages, unlisted pages last.
+    ///
+    /// Items of the same page and of unlisted pages keep their order.
+    pub(crate) fn sort_by_page<T>(&self, items: &mut [T], source_path: impl Fn(&T) -> &FilePath) {
+        items.sort_by_key(|item| self.position(source_path(item)).unwrap_or(usize::MAX));
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn pages(paths: &[&str]) -> Vec<OrderedPage> {
+        paths
+            .iter()
+            .map(|path| OrderedPage {
+                source_path: FilePath::from(*path),
+                title: path.to_string(),
+            })
+            .collect()
+    }
+
+    fn paths(pages: &[OrderedPage]) -> Vec<String> {
+        pages
+            .iter()
+            .map(|page| page.source_path.to_string())
+            .collect()
+    }
+
+    #[test]
+    fn test_arrange_by_parts() {
+        let order = ReadingOrder::new()
+            .with_part("Getting started", ["docs/setup.md", "docs/intro.md"])
+            .with_part("Internals", ["src/core/**", "src/*.rs"]);
+        let sequence = order.arrange(pages(&[
+            "src/lib.rs",
+            "docs/intro.md",
+            "src/core/store.rs",
+            "docs/setup.md",
+            "src/adapters/http.rs",
+            "src/core/api/mod.rs",
+        ]));
+        assert_eq!(
+            paths(&sequence.pages),
+            [
+                "docs/setup.md",
+                "docs/intro.md",
+                "src/core/api/mod.rs",
+                "src/core/store.rs",
+                "src/lib.rs",
+                "src/adapters/http.rs",
+            ]
+        );
+        assert_eq!(
+            sequence.parts,
+            [
+                SequencePart {
+                    title: "Getting started".to_string(),
+                    pages: 0..2,
+                },
+                SequencePart {
+                    title: "Internals".to_string(),
+                    pages: 2..5,
+                },
+            ]
+        );
+        assert!(sequence.unlisted.is_empty());
+
+        let setup = FilePath::from("docs/setup.md");
+        let http = FilePath::from("src/adapters/http.rs");
+        assert_eq!(sequence.previous(&setup), None);
+        let title = |page: Option<&OrderedPage>| page.map(|page| page.title.clone());
+        assert_eq!(
+            title(sequence.next(&setup)),
+            Some("docs/intro.md".to_string())
+        );
+        assert_eq!(
+            title(sequence.previous(&http)),
+            Some("src/lib.rs".to_string())
+        );
+        assert_eq!(sequence.next(&http), None);
+    }
+
+    #[test]
+    fn test_arrange_directory_entries() {
+        let order = ReadingOrder::new().with_part("Core first", ["src/core", "docs/", "src/*.rs"]);
+        let sequence = order.arrange(pages(&[
+            "src/lib.rs",
+            "src/core/api/mod.rs",
+            "src/core.rs",
+            "src/coreutils/x.rs",
+            "docs/intro.md",
+            "src/core/store.rs",
+        ]));
+        assert_eq!(
+            paths(&sequence.pages),
+            [
+                "src/core/api/mod.rs",
+                "src/core/store.rs",
+                "docs/intro.md",
+                "src/core.rs",
+                "src/lib.rs",
+                "src/coreutils/x.rs",
+            ]
+        );
+        assert_eq!(sequence.parts[0].pages, 0..5);
+    }
+
+    #[test]
+    fn test_arrange_unlisted_pages() {
+        let order = ReadingOrder::new()
+            .with_part("Guide", ["guide.md", "[draft.md"])
+            .with_unlisted_pages(UnlistedPages::Unlisted);
+        let sequence = order.arrange(pages(&["notes.md", "[draft.md", "guide.md"]));
+        assert_eq!(paths(&sequence.pages), ["guide.md", "[draft.md"]);
+        assert_eq!(paths(&sequence.unlisted), ["notes.md"]);
+        assert_eq!(sequence.position(&FilePath::from("notes.md")), None);
+        assert_eq!(sequence.next(&FilePath::from("[draft.md")), None);
+
+        // Without parts, all pages are listed in source path order
+        let order = ReadingOrder::new().with_unlisted_pages(UnlistedPages::Unlisted);
+        let sequence = order.arrange(pages(&["b.md", "a.md"]));
+        assert_eq!(paths(&sequence.pages), ["a.md", "b.md"]);
+        assert!(sequence.parts.is_empty() && sequence.unlisted.is_empty());
+    }
+}
@@ -0,0 +1,2931 @@
+/* 📖 # Why render a static HTML site?
+
+The web UI needs a running hyperlit server, which is not always available: docs
+are published to static hosting, attached to releases or simply opened from disk.
+Rendering ("weaving") the extracted documents into plain HTML files covers these
+cases without any JavaScript.
+
+The output layout mirrors the source tree. Each source file containing
+documentation becomes one page at `<source path>.html` (e.g. `src/lib.rs.html`),
+so page paths are predictable and never collide, even for `lib.rs` and `lib.md`
+in the same directory. The page lists all documents of the file in source order.
+An `index.html` page holds the table of contents across all files.
+
+Rendering is kept separate from writing: `render_site` is a pure function from
+documents to output files, `write_site` puts them on disk through the PAL. This
+keeps rendering easy to test and lets callers re-render single pages.
+*/
+
+/* 📖 # Why a single-file output mode?
+
+A site of many pages plus a stylesheet is awkward to hand to someone: it must be
+zipped, unpacked and opened at the right file. With `OutputMode::SingleFile` the
+whole site is rendered into one `index.html` that works offline on its own:
+
+- The stylesheet is inlined in a `<style>` element
+- The table of contents comes first, followed by one `<section>` per source file
+  in source path order. Sections keep the page path as their id (e.g.
+  `src/lib.rs.html`), so links to a page become links to its section
+- The site is one page, so heading anchors are made unique across the site and
+  links to headings (from the table of contents or `[[name]]` references) just
+  drop the page path
+- Highlighted code uses inline styles anyway, so it needs no extra stylesheet
+- Local images are embedded as base64 `data:` URIs. They are read through the
+  PAL set with `RenderOptions::with_pal`, the only input outside the documents.
+  An image that cannot be read is reported as a warning and left as a link
+
+The build cache is bypassed in this mode: every change rewrites the one file, so
+there are no unchanged pages to reuse.
+*/
+
+/* 📖 # Why is the output byte-for-byte reproducible?
+
+Generated docs are often checked into version control or diffed between
+releases, so rebuilding unchanged sources must not change a single byte. Files
+are scanned in path order, documents keep that order through parallel
+extraction and rendering, and anything kept in hash maps (metadata, symbols,
+anchors) is sorted or only looked up, never iterated into the output. Nor does
+the output contain timestamps or absolute paths, so there is nothing to
+suppress for reproducible builds. A test builds the same site twice, with files
+added in different orders, and compares the output, and a golden-file test
+compares every output file of a small site with a checked-in copy, so changes in
+the output show up in review and the output is the same across runs.
+*/
+
+use std::borrow::Cow;
+use std::collections::{BTreeMap, BTreeSet};
+use std::io::{Read, Write};
+use std::sync::Arc;
+
+use base64::Engine;
+use base64::engine::general_purpose::STANDARD as BASE64;
+use percent_encoding::percent_decode_str;
+use serde::Deserialize;
+
+use pulldown_cmark::{
+    CodeBlockKind, CowStr, Event, HeadingLevel, LinkType, Parser, Tag, TagEnd, html,
+};
+use tracing::warn;
+
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle, ResultExt, bail};
+
+use crate::asset::{Asset, copy_asset_reference, dedup_assets};
+use crate::code_attrs::{CodeBlockAttrs, decorate_code_html, split_info};
+use crate::code_wrap::{
+    CodeWrap, DEFAULT_TAB_WIDTH, DEFAULT_WRAP_COLUMN, expand_tabs, wrap_code_html,
+};
+use crate::conditional::{apply_profiles, apply_profiles_to_all};
+use crate::diagram::{
+    DiagramMode, DiagramOptions, client_diagram_html, mermaid_runtime_script, render_static_diagram,
+};
+use crate::directive::{expand_directives, is_expansion};
+use crate::export::{LineMap, heading_level};
+use crate::flat::{MANIFEST_FILE, flat_page_slug, render_manifest};
+use crate::following_code::expand_following_code;
+use crate::footnote::{FootnotePart, PARSER_OPTIONS, PageFootnotes, split_footnote_references};
+use crate::include::resolve_relative_path;
+use crate::logger::{Logger, NoopLogger};
+use crate::parallel::{default_concurrency, parallel_map};
+use crate::path_mapper::drop_colliding_pages;
+use crate::reading_order::{OrderedPage, PageSequence, ReadingOrder};
+use crate::search_index::{SEARCH_INDEX_FILE, render_search_index};
+use crate::theme::{CodeBlockData, PageData, PageLink, TocData};
+use crate::toc::{build_toc_with_heading_offset, offset_heading_level};
+use crate::transform::apply_transformers;
+use crate::xref::{LinkTarget, TextPart, split_references};
+use crate::{
+    Config, DefaultSlugifier, Document, Highlighter, IssueLinker, MirrorPathMapper, PathMapper,
+    Slugifier, SymbolIndex, Theme, Toc, TocEntry, Transformer, WordCount, build_toc_with_slugifier,
+};
+
+/// Name of the table of contents page in the output directory.
+pub const INDEX_PAGE: &str = "index.html";
+
+/// Name of the stylesheet in the output directory.
+pub const STYLESHEET: &str = "style.css";
+
+const DEFAULT_STYLESHEET: &str = r#"body {
+  font-family: system-ui, sans-serif;
+  line-height: 1.5;
+  margin: 0 auto;
+  max-width: 50rem;
+  padding: 1rem;
+}
+pre {
+  background: #f5f5f5;
+  overflow-x: auto;
+  padding: 0.75rem;
+}
+article.document {
+  border-bottom: 1px solid #ddd;
+  padding-bottom: 1rem;
+}
+article.preamble {
+  font-size: 1.125rem;
+}
+article.skipped {
+  border-left: 3px dashed #ccc;
+  opacity: 0.6;
+  padding-left: 0.75rem;
+}
+p.source {
+  color: #666;
+  font-size: 0.875rem;
+}
+figure.diagram svg {
+  height: auto;
+  max-width: 100%;
+}
+div.code-title {
+  background: #e5e5e5;
+  font-family: monospace;
+  font-size: 0.875rem;
+  padding: 0.25rem 0.75rem;
+}
+div.code-block pre {
+  margin-top: 0;
+}
+span.code-line {
+  display: block;
+}
+span.code-line.highlighted {
+  background: #fff3bf;
+}
+div.line-numbers span.code-line::before {
+  color: #999;
+  content: attr(data-line);
+  display: inline-block;
+  margin-right: 1rem;
+  min-width: 2ch;
+  text-align: right;
+  user-select: none;
+}
+span.code-continuation {
+  color: #999;
+  user-select: none;
+}
+section.footnotes {
+  border-top: 1px solid #ddd;
+  font-size: 0.875rem;
+}
+nav.pages {
+  display: flex;
+  justify-content: space-between;
+  margin-top: 1rem;
+}
+nav.pages a.next {
+  margin-left: auto;
+}
+"#;
+
+/// Start of the warning about a `[[name]]` reference without a target.
+pub(crate) const UNRESOLVED_REFERENCE: &str = "Unresolved cross-reference";
+
+/// Additional styles for [`OutputMode::SingleFile`], separating the pages.
+const SINGLE_FILE_STYLESHEET: &str = r#"section.page {
+  border-top: 2px solid #999;
+  margin-top: 2rem;
+}
+"#;
+
+/// How the rendered site is split into files.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum OutputMode {
+    /// A stylesheet, the table of contents and one page per source file
+    #[default]
+    MultiFile,
+    /// One self-contained `index.html` with the stylesheet, table of contents,
+    /// all pages and images inlined
+    SingleFile,
+    /// All pages next to `index.html`, named after a slug of their source path,
+    /// with images inlined and an `index.json` manifest of the pages
+    Flat,
+}
+
+impl OutputMode {
+    /// Returns the output path of the page for a source file in this mode.
+    ///
+    /// Pages mirror the source tree (see [`page_path`]), except in the flat
+    /// layout, see [`crate::flat`]. Use [`RenderOptions::page_path`] for the
+    /// path with a custom [`PathMapper`].
+    pub fn page_path(self, source_path: &FilePath) -> FilePath {
+        match self {
+            OutputMode::MultiFile | OutputMode::SingleFile => page_path(source_path),
+            OutputMode::Flat => FilePath::from(format!("{}.html", flat_page_slug(source_path))),
+        }
+    }
+
+    /// Returns true if local images are embedded, as pages are not next to the sources.
+    pub(crate) fn embeds_images(self) -> bool {
+        matches!(self, OutputMode::SingleFile | OutputMode::Flat)
+    }
+}
+
+/// Options controlling how documents are rendered to HTML.
+#[derive(Debug, Clone, Default)]
+pub struct RenderOptions {
+    title: String,
+    highlighter: Option<Arc<dyn Highlighter>>,
+    concurrency: Option<usize>,
+    output_mode: OutputMode,
+    pal: Option<PalHandle>,
+    slugifier: Option<Arc<dyn Slugifier>>,
+    path_mapper: Option<Arc<dyn PathMapper>>,
+    heading_offset: usize,
+    profiles: BTreeSet<String>,
+    diagram_options: DiagramOptions,
+    transformers: Vec<Arc<dyn Transformer>>,
+    code_wrap: CodeWrap,
+    wrap_column: Option<usize>,
+    tab_width: Option<usize>,
+    theme: Theme,
+    search_index: bool,
+    logger: Option<Arc<dyn Logger>>,
+    reading_order: ReadingOrder,
+}
+
+impl RenderOptions {
+    /// Create render options with default settings.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Create render options from a site configuration.
+    pub fn from_config(config: &Config) -> Self {
+        let options = Self::new()
+            .with_title(&config.title)
+            .with_output_mode(config.output_mode.unwrap_or_default())
+            .with_heading_offset(config.heading_offset.unwrap_or_default())
+            .with_profiles(config.profiles.iter().flatten())
+            .with_diagram_options(DiagramOptions::from_config(config))
+            .with_code_wrap(config.code_wrap.unwrap_or_default())
+            .with_search_index(config.search_index.unwrap_or_default())
+            .with_reading_order(ReadingOrder::from_config(config));
+        let options = match config.code_wrap_column {
+            Some(column) => options.with_wrap_column(column),
+            None => options,
+        };
+        let options = match config.tab_width {
+            Some(tab_width) => options.with_tab_width(tab_width),
+            None => options,
+        };
+        let options = match &config.issue_link_template {
+            Some(url_template) => options.with_transformer(IssueLinker::new(url_template)),
+            None => options,
+        };
+        match config.concurrency {
+            Some(concurrency) => options.with_concurrency(concurrency),
+            None => options,
+        }
+    }
+
+    /// Set the site title shown on every page.
+    pub fn with_title(mut self, title: impl Into<String>) -> Self {
+        self.title = title.into();
+        self
+    }
+
+    /// Highlight fenced code blocks that declare a language with `highlighter`.
+    ///
+    /// Without a highlighter, code blocks are rendered as plain escaped text.
+    pub fn with_highlighter(mut self, highlighter: impl Highlighter + 'static) -> Self {
+        self.highlighter = Some(Arc::new(highlighter));
+        self
+    }
+
+    /// Set the number of pages rendered in parallel.
+    ///
+    /// Defaults to the number of CPU cores. The rendered output does not depend
+    /// on the concurrency.
+    pub fn with_concurrency(mut self, concurrency: usize) -> Self {
+        self.concurrency = Some(concurrency);
+        self
+    }
+
+    /// Set how the site is split into files (defaults to [`OutputMode::MultiFile`]).
+    pub fn with_output_mode(mut self, output_mode: OutputMode) -> Self {
+        self.output_mode = output_mode;
+        self
+    }
+
+    /// Read the images embedded in [`OutputMode::SingleFile`] and [`OutputMode::Flat`] output through `pal`.
+    ///
+    /// Static diagram renderers are also run through `pal`.
+    pub fn with_pal(mut self, pal: PalHandle) -> Self {
+        self.pal = Some(pal);
+        self
+    }
+
+    /// Report rendering problems to `logger` as well, see [`crate::logger`].
+    pub fn with_logger(mut self, logger: impl Logger + 'static) -> Self {
+        self.logger = Some(Arc::new(logger));
+        self
+    }
+
+    /// Derive heading anchors with `slugifier` instead of the [`DefaultSlugifier`].
+    pub fn with_slugifier(mut self, slugifier: impl Slugifier + 'static) -> Self {
+        self.slugifier = Some(Arc::new(slugifier));
+        self
+    }
+
+    /// Place the pages of source files with `path_mapper` instead of the [`MirrorPathMapper`].
+    ///
+    /// Pages that collide are left out with a warning, see [`crate::path_mapper`].
+    pub fn with_path_mapper(mut self, path_mapper: impl PathMapper + 'static) -> Self {
+        self.path_mapper = Some(Arc::new(path_mapper));
+        self
+    }
+
+    /// Shift every heading down by `heading_offset` levels, in the pages and the TOC.
+    ///
+    /// A `#` heading becomes `##` with an offset of 1. Levels beyond 6 are
+    /// clamped to 6 with a warning, see [`crate::toc`].
+    pub fn with_heading_offset(mut self, heading_offset: usize) -> Self {
+        self.heading_offset = heading_offset;
+        self
+    }
+
+    /// Set the build profiles whose `{{if profile}}` blocks are shown.
+    ///
+    /// Blocks of other profiles are omitted, see [`crate::conditional`].
+    pub fn with_profiles(mut self, profiles: impl IntoIterator<Item = impl Into<String>>) -> Self {
+        self.profiles = profiles.into_iter().map(Into::into).collect();
+        self
+    }
+
+    /// Set whether and how diagram code blocks (e.g. `mermaid`) are rendered as diagrams.
+    ///
+    /// Diagrams are off by default, see [`crate::diagram`]. Static rendering
+    /// runs commands through the PAL set with [`Self::with_pal`].
+    pub fn with_diagram_options(mut self, diagram_options: DiagramOptions) -> Self {
+        self.diagram_options = diagram_options;
+        self
+    }
+
+    /// Add a transformer rewriting every document before it is rendered.
+    ///
+    /// Transformers run in the order they are added, see [`crate::transform`].
+    pub fn with_transformer(mut self, transformer: impl Transformer + 'static) -> Self {
+        self.transformers.push(Arc::new(transformer));
+        self
+    }
+
+    /// Set how code lines wider than the page are shown (defaults to [`CodeWrap::Scroll`]).
+    ///
+    /// See [`crate::code_wrap`].
+    pub fn with_code_wrap(mut self, code_wrap: CodeWrap) -> Self {
+        self.code_wrap = code_wrap;
+        self
+    }
+
+    /// Set the column at which code lines are wrapped with [`CodeWrap::Wrap`].
+    pub fn with_wrap_column(mut self, wrap_column: usize) -> Self {
+        self.wrap_column = Some(wrap_column);
+        self
+    }
+
+    /// Expand tabs in code blocks to `tab_width` columns.
+    ///
+    /// Without a tab width, tabs are only expanded when wrapping, to 4 columns.
+    pub fn with_tab_width(mut self, tab_width: usize) -> Self {
+        self.tab_width = Some(tab_width);
+        self
+    }
+
+    /// Render pages with the templates of `theme` instead of the built-in ones.
+    ///
+    /// See [`crate::theme`].
+    pub fn with_theme(mut self, theme: Theme) -> Self {
+        self.theme = theme;
+        self
+    }
+
+    /// Set whether the site includes an index for client-side search (defaults to false).
+    ///
+    /// See [`crate::search_index`].
+    pub fn with_search_index(mut self, search_index: bool) -> Self {
+        self.search_index = search_index;
+        self
+    }
+
+    /// Set the order pages are read in (defaults to sorted by source path).
+    ///
+    /// See [`crate::reading_order`].
+    pub fn with_reading_order(mut self, reading_order: ReadingOrder) -> Self {
+        self.reading_order = reading_order;
+        self
+    }
+
+    /// Returns the PAL images and assets are read through, if any.
+    pub(crate) fn pal(&self) -> Option<&PalHandle> {
+        self.pal.as_ref()
+    }
+
+    /// Returns the site title.
+    pub fn title(&self) -> &str {
+        &self.title
+    }
+
+    /// Returns the code block highlighter, if any.
+    pub fn highlighter(&self) -> Option<&dyn Highlighter> {
+        self.highlighter.as_deref()
+    }
+
+    /// Returns the number of pages rendered in parallel.
+    pub fn concurrency(&self) -> usize {
+        self.concurrency.unwrap_or_else(default_concurrency)
+    }
+
+    /// Returns how the site is split into files.
+    pub fn output_mode(&self) -> OutputMode {
+        self.output_mode
+    }
+
+    /// Returns the logger rendering problems are reported to.
+    pub fn logger(&self) -> &dyn Logger {
+        self.logger.as_deref().unwrap_or(&NoopLogger)
+    }
+
+    /// Returns the slugifier deriving heading anchors.
+    pub fn slugifier(&self) -> &dyn Slugifier {
+        self.slugifier.as_deref().unwrap_or(&DefaultSlugifier)
+    }
+
+    /// Returns the path mapper placing the pages of source files.
+    pub fn path_mapper(&self) -> &dyn PathMapper {
+        self.path_mapper.as_deref().unwrap_or(&MirrorPathMapper)
+    }
+
+    /// Returns true if a custom path mapper places the pages.
+    pub(crate) fn maps_paths(&self) -> bool {
+        self.path_mapper.is_some()
+    }
+
+    /// Returns the output path of the HTML page for a source file.
+    ///
+    /// Pages are placed by the path mapper, except in the flat layout, see
+    /// [`crate::path_mapper`].
+    pub fn page_path(&self, source_path: &FilePath) -> FilePath {
+        self.page_path_with_extension(source_path, "html")
+    }
+
+    /// Returns the output path of the page for a source file in a format with `extension`.
+    pub(crate) fn page_path_with_extension(
+        &self,
+        source_path: &FilePath,
+        extension: &str,
+    ) -> FilePath {
+        let page = match self.output_mode {
+            OutputMode::MultiFile | OutputMode::SingleFile => {
+                self.path_mapper().map_path(source_path).to_string()
+            }
+            OutputMode::Flat => flat_page_slug(source_path),
+        };
+        FilePath::from(format!("{}.{}", page, extension))
+    }
+
+    /// Returns the number of levels every heading is shifted down by.
+    pub fn heading_offset(&self) -> usize {
+        self.heading_offset
+    }
+
+    /// Returns the active build profiles.
+    pub fn profiles(&self) -> &BTreeSet<String> {
+        &self.profiles
+    }
+
+    /// Returns how diagram code blocks are rendered.
+    pub fn diagram_options(&self) -> &DiagramOptions {
+        &self.diagram_options
+    }
+
+    /// Returns how code lines wider than the page are shown.
+    pub fn code_wrap(&self) -> CodeWrap {
+        self.code_wrap
+    }
+
+    /// Returns the column at which code lines are wrapped.
+    pub fn wrap_column(&self) -> usize {
+        self.wrap_column.unwrap_or(DEFAULT_WRAP_COLUMN)
+    }
+
+    /// Returns the width tabs in code blocks are expanded to, None if they are kept.
+    pub fn tab_width(&self) -> Option<usize> {
+        match self.code_wrap {
+            CodeWrap::Scroll => self.tab_width,
+            CodeWrap::Wrap => Some(self.tab_width.unwrap_or(DEFAULT_TAB_WIDTH)),
+        }
+    }
+
+    /// Returns the theme pages are rendered with.
+    pub fn theme(&self) -> &Theme {
+        &self.theme
+    }
+
+    /// Returns true if the site includes an index for client-side search.
+    pub fn search_index(&self) -> bool {
+        self.search_index
+    }
+
+    /// Returns the order pages are read in.
+    pub fn reading_order(&self) -> &ReadingOrder {
+        &self.reading_order
+    }
+
+    /// Returns the document transformers, in the order they run.
+    pub fn transformers(&self) -> impl Iterator<Item = &dyn Transformer> {
+        self.transformers.iter().map(|transformer| &**transformer)
+    }
+
+    /// Identifies all options that affect rendered output, for the build cache.
+    pub(crate) fn cache_key(&self) -> String {
+        let highlighter = self
+            .highlighter()
+            .map(Highlighter::cache_key)
+            .unwrap_or_else(|| "none".to_string());
+        let transformers: Vec<String> = self.transformers().map(Transformer::cache_key).collect();
+        format!(
+            "title={:?};highlighter={:?};output_mode={:?};slugifier={:?};path_mapper={:?};heading_offset={};profiles={:?};diagrams={:?};transformers={:?};code_wrap={:?};wrap_column={};tab_width={:?};theme={};reading_order={}",
+            self.title,
+            highlighter,
+            self.output_mode,
+            self.slugifier().cache_key(),
+            self.path_mapper().cache_key(),
+            self.heading_offset,
+            self.profiles,
+            self.diagram_options.cache_key(),
+            transformers,
+            self.code_wrap,
+            self.wrap_column(),
+            self.tab_width(),
+            self.theme.cache_key(),
+            self.reading_order.cache_key()
+        )
+    }
+}
+
+/// A rendered file, ready to be written to the output directory.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RenderedFile {
+    /// Path relative to the output directory
+    pub path: FilePath,
+    /// File content
+    pub content: String,
+}
+
+/// Returns the output path of the page for a source file.
+///
+/// # Examples
+/// ```
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::page_path;
+///
+/// assert_eq!(page_path(&FilePath::from("src/lib.rs")), FilePath::from("src/lib.rs.html"));
+/// ```
+pub fn page_path(source_path: &FilePath) -> FilePath {
+    FilePath::from(format!("{}.html", source_path))
+}
+
+/// A problem found while rendering, e.g. an unresolved cross-reference.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RenderWarning {
+    /// Source file containing the problem
+    pub file_path: FilePath,
+    /// Source line of the problem (1-indexed)
+    pub line: usize,
+    /// Description of the problem
+    pub message: String,
+}
+
+impl RenderWarning {
+    /// Report the warning to `logger`, with its source location.
+    pub(crate) fn log(&self, logger: &dyn Logger) {
+        logger.warn(
+            &self.message,
+            &[("file", &self.file_path), ("line", &self.line)],
+        );
+    }
+}
+
+impl std::fmt::Display for RenderWarning {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}:{}: {}", self.file_path, self.line, self.message)
+    }
+}
+
+/// Results from rendering documents.
+///
+/// Like extraction, rendering is fail-tolerant: problems are reported as
+/// warnings and the affected content is rendered as well as possible.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct RenderResult {
+    /// Rendered files
+    pub files: Vec<RenderedFile>,
+    /// Problems found while rendering
+    pub warnings: Vec<RenderWarning>,
+    /// Files of the source tree to copy into the output, sorted by output path,
+    /// see [`crate::asset`]
+    pub assets: Vec<Asset>,
+}
+
+/* 📖 # Why a Renderer trait?
+
+The woven site can be produced in several formats: HTML pages to browse, and
+Markdown to feed into other tools such as Pandoc. Each format is a `Renderer`
+that turns all documents of a site into output files. The renderers share
+everything format independent: the site map (heading anchors and cross-reference
+targets), `RenderOptions`, `RenderResult` with its warnings and `write_site`.
+A new format only implements `render_site`.
+*/
+
+/// An output format the documents of a site can be rendered to.
+pub trait Renderer: std::fmt::Debug + Send + Sync {
+    /// Name of the output format, e.g. "html".
+    fn name(&self) -> &'static str;
+
+    /// Render all documents to output files.
+    fn render_site(&self, documents: &[Document], options: &RenderOptions) -> RenderResult;
+}
+
+/// Renders the site as HTML pages, see [`render_site`].
+#[derive(Debug, Clone, Copy, Default)]
+pub struct HtmlRenderer;
+
+impl HtmlRenderer {
+    /// Create an HTML renderer.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Renderer for HtmlRenderer {
+    fn name(&self) -> &'static str {
+        "html"
+    }
+
+    fn render_site(&self, documents: &[Document], options: &RenderOptions) -> RenderResult {
+        render_site(documents, options)
+    }
+}
+
+/// Format of the rendered site.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum OutputFormat {
+    /// HTML pages, see [`HtmlRenderer`]
+    #[default]
+    Html,
+    /// GitHub-flavored Markdown, see [`MarkdownRenderer`](crate::MarkdownRenderer)
+    Markdown,
+}
+
+impl OutputFormat {
+    /// Returns the renderer producing this format.
+    pub fn renderer(&self) -> Box<dyn Renderer> {
+        match self {
+            Self::Html => Box::new(HtmlRenderer::new()),
+            Self::Markdown => Box::new(crate::MarkdownRenderer::new()),
+        }
+    }
+}
+
+/// Where the headings and symbols of all documents end up in the rendered site.
+///
+/// Pages link to each other through the site map, so it must always be built
+/// from all documents of the site, even when only some pages are rendered.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct SiteMap {
+    /// Table of contents, including the anchors of all headings
+    pub toc: Toc,
+    /// Targets of `[[name]]` cross-references
+    pub symbols: SymbolIndex,
+    /// Pages in reading order, see [`crate::reading_order`]
+    pub pages: PageSequence,
+}
+
+impl SiteMap {
+    /// Build the site map for a set of documents.
+    pub fn build(documents: &[Document]) -> Self {
+        Self::build_with_slugifier(documents, &DefaultSlugifier)
+    }
+
+    /// Build the site map, deriving heading anchors with `slugifier`.
+    pub fn build_with_slugifier(documents: &[Document], slugifier: &dyn Slugifier) -> Self {
+        let toc = build_toc_with_slugifier(documents, slugifier);
+        let symbols = SymbolIndex::build(documents, &toc);
+        Self::arranged(toc, symbols, documents, &ReadingOrder::new())
+    }
+
+    /// Build the site map matching the pages rendered with `options`.
+    ///
+    /// Uses the slugifier, heading offset, profiles and reading order of the options.
+    pub fn build_with_options(documents: &[Document], options: &RenderOptions) -> Self {
+        let documents = apply_profiles_to_all(documents, options.profiles());
+        let toc = build_toc_with_heading_offset(
+            &documents,
+            options.slugifier(),
+            options.heading_offset(),
+            options.output_mode() == OutputMode::SingleFile,
+        );
+        let symbols = SymbolIndex::build(&documents, &toc);
+        Self::arranged(toc, symbols, &documents, options.reading_order())
+    }
+
+    /// Arrange the pages of `documents` and the TOC in `reading_order`.
+    ///
+    /// Anchors and symbols are derived in source order, so they do not depend
+    /// on the reading order.
+    fn arranged(
+        mut toc: Toc,
+        symbols: SymbolIndex,
+        documents: &[Document],
+        reading_order: &ReadingOrder,
+    ) -> Self {
+        let pages = group_by_file(documents)
+            .into_iter()
+            .map(|(source_path, file_documents)| OrderedPage {
+                title: page_title(&source_path, &file_documents),
+                source_path,
+            })
+            .collect();
+        let pages = reading_order.arrange(pages);
+        toc.arrange(&pages);
+        Self {
+            toc,
+            symbols,
+            pages,
+        }
+    }
+
+    /// Group documents by source file, in reading order with unlisted pages last.
+    pub(crate) fn pages<'a>(
+        &self,
+        documents: &'a [Document],
+    ) -> Vec<(FilePath, Vec<&'a Document>)> {
+        let mut groups = group_by_file(documents);
+        self.pages
+            .sort_by_page(&mut groups, |(source_path, _)| source_path);
+        groups
+    }
+
+    /// Returns a warning for each page left out of the reading order.
+    pub(crate) fn unlisted_warnings(&self) -> Vec<RenderWarning> {
+        self.pages
+            .unlisted
+            .iter()
+            .map(|page| RenderWarning {
+                file_path: page.source_path.clone(),
+                line: 1,
+                message: "Page is not listed in the reading order".to_string(),
+            })
+            .collect()
+    }
+}
+
+/// Render all documents to a static site.
+///
+/// Returns the stylesheet, the table of contents page and one page per source
+/// file, in that order. Pages follow the reading order of the options, see
+/// [`crate::reading_order`]. Pages are rendered in
+/// parallel (see [`RenderOptions::with_concurrency`]), with the same result.
+///
+/// With [`OutputMode::SingleFile`], only `index.html` is returned, containing
+/// everything else. With [`OutputMode::Flat`], the `index.json` manifest
+/// follows the table of contents. With [`RenderOptions::with_search_index`],
+/// the search index comes next. The documents are transformed with the transformers of the
+/// options first. Pages the path mapper places onto another file are left out
+/// with a warning, see [`crate::path_mapper`].
+pub fn render_site(documents: &[Document], options: &RenderOptions) -> RenderResult {
+    let (documents, warnings) = site_documents(documents, options);
+    let mut result = if options.output_mode() == OutputMode::SingleFile {
+        render_single_file(&documents, options)
+    } else {
+        render_multi_file(&documents, options)
+    };
+    result.warnings.splice(0..0, warnings);
+    for warning in &result.warnings {
+        warning.log(options.logger());
+    }
+    result
+}
+
+/// Returns the documents of the pages of the site, transformed by the options.
+///
+/// Leaves out the pages that cannot be written, see [`crate::path_mapper`].
+pub(crate) fn site_documents<'a>(
+    documents: &'a [Document],
+    options: &RenderOptions,
+) -> (Cow<'a, [Document]>, Vec<RenderWarning>) {
+    let (documents, mut warnings) = apply_transformers(documents, options);
+    let (documents, collision_warnings) = drop_colliding_pages(
+        documents,
+        &[INDEX_PAGE, STYLESHEET, MANIFEST_FILE, SEARCH_INDEX_FILE],
+        |source_path| options.page_path(source_path),
+    );
+    warnings.extend(collision_warnings);
+    (documents, warnings)
+}
+
+/// Render the stylesheet, the table of contents and one page per source file.
+///
+/// In the flat layout, the manifest of the pages comes after the table of
+/// contents, followed by the search index if enabled.
+fn render_multi_file(documents: &[Document], options: &RenderOptions) -> RenderResult {
+    let site_map = SiteMap::build_with_options(documents, options);
+    let groups = site_map.pages(documents);
+    let mut result = RenderResult {
+        files: render_site_files(&site_map, options),
+        warnings: site_map.unlisted_warnings(),
+        assets: Vec::new(),
+    };
+    result.files.extend(site_manifest(&groups, options));
+    result
+        .files
+        .extend(render_search_index(&groups, &site_map, options));
+    let pages = parallel_map(
+        &groups,
+        options.concurrency(),
+        |(source_path, file_documents)| {
+            render_file_page(source_path, file_documents, &site_map, options)
+        },
+    );
+    for page in pages {
+        result.files.extend(page.files);
+        result.warnings.extend(page.warnings);
+        result.assets.extend(page.assets);
+    }
+    dedup_assets(&mut result.assets);
+    result
+}
+
+/// Render the whole site into one self-contained `index.html`, and the search index if enabled.
+fn render_single_file(documents: &[Document], options: &RenderOptions) -> RenderResult {
+    let site_map = SiteMap::build_with_options(documents, options);
+    let groups = site_map.pages(documents);
+    let sections = parallel_map(
+        &groups,
+        options.concurrency(),
+        |(source_path, file_documents)| {
+            let page = options.page_path(source_path);
+            let mut warnings = Vec::new();
+            let mut assets = Vec::new();
+            let body = render_page_body(
+                source_path,
+                file_documents,
+                &page,
+                &site_map,
+                options,
+                &mut warnings,
+                &mut assets,
+            );
+            let section = format!(
+                "<section class=\"page\" id=\"{}\">\n<h1 class=\"page-title\">{}</h1>\n{}</section>\n",
+                escape_html(&page.to_string()),
+                escape_html(&source_path.to_string()),
+                body
+            );
+            (section, warnings, assets)
+        },
+    );
+
+    let mut body = render_toc_body(&site_map.toc, options);
+    let mut warnings = site_map.unlisted_warnings();
+    let mut assets = Vec::new();
+    for (section, section_warnings, section_assets) in sections {
+        body.push_str(&section);
+        warnings.extend(section_warnings);
+        assets.extend(section_assets);
+    }
+    dedup_assets(&mut assets);
+    let mut files = vec![RenderedFile {
+        path: FilePath::from(INDEX_PAGE),
+        content: render_layout(
+            options.title(),
+            "",
+            &body,
+            [None, None],
+            &[],
+            &site_map.toc,
+            options,
+        ),
+    }];
+    files.extend(render_search_index(&groups, &site_map, options));
+    RenderResult {
+        files,
+        warnings,
+        assets,
+    }
+}
+
+/// Render the files shared by all pages: the stylesheet and the table of contents.
+pub(crate) fn render_site_files(site_map: &SiteMap, options: &RenderOptions) -> Vec<RenderedFile> {
+    vec![
+        RenderedFile {
+            path: FilePath::from(STYLESHEET),
+            content: DEFAULT_STYLESHEET.to_string(),
+        },
+        render_index_page(&site_map.toc, options),
+    ]
+}
+
+/// Render the manifest of the pages in the flat layout, None in other modes.
+pub(crate) fn site_manifest(
+    groups: &[(FilePath, Vec<&Document>)],
+    options: &RenderOptions,
+) -> Option<RenderedFile> {
+    if options.output_mode() != OutputMode::Flat {
+        return None;
+    }
+    let mut pages = vec![(
+        None,
+        FilePath::from(INDEX_PAGE),
+        options.title().to_string(),
+    )];
+    pages.extend(groups.iter().map(|(source_path, documents)| {
+        (
+            Some(source_path.clone()),
+            options.page_path(source_path),
+            page_title(source_path, documents),
+        )
+    }));
+    Some(render_manifest(pages))
+}
+
+/// Returns the title of the page for a source file.
+///
+/// A file preamble names the page, see [`crate::extractor`], other pages are
+/// named after the source path.
+pub(crate) fn page_title(source_path: &FilePath, documents: &[&Document]) -> String {
+    documents
+        .iter()
+        .filter(|document| document.is_preamble())
+        .min_by_key(|document| document.source().line_number())
+        .map(|document| document.title().to_string())
+        .unwrap_or_else(|| source_path.to_string())
+}
+
+/// Render the page for one source file.
+///
+/// `documents` are the documents extracted from the file, already transformed
+/// with the transformers of the options. `site_map` must be built from all
+/// documents of the site so links and anchors match other pages.
+pub fn render_file_page(
+    source_path: &FilePath,
+    documents: &[&Document],
+    site_map: &SiteMap,
+    options: &RenderOptions,
+) -> RenderResult {
+    let path = options.page_path(source_path);
+    let root = relative_root(&path);
+    let mut warnings = Vec::new();
+    let mut assets = Vec::new();
+    let body = render_page_body(
+        source_path,
+        documents,
+        &path,
+        site_map,
+        options,
+        &mut warnings,
+        &mut assets,
+    );
+    dedup_assets(&mut assets);
+
+    let title = page_title(source_path, documents);
+    let neighbours = neighbour_links(source_path, &root, &site_map.pages, options);
+
+    RenderResult {
+        files: vec![RenderedFile {
+            content: render_layout(
+                &title,
+                &root,
+                &body,
+                neighbours,
+                documents,
+                &site_map.toc,
+                options,
+            ),
+            path,
+        }],
+        warnings,
+        assets,
+    }
+}
+
+/// Render the documents of one source file, in source order, as shown on `page`.
+fn render_page_body(
+    source_path: &FilePath,
+    documents: &[&Document],
+    page: &FilePath,
+    site_map: &SiteMap,
+    options: &RenderOptions,
+    warnings: &mut Vec<RenderWarning>,
+    assets: &mut Vec<Asset>,
+) -> String {
+    let mut sorted = documents.to_vec();
+    sorted.sort_by_key(|document| document.source().line_number());
+    let id_prefix = match options.output_mode() {
+        OutputMode::MultiFile | OutputMode::Flat => String::new(),
+        OutputMode::SingleFile => format!("{}-", page),
+    };
+    let mut footnotes = PageFootnotes::collect(&sorted, options.profiles(), id_prefix);
+
+    let mut body = String::new();
+    for document in sorted {
+        body.push_str(match (document.is_preamble(), document.is_skipped()) {
+            (false, false) => "<article class=\"document\">\n",
+            (true, false) => "<article class=\"document preamble\">\n",
+            (false, true) => "<article class=\"document skipped\">\n",
+            (true, true) => "<article class=\"document preamble skipped\">\n",
+        });
+        body.push_str(&render_document(
+            document,
+            page,
+            site_map,
+            options,
+            &mut footnotes,
+            warnings,
+            assets,
+        ));
+        body.push_str(&format!(
+            "<p class=\"source\">{}:{}</p>\n",
+            escape_html(&source_path.to_string()),
+            document.source().line_number()
+        ));
+        body.push_str("</article>\n");
+    }
+    body.push_str(&footnotes.render());
+    body
+}
+
+/// Render the table of contents page.
+pub fn render_index_page(toc: &Toc, options: &RenderOptions) -> RenderedFile {
+    RenderedFile {
+        path: FilePath::from(INDEX_PAGE),
+        content: render_layout(
+            "Contents",
+            "",
+            &render_toc_body(toc, options),
+            [None, None],
+            &[],
+            toc,
+            options,
+        ),
+    }
+}
+
+fn render_toc_body(toc: &Toc, options: &RenderOptions) -> String {
+    let mut entries = String::new();
+    render_toc_parts(toc, options, "", &mut entries);
+    let body = options.theme().render_toc(&TocData {
+        site_title: options.title().to_string(),
+        entries,
+    });
+    match options.output_mode() {
+        OutputMode::MultiFile | OutputMode::Flat => body,
+        // The site link of the single page points here, whatever the theme
+        OutputMode::SingleFile => format!("<section id=\"contents\">\n{body}</section>\n"),
+    }
+}
+
+/// Write rendered files below `output_directory`, creating directories as needed.
+///
+/// The assets of a [`RenderResult`] are copied separately, with [`crate::copy_assets`].
+pub fn write_site(
+    pal: &PalHandle,
+    output_directory: &FilePath,
+    files: &[RenderedFile],
+) -> HyperlitResult<()> {
+    for file in files {
+        let path = FilePath::from(output_directory.as_relative().join(file.path.as_relative()));
+        write_file(pal, &path, &file.content)
+            .with_context(|| format!("Failed to write output file '{}'", path))?;
+    }
+    Ok(())
+}
+
+pub(crate) fn write_file(pal: &PalHandle, path: &FilePath, content: &str) -> HyperlitResult<()> {
+    if let Some(parent) = path.as_relative().parent() {
+        pal.create_directory_all(&FilePath::from(parent))?;
+    }
+    let mut writer = pal.create_file(path)?;
+    writer.write_all(content.as_bytes())?;
+    writer.flush()?;
+    Ok(())
+}
+
+/// Group documents by source file, sorted by source path.
+pub(crate) fn group_by_file(documents: &[Document]) -> Vec<(FilePath, Vec<&Document>)> {
+    let mut files: BTreeMap<String, (FilePath, Vec<&Document>)> = BTreeMap::new();
+    for document in documents {
+        let source_path = document.source().file_path();
+        files
+            .entry(source_path.to_string())
+            .or_insert_with(|| (source_path.clone(), Vec::new()))
+            .1
+            .push(document);
+    }
+    files.into_values().collect()
+}
+
+/// Render the markdown content of a document on `page` to HTML.
+///
+/// Headings get their anchors from the site map, `[[name]]` references are
+/// resolved to links and code blocks are highlighted if a highlighter is set.
+/// Footnote references are numbered with the `footnotes` of the page, their
+/// definitions are left out and rendered with the footnotes.
+fn render_document(
+    document: &Document,
+    page: &FilePath,
+    site_map: &SiteMap,
+    options: &RenderOptions,
+    footnotes: &mut PageFootnotes,
+    warnings: &mut Vec<RenderWarning>,
+    assets: &mut Vec<Asset>,
+) -> String {
+    let document = &*apply_profiles(document, options.profiles());
+    let content = document.content();
+    let lines = LineMap::new(content, document.source().line_number());
+    let mut anchors = site_map.toc.anchors(document.id()).iter();
+    let mut events = Vec::new();
+    // Adjacent text events (and the offset of the first), merged to find references
+    let mut pending_text: Option<(String, usize)> = None;
+    let mut in_code_block = false;
+    // Events of the code block currently being highlighted, and its language and code
+    let mut code_block: Option<(Vec<Event>, String, String)> = None;
+    // Attributes of the code block in `code_block`, if it declares valid ones
+    let mut code_attrs: Option<CodeBlockAttrs> = None;
+    // Start offset of the code block in `code_block`, if it is rendered as a diagram
+    let mut diagram_start = None;
+    // Warnings about images and headings, collected separately, `flush_text` holds on to `warnings`
+    let mut event_warnings = Vec::new();
+    let mut in_footnote_definition = false;
+
+    // Returns the URL of the copy of a referenced asset, see `crate::asset`
+    let mut copy_asset = |url: &str,
+                          image: bool,
+                          offset: usize,
+                          event_warnings: &mut Vec<RenderWarning>|
+     -> Option<String> {
+        let source_path = document.source().file_path();
+        match copy_asset_reference(page, source_path, url, image, options) {
+            Ok(Some((href, asset))) => {
+                assets.push(asset);
+                Some(href)
+            }
+            Ok(None) => None,
+            Err(reason) => {
+                event_warnings.push(RenderWarning {
+                    file_path: source_path.clone(),
+                    line: lines.line_of(offset),
+                    message: format!("Asset '{}' not copied: {}", url, reason),
+                });
+                None
+            }
+        }
+    };
+
+    let mut flush_text = |pending_text: &mut Option<(String, usize)>,
+                          events: &mut Vec<Event>,
+                          footnotes: &mut PageFootnotes| {
+        let Some((text, start)) = pending_text.take() else {
+            return;
+        };
+        // Parts are contiguous, this is the offset of the current part in `text`
+        let mut part_offset = 0;
+        for part in split_references(&text) {
+            match part {
+                TextPart::Text(text) => {
+                    for footnote_part in split_footnote_references(text) {
+                        match footnote_part {
+                            FootnotePart::Text(text) => {
+                                events.push(Event::Text(text.to_string().into()))
+                            }
+                            FootnotePart::Reference { label, offset } => {
+                                match footnotes.reference(label) {
+                                    Some(reference) => events.push(Event::Html(reference.into())),
+                                    None => {
+                                        warnings.push(RenderWarning {
+                                            file_path: document.source().file_path().clone(),
+                                            line: lines.line_of(start + part_offset + offset),
+                                            message: format!(
+                                                "Footnote '[^{}]' has no definition",
+                                                label
+                                            ),
+                                        });
+                                        events.push(Event::Text(format!("[^{}]", label).into()));
+                                    }
+                                }
+                            }
+                        }
+                    }
+                    part_offset += text.len();
+                }
+                TextPart::Reference {
+                    name,
+                    offset,
+                    length,
+                } => {
+                    part_offset = offset + length;
+                    match site_map.symbols.resolve(name) {
+                        Some(target) => {
+                            events.push(Event::Start(Tag::Link {
+                                link_type: LinkType::Inline,
+                                dest_url: link_href(page, target, options).into(),
+                                title: CowStr::Borrowed(""),
+                                id: CowStr::Borrowed(""),
+                            }));
+                            events.push(Event::Text(name.to_string().into()));
+                            events.push(Event::End(TagEnd::Link));
+                        }
+                        None => {
+                            warnings.push(RenderWarning {
+                                file_path: document.source().file_path().clone(),
+                                line: lines.line_of(start + offset),
+                                message: format!("{} '[[{}]]'", UNRESOLVED_REFERENCE, name),
+                            });
+                            events.push(Event::Text(format!("[[{}]]", name).into()));
+                        }
+                    }
+                }
+            }
+        }
+    };
+
+    let parsed = expand_directives(
+        document,
+        Parser::new_ext(content, PARSER_OPTIONS).into_offset_iter(),
+    );
+    let parsed = expand_following_code(document, parsed);
+    for (event, range) in parsed {
+        // Definitions are rendered with the footnotes of the page
+        match event {
+            Event::Start(Tag::FootnoteDefinition(_)) => in_footnote_definition = true,
+            Event::End(TagEnd::FootnoteDefinition) => in_footnote_definition = false,
+            _ => {}
+        }
+        if in_footnote_definition || matches!(event, Event::End(TagEnd::FootnoteDefinition)) {
+            continue;
+        }
+        if let Event::Text(text) = &event
+            && !in_code_block
+        {
+            pending_text
+                .get_or_insert_with(|| (String::new(), range.start))
+                .0
+                .push_str(text);
+            continue;
+        }
+        flush_text(&mut pending_text, &mut events, footnotes);
+
+        if let Event::Start(Tag::CodeBlock(CodeBlockKind::Fenced(info))) = &event {
+            // Malformed attributes are ignored, the block is rendered without them
+            code_attrs = CodeBlockAttrs::parse(info).unwrap_or_else(|e| {
+                event_warnings.push(RenderWarning {
+                    file_path: document.source().file_path().clone(),
+                    line: lines.line_of(range.start),
+                    message: format!("Ignoring code block attributes: {}", e),
+                });
+                None
+            });
+        }
+        match event {
+            Event::FootnoteReference(label) => match footnotes.reference(&label) {
+                Some(reference) => events.push(Event::Html(reference.into())),
+                None => events.push(Event::Text(format!("[^{}]", label).into())),
+            },
+            Event::Start(Tag::Heading {
+                level,
+                id,
+                classes,
+                attrs,
+            }) => {
+                let (shifted, clamped) = offset_heading_level(level, options.heading_offset());
+                if clamped {
+                    event_warnings.push(clamped_heading_warning(
+                        document,
+                        lines.line_of(range.start),
+                        level,
+                        options.heading_offset(),
+                    ));
+                }
+                // Headings of directive expansions have no anchor in the site map
+                let anchor = match is_expansion(content, &range) {
+                    true => None,
+                    false => anchors.next(),
+                };
+                events.push(Event::Start(Tag::Heading {
+                    level: shifted,
+                    id: anchor.map(|anchor| anchor.clone().into()).or(id),
+                    classes,
+                    attrs,
+                }))
+            }
+            Event::End(TagEnd::Heading(level)) => events.push(Event::End(TagEnd::Heading(
+                offset_heading_level(level, options.heading_offset()).0,
+            ))),
+            Event::Start(Tag::CodeBlock(CodeBlockKind::Fenced(ref info)))
+                if info_language(info)
+                    .is_some_and(|language| options.diagram_options().renders(language)) =>
+            {
+                in_code_block = true;
+                diagram_start = Some(range.start);
+                let language = info_language(info).unwrap_or_default().to_string();
+                code_block = Some((vec![event], language, String::new()));
+            }
+            Event::Start(Tag::CodeBlock(CodeBlockKind::Fenced(ref info)))
+                if options.highlighter().is_some() && info_language(info).is_some() =>
+            {
+                in_code_block = true;
+                let language = info_language(info).unwrap_or_default().to_string();
+                code_block = Some((vec![event], language, String::new()));
+            }
+            // Plain code blocks are collected as well when their layout changes
+            Event::Start(Tag::CodeBlock(ref kind))
+                if options.code_wrap() == CodeWrap::Wrap
+                    || options.tab_width().is_some()
+                    || options.theme().overrides_code_block()
+                    || code_attrs.is_some() =>
+            {
+                in_code_block = true;
+                let language = match kind {
+                    CodeBlockKind::Fenced(info) => info_language(info).unwrap_or_default(),
+                    CodeBlockKind::Indented => "",
+                }
+                .to_string();
+                code_block = Some((vec![event], language, String::new()));
+            }
+            Event::Start(Tag::CodeBlock(_)) => {
+                in_code_block = true;
+                events.push(event);
+            }
+            Event::Start(Tag::Image {
+                link_type,
+                dest_url,
+                title,
+                id,
+            }) if options.output_mode().embeds_images() => {
+                let source_path = document.source().file_path();
+                let dest_url = match embed_image(source_path, &dest_url, options) {
+                    Ok(Some(data_uri)) => data_uri.into(),
+                    Ok(None) => dest_url,
+                    Err(reason) => {
+                        event_warnings.push(RenderWarning {
+                            file_path: source_path.clone(),
+                            line: lines.line_of(range.start),
+                            message: format!("Image '{}' not embedded: {}", dest_url, reason),
+                        });
+                        dest_url
+                    }
+                };
+                events.push(Event::Start(Tag::Image {
+                    link_type,
+                    dest_url,
+                    title,
+                    id,
+                }));
+            }
+            Event::Start(Tag::Image {
+                link_type,
+                dest_url,
+                title,
+                id,
+            }) => {
+                let dest_url = copy_asset(&dest_url, true, range.start, &mut event_warnings)
+                    .map_or(dest_url, CowStr::from);
+                events.push(Event::Start(Tag::Image {
+                    link_type,
+                    dest_url,
+                    title,
+                    id,
+                }));
+            }
+            Event::Start(Tag::Link {
+                link_type,
+                dest_url,
+                title,
+                id,
+            }) => {
+                let dest_url = copy_asset(&dest_url, false, range.start, &mut event_warnings)
+                    .map_or(dest_url, CowStr::from);
+                events.push(Event::Start(Tag::Link {
+                    link_type,
+                    dest_url,
+                    title,
+                    id,
+                }));
+            }
+            Event::Text(ref text) if code_block.is_some() => {
+                if let Some((block_events, _, code)) = &mut code_block {
+                    code.push_str(text);
+                    block_events.push(event);
+                }
+            }
+            Event::End(TagEnd::CodeBlock) => {
+                in_code_block = false;
+                let attrs = code_attrs.take();
+                let Some((mut block_events, language, code)) = code_block.take() else {
+                    events.push(event);
+                    continue;
+                };
+                if let Some(start) = diagram_start.take() {
+                    match render_diagram(&language, &code, options) {
+                        Ok(diagram) => events.push(Event::Html(diagram.into())),
+                        Err(e) => {
+                            event_warnings.push(RenderWarning {
+                                file_path: document.source().file_path().clone(),
+                                line: lines.line_of(start),
+                                message: format!("Failed to render {} diagram: {}", language, e),
+                            });
+                            block_events.push(event);
+                            events.extend(block_events);
+                        }
+                    }
+                    continue;
+                }
+                let code = match options.tab_width() {
+                    Some(tab_width) => expand_tabs(&code, tab_width),
+                    None => code,
+                };
+                let highlighted = options
+                    .highlighter()
+                    .filter(|_| !language.is_empty())
+                    .and_then(|highlighter| match highlighter.highlight(&language, &code) {
+                        Ok(highlighted) => Some(highlighted),
+                        Err(e) => {
+                            warn!(language = %language, error = %e, "Failed to highlight code block");
+                            None
+                        }
+                    });
+                let plain = [block_events.swap_remove(0), Event::Text(code.into()), event];
+                if highlighted.is_none()
+                    && attrs.is_none()
+                    && options.code_wrap() == CodeWrap::Scroll
+                    && !options.theme().overrides_code_block()
+                {
+                    events.extend(plain);
+                    continue;
+                }
+                let mut html = highlighted.unwrap_or_else(|| {
+                    let mut html = String::new();
+                    html::push_html(&mut html, plain.into_iter());
+                    html
+                });
+                if let Some(attrs) = &attrs {
+                    html = decorate_code_html(&html, attrs);
+                }
+                if options.code_wrap() == CodeWrap::Wrap {
+                    html = wrap_code_html(&html, options.wrap_column());
+                }
+                let html = options.theme().render_code_block(&CodeBlockData {
+                    language,
+                    title: attrs
+                        .as_ref()
+                        .and_then(CodeBlockAttrs::title)
+                        .unwrap_or_default()
+                        .to_string(),
+                    code: html,
+                });
+                events.push(Event::Html(html.into()));
+            }
+            other => events.push(other),
+        }
+    }
+    flush_text(&mut pending_text, &mut events, footnotes);
+    warnings.extend(event_warnings);
+
+    let mut output = String::new();
+    html::push_html(&mut output, events.into_iter());
+    output
+}
+
+/// Render the code of a diagram block to HTML according to the diagram options.
+fn render_diagram(language: &str, code: &str, options: &RenderOptions) -> HyperlitResult<String> {
+    let diagram_options = options.diagram_options();
+    if diagram_options.mode() == DiagramMode::Client {
+        return Ok(client_diagram_html(code));
+    }
+    let Some(pal) = &options.pal else {
+        bail!("no PAL to run the renderer");
+    };
+    render_static_diagram(pal, diagram_options, language, code)
+}
+
+/// Warning for a heading at `line` that is too deep to be shifted by `heading_offset`.
+pub(crate) fn clamped_heading_warning(
+    document: &Document,
+    line: usize,
+    level: HeadingLevel,
+    heading_offset: usize,
+) -> RenderWarning {
+    RenderWarning {
+        file_path: document.source().file_path().clone(),
+        line,
+        message: format!(
+            "Heading level {} shifted by {} exceeds level 6, clamped to 6",
+            heading_level(level),
+            heading_offset
+        ),
+    }
+}
+
+/// Returns the link from `page` to a cross-reference target.
+fn link_href(page: &FilePath, target: &LinkTarget, options: &RenderOptions) -> String {
+    let target_page = options.page_path(&target.file_path);
+    if options.output_mode() == OutputMode::SingleFile {
+        // Heading anchors are unique on the single page, pages are sections
+        return match &target.anchor {
+            Some(anchor) => format!("#{}", anchor),
+            None => format!("#{}", target_page),
+        };
+    }
+    let mut href = if target_page == *page {
+        String::new()
+    } else {
+        format!("{}{}", relative_root(page), target_page)
+    };
+    if let Some(anchor) = &target.anchor {
+        href.push('#');
+        href.push_str(anchor);
+    }
+    href
+}
+
+/// Returns an image `src` as a `data:` URI with the content of the image file.
+///
+/// `src` is resolved relative to the directory of `source_path`. Returns None
+/// for URLs and absolute paths, which are not embedded.
+fn embed_image(
+    source_path: &FilePath,
+    src: &str,
+    options: &RenderOptions,
+) -> Result<Option<String>, String> {
+    if src.is_empty() || src.contains(':') || src.starts_with('/') || src.starts_with('#') {
+        return Ok(None);
+    }
+    let Some(pal) = &options.pal else {
+        return Err("no PAL to read images from".to_string());
+    };
+    let path = src.split(['?', '#']).next().unwrap_or_default();
+    let file_path =
+        resolve_relative_path(source_path, &percent_decode_str(path).decode_utf8_lossy());
+    let mut data = Vec::new();
+    pal.read_file(&file_path)
+        .and_then(|mut reader| Ok(reader.read_to_end(&mut data)?))
+        .map_err(|e| e.to_string())?;
+    let mime_type = match file_path.as_relative().extension() {
+        Some("png") => "image/png",
+        Some("jpg" | "jpeg") => "image/jpeg",
+        Some("gif") => "image/gif",
+        Some("svg") => "image/svg+xml",
+        Some("webp") => "image/webp",
+        Some("avif") => "image/avif",
+        Some("ico") => "image/x-icon",
+        Some("bmp") => "image/bmp",
+        _ => "application/octet-stream",
+    };
+    Ok(Some(format!(
+        "data:{};base64,{}",
+        mime_type,
+        BASE64.encode(data)
+    )))
+}
+
+/// Returns the language of a fenced code block info string, if it declares one.
+///
+/// The language is the first word, unless it is a `key=value` attribute or
+/// the attributes in braces.
+fn info_language(info: &str) -> Option<&str> {
+    split_info(info).0
+}
+
+/// Render the TOC as nested lists, below the titles of the parts of the reading order.
+fn render_toc_parts(toc: &Toc, options: &RenderOptions, root: &str, out: &mut String) {
+    let mut rest = 0;
+    for part in &toc.parts {
+        out.push_str(&format!(
+            "<h2 class=\"part\">{}</h2>\n",
+            escape_html(&part.title)
+        ));
+        render_toc_entries(&toc.entries[part.entries.clone()], options, root, out);
+        rest = part.entries.end;
+    }
+    render_toc_entries(&toc.entries[rest..], options, root, out);
+}
+
+/// Returns the links to the previous and next page in reading order, relative to `root`.
+fn neighbour_links(
+    source_path: &FilePath,
+    root: &str,
+    pages: &PageSequence,
+    options: &RenderOptions,
+) -> [Option<PageLink>; 2] {
+    [pages.previous(source_path), pages.next(source_path)].map(|page| {
+        page.map(|page| PageLink {
+            title: page.title.clone(),
+            url: format!("{root}{}", options.page_path(&page.source_path)),
+        })
+    })
+}
+
+/// Render the links to the previous and next page.
+///
+/// Returns an empty string for pages without either.
+fn render_navigation(previous: Option<&PageLink>, next: Option<&PageLink>) -> String {
+    if previous.is_none() && next.is_none() {
+        return String::new();
+    }
+    let mut navigation = "<nav class=\"pages\">\n".to_string();
+    for (class, rel, link) in [("previous", "prev", previous), ("next", "next", next)] {
+        if let Some(link) = link {
+            navigation.push_str(&format!(
+                "<a class=\"{}\" rel=\"{}\" href=\"{}\">{}</a>\n",
+                class,
+                rel,
+                escape_html(&link.url),
+                escape_html(&link.title)
+            ));
+        }
+    }
+    navigation.push_str("</nav>\n");
+    navigation
+}
+
+/// Render TOC entries as nested lists, linking pages relative to `root`.
+fn render_toc_entries(entries: &[TocEntry], options: &RenderOptions, root: &str, out: &mut String) {
+    if entries.is_empty() {
+        return;
+    }
+    out.push_str("<ul>\n");
+    for entry in entries {
+        let page = match options.output_mode() {
+            OutputMode::MultiFile | OutputMode::Flat => {
+                escape_html(&format!("{root}{}", options.page_path(&entry.file_path)))
+            }
+            OutputMode::SingleFile => String::new(),
+        };
+        out.push_str(&format!(
+            "<li><a href=\"{}#{}\">{}</a>",
+            page,
+            entry.anchor,
+            escape_html(&entry.title)
+        ));
+        if !entry.children.is_empty() {
+            out.push('\n');
+            render_toc_entries(&entry.children, options, root, out);
+        }
+        out.push_str("</li>\n");
+    }
+    out.push_str("</ul>\n");
+}
+
+/// Render the HTML document of a page with the page template of the theme.
+///
+/// `documents` are the documents on the page, providing its front matter,
+/// `neighbours` are the previous and next page in reading order.
+fn render_layout(
+    page_title: &str,
+    root: &str,
+    body: &str,
+    neighbours: [Option<PageLink>; 2],
+    documents: &[&Document],
+    toc: &Toc,
+    options: &RenderOptions,
+) -> String {
+    let (stylesheet, index) = match options.output_mode() {
+        OutputMode::MultiFile | OutputMode::Flat => (
+            format!("<link rel=\"stylesheet\" href=\"{root}{STYLESHEET}\">"),
+            format!("{root}{INDEX_PAGE}"),
+        ),
+        OutputMode::SingleFile => (
+            format!("<style>\n{DEFAULT_STYLESHEET}{SINGLE_FILE_STYLESHEET}</style>"),
+            "#contents".to_string(),
+        ),
+    };
+    let scripts = if options.diagram_options().mode() == DiagramMode::Client
+        && body.contains("<pre class=\"mermaid\">")
+    {
+        mermaid_runtime_script()
+    } else {
+        String::new()
+    };
+    let mut toc_html = String::new();
+    if options.theme().page_uses_toc() {
+        render_toc_parts(toc, options, root, &mut toc_html);
+    }
+    let word_count: WordCount = documents.iter().map(|document| document.word_count()).sum();
+    let [previous, next] = neighbours;
+    options.theme().render_page(&PageData {
+        title: page_title.to_string(),
+        site_title: options.title().to_string(),
+        root: root.to_string(),
+        index,
+        stylesheet,
+        body: body.to_string(),
+        navigation: render_navigation(previous.as_ref(), next.as_ref()),
+        previous,
+        next,
+        toc: toc_html,
+        scripts,
+        word_count: word_count.words,
+        reading_time: word_count.reading_minutes(),
+        front_matter: page_front_matter(documents),
+    })
+}
+
+/// Returns the scalar front matter values of the first document on a page that has any.
+fn page_front_matter(documents: &[&Document]) -> BTreeMap<String, String> {
+    documents
+        .iter()
+        .filter(|document| document.metadata().is_some())
+        .min_by_key(|document| document.source().line_number())
+        .and_then(|document| document.metadata())
+        .map(|metadata| {
+            metadata
+                .iter()
+                .map(|(key, value)| (key.to_string(), value.to_string()))
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// Returns the relative path from a page back to the output root (e.g. `../`).
+pub(crate) fn relative_root(page: &FilePath) -> String {
+    let depth = page.as_relative().components().count().saturating_sub(1);
+    "../".repeat(depth)
+}
+
+pub(crate) fn escape_html(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => escaped.push_str("&amp;"),
+            '<' => escaped.push_str("&lt;"),
+            '>' => escaped.push_str("&gt;"),
+            '"' => escaped.push_str("&quot;"),
+            _ => escaped.push(c),
+        }
+    }
+    escaped
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::logger::tests::RecordingLogger;
+    use crate::{
+        DocumentMetadata, DocumentSource, Exec, FnPathMapper, FnSlugifier, FollowingCode, Include,
+        MERMAID_RUNTIME_URL, MarkdownRenderer, SourceType, UnlistedPages,
+    };
+    use expect_test::{expect, expect_file};
+    use hyperlit_base::pal::CommandOutput;
+    use hyperlit_base::pal::MockPal;
+    use std::collections::{HashMap, HashSet};
+
+    fn doc(path: &str, line: usize, title: &str, content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), line);
+        Document::new(
+            title.to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    /// Render the content of a single document at `doc.md`.
+    fn render_content(content: &str, options: &RenderOptions) -> String {
+        let document = doc("doc.md", 1, "Doc", content);
+        let site_map = SiteMap::build(std::slice::from_ref(&document));
+        let mut warnings = Vec::new();
+        render_document(
+            &document,
+            &FilePath::from("doc.md.html"),
+            &site_map,
+            options,
+            &mut PageFootnotes::default(),
+            &mut warnings,
+            &mut Vec::new(),
+        )
+    }
+
+    fn find<'a>(files: &'a [RenderedFile], path: &str) -> &'a str {
+        &files
+            .iter()
+            .find(|file| file.path == FilePath::from(path))
+            .unwrap()
+            .content
+    }
+
+    #[test]
+    fn test_render_site_file_layout() {
+        let documents = vec![
+            doc("src/main.rs", 1, "Main", "# Main\n"),
+            doc("README.md", 1, "Readme", "# Readme\n"),
+            doc("src/main.rs", 20, "Later", "# Later\n"),
+        ];
+        let files = render_site(&documents, &RenderOptions::new().with_title("Site")).files;
+        let paths: Vec<String> = files.iter().map(|file| file.path.to_string()).collect();
+        assert_eq!(
+            paths,
+            [
+                "style.css",
+                "index.html",
+                "README.md.html",
+                "src/main.rs.html"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_render_index_page_links_headings() {
+        let documents = vec![
+            doc("src/a.rs", 1, "A", "# Overview\n\n## Details\n"),
+            doc("src/b.rs", 1, "B", "# Overview\n"),
+        ];
+        let files = render_site(&documents, &RenderOptions::new().with_title("My <Site>")).files;
+        expect![[r#"
+            <!DOCTYPE html>
+            <html lang="en">
+            <head>
+            <meta charset="utf-8">
+            <title>Contents - My &lt;Site&gt;</title>
+            <link rel="stylesheet" href="style.css">
+            </head>
+            <body>
+            <nav class="site"><a href="index.html">My &lt;Site&gt;</a></nav>
+            <main>
+            <h1>My &lt;Site&gt;</h1>
+            <nav class="toc">
+            <ul>
+            <li><a href="src/a.rs.html#overview">Overview</a>
+            <ul>
+            <li><a href="src/a.rs.html#details">Details</a></li>
+            </ul>
+            </li>
+            <li><a href="src/b.rs.html#overview">Overview</a></li>
+            </ul>
+            </nav>
+            </main>
+            </body>
+            </html>
+        "#]]
+        .assert_eq(find(&files, "index.html"));
+    }
+
+    #[test]
+    fn test_render_file_page_uses_toc_anchors() {
+        let documents = vec![
+            doc("src/b.rs", 1, "B", "# Overview\n\nSecond file."),
+            doc("src/a.rs", 1, "A", "# Overview\n\nFirst file."),
+        ];
+        let files = render_site(&documents, &RenderOptions::new().with_title("Site")).files;
+        expect![[r#"
+            <!DOCTYPE html>
+            <html lang="en">
+            <head>
+            <meta charset="utf-8">
+            <title>src/b.rs - Site</title>
+            <link rel="stylesheet" href="../style.css">
+            </head>
+            <body>
+            <nav class="site"><a href="../index.html">Site</a></nav>
+            <main>
+            <article class="document">
+            <h1 id="overview">Overview</h1>
+            <p>Second file.</p>
+            <p class="source">src/b.rs:1</p>
+            </article>
+            <nav class="pages">
+            <a class="previous" rel="prev" href="../src/a.rs.html">src/a.rs</a>
+            </nav>
+            </main>
+            </body>
+            </html>
+        "#]]
+        .assert_eq(find(&files, "src/b.rs.html"));
+    }
+
+    #[test]
+    fn test_render_file_page_orders_documents_by_line() {
+        let documents = vec![
+            doc("lib.rs", 30, "Second", "# Second\n"),
+            doc("lib.rs", 3, "First", "# First\n"),
+        ];
+        let files = render_site(&documents, &RenderOptions::new()).files;
+        let page = find(&files, "lib.rs.html");
+        assert!(page.find("First").unwrap() < page.find("Second").unwrap());
+    }
+
+    #[derive(Debug)]
+    struct FakeHighlighter;
+
+    impl Highlighter for FakeHighlighter {
+        fn highlight(&self, language: &str, code: &str) -> HyperlitResult<String> {
+            if language == "broken" {
+                hyperlit_base::bail!("cannot highlight");
+            }
+            Ok(format!(
+                "<pre class=\"{language}\">{}</pre>\n",
+                code.to_uppercase()
+            ))
+        }
+    }
+
+    #[test]
+    fn test_render_markdown_with_highlighter() {
+        let options = RenderOptions::new().with_highlighter(FakeHighlighter);
+        let content = "```rust file=main.rs\nfn main() {}\n```\n\n```\nno language\n```\n\n```file=x.txt\nattribute only\n```\n\n```broken\nfails\n```\n";
+        expect![[r#"
+            <pre class="rust">FN MAIN() {}
+            </pre>
+            <pre><code>no language
+            </code></pre>
+            <pre><code class="language-file=x.txt">attribute only
+            </code></pre>
+            <pre><code class="language-broken">fails
+            </code></pre>
+        "#]]
+        .assert_eq(&render_content(content, &options));
+    }
+
+    #[test]
+    fn test_render_markdown_without_highlighter() {
+        expect![[r#"
+            <pre><code class="language-rust">let x = 1 &lt; 2;
+            </code></pre>
+        "#]]
+        .assert_eq(&render_content(
+            "```rust\nlet x = 1 < 2;\n```\n",
+            &RenderOptions::new(),
+        ));
+    }
+
+    #[test]
+    fn test_render_code_block_attributes() {
+        let content =
+            "```rust {highlight=2 title=\"main.rs\" linenos}\nfn main() {\n    run();\n}\n```\n";
+        expect![[r#"
+            <div class="code-block line-numbers">
+            <div class="code-title">main.rs</div>
+            <pre class="rust"><span class="code-line" data-line="1">FN MAIN() {
+            </span><span class="code-line highlighted" data-line="2">    RUN();
+            </span><span class="code-line" data-line="3">}
+            </span></pre>
+            </div>
+        "#]]
+        .assert_eq(&render_content(
+            content,
+            &RenderOptions::new().with_highlighter(FakeHighlighter),
+        ));
+
+        // Malformed attributes render a plain block with a warning
+        let documents = vec![doc(
+            "lib.rs",
+            10,
+            "Doc",
+            "Text\n\n```rust {highlight=3-1}\nx\n```\n",
+        )];
+        let result = render_site(&documents, &RenderOptions::new());
+        assert!(
+            find(&result.files, "lib.rs.html")
+                .contains("<pre><code class=\"language-rust\">x\n</code></pre>")
+        );
+        let warnings: Vec<String> = result.warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            ["lib.rs:12: Ignoring code block attributes: invalid line range '3-1'"]
+        );
+    }
+
+    #[test]
+    fn test_render_site_with_path_mapper() {
+        let documents = vec![
+            doc(
+                "src/net/http/server.rs",
+                1,
+                "Server",
+                "# Server\n\nSee [[Client]].\n",
+            ),
+            doc("src/net/http/client.rs", 1, "Client", "# Client\n").with_symbol("Client"),
+            doc("tests/client.rs", 1, "Tests", "# Tests\n"),
+        ];
+        let options = RenderOptions::new().with_path_mapper(FnPathMapper::new(
+            "stem",
+            |source_path: &FilePath| {
+                FilePath::from(format!(
+                    "api/{}",
+                    source_path.as_relative().file_stem().unwrap_or_default()
+                ))
+            },
+        ));
+        let result = render_site(&documents, &options);
+        let paths: Vec<String> = result.files.iter().map(|f| f.path.to_string()).collect();
+        assert_eq!(
+            paths,
+            [
+                "style.css",
+                "index.html",
+                "api/client.html",
+                "api/server.html"
+            ]
+        );
+        // The TOC and cross-references link to the mapped pages
+        let index = find(&result.files, "index.html");
+        assert!(index.contains("<a href=\"api/server.html#server\">Server</a>"));
+        assert!(!index.contains("Tests"));
+        let server = find(&result.files, "api/server.html");
+        assert!(server.contains("<a href=\"../api/client.html#client\">Client</a>"));
+        assert!(server.contains("<a href=\"../index.html\">"));
+        let warnings: Vec<String> = result.warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            [
+                "tests/client.rs:1: Page 'api/client.html' collides with the page of src/net/http/client.rs, left out"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_render_site_copies_assets() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("src/net/img/flow.png"), b"PNG".to_vec());
+        mock_pal.add_file(FilePath::from("src/net/spec v2.pdf"), b"PDF".to_vec());
+        let documents = vec![
+            doc(
+                "src/net/server.rs",
+                1,
+                "Server",
+                "# Server\n\n![Flow](./img/flow.png) [Spec](spec%20v2.pdf#page=3)\n\n![Missing](missing.png) [Client](client.md) ![Web](https://example.com/a.png)\n",
+            ),
+            doc(
+                "src/net/client.rs",
+                1,
+                "Client",
+                "# Client\n\n![Flow](img/flow.png)\n",
+            ),
+        ];
+        let options = RenderOptions::new()
+            .with_pal(PalHandle::new(mock_pal))
+            .with_path_mapper(FnPathMapper::new("stem", |source_path: &FilePath| {
+                FilePath::from(format!(
+                    "api/{}",
+                    source_path.as_relative().file_stem().unwrap_or_default()
+                ))
+            }));
+        let result = render_site(&documents, &options);
+
+        let assets: Vec<(String, String)> = result
+            .assets
+            .iter()
+            .map(|asset| (asset.source.to_string(), asset.path.to_string()))
+            .collect();
+        assert_eq!(
+            assets,
+            [
+                (
+                    "src/net/img/flow.png".to_string(),
+                    "src/net/img/flow.png".to_string()
+                ),
+                (
+                    "src/net/spec v2.pdf".to_string(),
+                    "src/net/spec v2.pdf".to_string()
+                ),
+            ]
+        );
+        let server = find(&result.files, "api/server.html");
+        assert!(server.contains("<img src=\"../src/net/img/flow.png\" alt=\"Flow\" />"));
+        assert!(server.contains("<a href=\"../src/net/spec%20v2.pdf#page=3\">Spec</a>"));
+        assert!(server.contains("<img src=\"missing.png\" alt=\"Missing\" />"));
+        assert!(server.contains("<a href=\"client.md\">Client</a>"));
+        assert!(server.contains("<img src=\"https://example.com/a.png\" alt=\"Web\" />"));
+        let warnings: Vec<String> = result.warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            [
+                "src/net/server.rs:5: Asset 'missing.png' not copied: File not found: src/net/missing.png"
+            ]
+        );
+        // Without a PAL, references are left as written
+        let result = render_site(&documents, &RenderOptions::new());
+        assert!(result.assets.is_empty());
+        assert!(
+            find(&result.files, "src/net/client.rs.html").contains("<img src=\"img/flow.png\"")
+        );
+    }
+
+    #[test]
+    fn test_render_site_with_theme() {
+        let theme = Theme::default()
+            .with_page_template(
+                "<title>{{title}}</title>\n<aside>{{toc}}</aside>\n{{body}}<footer>{{front_matter.author}}</footer>\n",
+            )
+            .unwrap()
+            .with_code_block_template("<figure data-lang=\"{{language}}\">{{code}}</figure>")
+            .unwrap();
+        let metadata = DocumentMetadata::new(HashMap::from([(
+            "author".to_string(),
+            "Ada & Co".to_string(),
+        )]));
+        let documents = vec![Document::new(
+            "Server".to_string(),
+            "# Server\n\n```sh\nrun\n```\n\n    plain\n".to_string(),
+            DocumentSource::new(SourceType::CodeComment, FilePath::from("src/lib.rs"), 1),
+            Some(metadata),
+            &HashSet::new(),
+        )];
+        let result = render_site(&documents, &RenderOptions::new().with_theme(theme));
+        expect![[r#"
+            <title>src/lib.rs</title>
+            <aside><ul>
+            <li><a href="../src/lib.rs.html#server">Server</a></li>
+            </ul>
+            </aside>
+            <article class="document">
+            <h1 id="server">Server</h1>
+            <figure data-lang="sh"><pre><code class="language-sh">run
+            </code></pre>
+            </figure><figure data-lang=""><pre><code>plain
+            </code></pre>
+            </figure><p class="source">src/lib.rs:1</p>
+            </article>
+            <footer>Ada &amp; Co</footer>
+        "#]]
+        .assert_eq(find(&result.files, "src/lib.rs.html"));
+        // Templates not overridden by the theme are the built-in ones
+        assert!(find(&result.files, "index.html").contains("<nav class=\"toc\">\n<ul>"));
+    }
+
+    #[test]
+    fn test_render_markdown_with_code_wrap() {
+        let content = "```rust\nfn\tlong_name() {}\n```\n\n    a < b <= c\n";
+        let options = RenderOptions::new()
+            .with_code_wrap(CodeWrap::Wrap)
+            .with_wrap_column(8);
+        expect![[r#"
+            <pre><code class="language-rust">fn  long
+            <span class="code-continuation" aria-hidden="true">↪</span>_name()
+            <span class="code-continuation" aria-hidden="true">↪</span> {}
+            </code></pre>
+            <pre><code>a &lt; b &lt;=
+            <span class="code-continuation" aria-hidden="true">↪</span> c
+            </code></pre>
+        "#]]
+        .assert_eq(&render_content(content, &options));
+
+        // Highlighted code is wrapped after highlighting
+        let options = options.with_highlighter(FakeHighlighter).with_tab_width(2);
+        expect![[r#"
+            <pre class="rust">FN  LONG
+            <span class="code-continuation" aria-hidden="true">↪</span>_NAME()
+            <span class="code-continuation" aria-hidden="true">↪</span> {}
+            </pre>
+            <pre><code>a &lt; b &lt;=
+            <span class="code-continuation" aria-hidden="true">↪</span> c
+            </code></pre>
+        "#]]
+        .assert_eq(&render_content(content, &options));
+
+        // Scrolling code blocks only expand tabs if a tab width is set
+        let scroll = render_content(content, &RenderOptions::new());
+        assert!(scroll.contains("fn\tlong_name"));
+        let expanded = render_content(content, &RenderOptions::new().with_tab_width(4));
+        assert!(expanded.contains("fn  long_name() {}\n</code></pre>"));
+        assert_ne!(options.cache_key(), RenderOptions::new().cache_key());
+    }
+
+    #[test]
+    fn test_render_site_with_custom_slugifier() {
+        let documents = vec![doc("src/a.rs", 1, "A", "# Getting Started\n\nSee [[a]].\n")];
+        let options = RenderOptions::new()
+            .with_slugifier(FnSlugifier::new("underscore", |text: &str| {
+                text.to_lowercase().replace(' ', "_")
+            }));
+
+        let files = render_site(&documents, &options).files;
+
+        let page = find(&files, "src/a.rs.html");
+        assert!(page.contains("id=\"getting_started\""));
+        assert!(page.contains("<a href=\"#getting_started\">a</a>"));
+        assert!(find(&files, "index.html").contains("src/a.rs.html#getting_started"));
+        assert_ne!(options.cache_key(), RenderOptions::new().cache_key());
+    }
+
+    #[test]
+    fn test_render_file_page_with_preamble() {
+        let preamble = doc(
+            "src/server.go",
+            1,
+            "Server",
+            "# Server\n\nServes the API.\n",
+        )
+        .with_preamble(true);
+        let start = doc("src/server.go", 6, "Start", "# Start\n");
+        let documents = vec![start, preamble];
+
+        let files = render_site(&documents, &RenderOptions::new()).files;
+        let page = find(&files, "src/server.go.html");
+
+        assert!(page.contains("<title>Server - </title>"));
+        let preamble_start = page.find("<article class=\"document preamble\">").unwrap();
+        let start_start = page.find("<article class=\"document\">").unwrap();
+        assert!(preamble_start < start_start);
+
+        // Without a preamble, the page is named after the source file
+        let documents = vec![doc("src/server.go", 6, "Start", "# Start\n")];
+        let files = render_site(&documents, &RenderOptions::new()).files;
+        let page = find(&files, "src/server.go.html");
+        assert!(page.contains("<title>src/server.go - </title>"));
+    }
+
+    #[test]
+    fn test_render_site_with_transformers() {
+        let documents = vec![doc("src/a.rs", 1, "A", "# A\n\nFixed in #7, see `#8`.\n")];
+        let options =
+            RenderOptions::new().with_transformer(IssueLinker::new("https://example.com/{number}"));
+
+        let result = render_site(&documents, &options);
+
+        let page = find(&result.files, "src/a.rs.html");
+        assert!(page.contains("Fixed in <a href=\"https://example.com/7\">#7</a>"));
+        assert!(page.contains("<code>#8</code>"));
+        assert!(result.warnings.is_empty());
+        assert_ne!(options.cache_key(), RenderOptions::new().cache_key());
+    }
+
+    #[test]
+    fn test_render_site_with_heading_offset() {
+        let documents = vec![doc(
+            "guide.md",
+            1,
+            "Guide",
+            "# Guide\n\n## Install\n\n###### Deep\n",
+        )];
+        let options = RenderOptions::new().with_heading_offset(1);
+
+        let result = render_site(&documents, &options);
+
+        let page = find(&result.files, "guide.md.html");
+        assert!(page.contains("<h2 id=\"guide\">Guide</h2>"));
+        assert!(page.contains("<h3 id=\"install\">Install</h3>"));
+        assert!(page.contains("<h6 id=\"deep\">Deep</h6>"));
+        assert_eq!(
+            result.warnings,
+            [RenderWarning {
+                file_path: FilePath::from("guide.md"),
+                line: 5,
+                message: "Heading level 6 shifted by 1 exceeds level 6, clamped to 6".to_string(),
+            }]
+        );
+        let site_map = SiteMap::build_with_options(&documents, &options);
+        assert_eq!(site_map.toc.entries[0].level, 2);
+        assert_eq!(site_map.toc.entries[0].children[0].level, 3);
+        assert_ne!(options.cache_key(), RenderOptions::new().cache_key());
+    }
+
+    #[test]
+    fn test_render_site_logs_warnings() {
+        let documents = vec![doc(
+            "guide.md",
+            1,
+            "Guide",
+            "# Guide\n\nSee [[missing]].\n\n###### Deep\n",
+        )];
+        let logger = RecordingLogger::default();
+        let options = RenderOptions::new()
+            .with_heading_offset(1)
+            .with_logger(logger.clone());
+
+        let result = render_site(&documents, &options);
+
+        assert_eq!(result.warnings.len(), 2);
+        assert_eq!(
+            logger.entries(),
+            [
+                "warning: Unresolved cross-reference '[[missing]]' file=guide.md line=3",
+                "warning: Heading level 6 shifted by 1 exceeds level 6, clamped to 6 file=guide.md line=5",
+            ]
+        );
+        let markdown_logger = RecordingLogger::default();
+        MarkdownRenderer::new().render_site(
+            &documents,
+            &options.clone().with_logger(markdown_logger.clone()),
+        );
+        assert_eq!(markdown_logger.entries().len(), 2);
+    }
+
+    #[test]
+    fn test_render_site_with_profiles() {
+        let documents = vec![doc(
+            "src/auth.rs",
+            1,
+            "Auth",
+            "# Auth\n\n{{if enterprise}}\n## SSO\n\nSAML.\n{{end}}\n\n{{if oss}}\n## Passwords\n{{end}}\n\nSee [[missing]].\n",
+        )];
+
+        let result = render_site(&documents, &RenderOptions::new().with_profiles(["oss"]));
+
+        let page = find(&result.files, "src/auth.rs.html");
+        assert!(page.contains("<h2 id=\"passwords\">Passwords</h2>"));
+        assert!(!page.contains("SSO"));
+        assert!(!page.contains("{{"));
+        let index = find(&result.files, "index.html");
+        assert!(index.contains("#passwords"));
+        assert!(!index.contains("#sso"));
+        // Omitted lines keep the lines of the remaining content
+        assert_eq!(result.warnings[0].line, 13);
+    }
+
+    #[test]
+    fn test_render_site_resolves_cross_references() {
+        let documents = vec![
+            doc(
+                "src/greet.rs",
+                3,
+                "Why greet?",
+                "# Why greet?\n\nSee [[Config]], [[greet]] and [[missing]].\n\n```text\n[[greet]]\n```\n",
+            )
+            .with_symbol("greet"),
+            doc("src/config/mod.rs", 1, "Config", "# Config\n").with_symbol("Config"),
+            doc("guide.md", 1, "Guide", "# Guide\n\nStart with\n[[why-greet]] or `[[greet]]`.\n"),
+        ];
+        let result = render_site(&documents, &RenderOptions::new());
+
+        let page = find(&result.files, "src/greet.rs.html");
+        assert!(page.contains("<a href=\"../src/config/mod.rs.html#config\">Config</a>"));
+        assert!(page.contains("<a href=\"#why-greet\">greet</a>"));
+        assert!(page.contains("[[missing]]"));
+        assert!(page.contains("<code class=\"language-text\">[[greet]]\n</code>"));
+
+        let guide = find(&result.files, "guide.md.html");
+        assert!(guide.contains("<a href=\"src/greet.rs.html#why-greet\">why-greet</a>"));
+        assert!(guide.contains("<code>[[greet]]</code>"));
+
+        let warnings: Vec<String> = result.warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            ["src/greet.rs:5: Unresolved cross-reference '[[missing]]'"]
+        );
+    }
+
+    #[test]
+    fn test_render_document_with_include() {
+        let document = doc(
+            "src/lib.rs",
+            1,
+            "Config",
+            "# Config\n\n{{include example.yaml}}\n\n{{include missing.yaml}}\n",
+        )
+        .with_include(Include {
+            directive_path: "example.yaml".to_string(),
+            file_path: FilePath::from("src/example.yaml"),
+            content: "key: <value>".to_string(),
+        });
+        let result = render_site(&[document], &RenderOptions::new());
+        let page = find(&result.files, "src/lib.rs.html");
+        assert!(page.contains(
+            "<pre><code class=\"language-yaml\">key: &lt;value&gt;\n</code></pre>\n<p>{{include missing.yaml}}</p>"
+        ));
+    }
+
+    #[test]
+    fn test_render_document_with_following_code() {
+        let document = doc("src/greet.rs", 1, "Greet", "# Greet\n\nSays hello.\n")
+            .with_following_code(FollowingCode {
+                code: "fn greet() {}\n".to_string(),
+                start_line: 5,
+                language: Some("rust".to_string()),
+            });
+        let options = RenderOptions::new().with_highlighter(FakeHighlighter);
+        let result = render_site(&[document], &options);
+        let page = find(&result.files, "src/greet.rs.html");
+        assert!(page.contains("<p>Says hello.</p>\n<pre class=\"rust\">FN GREET() {}\n</pre>"));
+    }
+
+    #[test]
+    fn test_render_site_single_file() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("docs/img/logo.png"), b"PNG".to_vec());
+        let documents = vec![
+            doc(
+                "docs/guide.md",
+                1,
+                "Guide",
+                "# Guide\n\n![Logo](img/logo.png) ![Missing](missing.svg) ![Web](https://example.com/a.png)\n\nSee [[greet]].\n",
+            ),
+            doc("src/greet.rs", 1, "Greeting", "# Greeting\n").with_symbol("greet"),
+            doc("src/greet.rs", 10, "Plain", "No heading\n").with_symbol("plain"),
+            doc("src/main.rs", 1, "Main", "# Main\n\nSee [[plain]].\n"),
+        ];
+        let options = RenderOptions::new()
+            .with_title("Site")
+            .with_output_mode(OutputMode::SingleFile)
+            .with_pal(PalHandle::new(mock_pal));
+
+        let result = render_site(&documents, &options);
+
+        let paths: Vec<String> = result
+            .files
+            .iter()
+            .map(|file| file.path.to_string())
+            .collect();
+        assert_eq!(paths, ["index.html"]);
+        let html = &result.files[0].content;
+        assert!(html.contains("<style>\nbody {"));
+        assert!(html.contains("section.page {"));
+        assert!(!html.contains("style.css"));
+        assert!(html.contains("<nav class=\"site\"><a href=\"#contents\">Site</a></nav>"));
+        assert!(html.contains("<li><a href=\"#guide\">Guide</a></li>"));
+        assert!(html.contains(
+            "<section class=\"page\" id=\"src/greet.rs.html\">\n<h1 class=\"page-title\">src/greet.rs</h1>"
+        ));
+        assert!(html.contains("<a href=\"#greeting\">greet</a>"));
+        assert!(html.contains("<a href=\"#src/greet.rs.html\">plain</a>"));
+        assert!(html.contains("<img src=\"data:image/png;base64,UE5H\" alt=\"Logo\" />"));
+        assert!(html.contains("<img src=\"missing.svg\" alt=\"Missing\" />"));
+        assert!(html.contains("<img src=\"https://example.com/a.png\" alt=\"Web\" />"));
+        let sections: Vec<usize> = [
+            "docs/guide.md.html",
+            "src/greet.rs.html",
+            "src/main.rs.html",
+        ]
+        .iter()
+        .map(|id| html.find(&format!("id=\"{}\"", id)).unwrap())
+        .collect();
+        assert!(sections.is_sorted());
+        let warnings: Vec<String> = result.warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            [
+                "docs/guide.md:3: Image 'missing.svg' not embedded: File error at docs/missing.svg: File not found: docs/missing.svg"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_render_document_with_exec_output() {
+        let output = |stdout: &str, stderr: &str| CommandOutput {
+            stdout: stdout.to_string(),
+            stderr: stderr.to_string(),
+            exit_code: Some(0),
+        };
+        let document = doc(
+            "src/cli.rs",
+            1,
+            "CLI",
+            "# CLI\n\n{{exec: tool --help}}\n\n{{exec: tool check}}\n",
+        )
+        .with_exec(Exec {
+            command: "tool --help".to_string(),
+            output: output("usage: tool <file>", ""),
+        })
+        .with_exec(Exec {
+            command: "tool check".to_string(),
+            output: output("checked\n", "warning: slow\n"),
+        });
+        let result = render_site(&[document], &RenderOptions::new());
+        let page = find(&result.files, "src/cli.rs.html");
+        assert!(page.contains(
+            "<pre><code class=\"language-text\">usage: tool &lt;file&gt;\n</code></pre>\n\
+             <p><strong>stdout</strong></p>\n<pre><code class=\"language-text\">checked\n</code></pre>\n\
+             <p><strong>stderr</strong></p>\n<pre><code class=\"language-text\">warning: slow\n</code></pre>"
+        ));
+    }
+
+    #[test]
+    fn test_render_site_flat() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("docs/img/logo.png"), b"PNG".to_vec());
+        let documents = vec![
+            doc(
+                "docs/Guide.md",
+                1,
+                "Guide",
+                "# Guide\n\n![Logo](img/logo.png)\n\nSee [[greet]].\n",
+            ),
+            doc("src/greet.rs", 1, "Greeting", "# Greeting\n")
+                .with_symbol("greet")
+                .with_preamble(true),
+        ];
+        let options = RenderOptions::new()
+            .with_title("Site")
+            .with_output_mode(OutputMode::Flat)
+            .with_pal(PalHandle::new(mock_pal));
+
+        let result = render_site(&documents, &options);
+
+        let guide = OutputMode::Flat.page_path(&FilePath::from("docs/Guide.md"));
+        let greet = OutputMode::Flat.page_path(&FilePath::from("src/greet.rs"));
+        assert!(guide.to_string().starts_with("docs-guide-md-"));
+        let paths: Vec<String> = result
+            .files
+            .iter()
+            .map(|file| file.path.to_string())
+            .collect();
+        assert_eq!(
+            paths,
+            [
+                "style.css".to_string(),
+                "index.html".to_string(),
+                "index.json".to_string(),
+                guide.to_string(),
+                greet.to_string(),
+            ]
+        );
+        assert!(
+            result.files[1]
+                .content
+                .contains(&format!("<li><a href=\"{}#guide\">Guide</a></li>", guide))
+        );
+        let manifest: serde_json::Value = serde_json::from_str(&result.files[2].content).unwrap();
+        assert_eq!(manifest["pages"][2]["title"], "Greeting");
+        assert_eq!(manifest["pages"][2]["path"], greet.to_string());
+        assert_eq!(manifest["pages"][2]["source"], "src/greet.rs");
+        let page = &result.files[3].content;
+        assert!(page.contains("<link rel=\"stylesheet\" href=\"style.css\">"));
+        assert!(page.contains(&format!("<a href=\"{}#greeting\">greet</a>", greet)));
+        assert!(page.contains("<img src=\"data:image/png;base64,UE5H\" alt=\"Logo\" />"));
+    }
+
+    #[test]
+    fn test_render_site_in_reading_order() {
+        let documents = vec![
+            doc("src/lib.rs", 1, "Lib", "# Library\n"),
+            doc("docs/intro.md", 1, "Intro", "# Introduction\n").with_preamble(true),
+            doc("src/core.rs", 1, "Core", "# Core\n"),
+            doc("notes.md", 1, "Notes", "# Notes\n"),
+        ];
+        let order = ReadingOrder::new()
+            .with_part("Getting started", ["docs/intro.md"])
+            .with_part("Internals", ["src/core.rs", "src/*.rs"]);
+        let options = RenderOptions::new().with_reading_order(order.clone());
+
+        let result = render_site(&documents, &options);
+        let paths: Vec<String> = result.files[2..]
+            .iter()
+            .map(|file| file.path.to_string())
+            .collect();
+        assert_eq!(
+            paths,
+            [
+                "docs/intro.md.html",
+                "src/core.rs.html",
+                "src/lib.rs.html",
+                "notes.md.html"
+            ]
+        );
+        let index = find(&result.files, "index.html");
+        let toc =
+            &index[index.find("<nav class=\"toc\">").unwrap()..index.find("</main>").unwrap()];
+        expect![[r#"
+            <nav class="toc">
+            <h2 class="part">Getting started</h2>
+            <ul>
+            <li><a href="docs/intro.md.html#introduction">Introduction</a></li>
+            </ul>
+            <h2 class="part">Internals</h2>
+            <ul>
+            <li><a href="src/core.rs.html#core">Core</a></li>
+            <li><a href="src/lib.rs.html#library">Library</a></li>
+            </ul>
+            <ul>
+            <li><a href="notes.md.html#notes">Notes</a></li>
+            </ul>
+            </nav>
+        "#]]
+        .assert_eq(toc);
+        let core = find(&result.files, "src/core.rs.html");
+        assert!(core.contains(concat!(
+            "<nav class=\"pages\">\n",
+            "<a class=\"previous\" rel=\"prev\" href=\"../docs/intro.md.html\">Intro</a>\n",
+            "<a class=\"next\" rel=\"next\" href=\"../src/lib.rs.html\">src/lib.rs</a>\n",
+            "</nav>\n"
+        )));
+        assert!(!find(&result.files, "docs/intro.md.html").contains("class=\"previous\""));
+        assert!(result.warnings.is_empty());
+
+        // Unlisted pages are rendered, but left out of the TOC and navigation
+        let options =
+            options.with_reading_order(order.with_unlisted_pages(UnlistedPages::Unlisted));
+        let result = render_site(&documents, &options);
+        assert!(!find(&result.files, "index.html").contains("notes.md.html"));
+        assert!(!find(&result.files, "src/lib.rs.html").contains("class=\"next\""));
+        assert!(!find(&result.files, "notes.md.html").contains("<nav class=\"pages\">"));
+        let warnings: Vec<String> = result.warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            ["notes.md:1: Page is not listed in the reading order"]
+        );
+    }
+
+    #[test]
+    fn test_render_site_passes_neighbours_to_theme() {
+        let documents = vec![
+            doc("c.md", 1, "C", "# C\n"),
+            doc("a.md", 1, "A", "# A\n").with_preamble(true),
+            doc("b.md", 1, "B", "# B\n"),
+        ];
+        let theme = Theme::default()
+            .with_page_template("{{previous.title}}|{{previous.url}}|{{next.title}}|{{next.url}}")
+            .unwrap();
+        let options = RenderOptions::new().with_theme(theme);
+
+        let files = render_site(&documents, &options).files;
+        assert_eq!(find(&files, "a.md.html"), "||b.md|b.md.html");
+        assert_eq!(find(&files, "b.md.html"), "A|a.md.html|c.md|c.md.html");
+        assert_eq!(find(&files, "c.md.html"), "b.md|b.md.html||");
+
+        let order = ReadingOrder::new().with_part("Book", ["c.md", "a.md", "b.md"]);
+        let files = render_site(&documents, &options.with_reading_order(order)).files;
+        assert_eq!(find(&files, "c.md.html"), "||A|a.md.html");
+        assert_eq!(find(&files, "b.md.html"), "A|a.md.html||");
+    }
+
+    #[test]
+    fn test_render_site_with_footnotes() {
+        let documents = vec![
+            doc(
+                "src/retry.rs",
+                1,
+                "Retries",
+                "# Retries\n\nRetried with backoff[^backoff] and jitter[^jitter].\n\nSee [^missing].\n",
+            ),
+            doc(
+                "src/retry.rs",
+                20,
+                "Backoff",
+                "# Backoff\n\nDoubles[^Backoff].\n\n[^backoff]: Starting at *100ms*.\n\n[^jitter]: Random.\n",
+            ),
+        ];
+
+        let result = render_site(&documents, &RenderOptions::new());
+
+        let page = find(&result.files, "src/retry.rs.html");
+        let body = &page[page.find("<main>").unwrap()..];
+        expect![[r##"
+            <main>
+            <article class="document">
+            <h1 id="retries">Retries</h1>
+            <p>Retried with backoff<sup class="footnote-ref"><a href="#fn-1" id="fnref-1">1</a></sup> and jitter<sup class="footnote-ref"><a href="#fn-2" id="fnref-2">2</a></sup>.</p>
+            <p>See [^missing].</p>
+            <p class="source">src/retry.rs:1</p>
+            </article>
+            <article class="document">
+            <h1 id="backoff">Backoff</h1>
+            <p>Doubles<sup class="footnote-ref"><a href="#fn-1" id="fnref-1-2">1</a></sup>.</p>
+            <p class="source">src/retry.rs:20</p>
+            </article>
+            <section class="footnotes">
+            <ol>
+            <li id="fn-1">
+            <p>Starting at <em>100ms</em>. <a href="#fnref-1" class="footnote-backref">↩</a> <a href="#fnref-1-2" class="footnote-backref">↩</a></p>
+            </li>
+            <li id="fn-2">
+            <p>Random. <a href="#fnref-2" class="footnote-backref">↩</a></p>
+            </li>
+            </ol>
+            </section>
+            </main>
+            </body>
+            </html>
+        "##]]
+        .assert_eq(body);
+        assert_eq!(result.warnings.len(), 1);
+        assert_eq!(
+            result.warnings[0].to_string(),
+            "src/retry.rs:5: Footnote '[^missing]' has no definition"
+        );
+
+        let options = RenderOptions::new().with_output_mode(OutputMode::SingleFile);
+        let index = find(&render_site(&documents, &options).files, "index.html").to_string();
+        assert!(
+            index.contains("<a href=\"#src/retry.rs.html-fn-1\" id=\"src/retry.rs.html-fnref-1\">")
+        );
+    }
+
+    const DIAGRAM: &str = "# Flow\n\n```mermaid\ngraph TD\n  A --> B\n```\n";
+
+    #[test]
+    fn test_render_site_with_client_diagrams() {
+        let documents = vec![
+            doc("src/flow.rs", 1, "Flow", DIAGRAM),
+            doc("src/lib.rs", 1, "Lib", "# Lib\n"),
+        ];
+        let options = RenderOptions::new()
+            .with_diagram_options(DiagramOptions::new().with_mode(DiagramMode::Client));
+
+        let result = render_site(&documents, &options);
+
+        let page = find(&result.files, "src/flow.rs.html");
+        assert!(page.contains("<pre class=\"mermaid\">graph TD\n  A --&gt; B\n</pre>"));
+        assert!(page.contains(MERMAID_RUNTIME_URL));
+        // Pages without diagrams do not load the runtime
+        assert!(!find(&result.files, "src/lib.rs.html").contains(MERMAID_RUNTIME_URL));
+        // Diagrams are off by default
+        let page = render_content(DIAGRAM, &RenderOptions::new());
+        assert!(page.contains("<code class=\"language-mermaid\">"));
+    }
+
+    #[test]
+    fn test_render_site_with_static_diagrams() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_command_output(
+            "render-diagram",
+            CommandOutput {
+                stdout: "<svg>flow</svg>\n".to_string(),
+                exit_code: Some(0),
+                ..CommandOutput::default()
+            },
+        );
+        let diagram_options = DiagramOptions::new()
+            .with_mode(DiagramMode::Static)
+            .with_command("mermaid", vec!["render-diagram".to_string()]);
+        let options = RenderOptions::new()
+            .with_pal(PalHandle::new(mock_pal))
+            .with_diagram_options(diagram_options);
+
+        let result = render_site(&[doc("src/flow.rs", 1, "Flow", DIAGRAM)], &options);
+
+        let page = find(&result.files, "src/flow.rs.html");
+        assert!(page.contains("<figure class=\"diagram\">\n<svg>flow</svg>\n</figure>"));
+        assert!(!page.contains(MERMAID_RUNTIME_URL));
+        assert!(result.warnings.is_empty());
+    }
+
+    #[test]
+    fn test_render_site_falls_back_without_diagram_renderer() {
+        let options = RenderOptions::new()
+            .with_pal(PalHandle::new(MockPal::new()))
+            .with_diagram_options(DiagramOptions::new().with_mode(DiagramMode::Static));
+
+        let result = render_site(&[doc("src/flow.rs", 1, "Flow", DIAGRAM)], &options);
+
+        let page = find(&result.files, "src/flow.rs.html");
+        assert!(page.contains("<code class=\"language-mermaid\">graph TD\n  A --&gt; B\n</code>"));
+        assert_eq!(result.warnings.len(), 1);
+        assert_eq!(result.warnings[0].line, 3);
+        assert!(
+            result.warnings[0]
+                .message
+                .starts_with("Failed to render mermaid diagram: Failed to run command 'mmdc")
+        );
+    }
+
+    #[test]
+    fn test_render_site_is_independent_of_concurrency() {
+        let documents: Vec<Document> = (0..20)
+            .map(|i| {
+                doc(
+                    &format!("src/file{i}.rs"),
+                    1,
+                    "Overview",
+                    "# Overview\n\nSee [[overview]].\n",
+                )
+            })
+            .collect();
+        let render = |concurrency| {
+            render_site(
+                &documents,
+                &RenderOptions::new().with_concurrency(concurrency),
+            )
+        };
+        assert_eq!(render(1), render(8));
+    }
+
+    /// Scan, extract and render a site from `files`, added to the PAL in the given order.
+    fn build(files: &[(&str, &str)], format: OutputFormat) -> Vec<RenderedFile> {
+        let mock_pal = MockPal::new();
+        for (path, content) in files {
+            mock_pal.add_file(FilePath::from(*path), content.as_bytes().to_vec());
+        }
+        let pal = PalHandle::new(mock_pal);
+        let config: Config = toml::from_str(
+            r#"
+title = "Site"
+source_link_template = ""
+
+[[directory]]
+paths = ["."]
+globs = ["**/*.rs", "**/*.md"]
+"#,
+        )
+        .unwrap();
+        let scanned = crate::scan_files(&pal, &config).unwrap();
+        let extraction = crate::extract_documents_with_options(
+            &pal,
+            &scanned.files,
+            &crate::ExtractionOptions::from_config(&config),
+        )
+        .unwrap();
+        let options = RenderOptions::from_config(&config).with_concurrency(4);
+        format
+            .renderer()
+            .render_site(&extraction.documents, &options)
+            .files
+    }
+
+    #[test]
+    fn test_build_output_is_reproducible() {
+        let files = [
+            (
+                "src/b.rs",
+                "// 📖 # Overview\n// See [[a]] and [[Guide]].\nfn b() {}\n",
+            ),
+            (
+                "src/a.rs",
+                "/// 📖 # Overview\n/// Used by [[b]].\nfn a() {}\n",
+            ),
+            (
+                "guide.md",
+                "---\ntitle: Guide\nauthor: Ann\ntags: x\n---\n# Guide\n\n## Overview\n",
+            ),
+        ];
+        let mut reversed = files;
+        reversed.reverse();
+
+        for format in [OutputFormat::Html, OutputFormat::Markdown] {
+            let output = build(&files, format);
+            assert_eq!(output, build(&files, format));
+            assert_eq!(output, build(&reversed, format));
+        }
+
+        let output = build(&files, OutputFormat::Html);
+        let index = find(&output, "index.html");
+        let toc =
+            &index[index.find("<nav class=\"toc\">").unwrap()..index.find("</main>").unwrap()];
+        expect![[r#"
+            <nav class="toc">
+            <ul>
+            <li><a href="guide.md.html#guide">Guide</a>
+            <ul>
+            <li><a href="guide.md.html#overview">Overview</a></li>
+            </ul>
+            </li>
+            <li><a href="src/a.rs.html#overview">Overview</a></li>
+            <li><a href="src/b.rs.html#overview">Overview</a></li>
+            </ul>
+            </nav>
+        "#]]
+        .assert_eq(toc);
+    }
+
+    #[test]
+    fn test_build_output_matches_golden_files() {
+        let files = [
+            (
+                "src/store.rs",
+                concat!(
+                    "// 📖 # Why a store?\n",
+                    "// Keeps [[Guide]] data in memory[^memory], see [[load]].\n",
+                    "//\n",
+                    "// [^memory]: Nothing is persisted.\n",
+                    "struct Store;\n",
+                    "\n",
+                    "/// 📖 # Loading\n",
+                    "/// ## Overview\n",
+                    "/// Reads `store.toml`.\n",
+                    "fn load() {}\n",
+                ),
+            ),
+            (
+                "guide.md",
+                concat!(
+                    "---\ntitle: Guide\nauthor: Ann\ntags: x\n---\n",
+                    "# Guide\n\n## Overview\n\nStart with [the store](src/store.rs.html).\n\n",
+                    "```rust\nlet store = Store;\n```\n",
+                ),
+            ),
+        ];
+        let mut snapshot = String::new();
+        for format in [OutputFormat::Html, OutputFormat::Markdown] {
+            let mut output = build(&files, format);
+            output.sort_by(|a, b| a.path.to_string().cmp(&b.path.to_string()));
+            for file in output {
+                snapshot.push_str(&format!(
+                    "=== {:?} {}\n{}\n",
+                    format, file.path, file.content
+                ));
+            }
+        }
+        expect_file!["../testdata/golden_site.txt"].assert_eq(&snapshot);
+    }
+
+    #[test]
+    fn test_write_site() {
+        let mock_pal = MockPal::new();
+        let pal = PalHandle::new(mock_pal);
+        let documents = vec![doc("src/lib.rs", 1, "Lib", "# Lib\n")];
+        let files = render_site(&documents, &RenderOptions::new()).files;
+
+        write_site(&pal, &FilePath::from("output"), &files).unwrap();
+
+        let page = pal
+            .read_file_to_string(&FilePath::from("output/src/lib.rs.html"))
+            .unwrap();
+        assert!(page.contains("<h1 id=\"lib\">Lib</h1>"));
+        assert!(
+            pal.file_exists(&FilePath::from("output/index.html"))
+                .unwrap()
+        );
+    }
+}
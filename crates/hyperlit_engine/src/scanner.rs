@@ -96,8 +96,8 @@ pub fn scan_files(pal: &PalHandle, config: &Config) -> HyperlitResult<ScanResult
         for path_str in &dir_config.paths {
             let path = FilePath::from(path_str.as_str());
 
-            // Call walk_directory with globs
-            match pal.walk_directory(&path, &dir_config.globs) {
+            // Walk the directory with its globs, excludes and gitignore setting
+            match pal.walk_directory_with_options(&path, &dir_config.walk_options()) {
                 Ok(iter) => {
                     // Collect results from iterator
                     for result in iter {
@@ -156,12 +156,15 @@ mod tests {
                 DirectoryConfig {
                     paths: vec!["src".to_string()],
                     globs: vec!["*.rs".to_string()],
+                    ..Default::default()
                 },
                 DirectoryConfig {
                     paths: vec!["docs".to_string()],
                     globs: vec!["*.md".to_string()],
+                    ..Default::default()
                 },
             ],
+            ..Default::default()
         };
 
         let pal = PalHandle::new(mock_pal);
@@ -174,6 +177,33 @@ mod tests {
         assert_eq!(result.errors.len(), 0);
     }
 
+    #[test]
+    fn test_scan_files_skips_excluded_and_gitignored_paths() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from(".gitignore"), b"target/\n".to_vec());
+        mock_pal.add_file(FilePath::from("src/lib.rs"), b"".to_vec());
+        mock_pal.add_file(FilePath::from("src/generated/api.rs"), b"".to_vec());
+        mock_pal.add_file(FilePath::from("target/debug/build.rs"), b"".to_vec());
+
+        let config = Config {
+            title: "Test Project".to_string(),
+            source_link_template: "https://example.com/{path}".to_string(),
+            directory: vec![DirectoryConfig {
+                paths: vec![".".to_string()],
+                globs: vec!["*.rs".to_string()],
+                exclude: vec!["**/generated".to_string()],
+                ..Default::default()
+            }],
+            ..Default::default()
+        };
+
+        let pal = PalHandle::new(mock_pal);
+        let result = scan_files(&pal, &config).unwrap();
+
+        assert_eq!(result.files, [FilePath::from("src/lib.rs")]);
+        assert_eq!(result.errors.len(), 0);
+    }
+
     #[test]
     fn test_scan_files_empty_config() {
         let mock_pal = MockPal::new();
@@ -181,6 +211,7 @@ mod tests {
             title: "Empty Project".to_string(),
             source_link_template: "https://example.com/{path}".to_string(),
             directory: vec![],
+            ..Default::default()
         };
 
         let pal = PalHandle::new(mock_pal);
@@ -202,7 +233,9 @@ mod tests {
             directory: vec![DirectoryConfig {
                 paths: vec!["nonexistent".to_string()],
                 globs: vec!["*.rs".to_string()],
+                ..Default::default()
             }],
+            ..Default::default()
         };
 
         let pal = PalHandle::new(mock_pal);
@@ -227,7 +260,9 @@ mod tests {
             directory: vec![DirectoryConfig {
                 paths: vec!["src".to_string(), "lib".to_string()],
                 globs: vec!["*.rs".to_string()],
+                ..Default::default()
             }],
+            ..Default::default()
         };
 
         let pal = PalHandle::new(mock_pal);
@@ -266,7 +301,9 @@ mod tests {
             directory: vec![DirectoryConfig {
                 paths: vec!["src".to_string()],
                 globs: vec!["*.py".to_string()],
+                ..Default::default()
             }],
+            ..Default::default()
         };
 
         let pal = PalHandle::new(mock_pal);
@@ -289,12 +326,15 @@ mod tests {
                 DirectoryConfig {
                     paths: vec!["src".to_string()],
                     globs: vec!["*.rs".to_string()],
+                    ..Default::default()
                 },
                 DirectoryConfig {
                     paths: vec!["nonexistent".to_string()],
                     globs: vec!["*.py".to_string()],
+                    ..Default::default()
                 },
             ],
+            ..Default::default()
         };
 
         let pal = PalHandle::new(mock_pal);
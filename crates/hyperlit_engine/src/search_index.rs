@@ -0,0 +1,358 @@
+/* 📖 # Why generate a search index?
+
+A static site has no server to search on, so searching has to happen in the
+browser. With `search_index = true` the HTML build writes `search-index.json`
+next to `index.html`, with one record per section of a page (the text under a
+heading, up to the next heading) for a client-side search library to load:
+
+```json
+{
+  "version": 1,
+  "records": [
+    {
+      "id": "prose:src/server.rs.html#retries",
+      "url": "src/server.rs.html#retries",
+      "page": "HTTP server",
+      "heading": "Retries",
+      "kind": "prose",
+      "text": "Requests are retried up to three times."
+    },
+    {
+      "id": "code:src/server.rs.html#retries",
+      "url": "src/server.rs.html#retries",
+      "page": "HTTP server",
+      "heading": "Retries",
+      "kind": "code",
+      "text": "let retries = 3;"
+    }
+  ]
+}
+```
+
+The records are a plain document set, so they can be added to a lunr index as
+they are (`this.ref("id")`, `this.field("heading")`, `this.field("text")`), or
+searched with a few lines of custom code. The code blocks of a section get a
+record of their own with `kind = "code"`, so results can be restricted to prose,
+or to code, or ranked differently.
+
+Record IDs and URLs are derived from the page path and the heading anchor, the
+same ones the pages link with, so they stay stable across builds as long as the
+headings do. A URL is relative to the output directory. Text before the first
+heading of a page is indexed under the page itself, titled by the page title.
+Markup is stripped, `[[name]]` references are indexed by their name.
+*/
+
+use serde::Serialize;
+
+use hyperlit_base::FilePath;
+use pulldown_cmark::{Event, Parser, Tag, TagEnd};
+
+use crate::conditional::apply_profiles;
+use crate::directive::{expand_directives, is_expansion};
+use crate::following_code::expand_following_code;
+use crate::footnote::PARSER_OPTIONS;
+use crate::render::{INDEX_PAGE, SiteMap, page_title};
+use crate::xref::{TextPart, split_references};
+use crate::{Document, OutputMode, RenderOptions, RenderedFile};
+
+/// Name of the search index in the output directory.
+pub const SEARCH_INDEX_FILE: &str = "search-index.json";
+
+/// Version of the search index format, incremented on incompatible changes.
+pub const SEARCH_INDEX_VERSION: u32 = 1;
+
+/// What the text of a record is.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+enum RecordKind {
+    Prose,
+    Code,
+}
+
+impl RecordKind {
+    /// Returns the name the kind is serialized with.
+    fn name(self) -> &'static str {
+        match self {
+            RecordKind::Prose => "prose",
+            RecordKind::Code => "code",
+        }
+    }
+}
+
+/// One searchable piece of a page.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct SearchRecord {
+    id: String,
+    url: String,
+    /// Title of the page
+    page: String,
+    /// Heading of the section, the page title before the first heading
+    heading: String,
+    kind: RecordKind,
+    text: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct SearchIndex {
+    version: u32,
+    records: Vec<SearchRecord>,
+}
+
+/// The text under a heading of a page.
+#[derive(Debug, Default)]
+struct Section {
+    /// Anchor of the heading, None for the text before the first heading
+    anchor: Option<String>,
+    heading: String,
+    prose: String,
+    code: Vec<String>,
+}
+
+/// Render the search index of the site, None if it is disabled.
+///
+/// `groups` are the documents of every page, `site_map` must be built from all
+/// of them, so the anchors match the pages.
+pub(crate) fn render_search_index(
+    groups: &[(FilePath, Vec<&Document>)],
+    site_map: &SiteMap,
+    options: &RenderOptions,
+) -> Option<RenderedFile> {
+    if !options.search_index() {
+        return None;
+    }
+    let mut records = Vec::new();
+    for (source_path, documents) in groups {
+        let page = options.page_path(source_path);
+        let title = page_title(source_path, documents);
+        let mut sorted = documents.to_vec();
+        sorted.sort_by_key(|document| document.source().line_number());
+        let mut sections = vec![Section::default()];
+        for document in sorted {
+            collect_sections(document, site_map, options, &mut sections);
+        }
+        for section in sections {
+            let prose = plain_text(&section.prose);
+            // The text before the first heading is often empty
+            if section.anchor.is_none() && prose.is_empty() && section.code.is_empty() {
+                continue;
+            }
+            let url = section_url(&page, section.anchor.as_deref(), options);
+            let heading = match section.anchor {
+                Some(_) => section.heading,
+                None => title.clone(),
+            };
+            let record = |kind: RecordKind, text: String| SearchRecord {
+                id: format!("{}:{}", kind.name(), url),
+                url: url.clone(),
+                page: title.clone(),
+                heading: heading.clone(),
+                kind,
+                text,
+            };
+            records.push(record(RecordKind::Prose, prose));
+            if !section.code.is_empty() {
+                records.push(record(RecordKind::Code, section.code.join("\n")));
+            }
+        }
+    }
+    let index = SearchIndex {
+        version: SEARCH_INDEX_VERSION,
+        records,
+    };
+    let mut content = serde_json::to_string_pretty(&index).expect("search index serializes");
+    content.push('\n');
+    Some(RenderedFile {
+        path: FilePath::from(SEARCH_INDEX_FILE),
+        content,
+    })
+}
+
+/// Split the text of a document into sections, continuing the last one of `sections`.
+fn collect_sections(
+    document: &Document,
+    site_map: &SiteMap,
+    options: &RenderOptions,
+    sections: &mut Vec<Section>,
+) {
+    let document = &*apply_profiles(document, options.profiles());
+    let content = document.content();
+    let mut anchors = site_map.toc.anchors(document.id()).iter();
+    // Anchor and text of the heading being parsed
+    let mut heading: Option<(Option<String>, String)> = None;
+    let mut code_block: Option<String> = None;
+
+    let parsed = expand_directives(
+        document,
+        Parser::new_ext(content, PARSER_OPTIONS).into_offset_iter(),
+    );
+    for (event, range) in expand_following_code(document, parsed) {
+        match event {
+            // Headings of directive expansions have no anchor, as in the pages
+            Event::Start(Tag::Heading { .. }) if !is_expansion(content, &range) => {
+                heading = Some((anchors.next().cloned(), String::new()));
+            }
+            Event::End(TagEnd::Heading(_)) if heading.is_some() => {
+                let (anchor, text) = heading.take().expect("heading is some");
+                sections.push(Section {
+                    anchor,
+                    heading: plain_text(&text),
+                    ..Section::default()
+                });
+            }
+            Event::Start(Tag::CodeBlock(_)) => code_block = Some(String::new()),
+            Event::End(TagEnd::CodeBlock) => {
+                let code = code_block.take().unwrap_or_default();
+                let code = code.trim_matches('\n');
+                if !code.is_empty() {
+                    current(sections).code.push(code.to_string());
+                }
+            }
+            Event::Text(text) | Event::Code(text) => match (&mut heading, &mut code_block) {
+                (_, Some(code)) => code.push_str(&text),
+                (Some((_, heading_text)), None) => heading_text.push_str(&text),
+                (None, None) => current(sections).prose.push_str(&text),
+            },
+            Event::SoftBreak | Event::HardBreak => match &mut heading {
+                Some((_, heading_text)) => heading_text.push(' '),
+                None => current(sections).prose.push(' '),
+            },
+            // Separate the words of adjacent blocks
+            Event::End(TagEnd::Paragraph | TagEnd::Item | TagEnd::TableCell) => {
+                current(sections).prose.push(' ')
+            }
+            _ => {}
+        }
+    }
+}
+
+/// Returns the section text is added to.
+fn current(sections: &mut [Section]) -> &mut Section {
+    sections.last_mut().expect("there is always a section")
+}
+
+/// Returns text as it is indexed, with `[[name]]` references replaced by their
+/// name and runs of whitespace collapsed to single spaces.
+fn plain_text(text: &str) -> String {
+    let mut plain = String::with_capacity(text.len());
+    for part in split_references(text) {
+        match part {
+            TextPart::Text(text) => plain.push_str(text),
+            TextPart::Reference { name, .. } => plain.push_str(name.trim()),
+        }
+    }
+    plain.split_whitespace().collect::<Vec<_>>().join(" ")
+}
+
+/// Returns the URL of a section, relative to the output directory.
+fn section_url(page: &FilePath, anchor: Option<&str>, options: &RenderOptions) -> String {
+    match (options.output_mode(), anchor) {
+        // Pages are sections of the single page, with their path as ID
+        (OutputMode::SingleFile, Some(anchor)) => format!("{}#{}", INDEX_PAGE, anchor),
+        (OutputMode::SingleFile, None) => format!("{}#{}", INDEX_PAGE, page),
+        (_, Some(anchor)) => format!("{}#{}", page, anchor),
+        (_, None) => page.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use expect_test::expect;
+    use std::collections::HashSet;
+
+    fn doc(path: &str, line: usize, content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), line);
+        Document::new(
+            content.lines().next().unwrap_or_default().to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    fn search_index(documents: &[Document], options: &RenderOptions) -> String {
+        let site_map = SiteMap::build_with_options(documents, options);
+        let groups = crate::render::group_by_file(documents);
+        render_search_index(&groups, &site_map, options)
+            .expect("search index is enabled")
+            .content
+    }
+
+    #[test]
+    fn test_render_search_index() {
+        let documents = vec![
+            doc(
+                "src/server.rs",
+                1,
+                "Serves *HTTP* requests.\n\n# Retries\n\nRequests are retried\nup to three times, see [[Client]].\n\n```rust\nlet retries = 3;\n```\n\n- one\n- two\n",
+            ),
+            doc(
+                "src/server.rs",
+                20,
+                "Continued `text`.\n\n```\nlet delay = 100;\n```\n\n## Limits\n",
+            ),
+        ];
+        let options = RenderOptions::new().with_search_index(true);
+        expect![[r#"
+            {
+              "version": 1,
+              "records": [
+                {
+                  "id": "prose:src/server.rs.html",
+                  "url": "src/server.rs.html",
+                  "page": "src/server.rs",
+                  "heading": "src/server.rs",
+                  "kind": "prose",
+                  "text": "Serves HTTP requests."
+                },
+                {
+                  "id": "prose:src/server.rs.html#retries",
+                  "url": "src/server.rs.html#retries",
+                  "page": "src/server.rs",
+                  "heading": "Retries",
+                  "kind": "prose",
+                  "text": "Requests are retried up to three times, see Client. one two Continued text."
+                },
+                {
+                  "id": "code:src/server.rs.html#retries",
+                  "url": "src/server.rs.html#retries",
+                  "page": "src/server.rs",
+                  "heading": "Retries",
+                  "kind": "code",
+                  "text": "let retries = 3;\nlet delay = 100;"
+                },
+                {
+                  "id": "prose:src/server.rs.html#limits",
+                  "url": "src/server.rs.html#limits",
+                  "page": "src/server.rs",
+                  "heading": "Limits",
+                  "kind": "prose",
+                  "text": ""
+                }
+              ]
+            }
+        "#]]
+        .assert_eq(&search_index(&documents, &options));
+    }
+
+    #[test]
+    fn test_render_search_index_single_file() {
+        let documents = vec![doc("src/lib.rs", 1, "Intro\n\n# Usage\n\nCall it.\n")];
+        let options = RenderOptions::new()
+            .with_search_index(true)
+            .with_output_mode(OutputMode::SingleFile);
+        let index = search_index(&documents, &options);
+        assert!(index.contains(r#""url": "index.html#src/lib.rs.html","#));
+        assert!(index.contains(r#""url": "index.html#usage","#));
+    }
+
+    #[test]
+    fn test_render_search_index_disabled() {
+        let documents = vec![doc("src/lib.rs", 1, "# Usage\n")];
+        let site_map = SiteMap::build_with_options(&documents, &RenderOptions::new());
+        let groups = crate::render::group_by_file(&documents);
+        assert!(render_search_index(&groups, &site_map, &RenderOptions::new()).is_none());
+    }
+}
@@ -0,0 +1,111 @@
+/* 📖 # Why skip doc comments with a pragma?
+
+Sometimes a doc comment should stay in the code but not in the docs for now,
+e.g. notes on a feature that is not released yet, or prose that is out of date
+and waits for a rewrite. Deleting the comment loses the text for the reader of
+the code, removing the marker turns it into an ordinary comment that is easily
+forgotten. A skip pragma keeps both:
+
+```text
+// 📖 !skip # Streaming uploads
+// Large files are uploaded in chunks.
+```
+
+A doc comment starting with `!skip` right after its marker, or with a line
+`{{skip}}` before anything else, is still recognized and parsed as a doc
+comment, so it keeps ending the code captured for the comment before it and is
+never merged with its neighbours. It is left out of the extracted documents
+though, so it is not rendered, not listed in the table of contents and not
+searchable. The pragma is removed from the text.
+
+With `include_skipped = true` skipped doc comments are extracted anyway and
+rendered marked as skipped, so authors can review what is hidden. Markdown
+files are documents as a whole, to leave one out, exclude it from the globs of
+its directory instead.
+*/
+
+use crate::comment_parser::ExtractedComment;
+
+/// Pragma following the doc marker of a skipped doc comment.
+const SKIP_PRAGMA: &str = "!skip";
+
+/// Directive line at the start of a skipped doc comment.
+const SKIP_DIRECTIVE: &str = "{{skip}}";
+
+/// Remove the skip pragma of a doc comment, returns true if it had one.
+///
+/// Blank lines left at the start are removed as well, moving the start line of
+/// the comment, see [`crate::skip`].
+pub(crate) fn take_skip_pragma(comment: &mut ExtractedComment) -> bool {
+    let content = &mut comment.content;
+    let indented = content.trim_start_matches([' ', '\t']);
+    let pragma = indented
+        .strip_prefix(SKIP_PRAGMA)
+        .filter(|rest| rest.is_empty() || rest.starts_with(char::is_whitespace));
+    if let Some(rest) = pragma {
+        let rest = rest.trim_start_matches([' ', '\t']);
+        content.drain(..content.len() - rest.len());
+    } else {
+        let blank = content.len() - content.trim_start().len();
+        let line_start = content[..blank].rfind('\n').map_or(0, |index| index + 1);
+        let line_end = content[line_start..]
+            .find('\n')
+            .map_or(content.len(), |index| line_start + index);
+        if content[line_start..line_end].trim() != SKIP_DIRECTIVE {
+            return false;
+        }
+        content.replace_range(line_start..line_end, "");
+    }
+    let blank_lines = content.len() - content.trim_start().len();
+    let body_start = content[..blank_lines]
+        .rfind('\n')
+        .map_or(0, |index| index + 1);
+    comment.start_line += content[..body_start].matches('\n').count();
+    content.drain(..body_start);
+    true
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn skip(content: &str) -> Option<(String, usize)> {
+        let mut comment = ExtractedComment {
+            content: content.to_string(),
+            start_line: 1,
+            start_byte: 0,
+            end_byte: content.len(),
+            before_code: false,
+            skipped: false,
+        };
+        take_skip_pragma(&mut comment).then_some((comment.content, comment.start_line))
+    }
+
+    #[test]
+    fn test_take_skip_pragma() {
+        assert_eq!(
+            skip("!skip # Streaming\nIn chunks.\n"),
+            Some(("# Streaming\nIn chunks.\n".to_string(), 1))
+        );
+        assert_eq!(
+            skip("!skip\n\n# Streaming\n"),
+            Some(("# Streaming\n".to_string(), 3))
+        );
+        assert_eq!(
+            skip("{{skip}}\n# Streaming\n"),
+            Some(("# Streaming\n".to_string(), 2))
+        );
+        assert_eq!(
+            skip("\n  {{skip}}  \n# Streaming\n"),
+            Some(("# Streaming\n".to_string(), 3))
+        );
+    }
+
+    #[test]
+    fn test_no_skip_pragma() {
+        assert_eq!(skip("# Streaming\n"), None);
+        assert_eq!(skip("!skipping is not a pragma\n"), None);
+        assert_eq!(skip("# Streaming\n{{skip}}\n"), None);
+        assert_eq!(skip("`{{skip}}` skips a comment\n"), None);
+    }
+}
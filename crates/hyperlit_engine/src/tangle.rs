@@ -0,0 +1,401 @@
+/* 📖 # Why support tangling documents back into source files?
+
+Literate programming works in two directions: *weaving* turns source into
+documentation, *tangling* turns documentation back into runnable source.
+Hyperlit's main job is weaving, but documents that are written "prose first"
+(e.g. tutorials or design documents in markdown) often contain the complete
+program in fenced code blocks. Tangling lets such documents stay the single
+source of truth while a build step writes the assembled files to disk.
+
+Two kinds of fenced code blocks take part in tangling:
+
+- **File blocks** declare a target file in their info string
+  (```` ```go file=main.go ````). All blocks for the same file are
+  concatenated in document order.
+- **Named blocks** declare a name (```` ```go name=helpers ````) and can be
+  referenced from other blocks with a `<<helpers>>` line. Blocks sharing a name
+  are concatenated as well, so a named chunk can be built up step by step.
+
+References are expanded recursively. The indentation in front of a reference is
+applied to every expanded line, so a chunk can be referenced inside a function
+body. Reference cycles are reported as errors instead of recursing forever.
+*/
+
+use std::collections::{BTreeMap, HashMap};
+
+use pulldown_cmark::{CodeBlockKind, Event, Parser, Tag, TagEnd};
+
+use hyperlit_base::{HyperlitResult, ResultExt, bail};
+
+use crate::Document;
+
+/// Assemble the source files declared by the fenced code blocks of a document.
+///
+/// Returns a map from each declared filename to the assembled file content.
+/// Documents without any `file=` code blocks yield an empty map.
+///
+/// # Errors
+/// Returns an error if a `<<name>>` reference cannot be resolved or if block
+/// references form a cycle.
+///
+/// # Examples
+/// ```
+/// use std::collections::HashSet;
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::{Document, DocumentSource, SourceType, tangle};
+///
+/// let content = "```sh file=hello.sh\n<<greeting>>\n```\n\n```sh name=greeting\necho hello\n```\n";
+/// let source = DocumentSource::new(SourceType::MarkdownFile, FilePath::from("hello.md"), 1);
+/// let doc = Document::new("Hello".to_string(), content.to_string(), source, None, &HashSet::new());
+///
+/// let files = tangle(&doc).unwrap();
+/// assert_eq!(files["hello.sh"], b"echo hello\n");
+/// ```
+pub fn tangle(document: &Document) -> HyperlitResult<BTreeMap<String, Vec<u8>>> {
+    tangle_content(document.content())
+        .with_context(|| format!("Failed to tangle document '{}'", document.id()))
+}
+
+/// A fenced code block taking part in tangling.
+struct TangleBlock {
+    file: Option<String>,
+    name: Option<String>,
+    code: String,
+}
+
+fn tangle_content(content: &str) -> HyperlitResult<BTreeMap<String, Vec<u8>>> {
+    let blocks = collect_blocks(content);
+
+    let mut named_blocks: HashMap<&str, String> = HashMap::new();
+    for block in &blocks {
+        if let Some(name) = &block.name {
+            named_blocks
+                .entry(name.as_str())
+                .or_default()
+                .push_str(&block.code);
+        }
+    }
+
+    let mut files = BTreeMap::new();
+    for block in &blocks {
+        if let Some(file) = &block.file {
+            let mut stack = Vec::new();
+            let code = expand_references(&block.code, &named_blocks, &mut stack)
+                .with_context(|| format!("Failed to assemble file '{file}'"))?;
+            files
+                .entry(file.clone())
+                .or_insert_with(Vec::new)
+                .extend_from_slice(code.as_bytes());
+        }
+    }
+    Ok(files)
+}
+
+/// Collect all fenced code blocks declaring a `file=` or `name=` attribute.
+fn collect_blocks(content: &str) -> Vec<TangleBlock> {
+    let mut blocks = Vec::new();
+    let mut current: Option<TangleBlock> = None;
+    for event in Parser::new(content) {
+        match event {
+            Event::Start(Tag::CodeBlock(CodeBlockKind::Fenced(info))) => {
+                let attributes = parse_info_attributes(&info);
+                let file = attributes.get("file").cloned();
+                let name = attributes.get("name").cloned();
+                if file.is_some() || name.is_some() {
+                    current = Some(TangleBlock {
+                        file,
+                        name,
+                        code: String::new(),
+                    });
+                }
+            }
+            Event::Text(text) => {
+                if let Some(block) = &mut current {
+                    block.code.push_str(&text);
+                }
+            }
+            Event::End(TagEnd::CodeBlock) => {
+                if let Some(block) = current.take() {
+                    blocks.push(block);
+                }
+            }
+            _ => {}
+        }
+    }
+    blocks
+}
+
+/// Parse the `key=value` attributes of a fenced code block info string.
+///
+/// The first word (the language) and words without `=` are ignored.
+/// Values may be wrapped in double quotes to contain whitespace.
+fn parse_info_attributes(info: &str) -> HashMap<String, String> {
+    split_info_words(info)
+        .into_iter()
+        .filter_map(|word| {
+            let (key, value) = word.split_once('=')?;
+            Some((key.to_string(), value.to_string()))
+        })
+        .collect()
+}
+
+/// Split an info string into words at whitespace outside of double quotes.
+///
+/// The quotes themselves are removed, an unterminated quote extends to the end.
+fn split_info_words(info: &str) -> Vec<String> {
+    let mut words = Vec::new();
+    let mut current: Option<String> = None;
+    let mut quoted = false;
+    for c in info.chars() {
+        match c {
+            '"' => {
+                quoted = !quoted;
+                current.get_or_insert_with(String::new);
+            }
+            c if c.is_whitespace() && !quoted => words.extend(current.take()),
+            c => current.get_or_insert_with(String::new).push(c),
+        }
+    }
+    words.extend(current);
+    words
+}
+
+/// Recursively replace `<<name>>` reference lines with the referenced blocks.
+///
+/// `stack` holds the names currently being expanded and is used to detect cycles.
+fn expand_references<'a>(
+    code: &str,
+    named_blocks: &HashMap<&'a str, String>,
+    stack: &mut Vec<&'a str>,
+) -> HyperlitResult<String> {
+    let mut expanded = String::with_capacity(code.len());
+    for line in code.split_inclusive('\n') {
+        let Some((indent, name)) = parse_reference(line) else {
+            expanded.push_str(line);
+            continue;
+        };
+        let Some((&name, block)) = named_blocks.get_key_value(name) else {
+            bail!("Unknown code block reference '<<{}>>'", name);
+        };
+        if stack.contains(&name) {
+            let mut chain: Vec<&str> = stack.clone();
+            chain.push(name);
+            bail!("Cyclic code block reference: {}", chain.join(" -> "));
+        }
+        stack.push(name);
+        let block = expand_references(block, named_blocks, stack)?;
+        stack.pop();
+        for block_line in block.split_inclusive('\n') {
+            if !block_line.trim().is_empty() {
+                expanded.push_str(indent);
+            }
+            expanded.push_str(block_line);
+        }
+    }
+    Ok(expanded)
+}
+
+/// Parse a line consisting only of a `<<name>>` reference.
+///
+/// Returns the leading indentation and the referenced name.
+fn parse_reference(line: &str) -> Option<(&str, &str)> {
+    let trimmed = line.trim_start();
+    let indent = &line[..line.len() - trimmed.len()];
+    let name = trimmed.trim_end().strip_prefix("<<")?.strip_suffix(">>")?;
+    if name.is_empty() || name.contains("<<") || name.contains(">>") {
+        return None;
+    }
+    Some((indent, name.trim()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use expect_test::expect;
+
+    fn tangle_to_string(content: &str) -> String {
+        match tangle_content(content) {
+            Ok(files) => files
+                .iter()
+                .map(|(file, code)| format!("=== {}\n{}", file, String::from_utf8_lossy(code)))
+                .collect::<Vec<_>>()
+                .join(""),
+            Err(e) => format!("Error: {}", e),
+        }
+    }
+
+    #[test]
+    fn test_tangle_concatenates_blocks_in_document_order() {
+        let content = r#"# Hello
+
+First the package declaration:
+
+```go file=main.go
+package main
+```
+
+Then the entry point:
+
+```go file=main.go
+func main() {}
+```
+"#;
+        expect![[r#"
+            === main.go
+            package main
+            func main() {}
+        "#]]
+        .assert_eq(&tangle_to_string(content));
+    }
+
+    #[test]
+    fn test_tangle_quoted_file_name_with_spaces() {
+        let content = r#"
+```rust file="my notes.rs" name=notes
+fn note() {}
+```
+"#;
+        expect![[r#"
+            === my notes.rs
+            fn note() {}
+        "#]]
+        .assert_eq(&tangle_to_string(content));
+        assert_eq!(
+            split_info_words(r#"rust  file="a b.rs" title="x""#),
+            ["rust", "file=a b.rs", "title=x"]
+        );
+    }
+
+    #[test]
+    fn test_tangle_multiple_files() {
+        let content = r#"
+```go file=main.go
+package main
+```
+
+```text file="notes.txt"
+Some notes
+```
+
+```go
+// not tangled
+```
+"#;
+        expect![[r#"
+            === main.go
+            package main
+            === notes.txt
+            Some notes
+        "#]]
+        .assert_eq(&tangle_to_string(content));
+    }
+
+    #[test]
+    fn test_tangle_expands_named_blocks_with_indentation() {
+        let content = r#"
+```go file=main.go
+func main() {
+    <<setup>>
+}
+```
+
+```go name=setup
+<<greeting>>
+
+fmt.Println(greeting)
+```
+
+```go name=greeting
+greeting := "hello"
+```
+"#;
+        expect![[r#"
+            === main.go
+            func main() {
+                greeting := "hello"
+
+                fmt.Println(greeting)
+            }
+        "#]]
+        .assert_eq(&tangle_to_string(content));
+    }
+
+    #[test]
+    fn test_tangle_named_block_defined_in_parts() {
+        let content = r#"
+```py file=main.py
+<<imports>>
+```
+
+```py name=imports
+import os
+```
+
+```py name=imports
+import sys
+```
+"#;
+        expect![[r#"
+            === main.py
+            import os
+            import sys
+        "#]]
+        .assert_eq(&tangle_to_string(content));
+    }
+
+    #[test]
+    fn test_tangle_detects_cycles() {
+        let content = r#"
+```go file=main.go
+<<a>>
+```
+
+```go name=a
+<<b>>
+```
+
+```go name=b
+<<a>>
+```
+"#;
+        expect![
+            "Error: Failed to assemble file 'main.go': Cyclic code block reference: a -> b -> a"
+        ]
+        .assert_eq(&tangle_to_string(content));
+    }
+
+    #[test]
+    fn test_tangle_detects_self_reference() {
+        let content = "```go name=a\n<<a>>\n```\n\n```go file=main.go\n<<a>>\n```\n";
+        expect!["Error: Failed to assemble file 'main.go': Cyclic code block reference: a -> a"]
+            .assert_eq(&tangle_to_string(content));
+    }
+
+    #[test]
+    fn test_tangle_unknown_reference() {
+        let content = "```go file=main.go\n<<missing>>\n```\n";
+        expect![
+            "Error: Failed to assemble file 'main.go': Unknown code block reference '<<missing>>'"
+        ]
+        .assert_eq(&tangle_to_string(content));
+    }
+
+    #[test]
+    fn test_tangle_ignores_inline_angle_brackets() {
+        let content =
+            "```cpp file=main.cpp\nstd::cout << \"x\" << std::endl;\nint y = a<<b>>c;\n```\n";
+        expect![[r#"
+            === main.cpp
+            std::cout << "x" << std::endl;
+            int y = a<<b>>c;
+        "#]]
+        .assert_eq(&tangle_to_string(content));
+    }
+
+    #[test]
+    fn test_tangle_without_file_blocks() {
+        expect![""].assert_eq(&tangle_to_string(
+            "# Just prose\n\n```go\nfmt.Println()\n```\n",
+        ));
+    }
+}
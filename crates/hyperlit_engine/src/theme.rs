@@ -0,0 +1,529 @@
+/* 📖 # Why themes made of template files?
+
+The built-in HTML shell is deliberately plain, so sites with their own branding
+need to change the markup around the rendered documentation: add a header and
+footer, load their own fonts and stylesheets or show the table of contents in a
+sidebar. A theme is a directory with template files overriding the built-in
+templates, any subset of:
+
+- `page.html`: the whole HTML document of every page, see `PageData`
+- `toc.html`: the table of contents on the index page, see `TocData`
+- `code_block.html`: the markup around every code block, see `CodeBlockData`
+
+Templates not in the directory fall back to the built-in ones, so a theme
+overriding only `page.html` keeps the default table of contents and code blocks.
+Set `theme_directory` in the configuration, or load a theme with `Theme::load`
+and pass it to `RenderOptions::with_theme`.
+
+Templates insert fields with `{{name}}`, e.g. `<title>{{title}}</title>`, and
+front matter values of the page with `{{front_matter.key}}`. Like Go's
+`html/template`, text fields are HTML-escaped when inserted, only fields that
+already are HTML (such as the rendered `body`) are inserted as they are. There
+are no loops or conditions: everything that needs logic (the table of contents,
+highlighted code) is rendered by hyperlit and handed to the template as HTML.
+Themes with their own navigation controls use `{{previous.url}}`,
+`{{next.title}}` and so on instead of the rendered `{{navigation}}`, these
+link the neighbouring pages in reading order and are empty on the first and
+last page respectively.
+
+The fields of each template are documented on `PageData`, `TocData` and
+`CodeBlockData`, they are a stable interface: fields are only ever added.
+Templates are checked when the theme is loaded, so a typo in a field name or an
+unclosed `{{` fails before anything is rendered instead of producing broken
+pages. A missing front matter value is inserted as an empty string, since front
+matter differs from page to page.
+
+Themes only apply to HTML output, Markdown output has no page shell.
+*/
+
+use std::collections::BTreeMap;
+use std::sync::LazyLock;
+
+use hyperlit_base::{FilePath, HyperlitResult, PalHandle, bail};
+
+use crate::cache::ContentHasher;
+use crate::render::escape_html;
+
+/// File name of the page template in a theme directory.
+pub const PAGE_TEMPLATE: &str = "page.html";
+
+/// File name of the table of contents template in a theme directory.
+pub const TOC_TEMPLATE: &str = "toc.html";
+
+/// File name of the code block template in a theme directory.
+pub const CODE_BLOCK_TEMPLATE: &str = "code_block.html";
+
+/// Prefix of the fields inserting front matter values.
+const FRONT_MATTER_PREFIX: &str = "front_matter.";
+
+const BUILT_IN_PAGE: &str = r#"<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{title}} - {{site_title}}</title>
+{{stylesheet}}
+</head>
+<body>
+<nav class="site"><a href="{{index}}">{{site_title}}</a></nav>
+<main>
+{{body}}{{navigation}}</main>
+{{scripts}}</body>
+</html>
+"#;
+
+const BUILT_IN_TOC: &str = r#"<h1>{{site_title}}</h1>
+<nav class="toc">
+{{entries}}</nav>
+"#;
+
+static BUILT_IN_THEME: LazyLock<Theme> = LazyLock::new(|| Theme {
+    page: Template::parse(PAGE_TEMPLATE, BUILT_IN_PAGE, PageData::FIELDS, true)
+        .expect("built-in page template is valid"),
+    toc: Template::parse(TOC_TEMPLATE, BUILT_IN_TOC, TocData::FIELDS, false)
+        .expect("built-in toc template is valid"),
+    code_block: None,
+});
+
+/// Data of the page template, `page.html`.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct PageData {
+    /// `{{title}}`: title of the page, e.g. the source path
+    pub title: String,
+    /// `{{site_title}}`: title of the site
+    pub site_title: String,
+    /// `{{root}}`: relative path from the page to the output root, e.g. `../`
+    pub root: String,
+    /// `{{index}}`: link to the table of contents
+    pub index: String,
+    /// `{{stylesheet}}` (HTML): the element loading or holding the stylesheet
+    pub stylesheet: String,
+    /// `{{body}}` (HTML): the rendered documents of the page
+    pub body: String,
+    /// `{{navigation}}` (HTML): links to the previous and next page in reading
+    /// order, empty if there are none, see [`crate::reading_order`]
+    pub navigation: String,
+    /// `{{previous.title}}`, `{{previous.url}}`: the page before this one in
+    /// reading order, None on the first page (the fields are empty then)
+    pub previous: Option<PageLink>,
+    /// `{{next.title}}`, `{{next.url}}`: the page after this one in reading
+    /// order, None on the last page (the fields are empty then)
+    pub next: Option<PageLink>,
+    /// `{{toc}}` (HTML): the table of contents as nested lists, linked from the page
+    pub toc: String,
+    /// `{{scripts}}` (HTML): scripts the page needs, e.g. for diagrams
+    pub scripts: String,
+    /// `{{word_count}}`: number of words on the page, see [`crate::word_count`]
+    pub word_count: usize,
+    /// `{{reading_time}}`: estimated reading time of the page in whole minutes,
+    /// e.g. `5` for a `{{reading_time}} min read` badge
+    pub reading_time: u64,
+    /// `{{front_matter.key}}`: scalar front matter values of the page
+    pub front_matter: BTreeMap<String, String>,
+}
+
+impl PageData {
+    const FIELDS: &[&str] = &[
+        "title",
+        "site_title",
+        "root",
+        "index",
+        "stylesheet",
+        "body",
+        "navigation",
+        "previous.title",
+        "previous.url",
+        "next.title",
+        "next.url",
+        "toc",
+        "scripts",
+        "word_count",
+        "reading_time",
+    ];
+
+    fn field(&self, name: &str) -> String {
+        match name {
+            "title" => escape_html(&self.title),
+            "site_title" => escape_html(&self.site_title),
+            "root" => escape_html(&self.root),
+            "index" => escape_html(&self.index),
+            "stylesheet" => self.stylesheet.clone(),
+            "body" => self.body.clone(),
+            "navigation" => self.navigation.clone(),
+            "previous.title" => PageLink::title_of(&self.previous),
+            "previous.url" => PageLink::url_of(&self.previous),
+            "next.title" => PageLink::title_of(&self.next),
+            "next.url" => PageLink::url_of(&self.next),
+            "toc" => self.toc.clone(),
+            "scripts" => self.scripts.clone(),
+            "word_count" => self.word_count.to_string(),
+            "reading_time" => self.reading_time.to_string(),
+            _ => name
+                .strip_prefix(FRONT_MATTER_PREFIX)
+                .and_then(|key| self.front_matter.get(key))
+                .map(|value| escape_html(value))
+                .unwrap_or_default(),
+        }
+    }
+}
+
+/// A link to another page of the site, e.g. the next one in reading order.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct PageLink {
+    /// Title of the page
+    pub title: String,
+    /// URL of the page, relative to the linking page
+    pub url: String,
+}
+
+impl PageLink {
+    /// Returns the escaped title of `link`, empty for None.
+    fn title_of(link: &Option<PageLink>) -> String {
+        link.as_ref()
+            .map(|link| escape_html(&link.title))
+            .unwrap_or_default()
+    }
+
+    /// Returns the escaped URL of `link`, empty for None.
+    fn url_of(link: &Option<PageLink>) -> String {
+        link.as_ref()
+            .map(|link| escape_html(&link.url))
+            .unwrap_or_default()
+    }
+}
+
+/// Data of the table of contents template, `toc.html`.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct TocData {
+    /// `{{site_title}}`: title of the site
+    pub site_title: String,
+    /// `{{entries}}` (HTML): the headings of all pages as nested lists
+    pub entries: String,
+}
+
+impl TocData {
+    const FIELDS: &[&str] = &["site_title", "entries"];
+
+    fn field(&self, name: &str) -> String {
+        match name {
+            "site_title" => escape_html(&self.site_title),
+            "entries" => self.entries.clone(),
+            _ => String::new(),
+        }
+    }
+}
+
+/// Data of the code block template, `code_block.html`.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct CodeBlockData {
+    /// `{{language}}`: language of the code block, empty if it declares none
+    pub language: String,
+    /// `{{title}}`: title from the `title` attribute of the code block, if any
+    pub title: String,
+    /// `{{code}}` (HTML): the rendered code block
+    pub code: String,
+}
+
+impl CodeBlockData {
+    const FIELDS: &[&str] = &["language", "title", "code"];
+
+    fn field(&self, name: &str) -> String {
+        match name {
+            "language" => escape_html(&self.language),
+            "title" => escape_html(&self.title),
+            "code" => self.code.clone(),
+            _ => String::new(),
+        }
+    }
+}
+
+/// A part of a parsed template.
+#[derive(Debug, Clone, PartialEq, Eq)]
+enum Part {
+    Text(String),
+    Field(String),
+}
+
+/// A template with `{{name}}` fields, checked against the fields of its data.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct Template {
+    source: String,
+    parts: Vec<Part>,
+}
+
+impl Template {
+    /// Parse a template, failing on unknown fields and unclosed `{{`.
+    ///
+    /// With `front_matter`, `{{front_matter.key}}` fields are allowed as well.
+    fn parse(
+        name: &str,
+        source: &str,
+        fields: &[&str],
+        front_matter: bool,
+    ) -> HyperlitResult<Self> {
+        let mut parts = Vec::new();
+        let mut rest = source;
+        while let Some(start) = rest.find("{{") {
+            let line = source[..source.len() - rest.len() + start]
+                .matches('\n')
+                .count()
+                + 1;
+            let Some(length) = rest[start..].find("}}") else {
+                bail!("{}:{}: unclosed '{{{{'", name, line);
+            };
+            let field = rest[start + 2..start + length].trim();
+            let known = fields.contains(&field)
+                || (front_matter
+                    && field
+                        .strip_prefix(FRONT_MATTER_PREFIX)
+                        .is_some_and(|key| !key.is_empty()));
+            if !known {
+                bail!(
+                    "{}:{}: unknown field '{}', expected one of {}",
+                    name,
+                    line,
+                    field,
+                    fields.join(", ")
+                );
+            }
+            parts.push(Part::Text(rest[..start].to_string()));
+            parts.push(Part::Field(field.to_string()));
+            rest = &rest[start + length + 2..];
+        }
+        parts.push(Part::Text(rest.to_string()));
+        Ok(Self {
+            source: source.to_string(),
+            parts,
+        })
+    }
+
+    /// Returns true if the template inserts `field`.
+    fn uses(&self, field: &str) -> bool {
+        self.parts.contains(&Part::Field(field.to_string()))
+    }
+
+    fn render(&self, field: impl Fn(&str) -> String) -> String {
+        let mut rendered = String::new();
+        for part in &self.parts {
+            match part {
+                Part::Text(text) => rendered.push_str(text),
+                Part::Field(name) => rendered.push_str(&field(name)),
+            }
+        }
+        rendered
+    }
+}
+
+/// Templates of the HTML pages, see [`crate::theme`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Theme {
+    page: Template,
+    toc: Template,
+    /// None to render code blocks as they are
+    code_block: Option<Template>,
+}
+
+impl Default for Theme {
+    fn default() -> Self {
+        BUILT_IN_THEME.clone()
+    }
+}
+
+impl Theme {
+    /// Load the templates in `directory`, falling back to the built-in ones.
+    ///
+    /// Fails if a template cannot be read or is malformed.
+    pub fn load(pal: &PalHandle, directory: &FilePath) -> HyperlitResult<Self> {
+        let mut theme = Self::default();
+        if let Some(source) = read_template(pal, directory, PAGE_TEMPLATE)? {
+            theme = theme.with_page_template(&source)?;
+        }
+        if let Some(source) = read_template(pal, directory, TOC_TEMPLATE)? {
+            theme = theme.with_toc_template(&source)?;
+        }
+        if let Some(source) = read_template(pal, directory, CODE_BLOCK_TEMPLATE)? {
+            theme = theme.with_code_block_template(&source)?;
+        }
+        Ok(theme)
+    }
+
+    /// Override the page template, see [`PageData`] for its fields.
+    pub fn with_page_template(mut self, source: &str) -> HyperlitResult<Self> {
+        self.page = Template::parse(PAGE_TEMPLATE, source, PageData::FIELDS, true)?;
+        Ok(self)
+    }
+
+    /// Override the table of contents template, see [`TocData`] for its fields.
+    pub fn with_toc_template(mut self, source: &str) -> HyperlitResult<Self> {
+        self.toc = Template::parse(TOC_TEMPLATE, source, TocData::FIELDS, false)?;
+        Ok(self)
+    }
+
+    /// Override the code block template, see [`CodeBlockData`] for its fields.
+    pub fn with_code_block_template(mut self, source: &str) -> HyperlitResult<Self> {
+        self.code_block = Some(Template::parse(
+            CODE_BLOCK_TEMPLATE,
+            source,
+            CodeBlockData::FIELDS,
+            false,
+        )?);
+        Ok(self)
+    }
+
+    /// Render a page with the page template.
+    pub fn render_page(&self, data: &PageData) -> String {
+        self.page.render(|name| data.field(name))
+    }
+
+    /// Render the table of contents with the table of contents template.
+    pub fn render_toc(&self, data: &TocData) -> String {
+        self.toc.render(|name| data.field(name))
+    }
+
+    /// Render a code block with the code block template.
+    ///
+    /// Returns the code as it is if the template is not overridden.
+    pub fn render_code_block(&self, data: &CodeBlockData) -> String {
+        match &self.code_block {
+            Some(template) => template.render(|name| data.field(name)),
+            None => data.code.clone(),
+        }
+    }
+
+    /// Returns true if the page template shows the table of contents.
+    pub(crate) fn page_uses_toc(&self) -> bool {
+        self.page.uses("toc")
+    }
+
+    /// Returns true if code blocks are rendered with a custom template.
+    pub(crate) fn overrides_code_block(&self) -> bool {
+        self.code_block.is_some()
+    }
+
+    /// Identifies the templates for the build cache.
+    pub(crate) fn cache_key(&self) -> String {
+        if *self == Self::default() {
+            return "built-in".to_string();
+        }
+        let mut hasher = ContentHasher::new();
+        hasher.write_str(&self.page.source);
+        hasher.write_str(&self.toc.source);
+        hasher.write_str(
+            self.code_block
+                .as_ref()
+                .map_or("", |template| &template.source),
+        );
+        format!("{:016x}", hasher.finish())
+    }
+}
+
+/// Read a template of a theme directory, None if the theme does not override it.
+fn read_template(
+    pal: &PalHandle,
+    directory: &FilePath,
+    name: &str,
+) -> HyperlitResult<Option<String>> {
+    let path = FilePath::from(directory.as_relative().join(name));
+    if !pal.file_exists(&path)? {
+        return Ok(None);
+    }
+    pal.read_file_to_string(&path).map(Some)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use hyperlit_base::pal::MockPal;
+
+    #[test]
+    fn test_template_fields_are_escaped_unless_html() {
+        let theme = Theme::default()
+            .with_page_template(
+                "<title>{{ title }}</title>{{body}} by {{front_matter.author}}{{front_matter.missing}}",
+            )
+            .unwrap();
+        let data = PageData {
+            title: "a < b".to_string(),
+            body: "<p>Hi</p>".to_string(),
+            front_matter: BTreeMap::from([("author".to_string(), "Ada & Co".to_string())]),
+            ..Default::default()
+        };
+        assert_eq!(
+            theme.render_page(&data),
+            "<title>a &lt; b</title><p>Hi</p> by Ada &amp; Co"
+        );
+        assert!(!theme.page_uses_toc());
+    }
+
+    #[test]
+    fn test_template_page_links() {
+        let theme = Theme::default()
+            .with_page_template(
+                "<a href=\"{{previous.url}}\">{{previous.title}}</a><a href=\"{{next.url}}\">{{next.title}}</a>",
+            )
+            .unwrap();
+        let data = PageData {
+            next: Some(PageLink {
+                title: "Setup & usage".to_string(),
+                url: "../docs/setup.md.html".to_string(),
+            }),
+            ..Default::default()
+        };
+        assert_eq!(
+            theme.render_page(&data),
+            "<a href=\"\"></a><a href=\"../docs/setup.md.html\">Setup &amp; usage</a>"
+        );
+    }
+
+    #[test]
+    fn test_template_errors() {
+        let error = |source: &str| {
+            Theme::default()
+                .with_page_template(source)
+                .unwrap_err()
+                .to_string()
+        };
+        assert_eq!(
+            error("<p>\n{{titel}}</p>"),
+            "page.html:2: unknown field 'titel', expected one of title, site_title, root, index, stylesheet, body, navigation, previous.title, previous.url, next.title, next.url, toc, scripts, word_count, reading_time"
+        );
+        assert_eq!(error("{{body}}\n\n{{title"), "page.html:3: unclosed '{{'");
+        assert!(error("{{front_matter.}}").contains("unknown field 'front_matter.'"));
+        // Front matter is only available to pages
+        let error = Theme::default()
+            .with_code_block_template("{{front_matter.author}}")
+            .unwrap_err();
+        assert!(
+            error
+                .to_string()
+                .starts_with("code_block.html:1: unknown field")
+        );
+    }
+
+    #[test]
+    fn test_load_theme() {
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(
+            FilePath::from("theme/code_block.html"),
+            b"<figure data-lang=\"{{language}}\">{{code}}</figure>".to_vec(),
+        );
+        let pal = PalHandle::new(mock_pal);
+        let theme = Theme::load(&pal, &FilePath::from("theme")).unwrap();
+        // Templates not in the directory are the built-in ones
+        assert_eq!(theme.page, Theme::default().page);
+        assert_eq!(
+            theme.render_code_block(&CodeBlockData {
+                language: "rust".to_string(),
+                title: String::new(),
+                code: "<pre>x</pre>".to_string(),
+            }),
+            "<figure data-lang=\"rust\"><pre>x</pre></figure>"
+        );
+        assert_ne!(theme.cache_key(), Theme::default().cache_key());
+
+        let mock_pal = MockPal::new();
+        mock_pal.add_file(FilePath::from("theme/toc.html"), b"{{entries}".to_vec());
+        let pal = PalHandle::new(mock_pal);
+        let error = Theme::load(&pal, &FilePath::from("theme")).unwrap_err();
+        assert_eq!(error.to_string(), "toc.html:1: unclosed '{{'");
+    }
+}
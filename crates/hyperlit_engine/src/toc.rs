@@ -0,0 +1,661 @@
+/* 📖 # Why build a cross-file table of contents?
+
+Each woven page covers a single source file, so without a table of contents the
+pages stand alone and there is no way to navigate between them. The TOC collects
+the headings of all documents and nests them by heading level, giving readers a
+single overview that links to every section of every file.
+
+Heading anchors must be stable: links to a section are shared and bookmarked, so
+they must not change between two runs over the same sources, and readers of a
+long page should not lose their place when it is rebuilt after a small edit.
+Anchors are therefore derived from the heading text only, never from the
+position of a heading or the number of headings before it. Editing paragraphs,
+adding headings with other text or changing other files leaves them as they are.
+
+Anchors only need to be unique on their page, so headings like "Overview" in
+different files all get `overview`. With `OutputMode::SingleFile` the whole site
+is one page, so anchors are unique across the site. Headings with the same text
+on a page are told apart using the text of their surroundings, in this order:
+
+1. the heading text alone (`overview`)
+2. prefixed with the parent heading (`install-overview`)
+3. on a single page, prefixed with the source path (`src-lib-rs-overview`)
+4. with a sequential suffix as a last resort (`overview-1`)
+
+Documents are visited in a deterministic order (by file path, then by line), so
+the heading that keeps the plain anchor is the same on every run.
+
+Sites migrated from another tool often have existing links into their pages, so
+the way heading text becomes an anchor can be replaced with a custom
+`Slugifier`. The default lowercases the text, turns every run of characters
+other than letters and digits into a single hyphen (so `foo::bar` becomes
+`foo-bar` and `v1.2` becomes `v1-2`) and trims hyphens from both ends. It only
+depends on the heading text (lowercasing uses Unicode's case tables, not the
+system locale), so the same heading gets the same anchor on every run and every
+OS.
+
+When file docs are nested as chapters of a larger book, their top-level
+headings would collide with the chapter headings. A heading offset shifts every
+heading down by that many levels (`#` becomes `##` with offset 1), both in the
+rendered pages and in the TOC, so navigation matches the pages. Levels deeper
+than 6 do not exist in HTML or markdown and are clamped to 6, which the
+renderers report as a warning since it flattens the nesting.
+*/
+
+use std::collections::{HashMap, HashSet};
+use std::fmt::Debug;
+use std::ops::Range;
+
+use pulldown_cmark::{Event, HeadingLevel, Parser, Tag, TagEnd};
+
+use hyperlit_base::FilePath;
+
+use crate::export::heading_level;
+use crate::reading_order::PageSequence;
+use crate::{Document, DocumentId};
+
+/// Table of contents covering the headings of a set of documents.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Toc {
+    /// Top-level entries in document order
+    pub entries: Vec<TocEntry>,
+    /// Parts of the reading order grouping the entries, see [`crate::reading_order`]
+    pub parts: Vec<TocPart>,
+    /// Heading anchors per document, in heading order
+    anchors: HashMap<DocumentId, Vec<String>>,
+}
+
+/// A heading in the table of contents.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TocEntry {
+    /// Plain text of the heading
+    pub title: String,
+    /// Heading level (1-6)
+    pub level: u8,
+    /// Document containing the heading
+    pub document_id: DocumentId,
+    /// Source file of the document containing the heading
+    pub file_path: FilePath,
+    /// Anchor of the heading, unique on its page
+    pub anchor: String,
+    /// Headings nested below this heading
+    pub children: Vec<TocEntry>,
+}
+
+/// A titled part of the reading order in the table of contents.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TocPart {
+    /// Title of the part
+    pub title: String,
+    /// Positions of the top-level entries of the part in [`Toc::entries`]
+    pub entries: Range<usize>,
+}
+
+impl Toc {
+    /// Returns the anchors of all headings in a document, in heading order.
+    ///
+    /// Returns an empty slice for documents not covered by this TOC.
+    pub fn anchors(&self, document_id: &DocumentId) -> &[String] {
+        self.anchors
+            .get(document_id)
+            .map(Vec::as_slice)
+            .unwrap_or_default()
+    }
+
+    /// Returns true if the TOC contains no headings.
+    pub fn is_empty(&self) -> bool {
+        self.entries.is_empty()
+    }
+
+    /// Order the entries by the reading order of their pages and group them into its parts.
+    ///
+    /// Entries of unlisted pages are removed, their anchors are kept.
+    pub(crate) fn arrange(&mut self, pages: &PageSequence) {
+        self.entries
+            .retain(|entry| pages.position(&entry.file_path).is_some());
+        pages.sort_by_page(&mut self.entries, |entry| &entry.file_path);
+        let entries = &self.entries;
+        let start_of = |position: usize| {
+            entries.partition_point(|entry| {
+                pages.position(&entry.file_path).unwrap_or_default() < position
+            })
+        };
+        self.parts = pages
+            .parts
+            .iter()
+            .map(|part| TocPart {
+                title: part.title.clone(),
+                entries: start_of(part.pages.start)..start_of(part.pages.end),
+            })
+            .collect();
+    }
+}
+
+/// Turns the text of a heading into the base of its anchor.
+///
+/// Anchors are made unique by the TOC, so a slugifier may return the same slug
+/// for different headings. It must be deterministic, since anchors end up in
+/// shared links. An empty slug is replaced with `section`.
+pub trait Slugifier: Debug + Send + Sync {
+    /// Returns the slug for the plain text of a heading.
+    fn slugify(&self, text: &str) -> String;
+
+    /// Identifies the output of this slugifier for the build cache.
+    ///
+    /// Cached pages are rendered again when the key changes.
+    fn cache_key(&self) -> String {
+        format!("{self:?}")
+    }
+}
+
+/// The default slugifier, e.g. `Why use Arc?` becomes `why-use-arc`.
+///
+/// Lowercases the text, replaces every run of non-alphanumeric characters with a
+/// single hyphen and trims hyphens from both ends.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct DefaultSlugifier;
+
+impl DefaultSlugifier {
+    /// Create the default slugifier.
+    pub fn new() -> Self {
+        Self
+    }
+}
+
+impl Slugifier for DefaultSlugifier {
+    fn slugify(&self, text: &str) -> String {
+        let mut slug = String::with_capacity(text.len());
+        for c in text.to_lowercase().chars() {
+            if c.is_alphanumeric() {
+                slug.push(c);
+            } else if !slug.is_empty() && !slug.ends_with('-') {
+                slug.push('-');
+            }
+        }
+        slug.truncate(slug.trim_end_matches('-').len());
+        slug
+    }
+}
+
+/// A slugifier backed by a function.
+///
+/// # Examples
+/// ```
+/// use hyperlit_engine::{FnSlugifier, Slugifier};
+///
+/// let slugifier = FnSlugifier::new("underscore", |text| text.to_lowercase().replace(' ', "_"));
+/// assert_eq!(slugifier.slugify("Getting Started"), "getting_started");
+/// ```
+pub struct FnSlugifier<F> {
+    name: String,
+    function: F,
+}
+
+impl<F: Fn(&str) -> String + Send + Sync> FnSlugifier<F> {
+    /// Create a slugifier calling `function`.
+    ///
+    /// The `name` identifies the function in the build cache, so it should be
+    /// changed whenever the function produces different slugs.
+    pub fn new(name: impl Into<String>, function: F) -> Self {
+        Self {
+            name: name.into(),
+            function,
+        }
+    }
+}
+
+impl<F> Debug for FnSlugifier<F> {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("FnSlugifier")
+            .field("name", &self.name)
+            .finish_non_exhaustive()
+    }
+}
+
+impl<F: Fn(&str) -> String + Send + Sync> Slugifier for FnSlugifier<F> {
+    fn slugify(&self, text: &str) -> String {
+        (self.function)(text)
+    }
+}
+
+/// Build a table of contents from the headings of all given documents.
+///
+/// Documents are ordered by file path and line number, so the result does not
+/// depend on the order of `documents`.  Within each document, headings are
+/// nested by level. A heading that is deeper than its predecessor becomes its
+/// child, even if levels are skipped. Heading anchors are unique per source
+/// file (the page of the headings).
+///
+/// # Examples
+/// ```
+/// use std::collections::HashSet;
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::{Document, DocumentSource, SourceType, build_toc};
+///
+/// let source = DocumentSource::new(SourceType::MarkdownFile, FilePath::from("guide.md"), 1);
+/// let doc = Document::new(
+///     "Guide".to_string(),
+///     "# Guide\n\n## Overview\n".to_string(),
+///     source,
+///     None,
+///     &HashSet::new(),
+/// );
+///
+/// let toc = build_toc(&[doc]);
+/// assert_eq!(toc.entries[0].anchor, "guide");
+/// assert_eq!(toc.entries[0].children[0].anchor, "overview");
+/// ```
+pub fn build_toc(documents: &[Document]) -> Toc {
+    build_toc_with_slugifier(documents, &DefaultSlugifier)
+}
+
+/// Build a table of contents, deriving heading anchors with `slugifier`.
+///
+/// Behaves like [`build_toc`] otherwise.
+pub fn build_toc_with_slugifier(documents: &[Document], slugifier: &dyn Slugifier) -> Toc {
+    build_toc_with_heading_offset(documents, slugifier, 0, false)
+}
+
+/// Build a table of contents with all heading levels shifted down by `heading_offset`.
+///
+/// See [`offset_heading_level`], anchors do not depend on the offset. With
+/// `single_page` anchors are unique across all documents instead of per file.
+pub(crate) fn build_toc_with_heading_offset(
+    documents: &[Document],
+    slugifier: &dyn Slugifier,
+    heading_offset: usize,
+    single_page: bool,
+) -> Toc {
+    let mut used_anchors = HashSet::new();
+    let mut page: Option<&FilePath> = None;
+    let mut toc = Toc::default();
+    for document in sorted_by_source(documents) {
+        let file_path = document.source().file_path();
+        if !single_page && page != Some(file_path) {
+            used_anchors.clear();
+            page = Some(file_path);
+        }
+        let mut stack: Vec<TocEntry> = Vec::new();
+        let mut document_anchors = Vec::new();
+        for (level, title) in collect_headings(document.content()) {
+            let (level, _) = offset_heading_level(level, heading_offset);
+            let level = heading_level(level);
+            while stack.last().is_some_and(|top| top.level >= level) {
+                pop_entry(&mut stack, &mut toc.entries);
+            }
+            let mut prefixes = Vec::new();
+            if let Some(parent) = stack.last() {
+                prefixes.push(slugifier.slugify(&parent.title));
+            }
+            if single_page {
+                prefixes.push(DefaultSlugifier.slugify(&file_path.to_string()));
+            }
+            let anchor = unique_anchor(slugifier.slugify(&title), &prefixes, &mut used_anchors);
+            document_anchors.push(anchor.clone());
+            stack.push(TocEntry {
+                title,
+                level,
+                document_id: document.id().clone(),
+                file_path: file_path.clone(),
+                anchor,
+                children: Vec::new(),
+            });
+        }
+        while !stack.is_empty() {
+            pop_entry(&mut stack, &mut toc.entries);
+        }
+        toc.anchors.insert(document.id().clone(), document_anchors);
+    }
+    toc
+}
+
+/// Sort documents by file path and line number (and ID for equal locations).
+pub(crate) fn sorted_by_source(documents: &[Document]) -> Vec<&Document> {
+    let mut sorted: Vec<&Document> = documents.iter().collect();
+    sorted.sort_by(|a, b| {
+        let key_a = (
+            a.source().file_path().as_relative().as_str(),
+            a.source().line_number(),
+        );
+        let key_b = (
+            b.source().file_path().as_relative().as_str(),
+            b.source().line_number(),
+        );
+        key_a
+            .cmp(&key_b)
+            .then_with(|| a.id().as_str().cmp(b.id().as_str()))
+    });
+    sorted
+}
+
+/// Pop the innermost open entry and attach it to its parent or the top level.
+fn pop_entry(stack: &mut Vec<TocEntry>, entries: &mut Vec<TocEntry>) {
+    if let Some(entry) = stack.pop() {
+        match stack.last_mut() {
+            Some(parent) => parent.children.push(entry),
+            None => entries.push(entry),
+        }
+    }
+}
+
+/// Shift a heading level down by `offset` levels, clamping at level 6.
+///
+/// Returns the shifted level and whether it had to be clamped.
+pub(crate) fn offset_heading_level(level: HeadingLevel, offset: usize) -> (HeadingLevel, bool) {
+    let shifted = heading_level(level) as usize + offset;
+    match HeadingLevel::try_from(shifted) {
+        Ok(level) => (level, false),
+        Err(_) => (HeadingLevel::H6, true),
+    }
+}
+
+/// Collect the level and plain text of all headings in markdown content.
+fn collect_headings(content: &str) -> Vec<(HeadingLevel, String)> {
+    let mut headings = Vec::new();
+    let mut current: Option<(HeadingLevel, String)> = None;
+    for event in Parser::new(content) {
+        match event {
+            Event::Start(Tag::Heading { level, .. }) => {
+                current = Some((level, String::new()));
+            }
+            Event::Text(text) | Event::Code(text) => {
+                if let Some((_, title)) = &mut current {
+                    title.push_str(&text);
+                }
+            }
+            Event::End(TagEnd::Heading(_)) => {
+                if let Some((level, title)) = current.take() {
+                    headings.push((level, title.trim().to_string()));
+                }
+            }
+            _ => {}
+        }
+    }
+    headings
+}
+
+/// Make the slug of a heading unique among `used_anchors`.
+///
+/// Tries the slug alone, then prefixed with each of `prefixes` and finally
+/// with a sequential suffix.
+fn unique_anchor(
+    mut base: String,
+    prefixes: &[String],
+    used_anchors: &mut HashSet<String>,
+) -> String {
+    if base.is_empty() {
+        base = "section".to_string();
+    }
+    let candidate = std::iter::once(base.clone())
+        .chain(
+            prefixes
+                .iter()
+                .filter(|prefix| !prefix.is_empty())
+                .map(|prefix| format!("{}-{}", prefix, base)),
+        )
+        .chain((1..).map(|counter| format!("{}-{}", base, counter)))
+        .find(|candidate| !used_anchors.contains(candidate))
+        .expect("sequential suffixes are unbounded");
+    used_anchors.insert(candidate.clone());
+    candidate
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use expect_test::expect;
+
+    fn doc(path: &str, line: usize, title: &str, content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), line);
+        Document::new(
+            title.to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    fn format_toc(toc: &Toc) -> String {
+        fn format_entries(entries: &[TocEntry], depth: usize, out: &mut String) {
+            for entry in entries {
+                out.push_str(&format!(
+                    "{}{} [{}#{}]\n",
+                    "  ".repeat(depth),
+                    entry.title,
+                    entry.file_path,
+                    entry.anchor
+                ));
+                format_entries(&entry.children, depth + 1, out);
+            }
+        }
+        let mut out = String::new();
+        format_entries(&toc.entries, 0, &mut out);
+        out
+    }
+
+    #[test]
+    fn test_build_toc_nests_by_level() {
+        let docs = vec![doc(
+            "guide.md",
+            1,
+            "Guide",
+            "# Guide\n\n## Install\n\n### From `source`\n\n## Usage\n\n#### Deep\n\n# Appendix\n",
+        )];
+        expect![[r#"
+            Guide [guide.md#guide]
+              Install [guide.md#install]
+                From source [guide.md#from-source]
+              Usage [guide.md#usage]
+                Deep [guide.md#deep]
+            Appendix [guide.md#appendix]
+        "#]]
+        .assert_eq(&format_toc(&build_toc(&docs)));
+    }
+
+    #[test]
+    fn test_build_toc_disambiguates_duplicate_headings() {
+        let docs = vec![
+            doc("b.rs", 1, "B", "# Overview\n"),
+            doc("a.rs", 10, "A2", "# Overview\n"),
+            doc("a.rs", 1, "A1", "# Overview\n\n## Overview\n"),
+        ];
+        let toc = build_toc(&docs);
+        expect![[r#"
+            Overview [a.rs#overview]
+              Overview [a.rs#overview-overview]
+            Overview [a.rs#overview-1]
+            Overview [b.rs#overview]
+        "#]]
+        .assert_eq(&format_toc(&toc));
+        assert_eq!(toc.anchors(docs[0].id()), ["overview"]);
+        assert_eq!(toc.anchors(docs[2].id()), ["overview", "overview-overview"]);
+
+        // On a single page, headings of other files are prefixed with their path
+        let toc = build_toc_with_heading_offset(&docs, &DefaultSlugifier, 0, true);
+        assert_eq!(toc.anchors(docs[0].id()), ["b-rs-overview"]);
+        assert_eq!(toc.anchors(docs[1].id()), ["a-rs-overview"]);
+    }
+
+    #[test]
+    fn test_build_toc_disambiguates_by_parent_heading() {
+        let docs = vec![doc(
+            "guide.md",
+            1,
+            "Guide",
+            "# Install
+
+## Overview
+
+# Usage
+
+## Overview
+
+## Overview
+",
+        )];
+        assert_eq!(
+            build_toc(&docs).anchors(docs[0].id()),
+            [
+                "install",
+                "overview",
+                "usage",
+                "usage-overview",
+                "overview-1"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_anchors_are_stable_against_edits_elsewhere() {
+        let content = "# Guide\n\n## Setup\n\nText.\n\n## Usage\n";
+        let anchors = |content: &str, other: &str| {
+            let docs = vec![
+                doc("a.md", 1, "A", other),
+                doc("b.md", 1, "B", content),
+                doc("b.md", 20, "B2", "## Usage\n"),
+            ];
+            let toc = build_toc(&docs);
+            [toc.anchors(docs[1].id()), toc.anchors(docs[2].id())].concat()
+        };
+        let before = anchors(content, "# Usage\n");
+        assert_eq!(before, ["guide", "setup", "usage", "usage-1"]);
+
+        // A paragraph inserted before a heading keeps its anchor
+        let edited = content.replace("## Usage", "More text.\n\n## Usage");
+        assert_eq!(anchors(&edited, "# Usage\n"), before);
+        // Neither do headings with other text or headings in other files
+        let edited = content.replace("## Setup", "## Install\n\n## Setup");
+        let mut expected = before.clone();
+        expected.insert(1, "install".to_string());
+        assert_eq!(anchors(&edited, "# Usage\n"), expected);
+        assert_eq!(anchors(content, "# Usage\n\n## Usage\n"), before);
+    }
+
+    #[test]
+    fn test_build_toc_is_independent_of_input_order() {
+        let docs = vec![
+            doc("z.rs", 1, "Z", "# Overview\n"),
+            doc("m.md", 1, "M", "# Overview\n## Details\n"),
+        ];
+        let reversed: Vec<Document> = docs.iter().rev().cloned().collect();
+        assert_eq!(build_toc(&docs), build_toc(&reversed));
+    }
+
+    #[test]
+    fn test_build_toc_document_starting_below_top_level() {
+        let docs = vec![
+            doc("a.rs", 1, "A", "# Top\n"),
+            doc("a.rs", 5, "B", "## Nested?\n"),
+        ];
+        expect![[r#"
+            Top [a.rs#top]
+            Nested? [a.rs#nested]
+        "#]]
+        .assert_eq(&format_toc(&build_toc(&docs)));
+    }
+
+    #[test]
+    fn test_build_toc_heading_without_slug_characters() {
+        let docs = vec![doc("a.rs", 1, "A", "# ???\n\n# !!!\n")];
+        expect![[r#"
+            ??? [a.rs#section]
+            !!! [a.rs#section-1]
+        "#]]
+        .assert_eq(&format_toc(&build_toc(&docs)));
+    }
+
+    #[test]
+    fn test_build_toc_with_custom_slugifier() {
+        let docs = vec![doc(
+            "guide.md",
+            1,
+            "Guide",
+            "# Getting Started\n\n## Getting Started\n\n## ???\n",
+        )];
+        let slugifier = FnSlugifier::new("legacy", |text: &str| {
+            text.replace(' ', "_").replace('?', "")
+        });
+
+        let toc = build_toc_with_slugifier(&docs, &slugifier);
+
+        assert_eq!(
+            toc.anchors(docs[0].id()),
+            [
+                "Getting_Started",
+                "Getting_Started-Getting_Started",
+                "section"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_build_toc_with_heading_offset() {
+        let docs = vec![doc(
+            "guide.md",
+            1,
+            "Guide",
+            "# Guide\n\n## Install\n\n##### Deep\n\n###### Deeper\n",
+        )];
+
+        let toc = build_toc_with_heading_offset(&docs, &DefaultSlugifier, 2, false);
+
+        let guide = &toc.entries[0];
+        assert_eq!((guide.level, guide.children[0].level), (3, 4));
+        // Clamped to level 6, "Deeper" is no longer nested below "Deep"
+        let install = &guide.children[0];
+        assert_eq!(install.children.len(), 2);
+        assert_eq!(
+            (install.children[0].level, install.children[1].level),
+            (6, 6)
+        );
+        assert_eq!(
+            toc.anchors(docs[0].id()),
+            build_toc(&docs).anchors(docs[0].id())
+        );
+    }
+
+    #[test]
+    fn test_offset_heading_level() {
+        assert_eq!(
+            offset_heading_level(HeadingLevel::H1, 0),
+            (HeadingLevel::H1, false)
+        );
+        assert_eq!(
+            offset_heading_level(HeadingLevel::H1, 1),
+            (HeadingLevel::H2, false)
+        );
+        assert_eq!(
+            offset_heading_level(HeadingLevel::H4, 2),
+            (HeadingLevel::H6, false)
+        );
+        assert_eq!(
+            offset_heading_level(HeadingLevel::H5, 2),
+            (HeadingLevel::H6, true)
+        );
+    }
+
+    #[test]
+    fn test_default_slugifier() {
+        let slugifier = DefaultSlugifier::new();
+        assert_eq!(slugifier.slugify("Why use `Arc`?"), "why-use-arc");
+        assert_eq!(slugifier.slugify("  --Hello__World--  "), "hello-world");
+        assert_eq!(slugifier.slugify("Überblick"), "überblick");
+        assert_eq!(slugifier.slugify("foo::bar"), "foo-bar");
+        assert_eq!(slugifier.slugify("Version v1.2"), "version-v1-2");
+        assert_eq!(
+            slugifier.slugify("(Re)load: config/*.toml & env!"),
+            "re-load-config-toml-env"
+        );
+        assert_eq!(slugifier.slugify("--- !? ---"), "");
+        assert_eq!(slugifier.cache_key(), "DefaultSlugifier");
+    }
+
+    #[test]
+    fn test_anchors_unknown_document() {
+        let toc = build_toc(&[]);
+        assert!(toc.is_empty());
+        assert!(toc.anchors(&DocumentId::from_string("missing")).is_empty());
+    }
+}
@@ -0,0 +1,248 @@
+/* 📖 # Why document transformers?
+
+Sites often want small additions to the rendered documentation that hyperlit
+cannot know about, such as linking issue references to a particular tracker,
+expanding project specific shorthands or injecting badges. Rather than adding an
+option for each of these, a `Transformer` can rewrite the markdown content of
+every document after extraction and before rendering:
+
+```text
+let options = RenderOptions::new()
+    .with_transformer(IssueLinker::new("https://github.com/org/repo/issues/{number}"))
+    .with_transformer(MyTransformer::new());
+```
+
+Transformers run in the order they were added, each one sees the output of the
+previous ones. They work on the markdown rather than on the rendered HTML, so
+the result is the same in every output format, and headings they add end up in
+the table of contents and site map as well. Like the rest of rendering they are
+fail-tolerant: a transformer returning an error is reported as a warning for
+the document, which keeps the content of the transformers before it.
+
+`IssueLinker` is the built-in transformer, set up with `issue_link_template` in
+the configuration. It also serves as an example for writing transformers.
+*/
+
+use std::borrow::Cow;
+use std::fmt::Debug;
+use std::sync::LazyLock;
+
+use pulldown_cmark::{Event, Parser, Tag, TagEnd};
+use regex::Regex;
+
+use hyperlit_base::HyperlitResult;
+
+use crate::footnote::PARSER_OPTIONS;
+use crate::{Document, RenderOptions, RenderWarning};
+
+/// Rewrites documents after extraction and before rendering.
+pub trait Transformer: Debug + Send + Sync {
+    /// Name of the transformer, used in warnings.
+    fn name(&self) -> &str;
+
+    /// Transform the document in place.
+    ///
+    /// On error the changes to the document are discarded.
+    fn transform(&self, document: &mut Document) -> HyperlitResult<()>;
+
+    /// Identifies the output of this transformer for the build cache.
+    ///
+    /// Cached pages are rendered again when the key changes.
+    fn cache_key(&self) -> String {
+        format!("{self:?}")
+    }
+}
+
+static ISSUE_REFERENCE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"(?:^|[^\w&#/])(#(\d+))\b").expect("valid regex"));
+
+/// Links issue references like `#123` to an issue tracker.
+///
+/// References in code, links and HTML are left alone, as are references that
+/// are part of a word or an HTML entity (e.g. `&#123;`).
+///
+/// # Examples
+/// ```
+/// use std::collections::HashSet;
+/// use hyperlit_base::FilePath;
+/// use hyperlit_engine::{Document, DocumentSource, IssueLinker, SourceType, Transformer};
+///
+/// let mut document = Document::new(
+///     "Retries".to_string(),
+///     "Fixed in #42.".to_string(),
+///     DocumentSource::new(SourceType::CodeComment, FilePath::from("src/lib.rs"), 1),
+///     None,
+///     &HashSet::new(),
+/// );
+/// IssueLinker::new("https://example.com/issues/{number}")
+///     .transform(&mut document)
+///     .unwrap();
+/// assert_eq!(document.content(), "Fixed in [#42](https://example.com/issues/42).");
+/// ```
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct IssueLinker {
+    url_template: String,
+}
+
+impl IssueLinker {
+    /// Create an issue linker, `{number}` in `url_template` is replaced with the issue number.
+    pub fn new(url_template: impl Into<String>) -> Self {
+        Self {
+            url_template: url_template.into(),
+        }
+    }
+
+    /// Returns the template of the issue URLs.
+    pub fn url_template(&self) -> &str {
+        &self.url_template
+    }
+}
+
+impl Transformer for IssueLinker {
+    fn name(&self) -> &str {
+        "issue-linker"
+    }
+
+    fn transform(&self, document: &mut Document) -> HyperlitResult<()> {
+        let content = document.content();
+        // Byte ranges of the references in the content, with their issue number
+        let mut references = Vec::new();
+        // Nesting depth of links, images and code blocks, which are not linked
+        let mut skip_depth = 0usize;
+        for (event, range) in Parser::new_ext(content, PARSER_OPTIONS).into_offset_iter() {
+            match event {
+                Event::Start(Tag::Link { .. } | Tag::Image { .. } | Tag::CodeBlock(_)) => {
+                    skip_depth += 1
+                }
+                Event::End(TagEnd::Link | TagEnd::Image | TagEnd::CodeBlock) => {
+                    skip_depth = skip_depth.saturating_sub(1)
+                }
+                // Escaped or entity text does not match the source, leave it alone
+                Event::Text(text) if skip_depth == 0 && content[range.clone()] == *text => {
+                    for captures in ISSUE_REFERENCE.captures_iter(&text) {
+                        let reference = captures.get(1).expect("group 1 always matches");
+                        let number = captures.get(2).expect("group 2 always matches");
+                        references.push((
+                            range.start + reference.start()..range.start + reference.end(),
+                            number.as_str().to_string(),
+                        ));
+                    }
+                }
+                _ => {}
+            }
+        }
+        if references.is_empty() {
+            return Ok(());
+        }
+        let mut linked = content.to_string();
+        for (range, number) in references.into_iter().rev() {
+            let url = self.url_template.replace("{number}", &number);
+            linked.replace_range(range, &format!("[#{}]({})", number, url));
+        }
+        document.set_content(linked);
+        Ok(())
+    }
+}
+
+/// Returns the documents as transformed by the transformers of `options`.
+///
+/// A failing transformer is reported as a warning and skipped for the document.
+pub(crate) fn apply_transformers<'a>(
+    documents: &'a [Document],
+    options: &RenderOptions,
+) -> (Cow<'a, [Document]>, Vec<RenderWarning>) {
+    if options.transformers().next().is_none() {
+        return (Cow::Borrowed(documents), Vec::new());
+    }
+    let mut warnings = Vec::new();
+    let mut transformed = documents.to_vec();
+    for document in &mut transformed {
+        for transformer in options.transformers() {
+            let mut candidate = document.clone();
+            match transformer.transform(&mut candidate) {
+                Ok(()) => *document = candidate,
+                Err(e) => warnings.push(RenderWarning {
+                    file_path: document.source().file_path().clone(),
+                    line: document.source().line_number(),
+                    message: format!("Transformer '{}' failed: {}", transformer.name(), e),
+                }),
+            }
+        }
+    }
+    (Cow::Owned(transformed), warnings)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType};
+    use hyperlit_base::{FilePath, bail};
+    use std::collections::HashSet;
+
+    fn doc(content: &str) -> Document {
+        Document::new(
+            "Doc".to_string(),
+            content.to_string(),
+            DocumentSource::new(SourceType::CodeComment, FilePath::from("src/lib.rs"), 3),
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    fn link_issues(content: &str) -> String {
+        let mut document = doc(content);
+        IssueLinker::new("https://example.com/issues/{number}")
+            .transform(&mut document)
+            .unwrap();
+        document.content().to_string()
+    }
+
+    #[test]
+    fn test_issue_linker() {
+        assert_eq!(
+            link_issues("# Fix #1\n\nSee #12, (#3) and #45.\n"),
+            "# Fix [#1](https://example.com/issues/1)\n\n\
+             See [#12](https://example.com/issues/12), ([#3](https://example.com/issues/3)) \
+             and [#45](https://example.com/issues/45).\n"
+        );
+    }
+
+    #[test]
+    fn test_issue_linker_skips_code_links_and_words() {
+        let content = "`#1` [#2](https://other) C#3 #4a ##5 &#6; a/#7\n\n```text\n#8\n```\n";
+        assert_eq!(link_issues(content), content);
+    }
+
+    #[derive(Debug)]
+    struct Failing;
+
+    impl Transformer for Failing {
+        fn name(&self) -> &str {
+            "failing"
+        }
+
+        fn transform(&self, document: &mut Document) -> HyperlitResult<()> {
+            document.set_content("lost");
+            bail!("no tracker");
+        }
+    }
+
+    #[test]
+    fn test_apply_transformers() {
+        let documents = [doc("See #1.\n")];
+        let (transformed, warnings) = apply_transformers(&documents, &RenderOptions::new());
+        assert!(matches!(transformed, Cow::Borrowed(_)));
+        assert!(warnings.is_empty());
+
+        let options = RenderOptions::new()
+            .with_transformer(IssueLinker::new("/issues/{number}"))
+            .with_transformer(Failing);
+        let (transformed, warnings) = apply_transformers(&documents, &options);
+        assert_eq!(transformed[0].content(), "See [#1](/issues/1).\n");
+        let warnings: Vec<String> = warnings.iter().map(ToString::to_string).collect();
+        assert_eq!(
+            warnings,
+            ["src/lib.rs:3: Transformer 'failing' failed: no tracker"]
+        );
+    }
+}
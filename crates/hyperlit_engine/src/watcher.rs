@@ -13,8 +13,9 @@ The watcher creates a callback that extracts documents and updates the store,
 then registers this callback with PAL.watch_directory() for each configured directory.
 */
 
-use std::collections::HashMap;
+use std::collections::{HashMap, HashSet};
 use std::sync::{Arc, Mutex};
+use std::thread;
 use std::time::{Duration, Instant};
 
 use tracing::{debug, info, warn};
@@ -23,7 +24,19 @@ use hyperlit_base::pal::FileChangeEvent;
 use hyperlit_base::{FilePath, HyperlitResult, PalHandle};
 
 use crate::api::sse::{SseMessage, SseRegistry};
-use crate::{Config, StoreHandle, extract_documents};
+use crate::asset::{copy_assets, dedup_assets, referenced_assets};
+use crate::render::group_by_file;
+use crate::search_index::render_search_index;
+use crate::transform::apply_transformers;
+use crate::xref::references;
+use crate::{
+    Config, Document, ExtractionOptions, OutputFormat, OutputMode, RenderOptions, RenderWarning,
+    SiteMap, StoreHandle, extract_documents_with_options, render_file_page, render_index_page,
+    write_site,
+};
+
+/// Callback notified after the static site has been updated for a changed file.
+type WeaveListener = Arc<dyn Fn(&WeaveUpdate) + Send + Sync>;
 
 /// Configuration for the file watcher.
 #[derive(Clone)]
@@ -33,10 +46,22 @@ pub struct FileWatcherConfig {
     store: StoreHandle,
     debounce_duration: Duration,
     sse_registry: Option<Arc<SseRegistry>>,
+    weave: Option<WeaveTarget>,
+    weave_listener: Option<WeaveListener>,
+}
+
+/// Static site output kept up to date by the watcher.
+#[derive(Clone)]
+struct WeaveTarget {
+    output_directory: FilePath,
+    output_format: OutputFormat,
+    render_options: RenderOptions,
 }
 
 impl FileWatcherConfig {
     /// Create a new file watcher configuration.
+    ///
+    /// A changed file is processed once it has not changed for `debounce_duration`.
     pub fn new(
         config: Config,
         pal: PalHandle,
@@ -49,6 +74,8 @@ impl FileWatcherConfig {
             store,
             debounce_duration,
             sse_registry: None,
+            weave: None,
+            weave_listener: None,
         }
     }
 
@@ -57,6 +84,44 @@ impl FileWatcherConfig {
         self.sse_registry = Some(registry);
         self
     }
+
+    /// Keep the static site in `output_directory` up to date when files change.
+    pub fn with_output_directory(
+        mut self,
+        output_directory: FilePath,
+        render_options: RenderOptions,
+    ) -> Self {
+        self.weave = Some(WeaveTarget {
+            output_directory,
+            output_format: self.config.output_format.unwrap_or_default(),
+            render_options,
+        });
+        self
+    }
+
+    /// Call `listener` after the static site has been updated for a changed file.
+    pub fn with_weave_listener(
+        mut self,
+        listener: impl Fn(&WeaveUpdate) + Send + Sync + 'static,
+    ) -> Self {
+        self.weave_listener = Some(Arc::new(listener));
+        self
+    }
+}
+
+/// Pages of the static site that were updated after a source file changed.
+///
+/// Paths are relative to the output directory.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct WeaveUpdate {
+    /// The source file that changed
+    pub source: FilePath,
+    /// Pages that were rendered and written, followed by the copied assets
+    pub written: Vec<FilePath>,
+    /// Pages that were removed because their source file no longer has documents
+    pub removed: Vec<FilePath>,
+    /// Problems found while rendering the written pages
+    pub warnings: Vec<RenderWarning>,
 }
 
 /// Handle to a running file watcher.
@@ -65,11 +130,22 @@ impl FileWatcherConfig {
 /// when files change. The actual watching is handled by the PAL.
 pub struct FileWatcher;
 
+/* 📖 # Why debounce on the trailing edge?
+
+Editors often write a file several times in quick succession (e.g. truncate, then
+write, then touch). Processing the first event and dropping the following ones
+would extract the file while it is still being written and miss the final
+content. Instead, every change only (re)schedules the file, and a worker thread
+processes it once no further change has been seen for the debounce duration.
+This way each burst of saves results in exactly one rebuild of the final state.
+*/
+
 impl FileWatcher {
     /// Start the file watcher with the given configuration.
     ///
     /// This registers watch callbacks with the PAL for each configured directory.
-    /// The PAL handles the actual file system monitoring.
+    /// The PAL handles the actual file system monitoring, changed files are
+    /// processed on a background thread after the debounce duration.
     pub fn start(config: FileWatcherConfig) -> HyperlitResult<Self> {
         // Create shared debouncer wrapped in Arc<Mutex<>> for thread-safe access
         let debouncer = Arc::new(Mutex::new(Debouncer::new(config.debounce_duration)));
@@ -78,33 +154,14 @@ impl FileWatcher {
         for dir_config in &config.config.directory {
             for path in &dir_config.paths {
                 let file_path = FilePath::from(path.as_str());
-                let pal_clone = config.pal.clone();
-                let store_clone = config.store.clone();
                 let debouncer_clone = debouncer.clone();
-                let sse_registry_clone = config.sse_registry.clone();
 
                 // Create callback for this directory
                 let callback = Box::new(move |event: FileChangeEvent| {
+                    let mut debouncer = debouncer_clone.lock().unwrap();
                     for changed_file in event.changed_files {
-                        // Apply debouncing
-                        {
-                            let mut debouncer = debouncer_clone.lock().unwrap();
-                            if !debouncer.should_process(&changed_file) {
-                                continue;
-                            }
-                        }
-
                         debug!(file = %changed_file, "File changed");
-                        handle_file_change(&changed_file, &pal_clone, &store_clone);
-
-                        // Broadcast SSE notification to clients
-                        if let Some(ref registry) = sse_registry_clone {
-                            let timestamp = std::time::SystemTime::now()
-                                .duration_since(std::time::UNIX_EPOCH)
-                                .unwrap()
-                                .as_secs();
-                            registry.broadcast(SseMessage::FileChanged { timestamp });
-                        }
+                        debouncer.record(changed_file, Instant::now());
                     }
                 });
 
@@ -115,43 +172,317 @@ impl FileWatcher {
             }
         }
 
+        let mut watched_dependencies = HashSet::new();
+        watch_dependencies(&config, &debouncer, &mut watched_dependencies);
+
+        let poll_interval = (config.debounce_duration / 4).max(Duration::from_millis(10));
+        let options = ExtractionOptions::from_config(&config.config);
+        thread::spawn(move || {
+            loop {
+                thread::sleep(poll_interval);
+                let ready = debouncer.lock().unwrap().take_ready(Instant::now());
+                if ready.is_empty() {
+                    continue;
+                }
+                for changed_file in &ready {
+                    process_file_change(changed_file, &config, &options);
+                }
+                // Changed files may include files that are not watched yet
+                watch_dependencies(&config, &debouncer, &mut watched_dependencies);
+
+                // Broadcast SSE notification to clients
+                if let Some(ref registry) = config.sse_registry {
+                    let timestamp = std::time::SystemTime::now()
+                        .duration_since(std::time::UNIX_EPOCH)
+                        .unwrap()
+                        .as_secs();
+                    registry.broadcast(SseMessage::FileChanged { timestamp });
+                }
+            }
+        });
+
         Ok(Self)
     }
 }
 
+/// Watch the files included by documents in the store, and the assets they
+/// reference, that are not watched yet.
+///
+/// These files usually do not match the globs of the configured directories,
+/// so each one gets its own watch. A change of such a file is recorded as a
+/// change of every source file depending on it, which re-extracts these files
+/// with the new content (and copies changed assets again, see [`crate::asset`]).
+fn watch_dependencies(
+    config: &FileWatcherConfig,
+    debouncer: &Arc<Mutex<Debouncer>>,
+    watched: &mut HashSet<FilePath>,
+) {
+    for document in list_documents(&config.store) {
+        for dependency in dependencies(&document) {
+            if !watched.insert(dependency.clone()) {
+                continue;
+            }
+            let directory = dependency
+                .as_relative()
+                .parent()
+                .map(FilePath::from)
+                .unwrap_or_else(|| FilePath::from(""));
+            let store = config.store.clone();
+            let debouncer = debouncer.clone();
+            let callback = Box::new(move |event: FileChangeEvent| {
+                for changed_file in event.changed_files {
+                    let sources = dependent_files(&changed_file, &store);
+                    let mut debouncer = debouncer.lock().unwrap();
+                    for source in sources {
+                        debug!(file = %changed_file, source = %source, "Dependency changed");
+                        debouncer.record(source, Instant::now());
+                    }
+                }
+            });
+            let globs = [dependency.to_string()];
+            if let Err(e) = config.pal.watch_directory(&directory, &globs, callback) {
+                warn!(file = %dependency, error = %e, "Failed to watch dependency");
+            }
+        }
+    }
+}
+
+/// Returns the files a document depends on besides its source file: included
+/// files and referenced assets.
+fn dependencies(document: &Document) -> Vec<FilePath> {
+    let mut dependencies: Vec<FilePath> = document
+        .includes()
+        .iter()
+        .map(|include| include.file_path.clone())
+        .collect();
+    dependencies.extend(referenced_assets(document));
+    dependencies
+}
+
+/// Returns the source files with documents depending on `file_path`, sorted by path.
+fn dependent_files(file_path: &FilePath, store: &StoreHandle) -> Vec<FilePath> {
+    let mut sources: Vec<FilePath> = list_documents(store)
+        .iter()
+        .filter(|document| dependencies(document).contains(file_path))
+        .map(|document| document.source().file_path().clone())
+        .collect();
+    sources.sort_by(|a, b| a.as_relative().cmp(b.as_relative()));
+    sources.dedup();
+    sources
+}
+
 /// Debouncer to handle rapid file change events.
 ///
 /// Editors often save files multiple times in quick succession. The debouncer
-/// filters out duplicate events within a time window.
+/// collects changed files and releases each one after it has been quiet for the
+/// debounce duration.
 struct Debouncer {
-    last_events: HashMap<FilePath, Instant>,
+    pending: HashMap<FilePath, Instant>,
     debounce_duration: Duration,
 }
 
 impl Debouncer {
     fn new(debounce_duration: Duration) -> Self {
         Self {
-            last_events: HashMap::new(),
+            pending: HashMap::new(),
             debounce_duration,
         }
     }
 
-    /// Check if an event should be processed, updating the last event time.
+    /// Record a change of a file at time `now`, postponing its processing.
+    fn record(&mut self, file_path: FilePath, now: Instant) {
+        if self.pending.insert(file_path.clone(), now).is_some() {
+            debug!(file = %file_path, "Debouncing file change event");
+        }
+    }
+
+    /// Remove and return all files that have not changed for the debounce duration.
     ///
-    /// Returns true if enough time has passed since the last event for this file.
-    fn should_process(&mut self, file_path: &FilePath) -> bool {
-        let now = Instant::now();
+    /// Files are returned sorted by path.
+    fn take_ready(&mut self, now: Instant) -> Vec<FilePath> {
+        let mut ready: Vec<FilePath> = self
+            .pending
+            .iter()
+            .filter(|(_, last_change)| now.duration_since(**last_change) >= self.debounce_duration)
+            .map(|(file_path, _)| file_path.clone())
+            .collect();
+        for file_path in &ready {
+            self.pending.remove(file_path);
+        }
+        ready.sort_by(|a, b| a.as_relative().cmp(b.as_relative()));
+        ready
+    }
+}
 
-        if let Some(last_time) = self.last_events.get(file_path)
-            && now.duration_since(*last_time) < self.debounce_duration
+/// Update the store and, if configured, the static site for a changed file.
+fn process_file_change(
+    file_path: &FilePath,
+    config: &FileWatcherConfig,
+    options: &ExtractionOptions,
+) {
+    let Some(weave) = &config.weave else {
+        handle_file_change(file_path, &config.pal, &config.store, options);
+        return;
+    };
+    let previous_documents = list_documents(&config.store);
+    let (previous_documents, _) = apply_transformers(&previous_documents, &weave.render_options);
+    let previous_site_map = SiteMap::build_with_options(&previous_documents, &weave.render_options);
+    handle_file_change(file_path, &config.pal, &config.store, options);
+
+    match weave_file_change(
+        file_path,
+        &previous_site_map,
+        &config.pal,
+        &config.store,
+        weave,
+    ) {
+        Ok(update) => {
+            info!(file = %file_path, written = update.written.len(), removed = update.removed.len(), "Rebuilt static site");
+            if let Some(listener) = &config.weave_listener {
+                listener(&update);
+            }
+        }
+        Err(e) => {
+            warn!(file = %file_path, error = %e, "Failed to rebuild static site");
+        }
+    }
+}
+
+/* 📖 # Why may a change in one file re-render other pages?
+
+Heading anchors only depend on the headings of their own page, so a change in
+one file does not move the anchors of another. Anchors are looked up by document
+ID though, and IDs are unique across the whole site, so a new document can shift
+the ID of a document in another file. To keep links intact regardless, the
+watcher compares the anchors of every document before and after the change and
+also re-renders every page whose anchors moved. The same applies to `[[name]]`
+cross-references: pages referencing a name whose target moved are re-rendered
+as well. In the common case (editing prose) only the changed file's page and
+the index are written.
+*/
+
+/// Re-render the pages affected by a change of `file_path` after the store was updated.
+fn weave_file_change(
+    file_path: &FilePath,
+    previous_site_map: &SiteMap,
+    pal: &PalHandle,
+    store: &StoreHandle,
+    weave: &WeaveTarget,
+) -> HyperlitResult<WeaveUpdate> {
+    let documents = list_documents(store);
+    if weave.output_format != OutputFormat::Html
+        || weave.render_options.output_mode() != OutputMode::MultiFile
+        || weave.render_options.maps_paths()
+    {
+        // Only HTML pages are re-rendered selectively, everything else is
+        // cheap enough to render from scratch (a flat site's manifest lists
+        // all pages, and mapped pages may collide with pages of other files)
+        let result = weave
+            .output_format
+            .renderer()
+            .render_site(&documents, &weave.render_options);
+        write_site(pal, &weave.output_directory, &result.files)?;
+        copy_assets(pal, &weave.output_directory, &result.assets)?;
+        let assets = result.assets.into_iter().map(|asset| asset.path);
+        return Ok(WeaveUpdate {
+            source: file_path.clone(),
+            written: result
+                .files
+                .into_iter()
+                .map(|file| file.path)
+                .chain(assets)
+                .collect(),
+            removed: Vec::new(),
+            warnings: result.warnings,
+        });
+    }
+    let (documents, transform_warnings) = apply_transformers(&documents, &weave.render_options);
+    let site_map = SiteMap::build_with_options(&documents, &weave.render_options);
+    let changed_names = site_map.symbols.changed_names(&previous_site_map.symbols);
+
+    let mut affected = vec![file_path.clone()];
+    for document in documents.iter() {
+        let source_path = document.source().file_path();
+        let anchors_moved =
+            site_map.toc.anchors(document.id()) != previous_site_map.toc.anchors(document.id());
+        let references_moved = changed_names
+            .iter()
+            .any(|name| references(document.content(), name));
+        // The previous and next links of a page name their pages
+        let (pages, previous_pages) = (&site_map.pages, &previous_site_map.pages);
+        let neighbours_moved = pages.previous(source_path) != previous_pages.previous(source_path)
+            || pages.next(source_path) != previous_pages.next(source_path);
+        if (anchors_moved || references_moved || neighbours_moved)
+            && !affected.contains(source_path)
         {
-            debug!(file = %file_path, "Debouncing file change event");
-            return false;
+            affected.push(source_path.clone());
         }
+    }
 
-        self.last_events.insert(file_path.clone(), now);
-        true
+    let mut update = WeaveUpdate {
+        source: file_path.clone(),
+        written: Vec::new(),
+        removed: Vec::new(),
+        // Only warnings of the re-rendered pages are reported, as for rendering
+        warnings: transform_warnings
+            .into_iter()
+            .filter(|warning| affected.contains(&warning.file_path))
+            .collect(),
+    };
+    let mut files = Vec::new();
+    let mut assets = Vec::new();
+    for source_path in &affected {
+        let file_documents: Vec<&Document> = documents
+            .iter()
+            .filter(|document| document.source().file_path() == source_path)
+            .collect();
+        if file_documents.is_empty() {
+            let page = weave.render_options.page_path(source_path);
+            let output_path = FilePath::from(
+                weave
+                    .output_directory
+                    .as_relative()
+                    .join(page.as_relative()),
+            );
+            if pal.file_exists(&output_path)? {
+                pal.remove_file(&output_path)?;
+                update.removed.push(page);
+            }
+        } else {
+            let page = render_file_page(
+                source_path,
+                &file_documents,
+                &site_map,
+                &weave.render_options,
+            );
+            files.extend(page.files);
+            update.warnings.extend(page.warnings);
+            assets.extend(page.assets);
+        }
     }
+    files.push(render_index_page(&site_map.toc, &weave.render_options));
+    // The search index covers every page, it is cheap to rebuild completely
+    files.extend(render_search_index(
+        &group_by_file(&documents),
+        &site_map,
+        &weave.render_options,
+    ));
+    write_site(pal, &weave.output_directory, &files)?;
+    dedup_assets(&mut assets);
+    copy_assets(pal, &weave.output_directory, &assets)?;
+
+    update.written = files.into_iter().map(|file| file.path).collect();
+    update
+        .written
+        .extend(assets.into_iter().map(|asset| asset.path));
+    Ok(update)
+}
+
+fn list_documents(store: &StoreHandle) -> Vec<Document> {
+    store.list().unwrap_or_else(|e| {
+        warn!(error = %e, "Failed to list documents");
+        Vec::new()
+    })
 }
 
 /// Handle a file change event (creation, modification, or deletion).
@@ -160,7 +491,12 @@ impl Debouncer {
 /// 1. Removes all existing documents from the file
 /// 2. Re-extracts documents from the file (if it still exists)
 /// 3. Inserts the updated documents into the store
-fn handle_file_change(file_path: &FilePath, pal: &PalHandle, store: &StoreHandle) {
+fn handle_file_change(
+    file_path: &FilePath,
+    pal: &PalHandle,
+    store: &StoreHandle,
+    options: &ExtractionOptions,
+) {
     // First, remove all existing documents from this file
     remove_documents_for_file(file_path, store);
 
@@ -168,7 +504,7 @@ fn handle_file_change(file_path: &FilePath, pal: &PalHandle, store: &StoreHandle
     match pal.file_exists(file_path) {
         Ok(true) => {
             // File exists - extract and insert documents
-            match extract_documents(pal, std::slice::from_ref(file_path)) {
+            match extract_documents_with_options(pal, std::slice::from_ref(file_path), options) {
                 Ok(extraction) => {
                     let mut inserted_count = 0;
 
@@ -245,21 +581,233 @@ fn remove_documents_for_file(file_path: &FilePath, store: &StoreHandle) {
 #[cfg(test)]
 mod tests {
     use super::*;
-    use std::thread;
+    use crate::{RenderOptions, extract_documents, render_site};
+    use hyperlit_base::pal::MockPal;
 
     #[test]
     fn test_debouncer() {
         let mut debouncer = Debouncer::new(Duration::from_millis(100));
         let file_path = FilePath::from("test.rs");
+        let start = Instant::now();
+
+        debouncer.record(file_path.clone(), start);
+        assert!(
+            debouncer
+                .take_ready(start + Duration::from_millis(50))
+                .is_empty()
+        );
+
+        // A second save restarts the quiet period
+        debouncer.record(file_path.clone(), start + Duration::from_millis(80));
+        assert!(
+            debouncer
+                .take_ready(start + Duration::from_millis(150))
+                .is_empty()
+        );
+
+        assert_eq!(
+            debouncer.take_ready(start + Duration::from_millis(180)),
+            vec![file_path]
+        );
+        assert!(
+            debouncer
+                .take_ready(start + Duration::from_millis(500))
+                .is_empty()
+        );
+    }
 
-        // First event should be processed
-        assert!(debouncer.should_process(&file_path));
+    #[test]
+    fn test_debouncer_releases_files_independently() {
+        let mut debouncer = Debouncer::new(Duration::from_millis(100));
+        let start = Instant::now();
+
+        debouncer.record(FilePath::from("b.rs"), start);
+        debouncer.record(FilePath::from("a.rs"), start);
+        debouncer.record(FilePath::from("c.rs"), start + Duration::from_millis(60));
+
+        assert_eq!(
+            debouncer.take_ready(start + Duration::from_millis(100)),
+            vec![FilePath::from("a.rs"), FilePath::from("b.rs")]
+        );
+        assert_eq!(
+            debouncer.take_ready(start + Duration::from_millis(160)),
+            vec![FilePath::from("c.rs")]
+        );
+    }
 
-        // Immediate second event should be debounced
-        assert!(!debouncer.should_process(&file_path));
+    /// Set up a store and rendered site for the given source files.
+    fn setup_site(files: &[(&str, &str)]) -> (PalHandle, StoreHandle, WeaveTarget) {
+        let mock_pal = MockPal::new();
+        for (path, content) in files {
+            mock_pal.add_file(FilePath::from(*path), content.as_bytes().to_vec());
+        }
+        let pal = PalHandle::new(mock_pal);
+        let paths: Vec<FilePath> = files
+            .iter()
+            .map(|(path, _)| FilePath::from(*path))
+            .collect();
+        let documents = extract_documents(&pal, &paths).unwrap().documents;
+
+        let weave = WeaveTarget {
+            output_directory: FilePath::from("output"),
+            output_format: OutputFormat::Html,
+            render_options: RenderOptions::new().with_title("Site"),
+        };
+        write_site(
+            &pal,
+            &weave.output_directory,
+            &render_site(&documents, &weave.render_options).files,
+        )
+        .unwrap();
+
+        let store = StoreHandle::new(crate::InMemoryStore::new());
+        for document in documents {
+            store.insert(document).unwrap();
+        }
+        (pal, store, weave)
+    }
+
+    fn change_file(
+        pal: &PalHandle,
+        store: &StoreHandle,
+        weave: &WeaveTarget,
+        path: &str,
+    ) -> WeaveUpdate {
+        let previous_site_map = SiteMap::build(&list_documents(store));
+        handle_file_change(&FilePath::from(path), pal, store, &ExtractionOptions::new());
+        weave_file_change(&FilePath::from(path), &previous_site_map, pal, store, weave).unwrap()
+    }
+
+    fn read_output(pal: &PalHandle, path: &str) -> String {
+        pal.read_file_to_string(&FilePath::from(format!("output/{path}")))
+            .unwrap()
+    }
+
+    #[test]
+    fn test_weave_file_change_rerenders_only_changed_page() {
+        let (pal, store, weave) =
+            setup_site(&[("a.md", "# Alpha\n\nOld text."), ("b.md", "# Beta\n")]);
+        let untouched = read_output(&pal, "b.md.html");
+
+        pal.create_file(&FilePath::from("a.md"))
+            .unwrap()
+            .write_all(b"# Alpha\n\nNew text.")
+            .unwrap();
+        let update = change_file(&pal, &store, &weave, "a.md");
+
+        assert_eq!(
+            update.written,
+            vec![FilePath::from("a.md.html"), FilePath::from("index.html")]
+        );
+        assert!(update.removed.is_empty());
+        assert!(read_output(&pal, "a.md.html").contains("New text."));
+        assert_eq!(read_output(&pal, "b.md.html"), untouched);
+    }
+
+    #[test]
+    fn test_weave_file_change_keeps_anchors_of_other_pages() {
+        let (pal, store, weave) =
+            setup_site(&[("a.md", "# Alpha\n"), ("b.md", "# Beta\n\n## Overview\n")]);
+        assert!(read_output(&pal, "b.md.html").contains("id=\"overview\""));
+
+        pal.create_file(&FilePath::from("a.md"))
+            .unwrap()
+            .write_all(b"# Alpha\n\n## Overview\n")
+            .unwrap();
+        let update = change_file(&pal, &store, &weave, "a.md");
+
+        assert_eq!(
+            update.written,
+            vec![FilePath::from("a.md.html"), FilePath::from("index.html")]
+        );
+        assert!(read_output(&pal, "a.md.html").contains("id=\"overview\""));
+        assert!(read_output(&pal, "b.md.html").contains("id=\"overview\""));
+        assert!(read_output(&pal, "index.html").contains("b.md.html#overview"));
+    }
 
-        // After waiting, event should be processed
-        thread::sleep(Duration::from_millis(150));
-        assert!(debouncer.should_process(&file_path));
+    #[test]
+    fn test_weave_file_change_rerenders_pages_referencing_moved_symbols() {
+        let (pal, store, weave) = setup_site(&[
+            ("a.rs", "// 📖 # Greeting\nfn greet() {}\n"),
+            ("b.md", "# Beta\n\nSee [[greet]].\n"),
+            ("c.md", "# Gamma\n"),
+        ]);
+        assert!(read_output(&pal, "b.md.html").contains("a.rs.html#greeting"));
+
+        pal.create_file(&FilePath::from("a.rs"))
+            .unwrap()
+            .write_all("// 📖 # Hello\nfn greet() {}\n".as_bytes())
+            .unwrap();
+        let update = change_file(&pal, &store, &weave, "a.rs");
+
+        assert_eq!(
+            update.written,
+            vec![
+                FilePath::from("a.rs.html"),
+                FilePath::from("b.md.html"),
+                FilePath::from("index.html")
+            ]
+        );
+        assert!(read_output(&pal, "b.md.html").contains("a.rs.html#hello"));
+    }
+
+    #[test]
+    fn test_weave_included_file_change() {
+        let (pal, store, weave) = setup_site(&[
+            ("docs/a.md", "# Alpha\n\n{{include example.yaml}}\n"),
+            ("docs/b.md", "# Beta\n\n{{include ./example.yaml}}\n"),
+            ("docs/c.md", "# Gamma\n"),
+            ("docs/example.yaml", "key: old\n"),
+        ]);
+        assert!(read_output(&pal, "docs/a.md.html").contains("key: old"));
+
+        pal.create_file(&FilePath::from("docs/example.yaml"))
+            .unwrap()
+            .write_all(b"key: new\n")
+            .unwrap();
+        let sources = dependent_files(&FilePath::from("docs/example.yaml"), &store);
+        assert_eq!(
+            sources,
+            vec![FilePath::from("docs/a.md"), FilePath::from("docs/b.md")]
+        );
+        for source in &sources {
+            change_file(&pal, &store, &weave, &source.to_string());
+        }
+
+        assert!(read_output(&pal, "docs/a.md.html").contains("key: new"));
+        assert!(read_output(&pal, "docs/b.md.html").contains("key: new"));
+    }
+
+    #[test]
+    fn test_referenced_asset_is_a_dependency() {
+        let (_, store, _) = setup_site(&[
+            ("docs/a.md", "# Alpha\n\n![Flow](img/flow.png)\n"),
+            ("docs/b.md", "# Beta\n"),
+        ]);
+        assert_eq!(
+            dependent_files(&FilePath::from("docs/img/flow.png"), &store),
+            vec![FilePath::from("docs/a.md")]
+        );
+    }
+
+    #[test]
+    fn test_weave_file_change_removes_page_of_deleted_file() {
+        let (pal, store, weave) = setup_site(&[("a.md", "# Alpha\n"), ("b.md", "# Beta\n")]);
+
+        pal.remove_file(&FilePath::from("b.md")).unwrap();
+        let update = change_file(&pal, &store, &weave, "b.md");
+
+        assert_eq!(update.removed, vec![FilePath::from("b.md.html")]);
+        // The next link of the page before the deleted one is removed as well
+        assert_eq!(
+            update.written,
+            vec![FilePath::from("a.md.html"), FilePath::from("index.html")]
+        );
+        assert!(!read_output(&pal, "a.md.html").contains("b.md.html"));
+        assert!(
+            !pal.file_exists(&FilePath::from("output/b.md.html"))
+                .unwrap()
+        );
+        assert!(!read_output(&pal, "index.html").contains("Beta"));
     }
 }
@@ -0,0 +1,253 @@
+/* 📖 # How are words counted?
+
+Pages show how long they take to read ("5 min read"), so every document gets
+a word count and a reading time estimate during extraction. Only the text a
+reader reads counts: markup, link targets and HTML are left out, and so are
+code blocks, which are skimmed rather than read. With `count_code_words = true`
+the words of code blocks (including included files and the code following doc
+comments) are counted as well. The reading time is the word count divided by
+`words_per_minute` (defaults to 200), rounded up to whole seconds.
+
+A word is a run of letters and digits, possibly joined by apostrophes, hyphens
+or underscores (`don't`, `well-known`, `max_retries`), which works for any
+script separating words by spaces. Chinese and Japanese do not, a sentence
+would be a single "word". Every Han, Hiragana and Katakana character is
+counted as a word of its own instead, which roughly matches the reading speed
+of those scripts in characters per minute.
+
+Counts are taken from the extracted content. A `Transformer` changing the
+content later leaves them as they were.
+*/
+
+use std::iter::Sum;
+use std::time::Duration;
+
+use pulldown_cmark::{Event, Parser, Tag, TagEnd};
+
+use crate::Document;
+use crate::directive::expand_directives;
+use crate::following_code::expand_following_code;
+use crate::footnote::PARSER_OPTIONS;
+
+/// Default reading speed of the reading time estimates.
+pub const DEFAULT_WORDS_PER_MINUTE: u32 = 200;
+
+/// Options controlling how the words of documents are counted.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct WordCountOptions {
+    include_code: bool,
+    words_per_minute: u32,
+}
+
+impl Default for WordCountOptions {
+    fn default() -> Self {
+        Self {
+            include_code: false,
+            words_per_minute: DEFAULT_WORDS_PER_MINUTE,
+        }
+    }
+}
+
+impl WordCountOptions {
+    /// Create word count options with default settings.
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Count the words of code blocks as well (disabled by default).
+    pub fn with_include_code(mut self, include_code: bool) -> Self {
+        self.include_code = include_code;
+        self
+    }
+
+    /// Set the reading speed of the reading time estimates (defaults to
+    /// [`DEFAULT_WORDS_PER_MINUTE`], at least 1).
+    pub fn with_words_per_minute(mut self, words_per_minute: u32) -> Self {
+        self.words_per_minute = words_per_minute.max(1);
+        self
+    }
+
+    /// Returns true if the words of code blocks are counted.
+    pub fn include_code(&self) -> bool {
+        self.include_code
+    }
+
+    /// Returns the reading speed of the reading time estimates.
+    pub fn words_per_minute(&self) -> u32 {
+        self.words_per_minute
+    }
+}
+
+/// Number of words of a document and the estimated time to read them.
+///
+/// Counts of several documents add up with `sum()`, e.g. for a page.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Hash)]
+pub struct WordCount {
+    /// Number of words
+    pub words: usize,
+    /// Estimated time to read the words
+    pub reading_time: Duration,
+}
+
+impl WordCount {
+    /// Count the words of a document, see [`crate::word_count`].
+    pub fn of(document: &Document, options: &WordCountOptions) -> Self {
+        let content = document.content();
+        let parsed = expand_directives(
+            document,
+            Parser::new_ext(content, PARSER_OPTIONS).into_offset_iter(),
+        );
+        let mut words = 0;
+        let mut text = String::new();
+        let mut in_code_block = false;
+        for (event, _) in expand_following_code(document, parsed) {
+            match event {
+                Event::Start(Tag::CodeBlock(_)) => in_code_block = true,
+                Event::Text(_) if in_code_block && !options.include_code => {}
+                Event::Text(part) | Event::Code(part) => text.push_str(&part),
+                // Inline markup may be part of a word, as in `re*tried*`
+                Event::End(
+                    TagEnd::Emphasis | TagEnd::Strong | TagEnd::Strikethrough | TagEnd::Link,
+                ) => {}
+                // Words end with their block or line
+                Event::SoftBreak | Event::HardBreak | Event::End(_) => {
+                    in_code_block = false;
+                    words += count_words(&text);
+                    text.clear();
+                }
+                _ => {}
+            }
+        }
+        words += count_words(&text);
+        let seconds = (words as u64 * 60).div_ceil(u64::from(options.words_per_minute));
+        Self {
+            words,
+            reading_time: Duration::from_secs(seconds),
+        }
+    }
+
+    /// Returns the reading time in whole minutes, rounded up.
+    ///
+    /// This is what a "5 min read" badge shows, 0 only if there are no words.
+    pub fn reading_minutes(&self) -> u64 {
+        self.reading_time.as_secs().div_ceil(60)
+    }
+}
+
+impl Sum for WordCount {
+    fn sum<I: Iterator<Item = Self>>(iter: I) -> Self {
+        iter.fold(Self::default(), |total, count| Self {
+            words: total.words + count.words,
+            reading_time: total.reading_time + count.reading_time,
+        })
+    }
+}
+
+/// Returns the number of words in plain text.
+pub fn count_words(text: &str) -> usize {
+    let mut words = 0;
+    let mut in_word = false;
+    let mut chars = text.chars().peekable();
+    while let Some(c) = chars.next() {
+        if is_ideographic(c) {
+            words += 1;
+            in_word = false;
+        } else if c.is_alphanumeric() {
+            if !in_word {
+                words += 1;
+                in_word = true;
+            }
+        } else if !(in_word
+            && matches!(c, '\'' | '’' | '-' | '_')
+            && chars.peek().is_some_and(|next| next.is_alphanumeric()))
+        {
+            in_word = false;
+        }
+    }
+    words
+}
+
+/// Returns true for characters of scripts written without spaces between
+/// words, each of which is counted as a word.
+fn is_ideographic(c: char) -> bool {
+    matches!(c,
+        '\u{3040}'..='\u{30FF}' // Hiragana, Katakana
+        | '\u{31F0}'..='\u{31FF}' // Katakana phonetic extensions
+        | '\u{3400}'..='\u{4DBF}' // CJK unified ideographs extension A
+        | '\u{4E00}'..='\u{9FFF}' // CJK unified ideographs
+        | '\u{F900}'..='\u{FAFF}' // CJK compatibility ideographs
+        | '\u{FF66}'..='\u{FF9F}' // Halfwidth Katakana
+        | '\u{20000}'..='\u{3FFFF}' // CJK unified ideographs extensions B and later
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, FollowingCode, SourceType};
+    use hyperlit_base::FilePath;
+    use std::collections::HashSet;
+
+    fn doc(content: &str) -> Document {
+        let source = DocumentSource::new(SourceType::CodeComment, FilePath::from("src/lib.rs"), 1);
+        Document::new(
+            "Doc".to_string(),
+            content.to_string(),
+            source,
+            None,
+            &HashSet::new(),
+        )
+    }
+
+    #[test]
+    fn test_count_words() {
+        assert_eq!(count_words(""), 0);
+        assert_eq!(count_words("  Requests are retried\tup to 3 times. "), 7);
+        assert_eq!(count_words("Don't re-run max_retries - ever"), 4);
+        assert_eq!(count_words("Grüße, Straße и мир"), 4);
+        assert_eq!(count_words("日本語のテキスト"), 8);
+        assert_eq!(count_words("Hello 世界!"), 3);
+    }
+
+    #[test]
+    fn test_word_count_of_document() {
+        let document = doc(
+            "# Retries\n\nRequests are *retried*\nup to [three](https://example.com/three) times, see `retry()`.\n\n```rust\nlet retries = 3;\n```\n\n<div class=\"note\">ignored</div>\n",
+        )
+        .with_following_code(FollowingCode {
+            code: "fn retry() {}".to_string(),
+            language: Some("rust".to_string()),
+            start_line: 12,
+        });
+        let count = WordCount::of(&document, &WordCountOptions::new());
+        assert_eq!(count.words, 10);
+        assert_eq!(count.reading_time, Duration::from_secs(3));
+        assert_eq!(count.reading_minutes(), 1);
+
+        let options = WordCountOptions::new()
+            .with_include_code(true)
+            .with_words_per_minute(2);
+        let count = WordCount::of(&document, &options);
+        assert_eq!(count.words, 15);
+        assert_eq!(count.reading_time, Duration::from_secs(450));
+        assert_eq!(count.reading_minutes(), 8);
+    }
+
+    #[test]
+    fn test_word_count_sum() {
+        let counts = [
+            WordCount {
+                words: 150,
+                reading_time: Duration::from_secs(45),
+            },
+            WordCount {
+                words: 50,
+                reading_time: Duration::from_secs(15),
+            },
+        ];
+        let total: WordCount = counts.into_iter().sum();
+        assert_eq!(total.words, 200);
+        assert_eq!(total.reading_minutes(), 1);
+        assert_eq!(WordCount::default().reading_minutes(), 0);
+    }
+}
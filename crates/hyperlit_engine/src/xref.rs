@@ -0,0 +1,295 @@
+/* 📖 # Why support `[[name]]` cross-references?
+
+Documentation comments often explain how pieces of code relate to each other
+("the cache is filled by [[warm_up]]"). Writing such a link by hand requires
+knowing the output page and heading anchor of the other comment, which breaks
+as soon as code moves. A `[[name]]` reference instead names the documented
+symbol and is resolved to its current location when the site is rendered.
+
+Symbols are taken from the first line of code following a doc comment: the
+identifier declared there (`fn warm_up`, `func (c *Cache) WarmUp`, `class Cache`,
+...) becomes the symbol of the document. Detection is a deliberately simple,
+language-agnostic pattern over common declaration keywords rather than a full
+parser per language. Document IDs can be referenced the same way, which makes
+markdown files (that have no symbol) linkable too.
+
+References that cannot be resolved are reported as warnings with their source
+location instead of being rendered as broken links.
+*/
+
+use std::collections::HashMap;
+use std::sync::LazyLock;
+
+use regex::Regex;
+
+use hyperlit_base::FilePath;
+
+use crate::toc::sorted_by_source;
+use crate::{Document, Toc};
+
+/// Where a cross-reference points to in the rendered site.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct LinkTarget {
+    /// Source file whose page contains the target
+    pub file_path: FilePath,
+    /// Anchor of the target's first heading, if it has one
+    pub anchor: Option<String>,
+}
+
+/// Index of all names that `[[name]]` references can resolve to.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct SymbolIndex {
+    targets: HashMap<String, LinkTarget>,
+}
+
+impl SymbolIndex {
+    /// Build the index from the symbols and IDs of all documents.
+    ///
+    /// Symbols take precedence over document IDs. If several documents declare
+    /// the same symbol, the first one by file path and line wins.
+    pub fn build(documents: &[Document], toc: &Toc) -> Self {
+        let sorted = sorted_by_source(documents);
+        let mut targets = HashMap::new();
+        let target = |document: &Document| LinkTarget {
+            file_path: document.source().file_path().clone(),
+            anchor: toc.anchors(document.id()).first().cloned(),
+        };
+        for document in &sorted {
+            if let Some(symbol) = document.symbol() {
+                targets
+                    .entry(symbol.to_string())
+                    .or_insert_with(|| target(document));
+            }
+        }
+        for document in &sorted {
+            targets
+                .entry(document.id().as_str().to_string())
+                .or_insert_with(|| target(document));
+        }
+        Self { targets }
+    }
+
+    /// Resolve a referenced name.
+    pub fn resolve(&self, name: &str) -> Option<&LinkTarget> {
+        self.targets.get(name)
+    }
+
+    /// Returns the names whose target differs between `self` and `other`.
+    pub(crate) fn changed_names<'a>(&'a self, other: &'a SymbolIndex) -> Vec<&'a str> {
+        let mut names: Vec<&str> = self
+            .targets
+            .keys()
+            .chain(other.targets.keys())
+            .map(String::as_str)
+            .filter(|name| self.resolve(name) != other.resolve(name))
+            .collect();
+        names.sort_unstable();
+        names.dedup();
+        names
+    }
+}
+
+/// A piece of text, split at `[[name]]` references.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum TextPart<'a> {
+    Text(&'a str),
+    /// A reference, with the byte offset of the `[[` in the text and the
+    /// byte length of the whole `[[name]]`
+    Reference {
+        name: &'a str,
+        offset: usize,
+        length: usize,
+    },
+}
+
+static REFERENCE: LazyLock<Regex> =
+    LazyLock::new(|| Regex::new(r"\[\[([^\[\]\n]+)\]\]").expect("valid regex"));
+
+/// Split text into plain parts and `[[name]]` references.
+pub(crate) fn split_references(text: &str) -> Vec<TextPart<'_>> {
+    let mut parts = Vec::new();
+    let mut last = 0;
+    for captures in REFERENCE.captures_iter(text) {
+        let whole = captures.get(0).expect("group 0 always matches");
+        let name = captures.get(1).expect("group 1 always matches").as_str();
+        if name.trim().is_empty() {
+            continue;
+        }
+        if whole.start() > last {
+            parts.push(TextPart::Text(&text[last..whole.start()]));
+        }
+        parts.push(TextPart::Reference {
+            name: name.trim(),
+            offset: whole.start(),
+            length: whole.len(),
+        });
+        last = whole.end();
+    }
+    if last < text.len() {
+        parts.push(TextPart::Text(&text[last..]));
+    }
+    parts
+}
+
+/// Returns true if the content contains a `[[name]]` reference to `name`.
+pub(crate) fn references(content: &str, name: &str) -> bool {
+    split_references(content)
+        .iter()
+        .any(|part| matches!(part, TextPart::Reference { name: n, .. } if *n == name))
+}
+
+static DECLARATION: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(concat!(
+        r"^\s*(?:(?:pub(?:\([^)]*\))?|export|default|public|private|protected|internal|",
+        r"static|final|abstract|async|unsafe|const|override|virtual|inline|open|sealed|",
+        r"data|readonly|declare)\s+)*",
+        r"(?:(?:fn|func|def|class|struct|enum|trait|interface|type|function|const|let|var|val|",
+        r"impl|mod|module|object|record|union)\b\s*|macro_rules!\s*)",
+        r"(?:<[^>]*>\s*)?(?:\([^)]*\)\s*)?\*?\s*",
+        r"([A-Za-z_$][A-Za-z0-9_$]*)"
+    ))
+    .expect("valid regex")
+});
+
+/// Extract the identifier declared on a line of code.
+///
+/// Recognizes common declarations such as `fn greet`, `func (s *Server) Greet`,
+/// `def greet`, `class Greeter`, `export function greet` or `type Config struct`.
+pub(crate) fn symbol_from_declaration(line: &str) -> Option<&str> {
+    DECLARATION
+        .captures(line)
+        .and_then(|captures| captures.get(1))
+        .map(|symbol| symbol.as_str())
+}
+
+/// Returns the first line of code after a comment ending at `end_byte`.
+///
+/// The remainder of the line containing the comment end (e.g. a closing `*/`)
+/// is skipped, as are blank lines and attribute or decorator lines (`#[...]`, `@...`).
+pub(crate) fn following_code_line(content: &str, end_byte: usize) -> Option<&str> {
+    let mut rest = content.get(end_byte..)?;
+    if end_byte > 0 && !content[..end_byte].ends_with('\n') {
+        rest = &rest[rest.find('\n')? + 1..];
+    }
+    rest.lines()
+        .map(str::trim)
+        .find(|line| !line.is_empty() && !line.starts_with("#[") && !line.starts_with('@'))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{DocumentSource, SourceType, build_toc};
+    use std::collections::HashSet;
+
+    #[test]
+    fn test_symbol_from_declaration() {
+        let cases = [
+            ("fn greet(name: &str) {", Some("greet")),
+            ("pub(crate) async fn load() {", Some("load")),
+            ("pub const fn size() -> usize {", Some("size")),
+            ("pub struct Config {", Some("Config")),
+            ("impl<T> Store<T> {", Some("Store")),
+            (
+                "func (s *Server) Greet(name string) string {",
+                Some("Greet"),
+            ),
+            ("type Server struct {", Some("Server")),
+            ("def greet(name):", Some("greet")),
+            ("export default class Greeter {", Some("Greeter")),
+            ("export function greet() {", Some("greet")),
+            ("const MAX_SIZE: usize = 10;", Some("MAX_SIZE")),
+            ("macro_rules! bail {", Some("bail")),
+            ("let x = 1;", Some("x")),
+            ("return greet();", None),
+            ("functional()", None),
+            ("if (x) {", None),
+        ];
+        for (line, expected) in cases {
+            assert_eq!(symbol_from_declaration(line), expected, "{line}");
+        }
+    }
+
+    #[test]
+    fn test_following_code_line() {
+        let content = "/* 📖 # Doc */\n\n#[derive(Debug)]\npub struct Config;\n";
+        let end_byte = content.find(" */").unwrap();
+        assert_eq!(
+            following_code_line(content, end_byte),
+            Some("pub struct Config;")
+        );
+
+        let content = "// 📖 # Doc\n// more\nfn greet() {}\n";
+        let end_byte = content.find("fn").unwrap();
+        assert_eq!(
+            following_code_line(content, end_byte),
+            Some("fn greet() {}")
+        );
+
+        assert_eq!(following_code_line("// 📖 # Doc", 11), None);
+    }
+
+    #[test]
+    fn test_split_references() {
+        assert_eq!(
+            split_references("See [[greet]] and [[ Config ]]."),
+            vec![
+                TextPart::Text("See "),
+                TextPart::Reference {
+                    name: "greet",
+                    offset: 4,
+                    length: 9
+                },
+                TextPart::Text(" and "),
+                TextPart::Reference {
+                    name: "Config",
+                    offset: 18,
+                    length: 12
+                },
+                TextPart::Text("."),
+            ]
+        );
+        assert_eq!(
+            split_references("[[]] [x] [[a\nb]]"),
+            vec![TextPart::Text("[[]] [x] [[a\nb]]")]
+        );
+    }
+
+    #[test]
+    fn test_symbol_index_prefers_symbols_and_first_location() {
+        let doc = |path: &str, line: usize, title: &str, symbol: Option<&str>| {
+            let source = DocumentSource::new(SourceType::CodeComment, FilePath::from(path), line);
+            let document = Document::new(
+                title.to_string(),
+                format!("# {title}\n"),
+                source,
+                None,
+                &HashSet::new(),
+            );
+            match symbol {
+                Some(symbol) => document.with_symbol(symbol),
+                None => document,
+            }
+        };
+        let documents = vec![
+            doc("b.rs", 1, "Greet again", Some("greet")),
+            doc("a.rs", 5, "Greet", Some("greet")),
+            doc("guide.md", 1, "Why greet", None),
+        ];
+        let toc = build_toc(&documents);
+        let index = SymbolIndex::build(&documents, &toc);
+
+        assert_eq!(
+            index.resolve("greet"),
+            Some(&LinkTarget {
+                file_path: FilePath::from("a.rs"),
+                anchor: Some("greet".to_string()),
+            })
+        );
+        assert_eq!(
+            index.resolve("why-greet").unwrap().file_path,
+            FilePath::from("guide.md")
+        );
+        assert_eq!(index.resolve("missing"), None);
+    }
+}